@@ -0,0 +1,98 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/github/gh-ost/go/mysql"
+	"github.com/github/gh-ost/go/sql"
+)
+
+// Checkpoint is a snapshot of the migration progress: how far row-copy went, and up to which
+// binlog coordinates events have been applied onto the ghost table.
+// It is persisted in the changelog table so that an interrupted migration can be resumed via `--resume`.
+type Checkpoint struct {
+	UniqueKey                string
+	PartitionName            string
+	Iteration                int64
+	IterationRangeMaxValues  []string // hex 编码，保证写入 changelog(charset ascii) 时不会出错
+	TotalRowsCopied          int64
+	LastAppliedRowsEventHint string
+	ExecutedGTIDSet          string // GTID streaming: 已经apply的事务
+	MigrationToken           string // 被中断的migration执行DDL时使用的token
+	Timestamp                int64
+}
+
+// ParseCheckpoint reads a checkpoint as written to the changelog table
+func ParseCheckpoint(value string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal([]byte(value), checkpoint); err != nil {
+		return nil, fmt.Errorf("Cannot parse checkpoint %s: %s", value, err.Error())
+	}
+	return checkpoint, nil
+}
+
+// SetIterationRangeMaxValues keeps the (hex encoded) values of the last copied chunk end
+func (this *Checkpoint) SetIterationRangeMaxValues(columnValues *sql.ColumnValues) {
+	this.IterationRangeMaxValues = []string{}
+	if columnValues == nil {
+		return
+	}
+	for _, value := range columnValues.AbstractValues() {
+		var bytes []byte
+		switch v := value.(type) {
+		case []byte:
+			bytes = v
+		default:
+			bytes = []byte(fmt.Sprintf("%v", v))
+		}
+		this.IterationRangeMaxValues = append(this.IterationRangeMaxValues, hex.EncodeToString(bytes))
+	}
+}
+
+// GetIterationRangeMaxValues returns the values of the last copied chunk end, or nil when
+// no chunk was copied (for the checkpoint's partition)
+func (this *Checkpoint) GetIterationRangeMaxValues() (*sql.ColumnValues, error) {
+	if len(this.IterationRangeMaxValues) == 0 {
+		return nil, nil
+	}
+	abstractValues := []interface{}{}
+	for _, value := range this.IterationRangeMaxValues {
+		bytes, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		abstractValues = append(abstractValues, bytes)
+	}
+	return sql.ToColumnValues(abstractValues), nil
+}
+
+// GetLastAppliedRowsEventHint returns the coordinates up to which binlog events are known to be applied
+func (this *Checkpoint) GetLastAppliedRowsEventHint() (*mysql.BinlogCoordinates, error) {
+	coordinates, err := mysql.ParseBinlogCoordinates(this.LastAppliedRowsEventHint)
+	if err != nil {
+		return nil, err
+	}
+	coordinates.GTIDSet = this.ExecutedGTIDSet
+	return coordinates, nil
+}
+
+func (this *Checkpoint) ToJSON() (string, error) {
+	bytes, err := json.Marshal(this)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+func (this *Checkpoint) String() string {
+	return fmt.Sprintf("unique key: %s, partition: %s, iteration: %d, rows copied: %d, binlog: %s",
+		this.UniqueKey, this.PartitionName, this.Iteration, this.TotalRowsCopied, this.LastAppliedRowsEventHint,
+	)
+}
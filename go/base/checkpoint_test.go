@@ -0,0 +1,62 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"testing"
+
+	"github.com/github/gh-ost/go/sql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	checkpoint := &Checkpoint{
+		UniqueKey:                "PRIMARY",
+		PartitionName:            "p3",
+		Iteration:                17,
+		TotalRowsCopied:          17000,
+		LastAppliedRowsEventHint: "mysql-bin.000012:4711",
+		ExecutedGTIDSet:          "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",
+	}
+	checkpoint.SetIterationRangeMaxValues(sql.ToColumnValues([]interface{}{int64(12345), []byte("名字")}))
+	value, err := checkpoint.ToJSON()
+	test.S(t).ExpectNil(err)
+
+	parsed, err := ParseCheckpoint(value)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(parsed.UniqueKey, "PRIMARY")
+	test.S(t).ExpectEquals(parsed.PartitionName, "p3")
+	test.S(t).ExpectEquals(parsed.Iteration, int64(17))
+	test.S(t).ExpectEquals(parsed.TotalRowsCopied, int64(17000))
+
+	values, err := parsed.GetIterationRangeMaxValues()
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(values.String(), "12345,名字")
+
+	coordinates, err := parsed.GetLastAppliedRowsEventHint()
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(coordinates.LogFile, "mysql-bin.000012")
+	test.S(t).ExpectEquals(coordinates.LogPos, int64(4711))
+	test.S(t).ExpectEquals(coordinates.GTIDSet, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5")
+}
+
+func TestCheckpointEmptyRange(t *testing.T) {
+	checkpoint := &Checkpoint{UniqueKey: "PRIMARY", LastAppliedRowsEventHint: "mysql-bin.000001:120"}
+	checkpoint.SetIterationRangeMaxValues(nil)
+	value, err := checkpoint.ToJSON()
+	test.S(t).ExpectNil(err)
+
+	parsed, err := ParseCheckpoint(value)
+	test.S(t).ExpectNil(err)
+	values, err := parsed.GetIterationRangeMaxValues()
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectTrue(values == nil)
+}
+
+func TestParseCheckpointInvalid(t *testing.T) {
+	_, err := ParseCheckpoint("not a checkpoint")
+	test.S(t).ExpectNotNil(err)
+}
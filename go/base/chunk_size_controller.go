@@ -0,0 +1,73 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 每个chunk的观测值在平滑估计中的权重
+	chunkSizeSmoothingFactor = 0.3
+	// 每次调整, chunk size 最多扩大一倍, 或缩小一半
+	chunkSizeMaxAdjustmentFactor = 2.0
+)
+
+// ChunkSizeController adapts the row-copy chunk size, as per --chunk-target-millis, such that copying a chunk
+// takes about the target duration. It keeps an exponentially smoothed estimate of the time it takes to copy a row,
+// and changes the chunk size by at most a factor of 2 at a time.
+type ChunkSizeController struct {
+	rowNanos float64 // 拷贝一行的平滑估计耗时; 0: 尚无观测
+	mutex    *sync.Mutex
+}
+
+func NewChunkSizeController() *ChunkSizeController {
+	return &ChunkSizeController{
+		mutex: &sync.Mutex{},
+	}
+}
+
+// ChunkCopied accounts for a chunk of given size copied in given duration, and returns the chunk size
+// expected to take the target duration
+func (this *ChunkSizeController) ChunkCopied(chunkSize int64, duration time.Duration, target time.Duration) int64 {
+	if chunkSize <= 0 || target <= 0 {
+		return chunkSize
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	rowNanos := float64(duration.Nanoseconds()) / float64(chunkSize)
+	if this.rowNanos == 0 {
+		this.rowNanos = rowNanos
+	} else {
+		this.rowNanos = chunkSizeSmoothingFactor*rowNanos + (1-chunkSizeSmoothingFactor)*this.rowNanos
+	}
+	if this.rowNanos <= 0 {
+		return int64(float64(chunkSize) * chunkSizeMaxAdjustmentFactor)
+	}
+	targetChunkSize := float64(target.Nanoseconds()) / this.rowNanos
+	if max := float64(chunkSize) * chunkSizeMaxAdjustmentFactor; targetChunkSize > max {
+		targetChunkSize = max
+	}
+	if min := float64(chunkSize) / chunkSizeMaxAdjustmentFactor; targetChunkSize < min {
+		targetChunkSize = min
+	}
+	return int64(targetChunkSize)
+}
+
+// LockWaitFailed accounts for a chunk of given size failing on lock wait timeout or deadlock, and returns a halved
+// chunk size. The estimate is adjusted accordingly, such that the next chunks do not grow right back.
+func (this *ChunkSizeController) LockWaitFailed(chunkSize int64, target time.Duration) int64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	backedOffChunkSize := int64(float64(chunkSize) / chunkSizeMaxAdjustmentFactor)
+	if backedOffChunkSize > 0 && target > 0 {
+		this.rowNanos = float64(target.Nanoseconds()) / float64(backedOffChunkSize)
+	}
+	return backedOffChunkSize
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestChunkSizeControllerConverges(t *testing.T) {
+	controller := NewChunkSizeController()
+	target := 500 * time.Millisecond
+	rowDuration := 100 * time.Microsecond // 5000 rows per target
+
+	chunkSize := int64(1000)
+	chunkSize = controller.ChunkCopied(chunkSize, time.Duration(chunkSize)*rowDuration, target)
+	test.S(t).ExpectEquals(chunkSize, int64(2000)) // at most doubles
+	for i := 0; i < 10; i++ {
+		chunkSize = controller.ChunkCopied(chunkSize, time.Duration(chunkSize)*rowDuration, target)
+	}
+	test.S(t).ExpectEquals(chunkSize, int64(5000))
+
+	// rows become 10 times slower: shrinks gradually, at most halves
+	chunkSize = controller.ChunkCopied(chunkSize, time.Duration(chunkSize)*rowDuration*10, target)
+	test.S(t).ExpectEquals(chunkSize, int64(2500))
+	for i := 0; i < 20; i++ {
+		chunkSize = controller.ChunkCopied(chunkSize, time.Duration(chunkSize)*rowDuration*10, target)
+	}
+	test.S(t).ExpectEquals(chunkSize, int64(500))
+}
+
+func TestChunkSizeControllerSmoothing(t *testing.T) {
+	controller := NewChunkSizeController()
+	target := 500 * time.Millisecond
+
+	chunkSize := controller.ChunkCopied(5000, target, target)
+	test.S(t).ExpectEquals(chunkSize, int64(5000))
+	// a single slow chunk only weighs in partially
+	chunkSize = controller.ChunkCopied(chunkSize, 2*target, target)
+	test.S(t).ExpectTrue(chunkSize < 5000)
+	test.S(t).ExpectTrue(chunkSize > 2500)
+}
+
+func TestChunkSizeControllerLockWaitFailed(t *testing.T) {
+	controller := NewChunkSizeController()
+	target := 500 * time.Millisecond
+
+	chunkSize := controller.ChunkCopied(5000, target, target)
+	chunkSize = controller.LockWaitFailed(chunkSize, target)
+	test.S(t).ExpectEquals(chunkSize, int64(2500))
+	// copying at the former pace does not bounce right back
+	chunkSize = controller.ChunkCopied(chunkSize, target/2, target)
+	test.S(t).ExpectTrue(chunkSize < 5000)
+}
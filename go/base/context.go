@@ -74,15 +74,22 @@ func NewThrottleCheckResult(throttle bool, reason string, reasonHint ThrottleRea
 // all components throughout the migration process.
 type MigrationContext struct {
 	Uuid string
+	// 标识本次migration自己执行的DDL, 见 StatementComment(); --resume 时沿用checkpoint中的token
+	MigrationToken string
 
 	DatabaseName      string
+	DatabaseAlias     string // --db-alias, 用于标识 metrics
 	OriginalTableName string
 	AlterStatement    string
 
 	// 新增字段
-	OriginalFilter string               // 在数据整理的过程中，可以通过filter来选择"要保留的数据"，"不是要删除的数据"
-	PartitionInfos []*sql.PartitionInfo // table包含的partition信息
-	PartitionOpt   bool                 // 数据整理过程中，是否按照partition来逐步处理（效率更高，但是不支持修改schema）
+	OriginalFilter          string               // 在数据整理的过程中，可以通过filter来选择"要保留的数据"，"不是要删除的数据"
+	OriginalFilterEvaluator *sql.RowFilter       // 在binlog events上计算OriginalFilter
+	ArchiveTableName        string               // 被OriginalFilter排除的数据写入该表(与原表同一个database)
+	ArchiveFile             string               // 被OriginalFilter排除的数据写入该文件(gzip压缩的JSON lines)
+	PartitionInfos          []*sql.PartitionInfo // table包含的partition信息
+	PartitionOpt            bool                 // 数据整理过程中，是否按照partition来逐步处理（效率更高，但是不支持修改schema）
+	PartitionWorkers        int64                // --partition-opt: 同时拷贝的partition个数
 
 	CountTableRows           bool
 	ConcurrentCountTableRows bool
@@ -92,6 +99,7 @@ type MigrationContext struct {
 	AssumeRBR                bool
 	SkipForeignKeyChecks     bool
 	NullableUniqueKeyAllowed bool
+	AllowLossyUnique         bool
 	ApproveRenamedColumns    bool
 	SkipRenamedColumns       bool
 	IsTungsten               bool
@@ -108,11 +116,17 @@ type MigrationContext struct {
 	CliMasterPassword string
 
 	HeartbeatIntervalMilliseconds       int64
+	CheckpointIntervalSeconds           int64
 	defaultNumRetries                   int64
 	ChunkSize                           int64
+	ChunkTargetMillis                   int64 // >0: 自动调整 ChunkSize, 使每个chunk的拷贝耗时接近这个值
+	CopyWorkers                         int64
+	DMLApplyWorkers                     int64 // 并发apply binlog DML events 的连接数; 按unique key的hash分配
 	niceRatio                           float64
 	MaxLagMillisecondsThrottleThreshold int64
 	throttleControlReplicaKeys          *mysql.InstanceKeyMap
+	DiscoverThrottleControlReplicas     bool // 定期发现master的replicas, 加入 throttleControlReplicaKeys
+	ReplicaDiscovery                    *ReplicaDiscovery
 	ThrottleFlagFile                    string
 	ThrottleAdditionalFlagFile          string
 	throttleQuery                       string
@@ -121,6 +135,8 @@ type MigrationContext struct {
 	HibernateUntil                      int64
 	maxLoad                             LoadMap
 	criticalLoad                        LoadMap
+	copyWindows                         TimeWindows // --copy-window: row-copy 只在这些时间段内进行
+	cutOverWindows                      TimeWindows // --cut-over-window: cut-over 只在这些时间段内进行
 	CriticalLoadIntervalMilliseconds    int64
 	CriticalLoadHibernateSeconds        int64
 	PostponeCutOverFlagFile             string
@@ -129,12 +145,18 @@ type MigrationContext struct {
 	ExponentialBackoffMaxInterval       int64
 	ForceNamedCutOverCommand            bool
 	PanicFlagFile                       string
+	OnDDL                               string // binlog中发现对原表/ghost表/changelog表的DDL时: abort 或者 pause
 	HooksPath                           string
 	HooksHintMessage                    string
+	HooksURLs                           []string
+	HooksURLTimeoutMillis               int64
+	HooksURLRetries                     int64
+	HooksURLFailurePolicy               string
 
-	DropServeSocket bool
-	ServeSocketFile string
-	ServeTCPPort    int64
+	DropServeSocket  bool
+	ServeSocketFile  string
+	ServeTCPPort     int64
+	ServeMetricsPort int64
 
 	Noop                         bool
 	TestOnReplica                bool
@@ -143,7 +165,11 @@ type MigrationContext struct {
 	OkToDropTable                bool
 	InitiallyDropOldTable        bool
 	InitiallyDropGhostTable      bool
+	Resume                       bool // 基于 changelog 中的 checkpoint 继续之前中断的 migration
+	GTIDStreaming                bool // gtid_mode=ON 时按照GTID读取binlog
 	TimestampOldTable            bool // Should old table name include a timestamp
+	VerifyChecksum               bool // cut-over 之前通过checksum比较ghost table和original table
+	PreflightScan                bool // 拷贝数据之前扫描原表, 找出在ghost表上会失败或者被截断的值; 扫描之后退出
 	CutOverType                  CutOver
 	ReplicaServerId              uint
 
@@ -159,6 +185,7 @@ type MigrationContext struct {
 	OriginalBinlogRowImage                 string
 	InspectorConnectionConfig              *mysql.ConnectionConfig
 	InspectorMySQLVersion                  string
+	InspectorFlavor                        string // mysql/mariadb, 由 @@version 判断
 	ApplierConnectionConfig                *mysql.ConnectionConfig
 	ApplierMySQLVersion                    string
 	StartTime                              time.Time
@@ -173,8 +200,12 @@ type MigrationContext struct {
 	ThrottleHTTPStatusCode                 int64
 	controlReplicasLagResult               mysql.ReplicationLagResult
 	TotalRowsCopied                        int64
+	TotalRowsArchived                      int64
 	RowCopyComplete                        atomic.Value
 	TotalDMLEventsApplied                  int64
+	TotalDMLStatementsApplied              int64 // apply DML events 执行的语句数; 同一行的events合并之后少于events数
+	RelevantRowsEvents                     int64 // binlog中迁移相关的表(原表, changelog表)的rows event
+	SkippedRowsEvents                      int64 // binlog中其他表的rows event, 不解析
 	DMLBatchSize                           int64
 	isThrottled                            bool
 	throttleReason                         string
@@ -187,28 +218,27 @@ type MigrationContext struct {
 	AllEventsUpToLockProcessedInjectedFlag int64
 	CleanupImminentFlag                    int64
 	UserCommandedUnpostponeFlag            int64
+	IsPausedOnDDL                          int64
+	UserCommandedAckDDLFlag                int64
 	CutOverCompleteFlag                    int64
+	CutOverAttempts                        int64
 	InCutOverCriticalSectionFlag           int64
 	PanicAbort                             chan error
 
-	OriginalTableColumnsOnApplier    *sql.ColumnList
-	OriginalTableColumns             *sql.ColumnList
-	OriginalTableVirtualColumns      *sql.ColumnList
-	OriginalTableUniqueKeys          [](*sql.UniqueKey)
-	GhostTableColumns                *sql.ColumnList
-	GhostTableVirtualColumns         *sql.ColumnList
-	GhostTableUniqueKeys             [](*sql.UniqueKey)
-	UniqueKey                        *sql.UniqueKey
-	SharedColumns                    *sql.ColumnList
-	ColumnRenameMap                  map[string]string
-	DroppedColumnsMap                map[string]bool
-	MappedSharedColumns              *sql.ColumnList
-	MigrationRangeMinValues          *sql.ColumnValues
-	MigrationRangeMaxValues          *sql.ColumnValues
-	Iteration                        int64
-	MigrationIterationRangeMinValues *sql.ColumnValues
-	MigrationIterationRangeMaxValues *sql.ColumnValues
-	ForceTmpTableName                string
+	OriginalTableColumnsOnApplier *sql.ColumnList
+	OriginalTableColumns          *sql.ColumnList
+	OriginalTableVirtualColumns   *sql.ColumnList
+	OriginalTableUniqueKeys       [](*sql.UniqueKey)
+	GhostTableColumns             *sql.ColumnList
+	GhostTableVirtualColumns      *sql.ColumnList
+	GhostTableUniqueKeys          [](*sql.UniqueKey)
+	UniqueKey                     *sql.UniqueKey
+	SharedColumns                 *sql.ColumnList
+	ColumnRenameMap               map[string]string
+	DroppedColumnsMap             map[string]bool
+	MappedSharedColumns           *sql.ColumnList
+	Iteration                     int64 // 所有 RangeIteration 的 iteration 总和
+	ForceTmpTableName             string
 
 	recentBinlogCoordinates mysql.BinlogCoordinates
 }
@@ -239,20 +269,27 @@ func GetMigrationContext() *MigrationContext {
 }
 
 func NewMigrationContext() *MigrationContext {
+	migrationUuid := uuid.NewV4().String()
 	return &MigrationContext{
-		Uuid:                                uuid.NewV4().String(),
+		Uuid:                                migrationUuid,
+		MigrationToken:                      migrationUuid,
 		defaultNumRetries:                   60,
 		ChunkSize:                           1000,
+		CopyWorkers:                         1,
+		DMLApplyWorkers:                     1,
+		PartitionWorkers:                    1,
 		InspectorConnectionConfig:           mysql.NewConnectionConfig(),
 		ApplierConnectionConfig:             mysql.NewConnectionConfig(),
 		MaxLagMillisecondsThrottleThreshold: 1500,
 		CutOverLockTimeoutSeconds:           3,
+		CheckpointIntervalSeconds:           60,
 		DMLBatchSize:                        10,
 		maxLoad:                             NewLoadMap(),
 		criticalLoad:                        NewLoadMap(),
 		throttleMutex:                       &sync.Mutex{},
 		throttleHTTPMutex:                   &sync.Mutex{},
 		throttleControlReplicaKeys:          mysql.NewInstanceKeyMap(),
+		ReplicaDiscovery:                    NewReplicaDiscovery(),
 		configMutex:                         &sync.Mutex{},
 		pointOfInterestTimeMutex:            &sync.Mutex{},
 		ColumnRenameMap:                     make(map[string]string),
@@ -269,6 +306,12 @@ func getSafeTableName(baseName string, suffix string) string {
 	return fmt.Sprintf("_%s_%s", baseName[0:len(baseName)-extraCharacters], suffix)
 }
 
+// StatementComment is the comment of the DDL statements this migration issues, by which it tells them apart
+// from others' in the binlog, e.g. `/* gh-ost 6ba7b810-9dad-11d1-80b4-00c04fd430c8 */`
+func (this *MigrationContext) StatementComment() string {
+	return fmt.Sprintf("/* gh-ost %s */", this.MigrationToken)
+}
+
 // GetGhostTableName generates the name of ghost table, based on original table name
 // or a given table name
 func (this *MigrationContext) GetGhostTableName() string {
@@ -348,12 +391,6 @@ func (this *MigrationContext) InspectorIsAlsoApplier() bool {
 	return this.InspectorConnectionConfig.Equals(this.ApplierConnectionConfig)
 }
 
-// HasMigrationRange tells us whether there's a range to iterate for copying rows.
-// It will be `false` if the table is initially empty
-func (this *MigrationContext) HasMigrationRange() bool {
-	return this.MigrationRangeMinValues != nil && this.MigrationRangeMaxValues != nil
-}
-
 func (this *MigrationContext) SetCutOverLockTimeoutSeconds(timeoutSeconds int64) error {
 	if timeoutSeconds < 1 {
 		return fmt.Errorf("Minimal timeout is 1sec. Timeout remains at %d", this.CutOverLockTimeoutSeconds)
@@ -443,6 +480,12 @@ func (this *MigrationContext) GetTotalRowsCopied() int64 {
 	return atomic.LoadInt64(&this.TotalRowsCopied)
 }
 
+// GetTotalRowsArchived returns the number of rows excluded by --origin-filter which are archived: rows of the archive
+// table (net of rows unarchived), or lines written onto the archive file
+func (this *MigrationContext) GetTotalRowsArchived() int64 {
+	return atomic.LoadInt64(&this.TotalRowsArchived)
+}
+
 func (this *MigrationContext) GetIteration() int64 {
 	return atomic.LoadInt64(&this.Iteration)
 }
@@ -472,6 +515,13 @@ func (this *MigrationContext) SetHeartbeatIntervalMilliseconds(heartbeatInterval
 	this.HeartbeatIntervalMilliseconds = heartbeatIntervalMilliseconds
 }
 
+func (this *MigrationContext) SetCheckpointIntervalSeconds(checkpointIntervalSeconds int64) {
+	if checkpointIntervalSeconds < 1 {
+		checkpointIntervalSeconds = 1
+	}
+	atomic.StoreInt64(&this.CheckpointIntervalSeconds, checkpointIntervalSeconds)
+}
+
 func (this *MigrationContext) SetMaxLagMillisecondsThrottleThreshold(maxLagMillisecondsThrottleThreshold int64) {
 	if maxLagMillisecondsThrottleThreshold < 100 {
 		maxLagMillisecondsThrottleThreshold = 100
@@ -489,6 +539,54 @@ func (this *MigrationContext) SetChunkSize(chunkSize int64) {
 	atomic.StoreInt64(&this.ChunkSize, chunkSize)
 }
 
+// SetChunkTargetMillis sets the per-chunk copy duration ChunkSize is adapted to; 0 disables adaptive chunk sizing.
+// Adaptive chunk sizing cannot be combined with --copy-window chunk-size profiles, which would fight over ChunkSize.
+func (this *MigrationContext) SetChunkTargetMillis(chunkTargetMillis int64) error {
+	if chunkTargetMillis < 0 {
+		chunkTargetMillis = 0
+	}
+	this.throttleMutex.Lock()
+	defer this.throttleMutex.Unlock()
+
+	if chunkTargetMillis > 0 {
+		if err := validateCopyWindowsChunkSize(this.copyWindows); err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt64(&this.ChunkTargetMillis, chunkTargetMillis)
+	return nil
+}
+
+func (this *MigrationContext) SetCopyWorkers(copyWorkers int64) {
+	if copyWorkers < 1 {
+		copyWorkers = 1
+	}
+	if copyWorkers > 32 {
+		copyWorkers = 32
+	}
+	this.CopyWorkers = copyWorkers
+}
+
+func (this *MigrationContext) SetDMLApplyWorkers(dmlApplyWorkers int64) {
+	if dmlApplyWorkers < 1 {
+		dmlApplyWorkers = 1
+	}
+	if dmlApplyWorkers > 32 {
+		dmlApplyWorkers = 32
+	}
+	this.DMLApplyWorkers = dmlApplyWorkers
+}
+
+func (this *MigrationContext) SetPartitionWorkers(partitionWorkers int64) {
+	if partitionWorkers < 1 {
+		partitionWorkers = 1
+	}
+	if partitionWorkers > 32 {
+		partitionWorkers = 32
+	}
+	this.PartitionWorkers = partitionWorkers
+}
+
 func (this *MigrationContext) SetDMLBatchSize(batchSize int64) {
 	if batchSize < 1 {
 		batchSize = 1
@@ -581,6 +679,69 @@ func (this *MigrationContext) GetCriticalLoad() LoadMap {
 	return this.criticalLoad.Duplicate()
 }
 
+func (this *MigrationContext) GetCopyWindows() TimeWindows {
+	this.throttleMutex.Lock()
+	defer this.throttleMutex.Unlock()
+
+	return this.copyWindows
+}
+
+func (this *MigrationContext) GetCutOverWindows() TimeWindows {
+	this.throttleMutex.Lock()
+	defer this.throttleMutex.Unlock()
+
+	return this.cutOverWindows
+}
+
+// ReadCopyWindows parses the `--copy-window` flag: windows separated by ';', each with an optional time zone and
+// chunk-size/nice-ratio profile, such as: '01:00-07:00 Asia/Shanghai chunk-size=5000 nice-ratio=0'.
+// It only applies changes in case there's no parsing error.
+func (this *MigrationContext) ReadCopyWindows(copyWindowList string) error {
+	windows, err := ParseTimeWindows(copyWindowList)
+	if err != nil {
+		return err
+	}
+	this.throttleMutex.Lock()
+	defer this.throttleMutex.Unlock()
+
+	if atomic.LoadInt64(&this.ChunkTargetMillis) > 0 {
+		if err := validateCopyWindowsChunkSize(windows); err != nil {
+			return err
+		}
+	}
+	this.copyWindows = windows
+	return nil
+}
+
+// validateCopyWindowsChunkSize rejects copy windows with a chunk-size profile, while chunk-size is adaptive
+func validateCopyWindowsChunkSize(windows TimeWindows) error {
+	for _, window := range windows {
+		if window.ChunkSize > 0 {
+			return fmt.Errorf("chunk-size in --copy-window cannot be combined with --chunk-target-millis, which adapts chunk-size on its own: %s", window)
+		}
+	}
+	return nil
+}
+
+// ReadCutOverWindows parses the `--cut-over-window` flag, in the same format as `--copy-window`, though without
+// profiles. It only applies changes in case there's no parsing error.
+func (this *MigrationContext) ReadCutOverWindows(cutOverWindowList string) error {
+	windows, err := ParseTimeWindows(cutOverWindowList)
+	if err != nil {
+		return err
+	}
+	for _, window := range windows {
+		if window.HasProfile() {
+			return fmt.Errorf("chunk-size and nice-ratio only apply to --copy-window, not to --cut-over-window: %s", window)
+		}
+	}
+	this.throttleMutex.Lock()
+	defer this.throttleMutex.Unlock()
+
+	this.cutOverWindows = windows
+	return nil
+}
+
 func (this *MigrationContext) GetNiceRatio() float64 {
 	this.throttleMutex.Lock()
 	defer this.throttleMutex.Unlock()
@@ -6,6 +6,7 @@
 package base
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -56,3 +57,30 @@ func TestGetTableNames(t *testing.T) {
 		test.S(t).ExpectEquals(context.GetChangelogTableName(), "_tmp_ghc")
 	}
 }
+
+func TestReadTimeWindows(t *testing.T) {
+	context := NewMigrationContext()
+	test.S(t).ExpectNil(context.ReadCopyWindows("01:00-07:00 UTC chunk-size=5000"))
+	test.S(t).ExpectEquals(context.GetCopyWindows().String(), "01:00-07:00 UTC chunk-size=5000")
+
+	test.S(t).ExpectNil(context.ReadCutOverWindows("03:00-04:00 UTC"))
+	test.S(t).ExpectNotNil(context.ReadCutOverWindows("03:00-05:00 UTC nice-ratio=1"))
+	test.S(t).ExpectEquals(context.GetCutOverWindows().String(), "03:00-04:00 UTC")
+
+	test.S(t).ExpectNil(context.ReadCutOverWindows(""))
+	test.S(t).ExpectEquals(len(context.GetCutOverWindows()), 0)
+
+	// chunk-size profiles and --chunk-target-millis both drive chunk-size
+	test.S(t).ExpectNotNil(context.SetChunkTargetMillis(500))
+	test.S(t).ExpectEquals(context.ChunkTargetMillis, int64(0))
+	test.S(t).ExpectNil(context.ReadCopyWindows("01:00-07:00 UTC nice-ratio=0"))
+	test.S(t).ExpectNil(context.SetChunkTargetMillis(500))
+	test.S(t).ExpectNotNil(context.ReadCopyWindows("01:00-07:00 UTC chunk-size=5000"))
+	test.S(t).ExpectEquals(context.GetCopyWindows().String(), "01:00-07:00 UTC nice-ratio=0")
+}
+
+func TestStatementComment(t *testing.T) {
+	context := NewMigrationContext()
+	test.S(t).ExpectEquals(context.StatementComment(), fmt.Sprintf("/* gh-ost %s */", context.Uuid))
+	test.S(t).ExpectTrue(context.StatementComment() != NewMigrationContext().StatementComment())
+}
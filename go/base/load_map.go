@@ -7,23 +7,151 @@ package base
 
 import (
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
 )
 
-// LoadMap is a mapping of status variable & threshold
-// e.g. [Threads_connected: 100, Threads_running: 50]
-type LoadMap map[string]int64
+// Sources of load metrics, given as a prefix of the metric name in a load condition
+const (
+	LoadSourceStatus            = "status"             // SHOW GLOBAL STATUS, the default
+	LoadSourceInnoDBMetrics     = "innodb_metrics"     // information_schema.INNODB_METRICS
+	LoadSourcePerformanceSchema = "performance_schema" // metrics computed from performance_schema tables
+)
+
+var loadSources = []string{LoadSourceStatus, LoadSourceInnoDBMetrics, LoadSourcePerformanceSchema}
+
+// 按长度排列: 先匹配 ">=", 再匹配 ">"
+var loadOperators = []string{">=", "<=", ">", "<", "="}
+
+// rate 后缀: 取两次采样之间每秒的变化量
+const loadRateSuffix = "/s"
+
+// LoadCondition is a threshold on a metric, e.g. `Threads_running=100`, `Com_commit/s>5000` or
+// `innodb_metrics.trx_rseg_history_len>=1000000`.
+// The legacy `=` operator means the threshold is met when the value reaches it, i.e. `>=`
+type LoadCondition struct {
+	Source    string
+	Name      string
+	Rate      bool // per second rate of change of the metric, between two samples
+	Operator  string
+	Threshold float64
+}
+
+// Metric identifies the measured metric, e.g. `Threads_running`, `Com_commit/s`, `innodb_metrics.trx_rseg_history_len`.
+// The default status source is not prefixed.
+func (this *LoadCondition) Metric() string {
+	metric := this.Name
+	if this.Source != LoadSourceStatus {
+		metric = fmt.Sprintf("%s.%s", this.Source, metric)
+	}
+	if this.Rate {
+		metric = metric + loadRateSuffix
+	}
+	return metric
+}
+
+// comparison returns the effective comparison operator
+func (this *LoadCondition) comparison() string {
+	if this.Operator == "=" {
+		return ">="
+	}
+	return this.Operator
+}
+
+// IsMet checks whether given value of the metric meets this condition
+func (this *LoadCondition) IsMet(value float64) bool {
+	switch this.comparison() {
+	case ">":
+		return value > this.Threshold
+	case "<=":
+		return value <= this.Threshold
+	case "<":
+		return value < this.Threshold
+	}
+	return value >= this.Threshold
+}
+
+// Explain describes given value of the metric against this condition, e.g. `Threads_running=120 >= 100`
+func (this *LoadCondition) Explain(value float64) string {
+	return fmt.Sprintf("%s=%s %s %s", this.Metric(), formatLoadValue(value), this.comparison(), this.threshold())
+}
+
+func (this *LoadCondition) String() string {
+	return fmt.Sprintf("%s%s%s", this.Metric(), this.Operator, this.threshold())
+}
+
+// threshold formats the threshold as given
+func (this *LoadCondition) threshold() string {
+	return strconv.FormatFloat(this.Threshold, 'f', -1, 64)
+}
+
+// formatLoadValue formats integral values as such, and rounds others (typically rates) to 2 decimals
+func formatLoadValue(value float64) string {
+	if value == math.Trunc(value) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// ParseLoadCondition parses a single condition of a `--*-load` flag, of the form `[source.]metric[/s]<operator><threshold>`,
+// e.g. 'Threads_running=100', 'Com_commit/s>5000', 'innodb_metrics.trx_rseg_history_len>=1000000'
+func ParseLoadCondition(loadCondition string) (*LoadCondition, error) {
+	operatorIndex := strings.IndexAny(loadCondition, "<>=")
+	if operatorIndex < 0 {
+		return nil, fmt.Errorf("Error parsing load condition: %s", loadCondition)
+	}
+	condition := &LoadCondition{Source: LoadSourceStatus}
+	for _, operator := range loadOperators {
+		if strings.HasPrefix(loadCondition[operatorIndex:], operator) {
+			condition.Operator = operator
+			break
+		}
+	}
+	metric := strings.TrimSpace(loadCondition[:operatorIndex])
+	if strings.HasSuffix(metric, loadRateSuffix) {
+		condition.Rate = true
+		metric = strings.TrimSuffix(metric, loadRateSuffix)
+	}
+	if tokens := strings.SplitN(metric, ".", 2); len(tokens) == 2 {
+		condition.Source = strings.ToLower(tokens[0])
+		metric = tokens[1]
+		knownSource := false
+		for _, source := range loadSources {
+			knownSource = knownSource || condition.Source == source
+		}
+		if !knownSource {
+			return nil, fmt.Errorf("Unknown metric source %s in load condition: %s. Known sources: %s", tokens[0], loadCondition, strings.Join(loadSources, ", "))
+		}
+	}
+	if metric == "" {
+		return nil, fmt.Errorf("Error parsing status variable in load condition: %s", loadCondition)
+	}
+	condition.Name = metric
+
+	threshold := strings.TrimSpace(loadCondition[operatorIndex+len(condition.Operator):])
+	n, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing numeric value in load condition: %s", loadCondition)
+	}
+	condition.Threshold = n
+	return condition, nil
+}
+
+// LoadMap is a mapping of metric & load condition
+// e.g. [Threads_connected: Threads_connected=100, Com_commit/s: Com_commit/s>5000]
+type LoadMap map[string]*LoadCondition
 
 func NewLoadMap() LoadMap {
-	result := make(map[string]int64)
+	result := make(map[string]*LoadCondition)
 	return result
 }
 
-// NewLoadMap parses a `--*-load` flag (e.g. `--max-load`), which is in multiple
-// key-value format, such as:
+// NewLoadMap parses a `--*-load` flag (e.g. `--max-load`), which is a comma delimited
+// list of load conditions, such as:
 //   'Threads_running=100,Threads_connected=500'
+//   'Threads_running>=100,Com_commit/s>5000,innodb_metrics.trx_rseg_history_len>1000000'
 func ParseLoadMap(loadList string) (LoadMap, error) {
 	result := NewLoadMap()
 	if loadList == "" {
@@ -32,18 +160,11 @@ func ParseLoadMap(loadList string) (LoadMap, error) {
 
 	loadConditions := strings.Split(loadList, ",")
 	for _, loadCondition := range loadConditions {
-		loadTokens := strings.Split(loadCondition, "=")
-		if len(loadTokens) != 2 {
-			return result, fmt.Errorf("Error parsing load condition: %s", loadCondition)
-		}
-		if loadTokens[0] == "" {
-			return result, fmt.Errorf("Error parsing status variable in load condition: %s", loadCondition)
-		}
-		if n, err := strconv.ParseInt(loadTokens[1], 10, 0); err != nil {
-			return result, fmt.Errorf("Error parsing numeric value in load condition: %s", loadCondition)
-		} else {
-			result[loadTokens[0]] = n
+		condition, err := ParseLoadCondition(loadCondition)
+		if err != nil {
+			return result, err
 		}
+		result[condition.Metric()] = condition
 	}
 
 	return result, nil
@@ -51,9 +172,10 @@ func ParseLoadMap(loadList string) (LoadMap, error) {
 
 // Duplicate creates a clone of this map
 func (this *LoadMap) Duplicate() LoadMap {
-	dup := make(map[string]int64)
+	dup := make(map[string]*LoadCondition)
 	for k, v := range *this {
-		dup[k] = v
+		condition := *v
+		dup[k] = &condition
 	}
 	return dup
 }
@@ -61,9 +183,8 @@ func (this *LoadMap) Duplicate() LoadMap {
 // String() returns a string representation of this map
 func (this *LoadMap) String() string {
 	tokens := []string{}
-	for key, val := range *this {
-		token := fmt.Sprintf("%s=%d", key, val)
-		tokens = append(tokens, token)
+	for _, condition := range *this {
+		tokens = append(tokens, condition.String())
 	}
 	sort.Strings(tokens)
 	return strings.Join(tokens, ",")
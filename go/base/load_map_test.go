@@ -28,8 +28,8 @@ func TestParseLoadMap(t *testing.T) {
 		m, err := ParseLoadMap(loadList)
 		test.S(t).ExpectNil(err)
 		test.S(t).ExpectEquals(len(m), 2)
-		test.S(t).ExpectEquals(m["threads_running"], int64(20))
-		test.S(t).ExpectEquals(m["threads_connected"], int64(10))
+		test.S(t).ExpectEquals(m["threads_running"].Threshold, float64(20))
+		test.S(t).ExpectEquals(m["threads_connected"].Threshold, float64(10))
 	}
 	{
 		loadList := "threads_running=20=30,threads_connected=10"
@@ -41,6 +41,57 @@ func TestParseLoadMap(t *testing.T) {
 		_, err := ParseLoadMap(loadList)
 		test.S(t).ExpectNotNil(err)
 	}
+	{
+		loadList := "Threads_running>50,Com_commit/s>=5000.5,innodb_metrics.trx_rseg_history_len<=1000000,performance_schema.replication_applier_lag<30"
+		m, err := ParseLoadMap(loadList)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(len(m), 4)
+		test.S(t).ExpectEquals(*m["Threads_running"], LoadCondition{Source: LoadSourceStatus, Name: "Threads_running", Operator: ">", Threshold: 50})
+		test.S(t).ExpectEquals(*m["Com_commit/s"], LoadCondition{Source: LoadSourceStatus, Name: "Com_commit", Rate: true, Operator: ">=", Threshold: 5000.5})
+		test.S(t).ExpectEquals(*m["innodb_metrics.trx_rseg_history_len"], LoadCondition{Source: LoadSourceInnoDBMetrics, Name: "trx_rseg_history_len", Operator: "<=", Threshold: 1000000})
+		test.S(t).ExpectEquals(*m["performance_schema.replication_applier_lag"], LoadCondition{Source: LoadSourcePerformanceSchema, Name: "replication_applier_lag", Operator: "<", Threshold: 30})
+	}
+	{
+		_, err := ParseLoadMap("unknown.metric>1")
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, err := ParseLoadMap(">=1")
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, err := ParseLoadMap("Threads_running>")
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestLoadConditionIsMet(t *testing.T) {
+	tests := []struct {
+		condition string
+		value     float64
+		expected  bool
+	}{
+		{"Threads_running=100", 99, false},
+		{"Threads_running=100", 100, true},
+		{"Threads_running>=100", 100, true},
+		{"Threads_running>100", 100, false},
+		{"Threads_running>100", 100.5, true},
+		{"Threads_running<=100", 100, true},
+		{"Threads_running<100", 100, false},
+		{"Threads_running<100", 0, true},
+	}
+	for _, tt := range tests {
+		condition, err := ParseLoadCondition(tt.condition)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(condition.IsMet(tt.value), tt.expected)
+	}
+}
+
+func TestLoadConditionExplain(t *testing.T) {
+	condition, _ := ParseLoadCondition("Com_commit/s=5000")
+	test.S(t).ExpectEquals(condition.Explain(5123.4567), "Com_commit/s=5123.46 >= 5000")
+	condition, _ = ParseLoadCondition("innodb_metrics.trx_rseg_history_len>1000000")
+	test.S(t).ExpectEquals(condition.Explain(1200000), "innodb_metrics.trx_rseg_history_len=1200000 > 1000000")
 }
 
 func TestString(t *testing.T) {
@@ -55,4 +106,10 @@ func TestString(t *testing.T) {
 		s := m.String()
 		test.S(t).ExpectEquals(s, "threads_connected=10,threads_running=20")
 	}
+	{
+		loadList := "Threads_running>50,Com_commit/s>=5000.5,innodb_metrics.trx_rseg_history_len<1000000"
+		m, _ := ParseLoadMap(loadList)
+		s := m.String()
+		test.S(t).ExpectEquals(s, "Com_commit/s>=5000.5,Threads_running>50,innodb_metrics.trx_rseg_history_len<1000000")
+	}
 }
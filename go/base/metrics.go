@@ -0,0 +1,131 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are histogram upper bounds, in seconds, suitable for chunk copy latencies
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observed values into buckets, as exposed by Prometheus histograms
+type Histogram struct {
+	buckets []float64 // 各个bucket的上界, 递增
+	counts  []uint64  // 非累计的计数, 最后一个对应 +Inf
+	sum     float64
+	count   uint64
+	mutex   *sync.Mutex
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (this *Histogram) Observe(value float64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.counts[sort.SearchFloat64s(this.buckets, value)]++
+	this.sum += value
+	this.count++
+}
+
+// Snapshot returns the cumulative count per bucket (the last being +Inf), the sum and count of observed values
+func (this *Histogram) Snapshot() (cumulativeCounts []uint64, sum float64, count uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var cumulative uint64
+	for _, bucketCount := range this.counts {
+		cumulative += bucketCount
+		cumulativeCounts = append(cumulativeCounts, cumulative)
+	}
+	return cumulativeCounts, this.sum, this.count
+}
+
+// MetricsWriter writes metrics in the Prometheus text exposition format.
+// All samples carry the same identifying labels (e.g. database, table), followed by per-sample labels.
+type MetricsWriter struct {
+	writer io.Writer
+	labels []string // name="value"
+}
+
+// NewMetricsWriter creates a writer whose samples are all labeled with given name/value pairs
+func NewMetricsWriter(writer io.Writer, labelPairs ...string) *MetricsWriter {
+	return &MetricsWriter{
+		writer: writer,
+		labels: formatLabels(labelPairs),
+	}
+}
+
+func formatLabels(labelPairs []string) (labels []string) {
+	for i := 0; i+1 < len(labelPairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, labelPairs[i], escapeLabelValue(labelPairs[i+1])))
+	}
+	return labels
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Family writes the HELP and TYPE lines of a metric; to be followed by its samples
+func (this *MetricsWriter) Family(name string, help string, metricType string) {
+	fmt.Fprintf(this.writer, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(this.writer, "# TYPE %s %s\n", name, metricType)
+}
+
+// Sample writes a single sample, with optional extra label name/value pairs
+func (this *MetricsWriter) Sample(name string, value float64, labelPairs ...string) {
+	labels := append(append([]string{}, this.labels...), formatLabels(labelPairs)...)
+	if len(labels) == 0 {
+		fmt.Fprintf(this.writer, "%s %s\n", name, formatMetricValue(value))
+		return
+	}
+	fmt.Fprintf(this.writer, "%s{%s} %s\n", name, strings.Join(labels, ","), formatMetricValue(value))
+}
+
+func (this *MetricsWriter) Gauge(name string, help string, value float64) {
+	this.Family(name, help, "gauge")
+	this.Sample(name, value)
+}
+
+func (this *MetricsWriter) Counter(name string, help string, value float64) {
+	this.Family(name, help, "counter")
+	this.Sample(name, value)
+}
+
+func (this *MetricsWriter) Histogram(name string, help string, histogram *Histogram) {
+	this.Family(name, help, "histogram")
+	cumulativeCounts, sum, count := histogram.Snapshot()
+	for i, cumulativeCount := range cumulativeCounts {
+		upperBound := math.Inf(1)
+		if i < len(histogram.buckets) {
+			upperBound = histogram.buckets[i]
+		}
+		this.Sample(name+"_bucket", float64(cumulativeCount), "le", formatMetricValue(upperBound))
+	}
+	this.Sample(name+"_sum", sum)
+	this.Sample(name+"_count", float64(count))
+}
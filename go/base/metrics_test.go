@@ -0,0 +1,66 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestHistogramSnapshot(t *testing.T) {
+	histogram := NewHistogram([]float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.1)
+	histogram.Observe(0.5)
+	histogram.Observe(3)
+
+	cumulativeCounts, sum, count := histogram.Snapshot()
+	test.S(t).ExpectTrue(len(cumulativeCounts) == 3)
+	test.S(t).ExpectEquals(cumulativeCounts[0], uint64(2))
+	test.S(t).ExpectEquals(cumulativeCounts[1], uint64(3))
+	test.S(t).ExpectEquals(cumulativeCounts[2], uint64(4))
+	test.S(t).ExpectEquals(sum, 3.65)
+	test.S(t).ExpectEquals(count, uint64(4))
+}
+
+func TestMetricsWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	metricsWriter := NewMetricsWriter(&buffer, "database", "test", "table", `my"table`)
+	metricsWriter.Gauge("gh_ost_rows_estimate", "Estimated rows", 1000)
+	metricsWriter.Family("gh_ost_phase", "Migration phase", "gauge")
+	metricsWriter.Sample("gh_ost_phase", 1, "phase", "row-copy")
+
+	expected := strings.Join([]string{
+		"# HELP gh_ost_rows_estimate Estimated rows",
+		"# TYPE gh_ost_rows_estimate gauge",
+		`gh_ost_rows_estimate{database="test",table="my\"table"} 1000`,
+		"# HELP gh_ost_phase Migration phase",
+		"# TYPE gh_ost_phase gauge",
+		`gh_ost_phase{database="test",table="my\"table",phase="row-copy"} 1`,
+	}, "\n") + "\n"
+	test.S(t).ExpectEquals(buffer.String(), expected)
+}
+
+func TestMetricsWriterHistogram(t *testing.T) {
+	histogram := NewHistogram([]float64{0.5})
+	histogram.Observe(0.25)
+	histogram.Observe(2)
+
+	var buffer bytes.Buffer
+	NewMetricsWriter(&buffer).Histogram("latency_seconds", "Latency", histogram)
+	expected := strings.Join([]string{
+		"# HELP latency_seconds Latency",
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{le="0.5"} 1`,
+		`latency_seconds_bucket{le="+Inf"} 2`,
+		"latency_seconds_sum 2.25",
+		"latency_seconds_count 2",
+	}, "\n") + "\n"
+	test.S(t).ExpectEquals(buffer.String(), expected)
+}
@@ -0,0 +1,149 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/github/gh-ost/go/sql"
+)
+
+// RangeIteration is the row-copy state of a unique key range: either the whole table, or a single
+// partition (--partition-opt). Each partition has its own RangeIteration, so that several partitions
+// may be copied concurrently (--partition-workers).
+//
+// Chunks may complete out of order (--copy-workers); the progress of a RangeIteration is the
+// contiguous prefix of copied chunks, which is what can safely be checkpointed.
+type RangeIteration struct {
+	Partition *sql.PartitionInfo
+
+	RangeMinValues          *sql.ColumnValues
+	RangeMaxValues          *sql.ColumnValues
+	IterationRangeMinValues *sql.ColumnValues
+	IterationRangeMaxValues *sql.ColumnValues
+	Iteration               int64
+	RowsCopied              int64
+	started                 int64
+	complete                int64
+
+	progressMutex   *sync.Mutex
+	nextSeq         int64
+	copiedSeq       int64                       // 所有 seq < copiedSeq 的chunk都已经拷贝完毕
+	copiedOutOfSeq  map[int64]*sql.ColumnValues // 已经拷贝完毕, 但之前还有chunk未完成
+	copiedMaxValues *sql.ColumnValues
+	baseIteration   int64
+}
+
+func NewRangeIteration(partition *sql.PartitionInfo) *RangeIteration {
+	return &RangeIteration{
+		Partition:      partition,
+		progressMutex:  &sync.Mutex{},
+		copiedOutOfSeq: make(map[int64]*sql.ColumnValues),
+	}
+}
+
+// Name is the partition name, or empty when iterating the whole table
+func (this *RangeIteration) Name() string {
+	if this.Partition == nil {
+		return ""
+	}
+	return this.Partition.PartitionName
+}
+
+// HasRange tells whether there's a range to iterate; `false` if the table (partition) is empty
+func (this *RangeIteration) HasRange() bool {
+	return this.RangeMinValues != nil && this.RangeMaxValues != nil
+}
+
+func (this *RangeIteration) GetIteration() int64 {
+	return atomic.LoadInt64(&this.Iteration)
+}
+
+func (this *RangeIteration) GetRowsCopied() int64 {
+	return atomic.LoadInt64(&this.RowsCopied)
+}
+
+func (this *RangeIteration) SetStarted() {
+	atomic.StoreInt64(&this.started, 1)
+}
+
+func (this *RangeIteration) IsStarted() bool {
+	return atomic.LoadInt64(&this.started) == 1
+}
+
+func (this *RangeIteration) SetComplete() {
+	atomic.StoreInt64(&this.complete, 1)
+}
+
+func (this *RangeIteration) IsComplete() bool {
+	return atomic.LoadInt64(&this.complete) == 1
+}
+
+// SetIterationRange sets the range of the next chunk to copy. Only the goroutine iterating the range writes it,
+// and may read IterationRangeMinValues/IterationRangeMaxValues directly; others read it via GetIterationRange
+func (this *RangeIteration) SetIterationRange(iterationRangeMinValues, iterationRangeMaxValues *sql.ColumnValues) {
+	this.progressMutex.Lock()
+	defer this.progressMutex.Unlock()
+
+	this.IterationRangeMinValues = iterationRangeMinValues
+	this.IterationRangeMaxValues = iterationRangeMaxValues
+}
+
+// GetIterationRange returns the range of the chunk last dispatched; safe to call from any goroutine
+func (this *RangeIteration) GetIterationRange() (iterationRangeMinValues, iterationRangeMaxValues *sql.ColumnValues) {
+	this.progressMutex.Lock()
+	defer this.progressMutex.Unlock()
+
+	return this.IterationRangeMinValues, this.IterationRangeMaxValues
+}
+
+// ResumeAfter positions the iteration right after given (checkpointed) values
+func (this *RangeIteration) ResumeAfter(iterationRangeMaxValues *sql.ColumnValues, iteration int64) {
+	this.progressMutex.Lock()
+	defer this.progressMutex.Unlock()
+
+	this.IterationRangeMaxValues = iterationRangeMaxValues
+	atomic.StoreInt64(&this.Iteration, iteration)
+	this.copiedMaxValues = iterationRangeMaxValues
+	this.baseIteration = iteration
+}
+
+// DispatchChunk registers the chunk [IterationRangeMinValues..IterationRangeMaxValues] as being copied,
+// and returns its sequence number, to be reported back via ChunkCopied
+func (this *RangeIteration) DispatchChunk() (seq int64) {
+	this.progressMutex.Lock()
+	defer this.progressMutex.Unlock()
+
+	seq = this.nextSeq
+	this.nextSeq++
+	return seq
+}
+
+// ChunkCopied reports the chunk of given sequence number, ending at given values, as copied
+func (this *RangeIteration) ChunkCopied(seq int64, iterationRangeMaxValues *sql.ColumnValues) {
+	this.progressMutex.Lock()
+	defer this.progressMutex.Unlock()
+
+	this.copiedOutOfSeq[seq] = iterationRangeMaxValues
+	for {
+		maxValues, ok := this.copiedOutOfSeq[this.copiedSeq]
+		if !ok {
+			break
+		}
+		delete(this.copiedOutOfSeq, this.copiedSeq)
+		this.copiedMaxValues = maxValues
+		this.copiedSeq++
+	}
+}
+
+// Progress returns the end values of the contiguous prefix of copied chunks, and the matching iteration
+func (this *RangeIteration) Progress() (copiedMaxValues *sql.ColumnValues, iteration int64) {
+	this.progressMutex.Lock()
+	defer this.progressMutex.Unlock()
+
+	return this.copiedMaxValues, this.baseIteration + this.copiedSeq
+}
@@ -0,0 +1,77 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"testing"
+
+	"github.com/github/gh-ost/go/sql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestRangeIterationName(t *testing.T) {
+	test.S(t).ExpectEquals(NewRangeIteration(nil).Name(), "")
+	test.S(t).ExpectEquals(NewRangeIteration(&sql.PartitionInfo{PartitionName: "p7"}).Name(), "p7")
+}
+
+func TestRangeIterationProgress(t *testing.T) {
+	rangeIteration := NewRangeIteration(nil)
+	maxValues, iteration := rangeIteration.Progress()
+	test.S(t).ExpectTrue(maxValues == nil)
+	test.S(t).ExpectEquals(iteration, int64(0))
+
+	chunkEnd := func(id int64) *sql.ColumnValues {
+		return sql.ToColumnValues([]interface{}{id})
+	}
+	seq0 := rangeIteration.DispatchChunk()
+	seq1 := rangeIteration.DispatchChunk()
+	seq2 := rangeIteration.DispatchChunk()
+	test.S(t).ExpectEquals(seq2, int64(2))
+
+	// chunks complete out of order: progress only covers the contiguous prefix
+	rangeIteration.ChunkCopied(seq1, chunkEnd(200))
+	maxValues, iteration = rangeIteration.Progress()
+	test.S(t).ExpectTrue(maxValues == nil)
+	test.S(t).ExpectEquals(iteration, int64(0))
+
+	rangeIteration.ChunkCopied(seq0, chunkEnd(100))
+	maxValues, iteration = rangeIteration.Progress()
+	test.S(t).ExpectEquals(maxValues.String(), "200")
+	test.S(t).ExpectEquals(iteration, int64(2))
+
+	rangeIteration.ChunkCopied(seq2, chunkEnd(300))
+	maxValues, iteration = rangeIteration.Progress()
+	test.S(t).ExpectEquals(maxValues.String(), "300")
+	test.S(t).ExpectEquals(iteration, int64(3))
+}
+
+func TestRangeIterationResumeAfter(t *testing.T) {
+	rangeIteration := NewRangeIteration(&sql.PartitionInfo{PartitionName: "p1"})
+	rangeIteration.ResumeAfter(sql.ToColumnValues([]interface{}{int64(5000)}), 5)
+	test.S(t).ExpectEquals(rangeIteration.GetIteration(), int64(5))
+	test.S(t).ExpectEquals(rangeIteration.IterationRangeMaxValues.String(), "5000")
+
+	maxValues, iteration := rangeIteration.Progress()
+	test.S(t).ExpectEquals(maxValues.String(), "5000")
+	test.S(t).ExpectEquals(iteration, int64(5))
+
+	rangeIteration.ChunkCopied(rangeIteration.DispatchChunk(), sql.ToColumnValues([]interface{}{int64(6000)}))
+	maxValues, iteration = rangeIteration.Progress()
+	test.S(t).ExpectEquals(maxValues.String(), "6000")
+	test.S(t).ExpectEquals(iteration, int64(6))
+}
+
+func TestRangeIterationGetIterationRange(t *testing.T) {
+	rangeIteration := NewRangeIteration(nil)
+	minValues, maxValues := rangeIteration.GetIterationRange()
+	test.S(t).ExpectTrue(minValues == nil)
+	test.S(t).ExpectTrue(maxValues == nil)
+
+	rangeIteration.SetIterationRange(sql.ToColumnValues([]interface{}{int64(100)}), sql.ToColumnValues([]interface{}{int64(200)}))
+	minValues, maxValues = rangeIteration.GetIterationRange()
+	test.S(t).ExpectEquals(minValues.String(), "100")
+	test.S(t).ExpectEquals(maxValues.String(), "200")
+}
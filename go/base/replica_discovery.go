@@ -0,0 +1,110 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/github/gh-ost/go/mysql"
+)
+
+// ReplicaDiscovery configures --discover-throttle-control-replicas: which of the replicas found under the master
+// become throttle control replicas, and how to reach them
+type ReplicaDiscovery struct {
+	IntervalSeconds int64
+	Recursive       bool // 继续发现replica的replicas
+	include         *regexp.Regexp
+	exclude         *regexp.Regexp
+
+	// RDS等环境中 replica 上报的地址(SHOW SLAVE HOSTS, processlist)可能是不可达的内网IP,
+	// 这里映射为可以访问的地址: host 或 host:port 均可
+	addressMapping map[string]string
+}
+
+func NewReplicaDiscovery() *ReplicaDiscovery {
+	return &ReplicaDiscovery{
+		IntervalSeconds: 60,
+		addressMapping:  make(map[string]string),
+	}
+}
+
+// ReadInclude sets the pattern discovered replicas (as host:port, after address mapping) must match; empty matches all
+func (this *ReplicaDiscovery) ReadInclude(pattern string) (err error) {
+	this.include, err = compileReplicaPattern(pattern)
+	return err
+}
+
+// ReadExclude sets the pattern of discovered replicas (as host:port, after address mapping) to ignore
+func (this *ReplicaDiscovery) ReadExclude(pattern string) (err error) {
+	this.exclude, err = compileReplicaPattern(pattern)
+	return err
+}
+
+func compileReplicaPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid replica pattern %s: %+v", pattern, err)
+	}
+	return compiled, nil
+}
+
+// AddAddressMapping maps the address a replica reports, to an address gh-ost can reach it by.
+// Either may be a host or a host:port
+func (this *ReplicaDiscovery) AddAddressMapping(reported string, reachable string) {
+	this.addressMapping[reported] = reachable
+}
+
+// ReadAddressMapping parses a comma delimited list of reported=reachable addresses, e.g.
+// '10.3.0.50=shard00-r3.db.test.com,10.3.0.51:3306=shard00-r4.db.test.com:3307'
+func (this *ReplicaDiscovery) ReadAddressMapping(mappingList string) error {
+	if mappingList == "" {
+		return nil
+	}
+	for _, mapping := range strings.Split(mappingList, ",") {
+		tokens := strings.Split(mapping, "=")
+		if len(tokens) != 2 || strings.TrimSpace(tokens[0]) == "" || strings.TrimSpace(tokens[1]) == "" {
+			return fmt.Errorf("Error parsing replica address mapping: %s. Expected format is reported=reachable", mapping)
+		}
+		this.AddAddressMapping(strings.TrimSpace(tokens[0]), strings.TrimSpace(tokens[1]))
+	}
+	return nil
+}
+
+// Rewrite returns the reachable key of a replica, as reported. A mapping of host:port takes precedence over a
+// mapping of the host; a mapped host without a port keeps the reported port.
+func (this *ReplicaDiscovery) Rewrite(key mysql.InstanceKey) (mysql.InstanceKey, error) {
+	reachable, ok := this.addressMapping[key.StringCode()]
+	if !ok {
+		if reachable, ok = this.addressMapping[key.Hostname]; !ok {
+			return key, nil
+		}
+	}
+	if !strings.Contains(reachable, ":") {
+		return mysql.InstanceKey{Hostname: reachable, Port: key.Port}, nil
+	}
+	rewritten, err := mysql.NewRawInstanceKey(reachable)
+	if err != nil {
+		return key, err
+	}
+	return *rewritten, nil
+}
+
+// Accepts tells whether a discovered replica (after address mapping) should become a throttle control replica
+func (this *ReplicaDiscovery) Accepts(key mysql.InstanceKey) bool {
+	address := key.StringCode()
+	if this.include != nil && !this.include.MatchString(address) {
+		return false
+	}
+	if this.exclude != nil && this.exclude.MatchString(address) {
+		return false
+	}
+	return true
+}
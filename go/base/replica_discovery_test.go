@@ -0,0 +1,58 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"testing"
+
+	"github.com/github/gh-ost/go/mysql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestReplicaDiscoveryRewrite(t *testing.T) {
+	replicaDiscovery := NewReplicaDiscovery()
+	err := replicaDiscovery.ReadAddressMapping("10.3.0.50=shard00-r3.db.test.com, 10.3.0.51:3306=shard00-r4.db.test.com:3307")
+	test.S(t).ExpectNil(err)
+	replicaDiscovery.AddAddressMapping("10.3.0.51", "shard00-r5.db.test.com")
+
+	tests := []struct {
+		reported string
+		expected string
+	}{
+		{"10.3.0.50:3306", "shard00-r3.db.test.com:3306"},
+		{"10.3.0.50:3307", "shard00-r3.db.test.com:3307"},
+		{"10.3.0.51:3306", "shard00-r4.db.test.com:3307"},
+		{"10.3.0.51:3308", "shard00-r5.db.test.com:3308"},
+		{"10.3.0.52:3306", "10.3.0.52:3306"},
+	}
+	for _, tt := range tests {
+		key, _ := mysql.NewRawInstanceKey(tt.reported)
+		rewritten, err := replicaDiscovery.Rewrite(*key)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(rewritten.StringCode(), tt.expected)
+	}
+}
+
+func TestReplicaDiscoveryReadAddressMapping(t *testing.T) {
+	test.S(t).ExpectNil(NewReplicaDiscovery().ReadAddressMapping(""))
+	test.S(t).ExpectNotNil(NewReplicaDiscovery().ReadAddressMapping("10.3.0.50"))
+	test.S(t).ExpectNotNil(NewReplicaDiscovery().ReadAddressMapping("10.3.0.50="))
+	test.S(t).ExpectNotNil(NewReplicaDiscovery().ReadAddressMapping("a=b=c"))
+}
+
+func TestReplicaDiscoveryAccepts(t *testing.T) {
+	replicaDiscovery := NewReplicaDiscovery()
+	test.S(t).ExpectTrue(replicaDiscovery.Accepts(mysql.InstanceKey{Hostname: "backup-r1.db.test.com", Port: 3306}))
+
+	test.S(t).ExpectNil(replicaDiscovery.ReadInclude(`^shard00-`))
+	test.S(t).ExpectNil(replicaDiscovery.ReadExclude(`-delayed|:3307$`))
+	test.S(t).ExpectTrue(replicaDiscovery.Accepts(mysql.InstanceKey{Hostname: "shard00-r3.db.test.com", Port: 3306}))
+	test.S(t).ExpectFalse(replicaDiscovery.Accepts(mysql.InstanceKey{Hostname: "shard01-r3.db.test.com", Port: 3306}))
+	test.S(t).ExpectFalse(replicaDiscovery.Accepts(mysql.InstanceKey{Hostname: "shard00-delayed.db.test.com", Port: 3306}))
+	test.S(t).ExpectFalse(replicaDiscovery.Accepts(mysql.InstanceKey{Hostname: "shard00-r3.db.test.com", Port: 3307}))
+
+	test.S(t).ExpectNotNil(replicaDiscovery.ReadInclude(`(`))
+}
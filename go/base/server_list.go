@@ -7,6 +7,7 @@ import (
 	"github.com/juju/errors"
 	"github.com/outbrain/golib/log"
 	"io/ioutil"
+	"path"
 	"strconv"
 	"strings"
 )
@@ -48,6 +49,11 @@ type DatabaseConfig struct {
 	SlaveMasterMapping [][]string `toml:"slave_master_mapping"`
 	Slave2Master       map[string]string
 
+	// --discover-throttle-control-replicas: 发现的replica上报的地址不可达时(例如RDS的内网IP), 映射为可达的地址
+	// [["10.3.0.50", "shard00-r3.db.test.com"], ["10.3.0.51:3306", "shard00-r4.db.test.com:3307"]]
+	DiscoverThrottleControlReplicas bool       `toml:"discover_throttle_control_replicas"`
+	ReplicaAddressMapping           [][]string `toml:"replica_address_mapping"`
+
 	PasswordMapping    [][]string `toml:"alias_2_password_mapping"`
 	Alias2UserPassword map[string]*UserPassword
 
@@ -76,6 +82,12 @@ func NewConfig(data string) (*DatabaseConfig, error) {
 		c.Slave2Master[mapping[0]] = mapping[1]
 	}
 
+	for _, mapping := range c.ReplicaAddressMapping {
+		if len(mapping) != 2 {
+			return nil, fmt.Errorf("Invalid replica_address_mapping entry: %+v. Expected [reported, reachable]", mapping)
+		}
+	}
+
 	c.Alias2UserPassword = make(map[string]*UserPassword)
 	for _, mapping := range c.PasswordMapping {
 		passwords := strings.SplitN(mapping[1], ":", 2)
@@ -126,3 +138,53 @@ func (c *DatabaseConfig) GetDB(alias string) (dbName string, hostname string, po
 	log.Fatalf(color.RedString("No db found for alias: %s\n"), alias)
 	return
 }
+
+// Aliases returns the aliases of all (not commented out) dbs, in config order
+func (c *DatabaseConfig) Aliases() []string {
+	aliases := []string{}
+	for _, db := range c.Databases {
+		if strings.HasPrefix(db, "#") {
+			continue
+		}
+		fields := strings.Split(db, ":")
+		if len(fields) != 2 {
+			continue
+		}
+		aliases = append(aliases, fields[0])
+	}
+	return aliases
+}
+
+// MatchAliases expands a comma delimited list of aliases or glob patterns, e.g. "shard*" or "shard1,shard[2-4]".
+// Aliases are returned in config order, each at most once. A pattern matching no alias is an error.
+func (c *DatabaseConfig) MatchAliases(patterns string) ([]string, error) {
+	matched := make(map[string]bool)
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		found := false
+		for _, alias := range c.Aliases() {
+			ok, err := path.Match(pattern, alias)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid alias pattern %s: %s", pattern, err.Error())
+			}
+			if ok {
+				matched[alias] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("No db found for alias: %s", pattern)
+		}
+	}
+
+	aliases := []string{}
+	for _, alias := range c.Aliases() {
+		if matched[alias] {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases, nil
+}
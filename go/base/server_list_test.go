@@ -34,3 +34,35 @@ func TestServerListParse(t *testing.T) {
 		fmt.Printf("%s --> %s\n", key, value)
 	}
 }
+
+func TestMatchAliases(t *testing.T) {
+	config, err := NewConfig(`
+dbs = [
+    "shard0:shard_sm_0@shard00-r1.db.test.com",
+    "#shard1:shard_sm_1@shard00-r1.db.test.com",
+    "shard2:shard_sm_2@shard00-r1.db.test.com@3307",
+    "shard10:shard_sm_10@shard01-r1.db.test.com",
+    "info:information@info-r1.db.test.com"
+    ]
+`)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(strings.Join(config.Aliases(), ","), "shard0,shard2,shard10,info")
+	{
+		aliases, err := config.MatchAliases("shard*")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(strings.Join(aliases, ","), "shard0,shard2,shard10")
+	}
+	{
+		aliases, err := config.MatchAliases("info, shard?,shard0")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(strings.Join(aliases, ","), "shard0,shard2,info")
+	}
+	{
+		_, err := config.MatchAliases("shard1")
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, err := config.MatchAliases("shard[")
+		test.S(t).ExpectNotNil(err)
+	}
+}
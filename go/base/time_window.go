@@ -0,0 +1,164 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily span of time in a given time zone, e.g. `01:00-07:00 Asia/Shanghai`. It may span midnight,
+// e.g. `22:00-06:00`. While within a --copy-window, its profile (chunk-size, nice-ratio) applies, if given.
+type TimeWindow struct {
+	Start     time.Duration // since midnight
+	End       time.Duration // since midnight; up to 24:00
+	Location  *time.Location
+	ChunkSize int64   // 0: unchanged
+	NiceRatio float64 // <0: unchanged
+}
+
+// HasProfile tells whether this window overrides chunk-size or nice-ratio
+func (this *TimeWindow) HasProfile() bool {
+	return this.ChunkSize > 0 || this.NiceRatio >= 0
+}
+
+// Contains tells whether given time is within this window
+func (this *TimeWindow) Contains(t time.Time) bool {
+	t = t.In(this.Location)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if this.Start < this.End {
+		return this.Start <= sinceMidnight && sinceMidnight < this.End
+	}
+	// 跨越午夜, 例如 22:00-06:00
+	return this.Start <= sinceMidnight || sinceMidnight < this.End
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func (this *TimeWindow) String() string {
+	tokens := []string{fmt.Sprintf("%s-%s", formatTimeOfDay(this.Start), formatTimeOfDay(this.End))}
+	if this.Location != time.Local {
+		tokens = append(tokens, this.Location.String())
+	}
+	if this.ChunkSize > 0 {
+		tokens = append(tokens, fmt.Sprintf("chunk-size=%d", this.ChunkSize))
+	}
+	if this.NiceRatio >= 0 {
+		tokens = append(tokens, fmt.Sprintf("nice-ratio=%s", strconv.FormatFloat(this.NiceRatio, 'f', -1, 64)))
+	}
+	return strings.Join(tokens, " ")
+}
+
+// parseTimeOfDay parses `HH:MM`, from `00:00` to `24:00`
+func parseTimeOfDay(timeOfDay string) (time.Duration, error) {
+	tokens := strings.Split(timeOfDay, ":")
+	if len(tokens) != 2 {
+		return 0, fmt.Errorf("Error parsing time of day %s. Expected format is HH:MM", timeOfDay)
+	}
+	hours, err := strconv.Atoi(tokens[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("Error parsing hours in time of day %s", timeOfDay)
+	}
+	minutes, err := strconv.Atoi(tokens[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("Error parsing minutes in time of day %s", timeOfDay)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// ParseTimeWindow parses a window, in the format `HH:MM-HH:MM [time-zone] [chunk-size=<rows>] [nice-ratio=<ratio>]`.
+// The time zone is an IANA name such as `Asia/Shanghai` or `UTC`; the local time zone by default.
+func ParseTimeWindow(spec string) (*TimeWindow, error) {
+	tokens := strings.Fields(spec)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Empty time window")
+	}
+	window := &TimeWindow{Location: time.Local, NiceRatio: -1}
+	timesOfDay := strings.Split(tokens[0], "-")
+	if len(timesOfDay) != 2 {
+		return nil, fmt.Errorf("Error parsing time window %s. Expected format is HH:MM-HH:MM", spec)
+	}
+	var err error
+	if window.Start, err = parseTimeOfDay(timesOfDay[0]); err != nil {
+		return nil, err
+	}
+	if window.End, err = parseTimeOfDay(timesOfDay[1]); err != nil {
+		return nil, err
+	}
+	if window.Start == window.End || window.Start == 24*time.Hour {
+		return nil, fmt.Errorf("Empty time window %s", spec)
+	}
+
+	for _, token := range tokens[1:] {
+		keyValue := strings.SplitN(token, "=", 2)
+		if len(keyValue) == 1 {
+			if window.Location, err = time.LoadLocation(token); err != nil {
+				return nil, fmt.Errorf("Unknown time zone %s in time window %s: %+v", token, spec, err)
+			}
+			continue
+		}
+		switch keyValue[0] {
+		case "chunk-size":
+			if window.ChunkSize, err = strconv.ParseInt(keyValue[1], 10, 64); err != nil || window.ChunkSize < 100 || window.ChunkSize > 100000 {
+				return nil, fmt.Errorf("Invalid chunk-size in time window %s; expected a value in the range 100-100,000", spec)
+			}
+		case "nice-ratio":
+			if window.NiceRatio, err = strconv.ParseFloat(keyValue[1], 64); err != nil || window.NiceRatio < 0 || window.NiceRatio > 100 {
+				return nil, fmt.Errorf("Invalid nice-ratio in time window %s; expected a value in the range 0-100", spec)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown setting %s in time window %s; expected chunk-size or nice-ratio", keyValue[0], spec)
+		}
+	}
+	return window, nil
+}
+
+// TimeWindows is a list of windows, as given to --copy-window and --cut-over-window, separated by ';'
+type TimeWindows []*TimeWindow
+
+// ParseTimeWindows parses a list of windows separated by ';', e.g.
+// '01:00-07:00 Asia/Shanghai chunk-size=5000; 12:00-13:30 Asia/Shanghai nice-ratio=1'.
+// An empty list has no windows: no time restriction.
+func ParseTimeWindows(specs string) (windows TimeWindows, err error) {
+	for _, spec := range strings.Split(specs, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		window, err := ParseTimeWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// Find returns the first window containing given time, or nil if there is none
+func (this TimeWindows) Find(t time.Time) *TimeWindow {
+	for _, window := range this {
+		if window.Contains(t) {
+			return window
+		}
+	}
+	return nil
+}
+
+// Allows tells whether given time is within any of the windows. With no windows at all, any time is allowed.
+func (this TimeWindows) Allows(t time.Time) bool {
+	return len(this) == 0 || this.Find(t) != nil
+}
+
+func (this TimeWindows) String() string {
+	tokens := []string{}
+	for _, window := range this {
+		tokens = append(tokens, window.String())
+	}
+	return strings.Join(tokens, "; ")
+}
@@ -0,0 +1,93 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	{
+		window, err := ParseTimeWindow("01:00-07:30 Asia/Shanghai")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(window.Start, time.Hour)
+		test.S(t).ExpectEquals(window.End, 7*time.Hour+30*time.Minute)
+		test.S(t).ExpectEquals(window.Location.String(), "Asia/Shanghai")
+		test.S(t).ExpectFalse(window.HasProfile())
+		test.S(t).ExpectEquals(window.String(), "01:00-07:30 Asia/Shanghai")
+	}
+	{
+		window, err := ParseTimeWindow(" 22:00-24:00  UTC chunk-size=5000 nice-ratio=0 ")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(window.End, 24*time.Hour)
+		test.S(t).ExpectEquals(window.ChunkSize, int64(5000))
+		test.S(t).ExpectEquals(window.NiceRatio, float64(0))
+		test.S(t).ExpectTrue(window.HasProfile())
+		test.S(t).ExpectEquals(window.String(), "22:00-24:00 UTC chunk-size=5000 nice-ratio=0")
+	}
+	{
+		window, err := ParseTimeWindow("09:00-18:00 nice-ratio=1.5")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(window.Location, time.Local)
+		test.S(t).ExpectEquals(window.ChunkSize, int64(0))
+		test.S(t).ExpectEquals(window.String(), "09:00-18:00 nice-ratio=1.5")
+	}
+	for _, spec := range []string{"", "01:00", "01:00-07", "1:00-25:00", "01:60-07:00", "07:00-07:00", "24:00-01:00", "01:00-07:00 Mars/Olympus", "01:00-07:00 chunk-size=10", "01:00-07:00 nice-ratio=-1", "01:00-07:00 max-lag-millis=100"} {
+		_, err := ParseTimeWindow(spec)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, 1, 1, hour, minute, 0, 0, shanghai)
+	}
+	{
+		window := &TimeWindow{Start: time.Hour, End: 7 * time.Hour, Location: shanghai}
+		test.S(t).ExpectFalse(window.Contains(at(0, 59)))
+		test.S(t).ExpectTrue(window.Contains(at(1, 0)))
+		test.S(t).ExpectTrue(window.Contains(at(6, 59)))
+		test.S(t).ExpectFalse(window.Contains(at(7, 0)))
+		// 01:00 in Shanghai is 17:00 UTC
+		test.S(t).ExpectTrue(window.Contains(time.Date(2020, 1, 1, 17, 30, 0, 0, time.UTC)))
+	}
+	{
+		window := &TimeWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: shanghai}
+		test.S(t).ExpectTrue(window.Contains(at(23, 0)))
+		test.S(t).ExpectTrue(window.Contains(at(0, 0)))
+		test.S(t).ExpectTrue(window.Contains(at(5, 59)))
+		test.S(t).ExpectFalse(window.Contains(at(6, 0)))
+		test.S(t).ExpectFalse(window.Contains(at(21, 59)))
+	}
+}
+
+func TestParseTimeWindows(t *testing.T) {
+	{
+		windows, err := ParseTimeWindows("")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(len(windows), 0)
+		test.S(t).ExpectTrue(windows.Allows(time.Now()))
+		test.S(t).ExpectEquals(windows.String(), "")
+	}
+	{
+		windows, err := ParseTimeWindows("01:00-07:00 UTC chunk-size=5000; 12:00-13:30 UTC nice-ratio=1;")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(len(windows), 2)
+		test.S(t).ExpectEquals(windows.String(), "01:00-07:00 UTC chunk-size=5000; 12:00-13:30 UTC nice-ratio=1")
+		test.S(t).ExpectTrue(windows.Find(time.Date(2020, 1, 1, 3, 0, 0, 0, time.UTC)) == windows[0])
+		test.S(t).ExpectTrue(windows.Find(time.Date(2020, 1, 1, 13, 0, 0, 0, time.UTC)) == windows[1])
+		test.S(t).ExpectTrue(windows.Find(time.Date(2020, 1, 1, 8, 0, 0, 0, time.UTC)) == nil)
+		test.S(t).ExpectFalse(windows.Allows(time.Date(2020, 1, 1, 8, 0, 0, 0, time.UTC)))
+	}
+	{
+		_, err := ParseTimeWindows("01:00-07:00 UTC; 12:00")
+		test.S(t).ExpectNotNil(err)
+	}
+}
@@ -0,0 +1,34 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package binlog
+
+import (
+	"fmt"
+
+	"github.com/github/gh-ost/go/mysql"
+	"github.com/github/gh-ost/go/sql"
+)
+
+// BinlogDDLEvent is a binary log query event which changes tables: ALTER, TRUNCATE, RENAME, DROP...
+type BinlogDDLEvent struct {
+	DatabaseName string // 执行语句时的默认 database
+	Statement    string
+	DDL          *sql.DDLStatement
+	Coordinates  mysql.BinlogCoordinates
+}
+
+func NewBinlogDDLEvent(databaseName, statement string, ddl *sql.DDLStatement, coordinates mysql.BinlogCoordinates) *BinlogDDLEvent {
+	return &BinlogDDLEvent{
+		DatabaseName: databaseName,
+		Statement:    statement,
+		DDL:          ddl,
+		Coordinates:  coordinates,
+	}
+}
+
+func (this *BinlogDDLEvent) String() string {
+	return fmt.Sprintf("[%+v at %+v: %s]", this.DDL, this.Coordinates, this.Statement)
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/github/gh-ost/go/mysql"
 	"github.com/github/gh-ost/go/sql"
 )
 
@@ -53,6 +54,7 @@ type BinlogDMLEvent struct {
 	DML               EventDML
 	WhereColumnValues *sql.ColumnValues
 	NewColumnValues   *sql.ColumnValues
+	Coordinates       mysql.BinlogCoordinates // 所在的rows event的坐标
 }
 
 func NewBinlogDMLEvent(databaseName, tableName string, dml EventDML) *BinlogDMLEvent {
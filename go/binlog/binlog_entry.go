@@ -10,12 +10,24 @@ import (
 	"github.com/github/gh-ost/go/mysql"
 )
 
+// TransactionMarker marks the boundaries of source transactions in the stream of entries. Only transactions
+// with rows events on tables accepted by the reader's table filter are marked.
+type TransactionMarker int
+
+const (
+	NoTransactionMarker     TransactionMarker = iota
+	TransactionBeginMarker                    // QueryEvent BEGIN
+	TransactionCommitMarker                   // XIDEvent, QueryEvent COMMIT (non transactional engines), DDL (implicit commit)
+)
+
 // BinlogEntry describes an entry in the binary log
 type BinlogEntry struct {
 	Coordinates mysql.BinlogCoordinates
 	EndLogPos   uint64
 
-	DmlEvent *BinlogDMLEvent
+	DmlEvent          *BinlogDMLEvent
+	DdlEvent          *BinlogDDLEvent
+	TransactionMarker TransactionMarker
 }
 
 // NewBinlogEntry creates an empty, ready to go BinlogEntry object
@@ -34,6 +46,13 @@ func NewBinlogEntryAt(coordinates mysql.BinlogCoordinates) *BinlogEntry {
 	return binlogEntry
 }
 
+// NewTransactionMarkerEntryAt creates an entry which marks the beginning or the commit of a source transaction
+func NewTransactionMarkerEntryAt(coordinates mysql.BinlogCoordinates, marker TransactionMarker) *BinlogEntry {
+	binlogEntry := NewBinlogEntryAt(coordinates)
+	binlogEntry.TransactionMarker = marker
+	return binlogEntry
+}
+
 // Duplicate creates and returns a new binlog entry, with some of the attributes pre-assigned
 func (this *BinlogEntry) Duplicate() *BinlogEntry {
 	binlogEntry := NewBinlogEntry(this.Coordinates.LogFile, uint64(this.Coordinates.LogPos))
@@ -43,5 +62,14 @@ func (this *BinlogEntry) Duplicate() *BinlogEntry {
 
 // Duplicate creates and returns a new binlog entry, with some of the attributes pre-assigned
 func (this *BinlogEntry) String() string {
+	if this.DdlEvent != nil {
+		return fmt.Sprintf("[BinlogEntry at %+v; ddl:%+v]", this.Coordinates, this.DdlEvent)
+	}
+	switch this.TransactionMarker {
+	case TransactionBeginMarker:
+		return fmt.Sprintf("[BinlogEntry at %+v; begin]", this.Coordinates)
+	case TransactionCommitMarker:
+		return fmt.Sprintf("[BinlogEntry at %+v; commit]", this.Coordinates)
+	}
 	return fmt.Sprintf("[BinlogEntry at %+v; dml:%+v]", this.Coordinates, this.DmlEvent)
 }
@@ -7,13 +7,17 @@ package binlog
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/github/gh-ost/go/base"
 	"github.com/github/gh-ost/go/mysql"
 	"github.com/github/gh-ost/go/sql"
 
 	"github.com/outbrain/golib/log"
+	uuid "github.com/satori/go.uuid"
 	gomysql "github.com/siddontang/go-mysql/mysql"
 	"github.com/siddontang/go-mysql/replication"
 	"golang.org/x/net/context"
@@ -27,39 +31,103 @@ type GoMySQLReader struct {
 	currentCoordinatesMutex  *sync.Mutex
 	LastAppliedRowsEventHint mysql.BinlogCoordinates // binlog的坐标
 	MigrationContext         *base.MigrationContext
+
+	// GTID streaming: 已经完整接收的事务, 以及正在接收的事务的GTID(事务提交之后才加入 executedGTIDSet)
+	executedGTIDSet *gomysql.MysqlGTIDSet
+	pendingGTID     *gomysql.UUIDSet
+	// MariaDB: 单个 domain, 只需记录最后一个完整接收的事务
+	executedMariadbGTID *gomysql.MariadbGTID
+	pendingMariadbGTID  *gomysql.MariadbGTID
+
+	// 只解析 tableFilter 接受的表的rows event; nil 则解析所有的表
+	tableFilter func(databaseName, tableName string) bool
+	// 当前事务的 BEGIN 坐标; 事务中出现第一个相关的rows event时, 才发出 TransactionBeginMarker
+	beginCoordinates      mysql.BinlogCoordinates
+	inRelevantTransaction bool
 }
 
 func NewGoMySQLReader(migrationContext *base.MigrationContext) (binlogReader *GoMySQLReader, err error) {
 	binlogReader = &GoMySQLReader{
 		connectionConfig:        migrationContext.InspectorConnectionConfig,
+		MigrationContext:        migrationContext,
 		currentCoordinates:      mysql.BinlogCoordinates{},
 		currentCoordinatesMutex: &sync.Mutex{},
 		binlogSyncer:            nil,
 		binlogStreamer:          nil,
 	}
 
-	serverId := uint32(migrationContext.ReplicaServerId)
+	return binlogReader, err
+}
+
+// SetTableFilter makes the reader skip rows events of tables the filter rejects: their rows are not decoded (via the
+// table filter of the vendored go-mysql parser, consulted upon each table map event), and they neither are passed
+// on, nor open a transaction. The filter is called on the binlog syncer's goroutine.
+// It must be called before ConnectBinlogStreamer()
+func (this *GoMySQLReader) SetTableFilter(tableFilter func(databaseName, tableName string) bool) {
+	this.tableFilter = tableFilter
+}
 
+// newBinlogSyncer creates the syncer, hooking up to the inspected server as a replica
+func (this *GoMySQLReader) newBinlogSyncer() *replication.BinlogSyncer {
 	binlogSyncerConfig := replication.BinlogSyncerConfig{
-		ServerID: serverId,
-		Flavor:   "mysql",
-		Host:     binlogReader.connectionConfig.Key.Hostname,
-		Port:     uint16(binlogReader.connectionConfig.Key.Port),
-		User:     binlogReader.connectionConfig.User,
-		Password: binlogReader.connectionConfig.Password,
+		ServerID: uint32(this.MigrationContext.ReplicaServerId),
+		Flavor:   this.flavor(),
+		Host:     this.connectionConfig.Key.Hostname,
+		Port:     uint16(this.connectionConfig.Key.Port),
+		User:     this.connectionConfig.User,
+		Password: this.connectionConfig.Password,
+		// 其他表的rows event在TableMapEvent层面即被过滤, 不解析其rows
+		TableFilter: this.tableFilter,
 	}
-	binlogReader.binlogSyncer = replication.NewBinlogSyncer(binlogSyncerConfig)
+	return replication.NewBinlogSyncer(binlogSyncerConfig)
+}
 
-	return binlogReader, err
+// flavor is the inspected server's flavor, as detected by the inspector
+func (this *GoMySQLReader) flavor() string {
+	if this.MigrationContext.InspectorFlavor == mysql.MariaDBFlavor {
+		return mysql.MariaDBFlavor
+	}
+	return mysql.MySQLFlavor
+}
+
+// parseGTIDSet parses the GTID set to start streaming at, and keeps it as the executed GTID set
+func (this *GoMySQLReader) parseGTIDSet(gtidSetString string) (gtidSet gomysql.GTIDSet, err error) {
+	if this.flavor() == mysql.MariaDBFlavor {
+		if gtidSet, err = gomysql.ParseMariadbGTIDSet(gtidSetString); err != nil {
+			return nil, err
+		}
+		mariadbGTID := gtidSet.(gomysql.MariadbGTID)
+		this.executedMariadbGTID = &mariadbGTID
+		return gtidSet, nil
+	}
+	if gtidSet, err = gomysql.ParseMysqlGTIDSet(gtidSetString); err != nil {
+		return nil, err
+	}
+	this.executedGTIDSet = gtidSet.(*gomysql.MysqlGTIDSet)
+	return gtidSet, nil
 }
 
-// ConnectBinlogStreamer
+// ConnectBinlogStreamer starts streaming at given coordinates: by GTID when the coordinates carry a GTID set,
+// otherwise by binlog file & position
 func (this *GoMySQLReader) ConnectBinlogStreamer(coordinates mysql.BinlogCoordinates) (err error) {
-	if coordinates.IsEmpty() {
+	if coordinates.IsEmpty() && coordinates.GTIDSet == "" {
 		return log.Errorf("Empty coordinates at ConnectBinlogStreamer()")
 	}
 
 	this.currentCoordinates = coordinates
+	if coordinates.GTIDSet != "" {
+		gtidSet, err := this.parseGTIDSet(coordinates.GTIDSet)
+		if err != nil {
+			return err
+		}
+		this.currentCoordinates.GTIDSet = this.executedGTIDSetString()
+		this.binlogSyncer = this.newBinlogSyncer()
+		log.Infof("Connecting binlog streamer at GTID set %s", this.currentCoordinates.GTIDSet)
+		this.binlogStreamer, err = this.binlogSyncer.StartSyncGTID(gtidSet)
+		return err
+	}
+
+	this.binlogSyncer = this.newBinlogSyncer()
 	log.Infof("Connecting binlog streamer at %+v", this.currentCoordinates)
 	// Start sync with specified binlog file and position
 	this.binlogStreamer, err = this.binlogSyncer.StartSync(gomysql.Position{this.currentCoordinates.LogFile, uint32(this.currentCoordinates.LogPos)})
@@ -67,6 +135,87 @@ func (this *GoMySQLReader) ConnectBinlogStreamer(coordinates mysql.BinlogCoordin
 	return err
 }
 
+// executedGTIDSetString formats the executed GTID set; MySQL server UUIDs are listed in stable order
+func (this *GoMySQLReader) executedGTIDSetString() string {
+	if this.executedMariadbGTID != nil {
+		return this.executedMariadbGTID.String()
+	}
+	uuidSets := []string{}
+	for _, uuidSet := range this.executedGTIDSet.Sets {
+		uuidSets = append(uuidSets, uuidSet.String())
+	}
+	sort.Strings(uuidSets)
+	return strings.Join(uuidSets, ",")
+}
+
+// isGTIDStreaming tells whether the reader streams by GTID, rather than by binlog file & position
+func (this *GoMySQLReader) isGTIDStreaming() bool {
+	return this.executedGTIDSet != nil || this.executedMariadbGTID != nil
+}
+
+// checkRotateEvent fails GTID streaming once the syncer re-synced by binlog file & position. Upon a connection
+// error, the syncer reconnects on its own at the last file & position it got, on whatever server now answers at
+// the address: these are meaningless after a failover. Such a re-sync begins with an artificial rotate event
+// past the binlog file header, whereas a GTID dump (and each binlog file it moves on to) begins at the header.
+// The streamer then reconnects by the executed GTID set.
+func (this *GoMySQLReader) checkRotateEvent(ev *replication.BinlogEvent, rotateEvent *replication.RotateEvent) error {
+	if !this.isGTIDStreaming() || ev.Header.Flags&replication.LOG_EVENT_ARTIFICIAL_F == 0 {
+		return nil
+	}
+	if rotateEvent.Position > uint64(len(replication.BinLogFileHeader)) {
+		return fmt.Errorf("Binlog syncer re-synced at %s:%d, by file & position; reconnecting by GTID", rotateEvent.NextLogName, rotateEvent.Position)
+	}
+	return nil
+}
+
+// onGTIDEvent notes the GTID of the transaction that begins
+func (this *GoMySQLReader) onGTIDEvent(gtidEvent *replication.GTIDEvent) error {
+	if this.executedGTIDSet == nil {
+		return nil
+	}
+	sid, err := uuid.FromBytes(gtidEvent.SID)
+	if err != nil {
+		return err
+	}
+	this.pendingGTID = gomysql.NewUUIDSet(sid, gomysql.Interval{Start: gtidEvent.GNO, Stop: gtidEvent.GNO + 1})
+	return nil
+}
+
+// onMariadbGTIDEvent notes the GTID of the (MariaDB) transaction that begins
+func (this *GoMySQLReader) onMariadbGTIDEvent(gtidEvent *replication.MariadbGTIDEvent) {
+	if this.executedMariadbGTID == nil {
+		return
+	}
+	pendingMariadbGTID := gtidEvent.GTID
+	this.pendingMariadbGTID = &pendingMariadbGTID
+}
+
+// onTransactionCommitted adds the GTID of the transaction just streamed onto the executed GTID set
+func (this *GoMySQLReader) onTransactionCommitted() {
+	if this.executedGTIDSet != nil && this.pendingGTID != nil {
+		this.executedGTIDSet.AddSet(this.pendingGTID)
+	} else if this.executedMariadbGTID != nil && this.pendingMariadbGTID != nil {
+		this.executedMariadbGTID = this.pendingMariadbGTID
+	} else {
+		return
+	}
+	this.pendingGTID = nil
+	this.pendingMariadbGTID = nil
+
+	this.currentCoordinatesMutex.Lock()
+	defer this.currentCoordinatesMutex.Unlock()
+	this.currentCoordinates.GTIDSet = this.executedGTIDSetString()
+}
+
+// endTransaction marks the commit of the current transaction, should it have relevant rows events
+func (this *GoMySQLReader) endTransaction(entriesChannel chan<- *BinlogEntry) {
+	if !this.inRelevantTransaction {
+		return
+	}
+	entriesChannel <- NewTransactionMarkerEntryAt(this.currentCoordinates, TransactionCommitMarker)
+	this.inRelevantTransaction = false
+}
+
 func (this *GoMySQLReader) GetCurrentBinlogCoordinates() *mysql.BinlogCoordinates {
 	this.currentCoordinatesMutex.Lock()
 	defer this.currentCoordinatesMutex.Unlock()
@@ -76,15 +225,24 @@ func (this *GoMySQLReader) GetCurrentBinlogCoordinates() *mysql.BinlogCoordinate
 
 // StreamEvents
 func (this *GoMySQLReader) handleRowsEvent(ev *replication.BinlogEvent, rowsEvent *replication.RowsEvent, entriesChannel chan<- *BinlogEntry) error {
+	if rowsEvent.Skipped {
+		atomic.AddInt64(&this.MigrationContext.SkippedRowsEvents, 1)
+		return nil
+	}
 	if this.currentCoordinates.SmallerThanOrEquals(&this.LastAppliedRowsEventHint) {
 		log.Debugf("Skipping handled query at %+v", this.currentCoordinates)
 		return nil
 	}
+	atomic.AddInt64(&this.MigrationContext.RelevantRowsEvents, 1)
 
 	dml := ToEventDML(ev.Header.EventType.String())
 	if dml == NotDML {
 		return fmt.Errorf("Unknown DML type: %s", ev.Header.EventType.String())
 	}
+	if !this.inRelevantTransaction {
+		entriesChannel <- NewTransactionMarkerEntryAt(this.beginCoordinates, TransactionBeginMarker)
+		this.inRelevantTransaction = true
+	}
 	for i, row := range rowsEvent.Rows {
 		// UpdateDML 两个Row一组数据，不处理奇数组数据
 		if dml == UpdateDML && i%2 == 1 {
@@ -98,6 +256,7 @@ func (this *GoMySQLReader) handleRowsEvent(ev *replication.BinlogEvent, rowsEven
 			string(rowsEvent.Table.Table),
 			dml,
 		)
+		binlogEntry.DmlEvent.Coordinates = this.currentCoordinates
 
 		// Insert    --> NewColumnValues
 		// UpdateDML --> WhereColumnValues & NewColumnValues
@@ -129,6 +288,23 @@ func (this *GoMySQLReader) handleRowsEvent(ev *replication.BinlogEvent, rowsEven
 	return nil
 }
 
+// handleQueryEvent passes on DDL statements (ALTER, TRUNCATE, RENAME, DROP...) as BinlogEntries: the consumer
+// decides whether they concern the migration
+func (this *GoMySQLReader) handleQueryEvent(queryEvent *replication.QueryEvent, entriesChannel chan<- *BinlogEntry) {
+	if this.currentCoordinates.SmallerThanOrEquals(&this.LastAppliedRowsEventHint) {
+		// resume 时从binlog文件开头读取, 已经处理过的DDL不再处理
+		return
+	}
+	statement := string(queryEvent.Query)
+	ddl := sql.ParseDDLStatement(statement, string(queryEvent.Schema))
+	if ddl == nil {
+		return
+	}
+	binlogEntry := NewBinlogEntryAt(this.currentCoordinates)
+	binlogEntry.DdlEvent = NewBinlogDDLEvent(string(queryEvent.Schema), statement, ddl, this.currentCoordinates)
+	entriesChannel <- binlogEntry
+}
+
 // StreamEvents
 func (this *GoMySQLReader) StreamEvents(canStopStreaming func() bool, entriesChannel chan<- *BinlogEntry) error {
 	if canStopStreaming() {
@@ -154,6 +330,9 @@ func (this *GoMySQLReader) StreamEvents(canStopStreaming func() bool, entriesCha
 
 		// 如果是binlog文件rotate, 则更新LogFile
 		if rotateEvent, ok := ev.Event.(*replication.RotateEvent); ok {
+			if err := this.checkRotateEvent(ev, rotateEvent); err != nil {
+				return err
+			}
 			func() {
 				this.currentCoordinatesMutex.Lock()
 				defer this.currentCoordinatesMutex.Unlock()
@@ -165,6 +344,24 @@ func (this *GoMySQLReader) StreamEvents(canStopStreaming func() bool, entriesCha
 			if err := this.handleRowsEvent(ev, rowsEvent, entriesChannel); err != nil {
 				return err
 			}
+		} else if gtidEvent, ok := ev.Event.(*replication.GTIDEvent); ok {
+			if err := this.onGTIDEvent(gtidEvent); err != nil {
+				return err
+			}
+		} else if mariadbGTIDEvent, ok := ev.Event.(*replication.MariadbGTIDEvent); ok {
+			this.onMariadbGTIDEvent(mariadbGTIDEvent)
+		} else if _, ok := ev.Event.(*replication.XIDEvent); ok {
+			this.onTransactionCommitted()
+			this.endTransaction(entriesChannel)
+		} else if queryEvent, ok := ev.Event.(*replication.QueryEvent); ok {
+			// DDL, 或者非事务引擎的 COMMIT; BEGIN 开始一个事务
+			if strings.EqualFold(string(queryEvent.Query), "BEGIN") {
+				this.beginCoordinates = this.currentCoordinates
+			} else {
+				this.handleQueryEvent(queryEvent, entriesChannel)
+				this.onTransactionCommitted()
+				this.endTransaction(entriesChannel)
+			}
 		}
 	}
 	log.Debugf("done streaming events")
@@ -173,6 +370,8 @@ func (this *GoMySQLReader) StreamEvents(canStopStreaming func() bool, entriesCha
 }
 
 func (this *GoMySQLReader) Close() error {
-	this.binlogSyncer.Close()
+	if this.binlogSyncer != nil {
+		this.binlogSyncer.Close()
+	}
 	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/github/gh-ost/go/base"
@@ -53,6 +54,34 @@ func acceptSignals(migrationContext *base.MigrationContext) {
 	}()
 }
 
+// resolveHostsConf returns the db config file to use with --db-alias/--db-aliases; default: ~/.gh-ost/dbs.toml
+func resolveHostsConf(dbConfigFile string) string {
+	if len(dbConfigFile) > 0 {
+		return dbConfigFile
+	}
+	dir, err := base.Dir()
+	if err != nil {
+		return dbConfigFile
+	}
+	return path.Join(dir, DEFAULT_HOSTS_CONF)
+}
+
+// stringsFlag is a repeatable flag; each value may also be a comma delimited list
+type stringsFlag []string
+
+func (this *stringsFlag) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *stringsFlag) Set(value string) error {
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			*this = append(*this, token)
+		}
+	}
+	return nil
+}
+
 // main is the application's entry point. It will either spawn a CLI or HTTP interfaces.
 func main() {
 
@@ -67,7 +96,13 @@ func main() {
 	dbAlias := flag.String("db-alias", "", "db alias in db conf file")
 	dbConfigFile := flag.String("hosts-conf", "", "hosts config file")
 
+	// 批量执行: 在多个alias(例如所有的shard)上执行同样的migration
+	dbAliases := flag.String("db-aliases", "", "comma delimited list of db aliases or glob patterns in db conf file, e.g. 'shard*'. Runs one migration per alias (rollout mode)")
+	rolloutConcurrency := flag.Int("rollout-concurrency", 1, "(with --db-aliases) number of migrations to run concurrently")
+	rolloutCanary := flag.Int("rollout-canary", 0, "(with --db-aliases) number of aliases to migrate first, as a canary wave. The rest of the aliases are only migrated when the canary wave succeeds")
+
 	partitionOpt := flag.Bool("partition-opt", false, "是否优化partition逻辑")
+	partitionWorkers := flag.Int64("partition-workers", 1, "(with --partition-opt) number of partitions to copy concurrently (allowed range: 1-32)")
 
 	flag.StringVar(&migrationContext.InspectorConnectionConfig.Key.Hostname, "host", "127.0.0.1", "MySQL hostname (preferably a replica, not the master)")
 
@@ -90,6 +125,8 @@ func main() {
 	flag.StringVar(&migrationContext.DatabaseName, "database", "", "database name (mandatory)")
 	flag.StringVar(&migrationContext.OriginalTableName, "table", "", "table name (mandatory)")
 	flag.StringVar(&migrationContext.OriginalFilter, "origin-filter", "", "filter on origin talbe")
+	flag.StringVar(&migrationContext.ArchiveTableName, "archive-table", "", "(with --origin-filter) copy rows excluded by the filter onto this table (same database; created like the original table if not exists)")
+	flag.StringVar(&migrationContext.ArchiveFile, "archive-file", "", "(with --origin-filter) write rows excluded by the filter onto this local file, as gzip compressed JSON lines (appended if exists)")
 
 	flag.StringVar(&migrationContext.AlterStatement, "alter", "", "alter statement (mandatory)")
 
@@ -104,6 +141,7 @@ func main() {
 	flag.BoolVar(&migrationContext.AllowedRunningOnMaster, "allow-on-master", false, "allow this migration to run directly on master. Preferably it would run on a replica")
 	// 是否允许 master <---> master(双主）
 	flag.BoolVar(&migrationContext.AllowedMasterMaster, "allow-master-master", false, "explicitly allow running in a master-master setup")
+	flag.BoolVar(&migrationContext.AllowLossyUnique, "allow-lossy-unique", false, "allow an ALTER adding a UNIQUE/PRIMARY key to proceed without checking the table for duplicates on it. Rows duplicate on the new key are silently lost. Use at your own risk!")
 	flag.BoolVar(&migrationContext.NullableUniqueKeyAllowed, "allow-nullable-unique-key", false, "allow gh-ost to migrate based on a unique key with nullable columns. As long as no NULL values exist, this should be OK. If NULL values exist in chosen key, data may be corrupted. Use at your own risk!")
 	flag.BoolVar(&migrationContext.ApproveRenamedColumns, "approve-renamed-columns", false, "in case your `ALTER` statement renames columns, gh-ost will note that and offer its interpretation of the rename. By default gh-ost does not proceed to execute. This flag approves that gh-ost's interpretation is correct")
 	flag.BoolVar(&migrationContext.SkipRenamedColumns, "skip-renamed-columns", false, "in case your `ALTER` statement renames columns, gh-ost will note that and offer its interpretation of the rename. By default gh-ost does not proceed to execute. This flag tells gh-ost to skip the renamed columns, i.e. to treat what gh-ost thinks are renamed columns as unrelated columns. NOTE: you may lose column data")
@@ -125,11 +163,18 @@ func main() {
 	flag.BoolVar(&migrationContext.InitiallyDropOldTable, "initially-drop-old-table", false, "Drop a possibly existing OLD table (remains from a previous run?) before beginning operation. Default is to panic and abort if such table exists")
 	flag.BoolVar(&migrationContext.InitiallyDropGhostTable, "initially-drop-ghost-table", false, "Drop a possibly existing Ghost table (remains from a previous run?) before beginning operation. Default is to panic and abort if such table exists")
 
+	// 中断之后，可以从checkpoint继续
+	flag.BoolVar(&migrationContext.Resume, "resume", false, "resume a previously interrupted migration: reuse the existing ghost & changelog tables, and continue row-copy and binlog streaming from the last checkpoint found in the changelog table")
+	checkpointIntervalSeconds := flag.Int64("checkpoint-interval-seconds", 60, "how frequently would gh-ost persist its progress onto the changelog table, to be used by --resume")
+	flag.BoolVar(&migrationContext.GTIDStreaming, "gtid-streaming", true, "when the inspected server has gtid_mode=ON, stream binary logs and reconnect/resume by executed GTID set rather than by binlog file & position")
+
 	// 表名是否带上时间戳
 	flag.BoolVar(&migrationContext.TimestampOldTable, "timestamp-old-table", false, "Use a timestamp in old table name. This makes old table names unique and non conflicting cross migrations")
 
 	// 数据拷贝完毕，如何进行近cut-over呢?
 	cutOver := flag.String("cut-over", "atomic", "choose cut-over type (default|atomic, two-step)")
+	flag.BoolVar(&migrationContext.VerifyChecksum, "verify-checksum", false, "after row-copy is complete and before cut-over, compare the ghost table with the original table chunk by chunk via checksums; block cut-over when mismatching chunks persist. Always takes place with --test-on-replica (after tables are swapped back)")
+	flag.BoolVar(&migrationContext.PreflightScan, "preflight-scan", false, "after the ghost table is altered and before row-copy, compare column types of the original and ghost tables, and scan the original table (in throttled chunks) for values which would fail or be truncated on the ghost table. Reports findings and exits; no data is copied")
 	flag.BoolVar(&migrationContext.ForceNamedCutOverCommand, "force-named-cut-over", false, "When true, the 'unpostpone|cut-over' interactive command must name the migrated table")
 
 	flag.BoolVar(&migrationContext.SwitchToRowBinlogFormat, "switch-to-rbr", false, "let this tool automatically switch binary log format to 'ROW' on the replica, if needed. The format will NOT be switched back. I'm too scared to do that, and wish to protect you if you happen to execute another migration while this one is running")
@@ -137,6 +182,9 @@ func main() {
 	flag.BoolVar(&migrationContext.CutOverExponentialBackoff, "cut-over-exponential-backoff", false, "Wait exponentially longer intervals between failed cut-over attempts. Wait intervals obey a maximum configurable with 'exponential-backoff-max-interval').")
 	exponentialBackoffMaxInterval := flag.Int64("exponential-backoff-max-interval", 64, "Maximum number of seconds to wait between attempts when performing various operations with exponential backoff.")
 	chunkSize := flag.Int64("chunk-size", 1000, "amount of rows to handle in each iteration (allowed range: 100-100,000)")
+	chunkTargetMillis := flag.Int64("chunk-target-millis", 0, "when positive, adapt chunk-size (within 100-100,000) such that copying a chunk takes about this many milliseconds. chunk-size is then the initial size")
+	copyWorkers := flag.Int64("copy-workers", 1, "number of concurrent row-copy workers, each copying disjoint chunks on its own applier connection (allowed range: 1-32)")
+	dmlApplyWorkers := flag.Int64("dml-apply-workers", 1, "number of applier connections applying binlog DML events concurrently, events partitioned by hash of their unique key values (allowed range: 1-32)")
 	dmlBatchSize := flag.Int64("dml-batch-size", 10, "batch size for DML events to apply in a single transaction (range 1-100)")
 	defaultRetries := flag.Int64("default-retries", 60, "Default number of retries for various operations before panicking")
 	cutOverLockTimeoutSeconds := flag.Int64("cut-over-lock-timeout-seconds", 3, "Max number of seconds to hold locks on tables while attempting to cut-over (retry attempted when lock exceeds timeout)")
@@ -146,6 +194,12 @@ func main() {
 
 	// 通过谁来控制throttle
 	throttleControlReplicas := flag.String("throttle-control-replicas", "", "List of replicas on which to check for lag; comma delimited. Example: myhost1.com:3306,myhost2.com,myhost3.com:3307")
+	flag.BoolVar(&migrationContext.DiscoverThrottleControlReplicas, "discover-throttle-control-replicas", false, "periodically discover replicas of the master (SHOW SLAVE HOSTS, binlog dump threads), and add them to throttle-control-replicas")
+	flag.Int64Var(&migrationContext.ReplicaDiscovery.IntervalSeconds, "discover-replicas-interval-seconds", 60, "with --discover-throttle-control-replicas: how often to look for replicas")
+	flag.BoolVar(&migrationContext.ReplicaDiscovery.Recursive, "discover-replicas-recursive", false, "with --discover-throttle-control-replicas: also discover replicas of replicas, down the topology")
+	discoverReplicasInclude := flag.String("discover-replicas-include", "", "with --discover-throttle-control-replicas: regular expression discovered replicas (host:port) must match")
+	discoverReplicasExclude := flag.String("discover-replicas-exclude", "", "with --discover-throttle-control-replicas: regular expression of discovered replicas (host:port) to ignore. Example: '-delayed|backup'")
+	discoverReplicasAddressMapping := flag.String("discover-replicas-address-mapping", "", "with --discover-throttle-control-replicas: comma delimited reported=reachable addresses, for replicas whose reported address is unreachable. Example: 10.3.0.50=myhost4.com,10.3.0.51:3306=myhost5.com:3307")
 
 	throttleQuery := flag.String("throttle-query", "", "when given, issued (every second) to check if operation should throttle. Expecting to return zero for no-throttle, >0 for throttle. Query is issued on the migrated server. Make sure this query is lightweight")
 	throttleHTTP := flag.String("throttle-http", "", "when given, gh-ost checks given URL via HEAD request; any response code other than 200 (OK) causes throttling; make sure it has low latency response")
@@ -153,15 +207,23 @@ func main() {
 	heartbeatIntervalMillis := flag.Int64("heartbeat-interval-millis", 100, "how frequently would gh-ost inject a heartbeat value")
 	flag.StringVar(&migrationContext.ThrottleFlagFile, "throttle-flag-file", "", "operation pauses when this file exists; hint: use a file that is specific to the table being altered")
 	flag.StringVar(&migrationContext.ThrottleAdditionalFlagFile, "throttle-additional-flag-file", "/tmp/gh-ost.throttle", "operation pauses when this file exists; hint: keep default, use for throttling multiple gh-ost operations")
+	copyWindow := flag.String("copy-window", "", "when given, row copy only runs (otherwise throttles) within these daily windows, separated by ';'. Each window may set the chunk-size and nice-ratio in effect while within it. Example: '01:00-07:00 Asia/Shanghai chunk-size=5000 nice-ratio=0'")
+	cutOverWindow := flag.String("cut-over-window", "", "when given, cut-over is postponed until within these daily windows, separated by ';'. Example: '03:00-04:00 Asia/Shanghai'")
 	flag.StringVar(&migrationContext.PostponeCutOverFlagFile, "postpone-cut-over-flag-file", "", "while this file exists, migration will postpone the final stage of swapping tables, and will keep on syncing the ghost table. Cut-over/swapping would be ready to perform the moment the file is deleted.")
 	flag.StringVar(&migrationContext.PanicFlagFile, "panic-flag-file", "", "when this file is created, gh-ost will immediately terminate, without cleanup")
+	flag.StringVar(&migrationContext.OnDDL, "on-ddl", "abort", "what to do upon a DDL statement (ALTER, TRUNCATE, RENAME, DROP...) on the original, ghost or changelog table, issued by another party while migrating: 'abort' (immediately, without cleanup) or 'pause' (binlog streaming and row-copy, until the 'ack-ddl' or 'panic' interactive command)")
 
 	flag.BoolVar(&migrationContext.DropServeSocket, "initially-drop-socket-file", false, "Should gh-ost forcibly delete an existing socket file. Be careful: this might drop the socket file of a running migration!")
 	flag.StringVar(&migrationContext.ServeSocketFile, "serve-socket-file", "", "Unix socket file to serve on. Default: auto-determined and advertised upon startup")
 	flag.Int64Var(&migrationContext.ServeTCPPort, "serve-tcp-port", 0, "TCP port to serve on. Default: disabled")
+	flag.Int64Var(&migrationContext.ServeMetricsPort, "serve-metrics-port", 0, "HTTP port to serve Prometheus metrics on, at /metrics. Default: disabled")
 
 	flag.StringVar(&migrationContext.HooksPath, "hooks-path", "", "directory where hook files are found (default: empty, ie. hooks disabled). Hook files found on this path, and conforming to hook naming conventions will be executed")
 	flag.StringVar(&migrationContext.HooksHintMessage, "hooks-hint", "", "arbitrary message to be injected to hooks via GH_OST_HOOKS_HINT, for your convenience")
+	flag.Var((*stringsFlag)(&migrationContext.HooksURLs), "hooks-url", "URL to POST hooks onto, as JSON payload (default: empty, ie. webhooks disabled). May be repeated, or given as comma delimited list")
+	flag.Int64Var(&migrationContext.HooksURLTimeoutMillis, "hooks-url-timeout-millis", 5000, "timeout of a single webhook request")
+	flag.Int64Var(&migrationContext.HooksURLRetries, "hooks-url-retries", 2, "number of times to retry a failed webhook request")
+	flag.StringVar(&migrationContext.HooksURLFailurePolicy, "hooks-url-failure-policy", "abort", "what to do when a webhook fails after all retries: 'abort' (fail the hook, same as a failing hook file) or 'ignore' (log and proceed)")
 
 	// 默认的ServerId
 	// XXX: 注意这个很重要，不要和前天的server_id冲突
@@ -181,9 +243,9 @@ func main() {
 	// 多大的负载才算是大的负载呢?
 	//
 	// 暂停
-	maxLoad := flag.String("max-load", "", "Comma delimited status-name=threshold. e.g: 'Threads_running=100,Threads_connected=500'. When status exceeds threshold, app throttles writes")
+	maxLoad := flag.String("max-load", "", "Comma delimited load conditions: [source.]metric[/s]{=,>=,>,<=,<}threshold. e.g: 'Threads_running=100,Com_commit/s>5000,innodb_metrics.trx_rseg_history_len>1000000'. Sources: status (default), innodb_metrics, performance_schema; '/s' is the per second rate. When a condition is met, app throttles writes")
 	// 中断
-	criticalLoad := flag.String("critical-load", "", "Comma delimited status-name=threshold, same format as --max-load. When status exceeds threshold, app panics and quits")
+	criticalLoad := flag.String("critical-load", "", "Comma delimited load conditions, same format as --max-load. When a condition is met, app panics and quits")
 	// 等待一段时间后再中断
 	flag.Int64Var(&migrationContext.CriticalLoadIntervalMilliseconds, "critical-load-interval-millis", 0, "When 0, migration immediately bails out upon meeting critical-load. When non-zero, a second check is done after given interval, and migration only bails out if 2nd check still meets critical load")
 
@@ -203,6 +265,9 @@ func main() {
 	if *checkFlag {
 		return
 	}
+	if err := readRolloutCredentials(); err != nil {
+		log.Fatale(err)
+	}
 	if *help {
 		fmt.Fprintf(os.Stdout, "Usage of gh-ost:\n")
 		flag.PrintDefaults()
@@ -241,14 +306,40 @@ func main() {
 	throttleControlReplicasValue := *throttleControlReplicas
 	maxLagMillisValue := *maxLagMillis
 
-	if len(*dbAlias) > 0 {
-		// 默认地址: ~/.gh-ost/dbs.toml
-		if len(dbConfigFileValue) == 0 {
-			dir, err := base.Dir()
-			if err == nil {
-				dbConfigFileValue = path.Join(dir, DEFAULT_HOSTS_CONF)
-			}
+	if len(*dbAliases) > 0 {
+		if len(*dbAlias) > 0 {
+			log.Fatalf("--db-alias and --db-aliases are mutually exclusive")
+		}
+		if *askPass {
+			log.Fatalf("--ask-pass and --db-aliases are mutually exclusive")
+		}
+		if *rolloutConcurrency > 1 && (migrationContext.ServeSocketFile != "" || migrationContext.ServeTCPPort > 0) {
+			log.Fatalf("--serve-socket-file and --serve-tcp-port cannot be shared by concurrent migrations; remove them or use --rollout-concurrency=1")
 		}
+		if *rolloutConcurrency > 1 && migrationContext.ArchiveFile != "" {
+			log.Fatalf("--archive-file cannot be shared by concurrent migrations; use --archive-table or --rollout-concurrency=1")
+		}
+		dbConfigFileValue = resolveHostsConf(dbConfigFileValue)
+		config, err := base.NewConfigWithFile(dbConfigFileValue)
+		if err != nil {
+			log.Errore(err)
+			log.Fatalf("db config file invalid: %s", dbConfigFileValue)
+		}
+		aliases, err := config.MatchAliases(*dbAliases)
+		if err != nil {
+			log.Fatale(err)
+		}
+		log.Infof("Rollout on %d aliases: %s", len(aliases), strings.Join(aliases, ", "))
+		rollout := NewRollout(config, aliases, *rolloutConcurrency, *rolloutCanary, migrationContext.ReplicaServerId, migrationContext.ServeMetricsPort)
+		if err := rollout.Run(); err != nil {
+			log.Fatale(err)
+		}
+		fmt.Fprint(os.Stdout, color.GreenString("# === Done ===^-^ ^-^\n"))
+		return
+	}
+
+	if len(*dbAlias) > 0 {
+		dbConfigFileValue = resolveHostsConf(dbConfigFileValue)
 
 		if len(dbConfigFileValue) > 0 && base.FileExists(dbConfigFileValue) {
 			log.Infof("dbConfigFileValue is %s", dbConfigFileValue)
@@ -261,6 +352,7 @@ func main() {
 			// 实现alias到db的映射
 			db, host, port := config.GetDB(*dbAlias)
 			userPassword := config.Alias2UserPassword[*dbAlias]
+			migrationContext.DatabaseAlias = *dbAlias
 			migrationContext.InspectorConnectionConfig.Key.Hostname = host
 			migrationContext.InspectorConnectionConfig.Key.Port = port
 			migrationContext.DatabaseName = db
@@ -287,6 +379,13 @@ func main() {
 			criticalLoadValue = config.CriticalLoad
 			chunkSizeValue = config.ChunkSize
 
+			if config.DiscoverThrottleControlReplicas {
+				migrationContext.DiscoverThrottleControlReplicas = true
+			}
+			for _, mapping := range config.ReplicaAddressMapping {
+				migrationContext.ReplicaDiscovery.AddAddressMapping(mapping[0], mapping[1])
+			}
+
 			if config.IsRdsMySQL {
 				migrationContext.InspectorConnectionConfig.IsRds = true
 				migrationContext.ApplierConnectionConfig.IsRds = true
@@ -341,6 +440,29 @@ func main() {
 		}
 		log.Warning("--test-on-replica-skip-replica-stop enabled. We will not stop replication before cut-over. Ensure you have a plugin that does this.")
 	}
+	if migrationContext.ArchiveTableName != "" || migrationContext.ArchiveFile != "" {
+		if migrationContext.OriginalFilter == "" {
+			log.Fatalf("--archive-table and --archive-file require --origin-filter")
+		}
+		if migrationContext.ArchiveTableName != "" && migrationContext.ArchiveFile != "" {
+			log.Fatalf("--archive-table and --archive-file are mutually exclusive")
+		}
+		if migrationContext.ArchiveTableName == migrationContext.OriginalTableName {
+			log.Fatalf("--archive-table must differ from --table")
+		}
+	}
+	if migrationContext.Resume {
+		if migrationContext.Noop {
+			log.Fatalf("--resume requires --execute")
+		}
+		if migrationContext.InitiallyDropGhostTable {
+			log.Warning("--resume given; ignoring --initially-drop-ghost-table")
+			migrationContext.InitiallyDropGhostTable = false
+		}
+	}
+	if migrationContext.PreflightScan && migrationContext.Resume {
+		log.Fatalf("--preflight-scan and --resume are mutually exclusive")
+	}
 	if migrationContext.CliMasterUser != "" && migrationContext.AssumeMasterHostname == "" {
 		log.Fatalf("--master-user requires --assume-master-host")
 	}
@@ -348,6 +470,17 @@ func main() {
 		log.Fatalf("--master-password requires --assume-master-host")
 	}
 
+	switch migrationContext.OnDDL {
+	case logic.OnDDLAbort, logic.OnDDLPause:
+	default:
+		log.Fatalf("Unknown --on-ddl: %s", migrationContext.OnDDL)
+	}
+	switch migrationContext.HooksURLFailurePolicy {
+	case logic.HooksURLFailurePolicyAbort, logic.HooksURLFailurePolicyIgnore:
+	default:
+		log.Fatalf("Unknown --hooks-url-failure-policy: %s", migrationContext.HooksURLFailurePolicy)
+	}
+
 	switch *cutOver {
 	case "atomic", "default", "":
 		migrationContext.CutOverType = base.CutOverAtomic
@@ -362,12 +495,30 @@ func main() {
 	if err := migrationContext.ReadThrottleControlReplicaKeys(throttleControlReplicasValue); err != nil {
 		log.Fatale(err)
 	}
+	if err := migrationContext.ReplicaDiscovery.ReadInclude(*discoverReplicasInclude); err != nil {
+		log.Fatale(err)
+	}
+	if err := migrationContext.ReplicaDiscovery.ReadExclude(*discoverReplicasExclude); err != nil {
+		log.Fatale(err)
+	}
+	if err := migrationContext.ReplicaDiscovery.ReadAddressMapping(*discoverReplicasAddressMapping); err != nil {
+		log.Fatale(err)
+	}
+	if migrationContext.ReplicaDiscovery.IntervalSeconds < 1 {
+		log.Fatalf("--discover-replicas-interval-seconds must be positive")
+	}
 	if err := migrationContext.ReadMaxLoad(maxLoadValue); err != nil {
 		log.Fatale(err)
 	}
 	if err := migrationContext.ReadCriticalLoad(criticalLoadValue); err != nil {
 		log.Fatale(err)
 	}
+	if err := migrationContext.ReadCopyWindows(*copyWindow); err != nil {
+		log.Fatale(err)
+	}
+	if err := migrationContext.ReadCutOverWindows(*cutOverWindow); err != nil {
+		log.Fatale(err)
+	}
 	if migrationContext.ServeSocketFile == "" {
 		migrationContext.ServeSocketFile = fmt.Sprintf("/tmp/gh-ost.%s.%s.sock", migrationContext.DatabaseName, migrationContext.OriginalTableName)
 	}
@@ -382,8 +533,14 @@ func main() {
 		migrationContext.CliPassword = string(bytePassword)
 	}
 	migrationContext.SetHeartbeatIntervalMilliseconds(*heartbeatIntervalMillis)
+	migrationContext.SetCheckpointIntervalSeconds(*checkpointIntervalSeconds)
 	migrationContext.SetNiceRatio(*niceRatio)
 	migrationContext.SetChunkSize(chunkSizeValue)
+	if err := migrationContext.SetChunkTargetMillis(*chunkTargetMillis); err != nil {
+		log.Fatale(err)
+	}
+	migrationContext.SetCopyWorkers(*copyWorkers)
+	migrationContext.SetDMLApplyWorkers(*dmlApplyWorkers)
 	migrationContext.SetDMLBatchSize(*dmlBatchSize)
 	migrationContext.SetMaxLagMillisecondsThrottleThreshold(maxLagMillisValue)
 
@@ -408,6 +565,10 @@ func main() {
 	if *partitionOpt {
 		migrationContext.PartitionOpt = *partitionOpt
 	}
+	if *partitionWorkers > 1 && !migrationContext.PartitionOpt {
+		log.Fatalf("--partition-workers requires --partition-opt")
+	}
+	migrationContext.SetPartitionWorkers(*partitionWorkers)
 
 	log.Infof("starting gh-ost %+v", AppVersion)
 	acceptSignals(migrationContext)
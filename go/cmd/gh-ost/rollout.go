@@ -0,0 +1,300 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/github/gh-ost/go/base"
+	"github.com/outbrain/golib/log"
+)
+
+type rolloutStatus string
+
+const (
+	rolloutPending rolloutStatus = "pending"
+	rolloutSuccess rolloutStatus = "success"
+	rolloutFailed  rolloutStatus = "failed"
+	rolloutSkipped rolloutStatus = "skipped"
+)
+
+// rolloutFlags are consumed by the rollout itself and not passed on to the per-alias migrations
+var rolloutFlags = map[string]bool{
+	"db-aliases":          true,
+	"db-alias":            true,
+	"rollout-concurrency": true,
+	"rollout-canary":      true,
+	"replica-server-id":   true,
+	"serve-metrics-port":  true,
+}
+
+// rolloutCredentialFlags are passed on to the per-alias migrations via the environment (by flag name, as
+// environment variable), rather than on the command line, where they would show in `ps`
+var rolloutCredentialFlags = map[string]string{
+	"password":        "GH_OST_ROLLOUT_PASSWORD",
+	"master-password": "GH_OST_ROLLOUT_MASTER_PASSWORD",
+}
+
+// readRolloutCredentials sets the credential flags passed on by a rollout via the environment, unless
+// given on the command line. The variables are then unset, so that hooks do not inherit them
+func readRolloutCredentials() error {
+	visited := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { visited[f.Name] = true })
+	for name, variable := range rolloutCredentialFlags {
+		value, ok := os.LookupEnv(variable)
+		if !ok {
+			continue
+		}
+		os.Unsetenv(variable)
+		if visited[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rolloutMigration is the migration of a single alias, executed as a child gh-ost process with --db-alias
+type rolloutMigration struct {
+	alias           string
+	databaseName    string
+	hostname        string
+	port            int
+	replicaServerId uint
+	metricsPort     int64
+	status          rolloutStatus
+	err             error
+	startTime       time.Time
+	endTime         time.Time
+}
+
+func (this *rolloutMigration) duration() time.Duration {
+	if this.startTime.IsZero() || this.endTime.IsZero() {
+		return 0
+	}
+	return this.endTime.Sub(this.startTime).Truncate(time.Second)
+}
+
+// Rollout runs the same migration on multiple aliases of the db config file, e.g. on all shards.
+// Each alias is migrated by a child gh-ost process, so that per-alias user/password and
+// slave_master_mapping apply exactly as they do with --db-alias.
+type Rollout struct {
+	config      *base.DatabaseConfig
+	migrations  []*rolloutMigration
+	concurrency int
+	canary      int
+	args        []string // 除 rollout 自身参数之外的命令行参数
+	env         []string // 密码等通过环境变量传递, 见 rolloutCredentialFlags
+
+	mutex  sync.Mutex
+	failed bool
+}
+
+func NewRollout(config *base.DatabaseConfig, aliases []string, concurrency int, canary int, replicaServerId uint, metricsPort int64) *Rollout {
+	rollout := &Rollout{
+		config:      config,
+		migrations:  []*rolloutMigration{},
+		concurrency: concurrency,
+		canary:      canary,
+	}
+	if rollout.concurrency < 1 {
+		rollout.concurrency = 1
+	}
+	for i, alias := range aliases {
+		databaseName, hostname, port := config.GetDB(alias)
+		migrationMetricsPort := metricsPort
+		if metricsPort > 0 {
+			// 并发的 migration 各自占用一个端口
+			migrationMetricsPort = metricsPort + int64(i)
+		}
+		rollout.migrations = append(rollout.migrations, &rolloutMigration{
+			alias:        alias,
+			databaseName: databaseName,
+			hostname:     hostname,
+			port:         port,
+			// 同一个host上并发的多个binlog reader不能使用相同的server id
+			replicaServerId: replicaServerId + uint(i),
+			metricsPort:     migrationMetricsPort,
+			status:          rolloutPending,
+		})
+	}
+	// 将用户显式指定的参数原样传递给每一个 migration
+	flag.Visit(func(f *flag.Flag) {
+		if rolloutFlags[f.Name] {
+			return
+		}
+		if variable, ok := rolloutCredentialFlags[f.Name]; ok {
+			rollout.env = append(rollout.env, fmt.Sprintf("%s=%s", variable, f.Value.String()))
+			return
+		}
+		rollout.args = append(rollout.args, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+	return rollout
+}
+
+// Run executes the canary wave (if any), then the rest of the aliases. No further migration
+// is started once a migration fails; running migrations are allowed to complete.
+func (this *Rollout) Run() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	waves := [][]*rolloutMigration{this.migrations}
+	if this.canary > 0 && this.canary < len(this.migrations) {
+		waves = [][]*rolloutMigration{this.migrations[:this.canary], this.migrations[this.canary:]}
+	}
+	for i, wave := range waves {
+		if len(waves) > 1 {
+			if i == 0 {
+				log.Infof(color.MagentaString("=== Rollout canary wave: %d aliases ==="), len(wave))
+			} else {
+				log.Infof(color.MagentaString("=== Rollout wave: %d aliases ==="), len(wave))
+			}
+		}
+		this.runWave(executable, wave)
+		if this.hasFailed() {
+			break
+		}
+	}
+	for _, migration := range this.migrations {
+		if migration.status == rolloutPending {
+			migration.status = rolloutSkipped
+		}
+	}
+
+	this.printSummary(os.Stdout)
+	if this.hasFailed() {
+		return fmt.Errorf("Rollout failed")
+	}
+	return nil
+}
+
+func (this *Rollout) hasFailed() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.failed
+}
+
+func (this *Rollout) runWave(executable string, wave []*rolloutMigration) {
+	migrations := make(chan *rolloutMigration)
+	var wg sync.WaitGroup
+	for i := 0; i < this.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for migration := range migrations {
+				this.runMigration(executable, migration)
+			}
+		}()
+	}
+	for _, migration := range wave {
+		if this.hasFailed() {
+			break
+		}
+		migrations <- migration
+	}
+	close(migrations)
+	wg.Wait()
+}
+
+func (this *Rollout) runMigration(executable string, migration *rolloutMigration) {
+	// 等待期间可能已经有其他 migration 失败
+	if this.hasFailed() {
+		return
+	}
+	args := append([]string{
+		fmt.Sprintf("--db-alias=%s", migration.alias),
+		fmt.Sprintf("--replica-server-id=%d", migration.replicaServerId),
+		fmt.Sprintf("--serve-metrics-port=%d", migration.metricsPort),
+	}, this.args...)
+
+	log.Infof("Starting migration on %s (%s@%s:%d)", migration.alias, migration.databaseName, migration.hostname, migration.port)
+	migration.startTime = time.Now()
+	migration.err = this.execute(executable, args, migration.alias)
+	migration.endTime = time.Now()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if migration.err != nil {
+		migration.status = rolloutFailed
+		this.failed = true
+		log.Errorf("Migration on %s failed: %s; no further migrations will be started", migration.alias, migration.err.Error())
+	} else {
+		migration.status = rolloutSuccess
+		log.Infof("Migration on %s complete in %+v", migration.alias, migration.duration())
+	}
+}
+
+// execute runs the child gh-ost process, prefixing its output lines with the alias
+func (this *Rollout) execute(executable string, args []string, alias string) error {
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), this.env...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	prefix := color.CyanString("[%s] ", alias)
+	for _, pipe := range []struct {
+		reader io.Reader
+		writer io.Writer
+	}{{stdout, os.Stdout}, {stderr, os.Stderr}} {
+		wg.Add(1)
+		go func(reader io.Reader, writer io.Writer) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(reader)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				fmt.Fprintf(writer, "%s%s\n", prefix, scanner.Text())
+			}
+		}(pipe.reader, pipe.writer)
+	}
+	// 必须先读完输出, 再调用 Wait
+	wg.Wait()
+	return cmd.Wait()
+}
+
+func (this *Rollout) printSummary(writer io.Writer) {
+	fmt.Fprintln(writer, "# Rollout summary")
+	w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ALIAS\tDATABASE\tHOST\tSTATUS\tDURATION\tERROR")
+	for _, migration := range this.migrations {
+		errorMessage := ""
+		if migration.err != nil {
+			errorMessage = migration.err.Error()
+		}
+		duration := ""
+		if migration.status == rolloutSuccess || migration.status == rolloutFailed {
+			duration = migration.duration().String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s:%d\t%s\t%s\t%s\n",
+			migration.alias, migration.databaseName, migration.hostname, migration.port,
+			strings.ToUpper(string(migration.status)), duration, errorMessage,
+		)
+	}
+	w.Flush()
+}
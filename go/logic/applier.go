@@ -56,7 +56,12 @@ type Applier struct {
 	connectionConfig  *mysql.ConnectionConfig
 	db                *gosql.DB
 	migrationContext  *base.MigrationContext
+	fileArchiver      *FileArchiver // --archive-file
 	finishedMigrating int64
+
+	// 同一批次中, 同一行(unique key)的binlog events合并之后再apply
+	coalesceDMLEvents bool
+	uniqueKeyOrdinals []int
 }
 
 func NewApplier(migrationContext *base.MigrationContext) *Applier {
@@ -85,6 +90,9 @@ func (this *Applier) InitDBConnections() (err error) {
 	if err := this.validateAndReadTimeZone(); err != nil {
 		return err
 	}
+	if err := this.readOriginalFilterLocation(); err != nil {
+		return err
+	}
 	if !this.migrationContext.AliyunRDS && !this.migrationContext.GoogleCloudPlatform {
 		if impliedKey, err := mysql.GetInstanceKey(this.db); err != nil {
 			return err
@@ -110,6 +118,21 @@ func (this *Applier) validateAndReadTimeZone() error {
 	return nil
 }
 
+// readOriginalFilterLocation sets the time zone in which --origin-filter compares TIMESTAMP columns
+// on binlog events: that of the applier sessions, which copy rows
+func (this *Applier) readOriginalFilterLocation() error {
+	if this.migrationContext.OriginalFilterEvaluator == nil {
+		return nil
+	}
+	var offsetSeconds int
+	query := `select time_to_sec(timediff(now(), utc_timestamp()))`
+	if err := this.db.QueryRow(query).Scan(&offsetSeconds); err != nil {
+		return err
+	}
+	this.migrationContext.OriginalFilterEvaluator.SetLocation(time.FixedZone(this.migrationContext.ApplierTimeZone, offsetSeconds))
+	return nil
+}
+
 // readTableColumns reads table columns on applier
 func (this *Applier) readTableColumns() (err error) {
 	log.Infof("Examining table structure on applier")
@@ -137,15 +160,32 @@ func (this *Applier) tableExists(tableName string) (tableFound bool) {
 	return (m != nil)
 }
 
+// ValidateResumableTables verifies ghost and changelog tables, left over by an interrupted
+// migration, both exist. They are reused as they are with `--resume`.
+func (this *Applier) ValidateResumableTables() error {
+	if !this.tableExists(this.migrationContext.GetGhostTableName()) {
+		return fmt.Errorf("--resume given, but ghost table %s does not exist", sql.EscapeName(this.migrationContext.GetGhostTableName()))
+	}
+	if !this.tableExists(this.migrationContext.GetChangelogTableName()) {
+		return fmt.Errorf("--resume given, but changelog table %s does not exist", sql.EscapeName(this.migrationContext.GetChangelogTableName()))
+	}
+	return nil
+}
+
 // ValidateOrDropExistingTables verifies ghost and changelog tables do not exist,
 // or attempts to drop them if instructed to.
+// With `--resume` the ghost table is expected to exist, and is left untouched.
 func (this *Applier) ValidateOrDropExistingTables() error {
-	if this.migrationContext.InitiallyDropGhostTable {
+	if this.migrationContext.Resume {
+		if err := this.ValidateResumableTables(); err != nil {
+			return err
+		}
+	} else if this.migrationContext.InitiallyDropGhostTable {
 		if err := this.DropGhostTable(); err != nil {
 			return err
 		}
 	}
-	if this.tableExists(this.migrationContext.GetGhostTableName()) {
+	if !this.migrationContext.Resume && this.tableExists(this.migrationContext.GetGhostTableName()) {
 		return fmt.Errorf("Table %s already exists. Panicking. Use --initially-drop-ghost-table to force dropping it, though I really prefer that you drop it or rename it away", sql.EscapeName(this.migrationContext.GetGhostTableName()))
 	}
 	if this.migrationContext.InitiallyDropOldTable {
@@ -166,10 +206,41 @@ func (this *Applier) ValidateOrDropExistingTables() error {
 	return nil
 }
 
+// InitiateArchive prepares the archive (table or file) of the rows excluded by --origin-filter.
+// An existing archive table is reused, e.g. when resuming or re-running a cleanup migration.
+func (this *Applier) InitiateArchive() error {
+	if this.migrationContext.ArchiveTableName != "" {
+		query := fmt.Sprintf(`create %s table if not exists %s.%s like %s.%s`,
+			this.migrationContext.StatementComment(),
+			sql.EscapeName(this.migrationContext.DatabaseName),
+			sql.EscapeName(this.migrationContext.ArchiveTableName),
+			sql.EscapeName(this.migrationContext.DatabaseName),
+			sql.EscapeName(this.migrationContext.OriginalTableName),
+		)
+		log.Infof("Archiving filtered out rows onto table %s.%s",
+			sql.EscapeName(this.migrationContext.DatabaseName),
+			sql.EscapeName(this.migrationContext.ArchiveTableName),
+		)
+		if _, err := sqlutils.ExecNoPrepare(this.db, query); err != nil {
+			return err
+		}
+	}
+	if this.migrationContext.ArchiveFile != "" {
+		fileArchiver, err := NewFileArchiver(this.migrationContext.ArchiveFile, this.archiveColumns())
+		if err != nil {
+			return err
+		}
+		this.fileArchiver = fileArchiver
+		log.Infof("Archiving filtered out rows onto file %s", this.migrationContext.ArchiveFile)
+	}
+	return nil
+}
+
 // CreateGhostTable creates the ghost table on the applier host
 func (this *Applier) CreateGhostTable() error {
 	// 1. create table like ...., 创建一个schema完全一样的table
-	query := fmt.Sprintf(`create /* gh-ost */ table %s.%s like %s.%s`,
+	query := fmt.Sprintf(`create %s table %s.%s like %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.GetGhostTableName()),
 		sql.EscapeName(this.migrationContext.DatabaseName),
@@ -227,7 +298,8 @@ func (this *Applier) GetPartitionInfos() ([]*sql.PartitionInfo, error) {
 
 // AlterGhost applies `alter` statement on ghost table
 func (this *Applier) AlterGhost() error {
-	query := fmt.Sprintf(`alter /* gh-ost */ table %s.%s %s`,
+	query := fmt.Sprintf(`alter %s table %s.%s %s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.GetGhostTableName()),
 		this.migrationContext.AlterStatement,
@@ -249,7 +321,7 @@ func (this *Applier) CreateChangelogTable() error {
 	if err := this.DropChangelogTable(); err != nil {
 		return err
 	}
-	query := fmt.Sprintf(`create /* gh-ost */ table %s.%s (
+	query := fmt.Sprintf(`create %s table %s.%s (
 			id bigint auto_increment,
 			last_update timestamp not null DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			hint varchar(64) charset ascii not null,
@@ -258,6 +330,7 @@ func (this *Applier) CreateChangelogTable() error {
 			unique key hint_uidx(hint)
 		) auto_increment=256
 		`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.GetChangelogTableName()),
 	)
@@ -274,7 +347,8 @@ func (this *Applier) CreateChangelogTable() error {
 
 // dropTable drops a given table on the applied host
 func (this *Applier) dropTable(tableName string) error {
-	query := fmt.Sprintf(`drop /* gh-ost */ table if exists %s.%s`,
+	query := fmt.Sprintf(`drop %s table if exists %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(tableName),
 	)
@@ -315,6 +389,8 @@ func (this *Applier) WriteChangelog(hint, value string) (string, error) {
 		explicitId = 2
 	case "throttle":
 		explicitId = 3
+	case "checkpoint":
+		explicitId = 4
 	}
 	query := fmt.Sprintf(`
 			insert /* gh-ost */ into %s.%s
@@ -341,6 +417,19 @@ func (this *Applier) WriteChangelogState(value string) (string, error) {
 	return this.WriteAndLogChangelog("state", value)
 }
 
+// WriteCheckpoint persists the migration progress onto the changelog table, to be used by `--resume`
+func (this *Applier) WriteCheckpoint(checkpoint *base.Checkpoint) error {
+	value, err := checkpoint.ToJSON()
+	if err != nil {
+		return err
+	}
+	if len(value) > 4096 {
+		return fmt.Errorf("Checkpoint too long (%d characters) to be written to changelog table", len(value))
+	}
+	_, err = this.WriteChangelog("checkpoint", value)
+	return err
+}
+
 // InitiateHeartbeat creates a heartbeat cycle, writing to the changelog table.
 // This is done asynchronously
 func (this *Applier) InitiateHeartbeat() {
@@ -398,68 +487,64 @@ func (this *Applier) ExecuteThrottleQuery() (int64, error) {
 }
 
 // ReadMigrationMinValues returns the minimum values to be iterated on rowcopy
-func (this *Applier) ReadMigrationMinValues(uniqueKey *sql.UniqueKey, partition *sql.PartitionInfo) (string, error) {
+func (this *Applier) ReadMigrationMinValues(uniqueKey *sql.UniqueKey, partition *sql.PartitionInfo) (*sql.ColumnValues, error) {
 	log.Debugf("Reading migration range according to key: %s", uniqueKey.Name)
 	query, err := sql.BuildUniqueKeyMinValuesPreparedQuery(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, partition, &uniqueKey.Columns)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	rows, err := this.db.Query(query)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close() // 必须关闭，否则存在db connection泄露
 	for rows.Next() {
-		this.migrationContext.MigrationRangeMinValues = sql.NewColumnValues(uniqueKey.Len())
-		if err = rows.Scan(this.migrationContext.MigrationRangeMinValues.ValuesPointers...); err != nil {
-			return "", err
+		minValues := sql.NewColumnValues(uniqueKey.Len())
+		if err = rows.Scan(minValues.ValuesPointers...); err != nil {
+			return nil, err
 		}
-		return this.migrationContext.MigrationRangeMinValues.String(), nil
+		return minValues, nil
 	}
-	return "", err
+	return nil, rows.Err()
 }
 
 // ReadMigrationMaxValues returns the maximum values to be iterated on rowcopy
-func (this *Applier) ReadMigrationMaxValues(uniqueKey *sql.UniqueKey, partition *sql.PartitionInfo) (string, error) {
+func (this *Applier) ReadMigrationMaxValues(uniqueKey *sql.UniqueKey, partition *sql.PartitionInfo) (*sql.ColumnValues, error) {
 	log.Debugf("Reading migration range according to key: %s", uniqueKey.Name)
 	query, err := sql.BuildUniqueKeyMaxValuesPreparedQuery(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, partition, &uniqueKey.Columns)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	rows, err := this.db.Query(query)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close() // 必须关闭
 
 	for rows.Next() {
-		this.migrationContext.MigrationRangeMaxValues = sql.NewColumnValues(uniqueKey.Len())
-		if err = rows.Scan(this.migrationContext.MigrationRangeMaxValues.ValuesPointers...); err != nil {
-			return "", err
+		maxValues := sql.NewColumnValues(uniqueKey.Len())
+		if err = rows.Scan(maxValues.ValuesPointers...); err != nil {
+			return nil, err
 		}
-		return this.migrationContext.MigrationRangeMaxValues.String(), nil
+		return maxValues, nil
 	}
-	return "", err
+	return nil, rows.Err()
 }
 
-// ReadMigrationRangeValues reads min/max values that will be used for rowcopy
-func (this *Applier) ReadMigrationRangeValues(partition *sql.PartitionInfo) error {
-	var msgMin string
-	var msgMax string
-	var err error
-
-	// 读取全局的Range
-	if msgMin, err = this.ReadMigrationMinValues(this.migrationContext.UniqueKey, partition); err != nil {
+// ReadMigrationRangeValues reads min/max values that will be used for rowcopy of given range iteration
+func (this *Applier) ReadMigrationRangeValues(rangeIteration *base.RangeIteration) (err error) {
+	// 读取全局(或partition)的Range
+	if rangeIteration.RangeMinValues, err = this.ReadMigrationMinValues(this.migrationContext.UniqueKey, rangeIteration.Partition); err != nil {
 		return err
 	}
-	if msgMax, err = this.ReadMigrationMaxValues(this.migrationContext.UniqueKey, partition); err != nil {
+	if rangeIteration.RangeMaxValues, err = this.ReadMigrationMaxValues(this.migrationContext.UniqueKey, rangeIteration.Partition); err != nil {
 		return err
 	}
 
-	if partition != nil {
-		log.Infof("MigrationRange "+color.CyanString("%3s")+" ==> %s ~ %s", partition.PartitionName, msgMin, msgMax)
+	if rangeIteration.Partition != nil {
+		log.Infof("MigrationRange "+color.CyanString("%3s")+" ==> %s ~ %s", rangeIteration.Name(), rangeIteration.RangeMinValues, rangeIteration.RangeMaxValues)
 	} else {
-		log.Infof("MigrationRange %s ~ %s", msgMin, msgMax)
+		log.Infof("MigrationRange %s ~ %s", rangeIteration.RangeMinValues, rangeIteration.RangeMaxValues)
 	}
 	return nil
 }
@@ -468,31 +553,32 @@ func (this *Applier) ReadMigrationRangeValues(partition *sql.PartitionInfo) erro
 // which will be used for copying the next chunk of rows. Ir returns "false" if there is
 // no further chunk to work through, i.e. we're past the last chunk and are done with
 // iterating the range (and this done with copying row chunks)
-func (this *Applier) CalculateNextIterationRangeEndValues(partition *sql.PartitionInfo) (hasFurtherRange bool, err error) {
+func (this *Applier) CalculateNextIterationRangeEndValues(rangeIteration *base.RangeIteration) (hasFurtherRange bool, err error) {
 
 	// 1. 当前iteration的min value是上次iteration的max value
-	this.migrationContext.MigrationIterationRangeMinValues = this.migrationContext.MigrationIterationRangeMaxValues
+	iterationRangeMinValues := rangeIteration.IterationRangeMaxValues
 	//    边界情况
-	if this.migrationContext.MigrationIterationRangeMinValues == nil {
-		this.migrationContext.MigrationIterationRangeMinValues = this.migrationContext.MigrationRangeMinValues
+	if iterationRangeMinValues == nil {
+		iterationRangeMinValues = rangeIteration.RangeMinValues
 	}
+	rangeIteration.SetIterationRange(iterationRangeMinValues, rangeIteration.IterationRangeMaxValues)
 	for i := 0; i < 2; i++ {
 		buildFunc := sql.BuildUniqueKeyRangeEndPreparedQueryViaOffset
 		if i == 1 {
 			buildFunc = sql.BuildUniqueKeyRangeEndPreparedQueryViaTemptable
 		}
 
-		// 给定(MigrationIterationRangeMinValues, MigrationRangeMaxValues, ChunkSize) 得到 iterationRangeMaxValues
+		// 给定(IterationRangeMinValues, RangeMaxValues, ChunkSize) 得到 iterationRangeMaxValues
 		query, explodedArgs, err := buildFunc(
 			this.migrationContext.DatabaseName,
 			this.migrationContext.OriginalTableName,
-			partition,
+			rangeIteration.Partition,
 			&this.migrationContext.UniqueKey.Columns,
-			this.migrationContext.MigrationIterationRangeMinValues.AbstractValues(),
-			this.migrationContext.MigrationRangeMaxValues.AbstractValues(),
+			iterationRangeMinValues.AbstractValues(),
+			rangeIteration.RangeMaxValues.AbstractValues(),
 			atomic.LoadInt64(&this.migrationContext.ChunkSize),
-			this.migrationContext.GetIteration() == 0,
-			fmt.Sprintf("iteration:%d", this.migrationContext.GetIteration()),
+			rangeIteration.GetIteration() == 0,
+			fmt.Sprintf("iteration:%s:%d", rangeIteration.Name(), rangeIteration.GetIteration()),
 		)
 		if err != nil {
 			return hasFurtherRange, err
@@ -509,7 +595,7 @@ func (this *Applier) CalculateNextIterationRangeEndValues(partition *sql.Partiti
 			hasFurtherRange = true
 		}
 		if hasFurtherRange {
-			this.migrationContext.MigrationIterationRangeMaxValues = iterationRangeMaxValues
+			rangeIteration.SetIterationRange(iterationRangeMinValues, iterationRangeMaxValues)
 			return hasFurtherRange, nil
 		}
 	}
@@ -519,9 +605,161 @@ func (this *Applier) CalculateNextIterationRangeEndValues(partition *sql.Partiti
 	return hasFurtherRange, nil
 }
 
+// ReadChecksumRangeValues reads min/max unique key values of the original table for checksum verification.
+// Unlike ReadMigrationRangeValues, it does not touch the row-copy state of any RangeIteration
+func (this *Applier) ReadChecksumRangeValues() (minValues *sql.ColumnValues, maxValues *sql.ColumnValues, err error) {
+	uniqueKey := this.migrationContext.UniqueKey
+	minQuery, err := sql.BuildUniqueKeyMinValuesPreparedQuery(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, nil, &uniqueKey.Columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	if minValues, err = this.readUniqueKeyValues(minQuery); err != nil {
+		return nil, nil, err
+	}
+	maxQuery, err := sql.BuildUniqueKeyMaxValuesPreparedQuery(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, nil, &uniqueKey.Columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	if maxValues, err = this.readUniqueKeyValues(maxQuery); err != nil {
+		return nil, nil, err
+	}
+	return minValues, maxValues, nil
+}
+
+// readUniqueKeyValues returns the unique key values of the single row returned by given query, or nil if no row returned
+func (this *Applier) readUniqueKeyValues(query string, args ...interface{}) (*sql.ColumnValues, error) {
+	rows, err := this.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values *sql.ColumnValues
+	for rows.Next() {
+		values = sql.NewColumnValues(this.migrationContext.UniqueKey.Len())
+		if err = rows.Scan(values.ValuesPointers...); err != nil {
+			return nil, err
+		}
+	}
+	return values, rows.Err()
+}
+
+// CalculateChecksumRangeEndValues returns the end of the next checksum chunk, which starts at rangeStartValues.
+// It returns nil when there is no further chunk up to rangeMaxValues.
+func (this *Applier) CalculateChecksumRangeEndValues(rangeStartValues, rangeMaxValues *sql.ColumnValues, includeRangeStartValues bool) (*sql.ColumnValues, error) {
+	query, explodedArgs, err := sql.BuildUniqueKeyRangeEndPreparedQueryViaOffset(
+		this.migrationContext.DatabaseName,
+		this.migrationContext.OriginalTableName,
+		nil,
+		&this.migrationContext.UniqueKey.Columns,
+		rangeStartValues.AbstractValues(),
+		rangeMaxValues.AbstractValues(),
+		atomic.LoadInt64(&this.migrationContext.ChunkSize),
+		includeRangeStartValues,
+		"checksum",
+	)
+	if err != nil {
+		return nil, err
+	}
+	rangeEndValues, err := this.readUniqueKeyValues(query, explodedArgs...)
+	if err != nil || rangeEndValues != nil {
+		return rangeEndValues, err
+	}
+	// 最后一个chunk不足ChunkSize行时, offset 方式拿不到结果, 使用 temptable 方式
+	query, explodedArgs, err = sql.BuildUniqueKeyRangeEndPreparedQueryViaTemptable(
+		this.migrationContext.DatabaseName,
+		this.migrationContext.OriginalTableName,
+		nil,
+		&this.migrationContext.UniqueKey.Columns,
+		rangeStartValues.AbstractValues(),
+		rangeMaxValues.AbstractValues(),
+		atomic.LoadInt64(&this.migrationContext.ChunkSize),
+		includeRangeStartValues,
+		"checksum",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return this.readUniqueKeyValues(query, explodedArgs...)
+}
+
+// ChecksumColumns returns the shared columns which can be checksummed on both tables, as named on the original
+// and on the ghost table: those of identical type and charset. The textual value of a column whose type the migration
+// changes (e.g. `int` to `bigint`, `datetime` to `timestamp`, a charset conversion) may legitimately differ, hence such
+// columns are returned as skipped.
+func (this *Applier) ChecksumColumns() (originalColumns []string, ghostColumns []string, skippedColumns []string) {
+	mappedColumns := this.migrationContext.MappedSharedColumns.Columns()
+	for i, column := range this.migrationContext.SharedColumns.Columns() {
+		mappedColumn := mappedColumns[i]
+		if column.MySQLType != mappedColumn.MySQLType || column.Charset != mappedColumn.Charset {
+			skippedColumns = append(skippedColumns, column.Name)
+			continue
+		}
+		originalColumns = append(originalColumns, column.Name)
+		ghostColumns = append(ghostColumns, mappedColumn.Name)
+	}
+	return originalColumns, ghostColumns, skippedColumns
+}
+
+// mappedUniqueKeyColumns returns the migration unique key columns as named on the ghost table
+func (this *Applier) mappedUniqueKeyColumns() *sql.ColumnList {
+	mappedColumns := this.migrationContext.MappedSharedColumns.Columns()
+	names := []string{}
+	for _, name := range this.migrationContext.UniqueKey.Columns.Names() {
+		if ordinal, ok := this.migrationContext.SharedColumns.Ordinals[name]; ok {
+			name = mappedColumns[ordinal].Name
+		}
+		names = append(names, name)
+	}
+	return sql.NewColumnList(names)
+}
+
+// ChecksumRange computes row count & checksum over given columns (see ChecksumColumns()) of the given unique key range,
+// on the original table (respecting OriginalFilter) or on the ghost table
+func (this *Applier) ChecksumRange(onGhostTable bool, columns []string, rangeStartValues, rangeEndValues *sql.ColumnValues, includeRangeStartValues bool) (count int64, checksum int64, err error) {
+	tableName := this.migrationContext.OriginalTableName
+	filterCondition := this.migrationContext.OriginalFilter
+	uniqueKey := this.migrationContext.UniqueKey.Name
+	uniqueKeyColumns := &this.migrationContext.UniqueKey.Columns
+	if onGhostTable {
+		// 被过滤掉的数据不会出现在ghost表中, 因此ghost表上不需要filter
+		tableName = this.migrationContext.GetGhostTableName()
+		filterCondition = ""
+		uniqueKey = ""
+		uniqueKeyColumns = this.mappedUniqueKeyColumns()
+	}
+	query, explodedArgs, err := sql.BuildRangeChecksumPreparedQuery(
+		this.migrationContext.DatabaseName,
+		tableName,
+		filterCondition,
+		columns,
+		uniqueKey,
+		uniqueKeyColumns,
+		rangeStartValues.AbstractValues(),
+		rangeEndValues.AbstractValues(),
+		includeRangeStartValues,
+	)
+	if err != nil {
+		return count, checksum, err
+	}
+	err = this.db.QueryRow(query, explodedArgs...).Scan(&count, &checksum)
+	return count, checksum, err
+}
+
 // ApplyIterationInsertQuery issues a chunk-INSERT query on the ghost table. It is where
 // data actually gets copied from original table.
-func (this *Applier) ApplyIterationInsertQuery(partition *sql.PartitionInfo) (chunkSize int64, rowsAffected int64, duration time.Duration, err error) {
+func (this *Applier) ApplyIterationInsertQuery(rangeIteration *base.RangeIteration) (chunkSize int64, rowsAffected int64, duration time.Duration, err error) {
+	return this.ApplyRangeInsertQuery(
+		rangeIteration.Partition,
+		rangeIteration.IterationRangeMinValues,
+		rangeIteration.IterationRangeMaxValues,
+		rangeIteration.GetIteration() == 0,
+	)
+}
+
+// ApplyRangeInsertQuery issues a chunk-INSERT query on the ghost table for the given range.
+// Unlike ApplyIterationInsertQuery it does not read the iteration state, and can be used concurrently (--copy-workers)
+func (this *Applier) ApplyRangeInsertQuery(partition *sql.PartitionInfo, rangeMinValues, rangeMaxValues *sql.ColumnValues, includeRangeStartValues bool) (chunkSize int64, rowsAffected int64, duration time.Duration, err error) {
 	startTime := time.Now()
 	chunkSize = atomic.LoadInt64(&this.migrationContext.ChunkSize)
 
@@ -538,9 +776,9 @@ func (this *Applier) ApplyIterationInsertQuery(partition *sql.PartitionInfo) (ch
 		this.migrationContext.MappedSharedColumns.Names(),
 		this.migrationContext.UniqueKey.Name,
 		&this.migrationContext.UniqueKey.Columns,
-		this.migrationContext.MigrationIterationRangeMinValues.AbstractValues(),
-		this.migrationContext.MigrationIterationRangeMaxValues.AbstractValues(),
-		this.migrationContext.GetIteration() == 0,
+		rangeMinValues.AbstractValues(),
+		rangeMaxValues.AbstractValues(),
+		includeRangeStartValues,
 		this.migrationContext.IsTransactionalTable(),
 	)
 
@@ -548,6 +786,8 @@ func (this *Applier) ApplyIterationInsertQuery(partition *sql.PartitionInfo) (ch
 		return chunkSize, rowsAffected, duration, err
 	}
 
+	var rowsArchived int64
+	var archivedRows [][]interface{}
 	sqlResult, err := func() (gosql.Result, error) {
 		tx, err := this.db.Begin()
 		if err != nil {
@@ -573,6 +813,10 @@ func (this *Applier) ApplyIterationInsertQuery(partition *sql.PartitionInfo) (ch
 		if err != nil {
 			return nil, err
 		}
+		// 同一个事务内: 被filter排除的数据写入archive
+		if rowsArchived, archivedRows, err = this.archiveRange(tx, partition, rangeMinValues, rangeMaxValues, includeRangeStartValues); err != nil {
+			return nil, err
+		}
 		if err := tx.Commit(); err != nil {
 			return nil, err
 		}
@@ -582,23 +826,200 @@ func (this *Applier) ApplyIterationInsertQuery(partition *sql.PartitionInfo) (ch
 	if err != nil {
 		return chunkSize, rowsAffected, duration, err
 	}
+	if this.fileArchiver != nil && len(archivedRows) > 0 {
+		// 事务提交之后再写文件, 避免重试时写入重复数据
+		if err := this.fileArchiver.Archive(archivedRows); err != nil {
+			return chunkSize, rowsAffected, duration, err
+		}
+	}
+	atomic.AddInt64(&this.migrationContext.TotalRowsArchived, rowsArchived)
 	rowsAffected, _ = sqlResult.RowsAffected()
 	duration = time.Since(startTime)
 	log.Debugf(
 		"Issued INSERT on range: [%s]..[%s]; iteration: %d; chunk-size: %d",
-		this.migrationContext.MigrationIterationRangeMinValues,
-		this.migrationContext.MigrationIterationRangeMaxValues,
+		rangeMinValues,
+		rangeMaxValues,
 		this.migrationContext.GetIteration(),
 		chunkSize)
 	return chunkSize, rowsAffected, duration, nil
 }
 
+// archiveColumns are the columns of the original table, excluding virtual (generated) columns, which cannot be inserted
+func (this *Applier) archiveColumns() *sql.ColumnList {
+	virtualColumns := make(map[string]bool)
+	if this.migrationContext.OriginalTableVirtualColumns != nil {
+		for _, name := range this.migrationContext.OriginalTableVirtualColumns.Names() {
+			virtualColumns[name] = true
+		}
+	}
+	columns := []sql.Column{}
+	for _, column := range this.migrationContext.OriginalTableColumns.Columns() {
+		if !virtualColumns[column.Name] {
+			columns = append(columns, column)
+		}
+	}
+	return sql.NewColumnListFromColumns(columns)
+}
+
+// isArchiving tells whether rows excluded by --origin-filter are archived
+func (this *Applier) isArchiving() bool {
+	return this.migrationContext.OriginalFilterEvaluator != nil && (this.migrationContext.ArchiveTableName != "" || this.fileArchiver != nil)
+}
+
+// buildDMLEventsArchiveQueries archives rows which binlog events exclude from the ghost table, after their chunk
+// may have been copied: rows inserted failing --origin-filter, and rows updated so as to fail it, are written
+// (as updated) onto the archive table and returned for the archive file. Rows updated so as to pass the filter
+// again are removed from the archive table. Queries are in event order, and run within the events transaction.
+// The rowsDelta of a query is the change it intends in the number of archived rows: 1 to archive, -1 to unarchive.
+func (this *Applier) buildDMLEventsArchiveQueries(dmlEvents [](*binlog.BinlogDMLEvent)) (results [](*dmlBuildResult), archivedRows [][]interface{}) {
+	if !this.isArchiving() {
+		return results, archivedRows
+	}
+	tableColumns := this.migrationContext.OriginalTableColumns
+	archiveColumns := this.archiveColumns()
+	archive := func(values *sql.ColumnValues) {
+		if this.migrationContext.ArchiveTableName != "" {
+			query, args, err := sql.BuildDMLInsertQuery(this.migrationContext.DatabaseName, this.migrationContext.ArchiveTableName, tableColumns, archiveColumns, archiveColumns, values.AbstractValues())
+			results = append(results, newDmlBuildResult(query, args, 1, err))
+		}
+		row := []interface{}{}
+		for _, column := range archiveColumns.Columns() {
+			row = append(row, values.AbstractValues()[tableColumns.Ordinals[column.Name]])
+		}
+		archivedRows = append(archivedRows, row)
+	}
+	unarchive := func(values *sql.ColumnValues) {
+		if this.migrationContext.ArchiveTableName != "" {
+			query, args, err := sql.BuildDMLDeleteQuery(this.migrationContext.DatabaseName, this.migrationContext.ArchiveTableName, tableColumns, &this.migrationContext.UniqueKey.Columns, values.AbstractValues())
+			results = append(results, newDmlBuildResult(query, args, -1, err))
+		}
+	}
+
+	for _, dmlEvent := range dmlEvents {
+		switch dmlEvent.DML {
+		case binlog.InsertDML:
+			matches, err := this.matchesOriginalFilter(dmlEvent.NewColumnValues)
+			if err != nil {
+				return append(results, newDmlBuildResultError(err)), nil
+			}
+			if !matches {
+				archive(dmlEvent.NewColumnValues)
+			}
+		case binlog.UpdateDML:
+			newMatches, err := this.matchesOriginalFilter(dmlEvent.NewColumnValues)
+			if err != nil {
+				return append(results, newDmlBuildResultError(err)), nil
+			}
+			whereMatches, err := this.matchesOriginalFilter(dmlEvent.WhereColumnValues)
+			if err != nil {
+				return append(results, newDmlBuildResultError(err)), nil
+			}
+			if !whereMatches {
+				// 更新之前已经被排除(可能已经archive): 以更新之后的row为准
+				unarchive(dmlEvent.WhereColumnValues)
+			}
+			if !newMatches {
+				archive(dmlEvent.NewColumnValues)
+			}
+		}
+	}
+	return results, archivedRows
+}
+
+// archivedRowsDelta returns the change in the number of rows of the archive table, made by an archive query (as built
+// by buildDMLEventsArchiveQueries) which affected given rows: archiving a row already archived REPLACEs it, affecting
+// 2 rows and archiving none; unarchiving a row which is not archived affects none.
+func archivedRowsDelta(buildResult *dmlBuildResult, rowsAffected int64) int64 {
+	if buildResult.rowsDelta < 0 {
+		return -rowsAffected
+	}
+	if rowsAffected == 1 {
+		return 1
+	}
+	return 0
+}
+
+// archiveRange copies the rows of the given range which do not pass --origin-filter
+// onto the archive table, and/or reads them for the archive file. It runs within the row-copy transaction.
+func (this *Applier) archiveRange(tx *gosql.Tx, partition *sql.PartitionInfo, rangeMinValues, rangeMaxValues *sql.ColumnValues, includeRangeStartValues bool) (rowsArchived int64, archivedRows [][]interface{}, err error) {
+	if this.migrationContext.OriginalFilter == "" {
+		return 0, nil, nil
+	}
+	complementFilter := sql.BuildComplementFilterCondition(this.migrationContext.OriginalFilter)
+	columnNames := this.archiveColumns().Names()
+
+	if this.migrationContext.ArchiveTableName != "" {
+		query, explodedArgs, err := sql.BuildRangeInsertPreparedQuery(
+			this.migrationContext.DatabaseName,
+			this.migrationContext.OriginalTableName,
+			this.migrationContext.ArchiveTableName,
+			partition,
+			complementFilter,
+			columnNames,
+			columnNames,
+			this.migrationContext.UniqueKey.Name,
+			&this.migrationContext.UniqueKey.Columns,
+			rangeMinValues.AbstractValues(),
+			rangeMaxValues.AbstractValues(),
+			includeRangeStartValues,
+			this.migrationContext.IsTransactionalTable(),
+		)
+		if err != nil {
+			return 0, nil, err
+		}
+		result, err := tx.Exec(query, explodedArgs...)
+		if err != nil {
+			return 0, nil, err
+		}
+		rowsArchived, _ = result.RowsAffected()
+	}
+
+	if this.fileArchiver != nil {
+		query, explodedArgs, err := sql.BuildRangeSelectPreparedQuery(
+			this.migrationContext.DatabaseName,
+			this.migrationContext.OriginalTableName,
+			partition,
+			complementFilter,
+			columnNames,
+			this.migrationContext.UniqueKey.Name,
+			&this.migrationContext.UniqueKey.Columns,
+			rangeMinValues.AbstractValues(),
+			rangeMaxValues.AbstractValues(),
+			includeRangeStartValues,
+			this.migrationContext.IsTransactionalTable(),
+		)
+		if err != nil {
+			return 0, nil, err
+		}
+		rows, err := tx.Query(query, explodedArgs...)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			values := sql.NewColumnValues(len(columnNames))
+			if err := rows.Scan(values.ValuesPointers...); err != nil {
+				return 0, nil, err
+			}
+			archivedRows = append(archivedRows, values.AbstractValues())
+		}
+		if err := rows.Err(); err != nil {
+			return 0, nil, err
+		}
+		if this.migrationContext.ArchiveTableName == "" {
+			rowsArchived = int64(len(archivedRows))
+		}
+	}
+	return rowsArchived, archivedRows, nil
+}
+
 // RenameTablesRollback renames back both table: original back to ghost,
 // _old back to original. This is used by `--test-on-replica`
 func (this *Applier) RenameTablesRollback() (renameError error) {
 	// Restoring tables to original names.
 	// We prefer the single, atomic operation:
-	query := fmt.Sprintf(`rename /* gh-ost */ table %s.%s to %s.%s, %s.%s to %s.%s`,
+	query := fmt.Sprintf(`rename %s table %s.%s to %s.%s, %s.%s to %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.OriginalTableName),
 		sql.EscapeName(this.migrationContext.DatabaseName),
@@ -613,7 +1034,8 @@ func (this *Applier) RenameTablesRollback() (renameError error) {
 		return nil
 	}
 	// But, if for some reason the above was impossible to do, we rename one by one.
-	query = fmt.Sprintf(`rename /* gh-ost */ table %s.%s to %s.%s`,
+	query = fmt.Sprintf(`rename %s table %s.%s to %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.OriginalTableName),
 		sql.EscapeName(this.migrationContext.DatabaseName),
@@ -623,7 +1045,8 @@ func (this *Applier) RenameTablesRollback() (renameError error) {
 	if _, err := sqlutils.ExecNoPrepare(this.db, query); err != nil {
 		renameError = err
 	}
-	query = fmt.Sprintf(`rename /* gh-ost */ table %s.%s to %s.%s`,
+	query = fmt.Sprintf(`rename %s table %s.%s to %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.GetOldTableName()),
 		sql.EscapeName(this.migrationContext.DatabaseName),
@@ -777,10 +1200,11 @@ func (this *Applier) CreateAtomicCutOverSentryTable() error {
 	}
 	tableName := this.migrationContext.GetOldTableName()
 
-	query := fmt.Sprintf(`create /* gh-ost */ table %s.%s (
+	query := fmt.Sprintf(`create %s table %s.%s (
 			id int auto_increment primary key
 		) engine=%s comment='%s'
 		`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(tableName),
 		this.migrationContext.TableEngine,
@@ -875,7 +1299,8 @@ func (this *Applier) AtomicCutOverMagicLock(sessionIdChan chan int64, tableLocke
 	// The magic table is here because we locked it. And we are the only ones allowed to drop it.
 	// And in fact, we will:
 	log.Infof("Dropping magic cut-over table")
-	query = fmt.Sprintf(`drop /* gh-ost */ table if exists %s.%s`,
+	query = fmt.Sprintf(`drop %s table if exists %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.GetOldTableName()),
 	)
@@ -924,7 +1349,8 @@ func (this *Applier) AtomicCutoverRename(sessionIdChan chan int64, tablesRenamed
 		return err
 	}
 
-	query = fmt.Sprintf(`rename /* gh-ost */ table %s.%s to %s.%s, %s.%s to %s.%s`,
+	query = fmt.Sprintf(`rename %s table %s.%s to %s.%s, %s.%s to %s.%s`,
+		this.migrationContext.StatementComment(),
 		sql.EscapeName(this.migrationContext.DatabaseName),
 		sql.EscapeName(this.migrationContext.OriginalTableName),
 		sql.EscapeName(this.migrationContext.DatabaseName),
@@ -945,6 +1371,113 @@ func (this *Applier) AtomicCutoverRename(sessionIdChan chan int64, tablesRenamed
 	return nil
 }
 
+// LockOriginalTable places a write lock on the original table, on a dedicated session which
+// is returned. This is used by the two-step cut-over; the same session must later issue
+// RenameOriginalToOld() and UnlockTables().
+func (this *Applier) LockOriginalTable() (lockSession *gosql.Tx, err error) {
+	tx, err := this.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	tableLockTimeoutSeconds := this.migrationContext.CutOverLockTimeoutSeconds * 2
+	log.Infof("Setting LOCK timeout as %d seconds", tableLockTimeoutSeconds)
+	query := fmt.Sprintf(`set session lock_wait_timeout:=%d`, tableLockTimeoutSeconds)
+	if _, err := tx.Exec(query); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`lock /* gh-ost */ tables %s.%s write`,
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.OriginalTableName),
+	)
+	log.Infof("Locking %s.%s",
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.OriginalTableName),
+	)
+	this.migrationContext.LockTablesStartTime = time.Now()
+	if _, err := tx.Exec(query); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	log.Infof("Table locked")
+	return tx, nil
+}
+
+// RenameOriginalToOld renames the original table to _del. It is the first step of the two-step
+// cut-over, issued on the session holding the lock on the original table. It uses ALTER TABLE ... RENAME,
+// as does upstream's SwapTablesQuickAndBumpy(): before MySQL 8.0.13, RENAME TABLE fails on a session
+// holding LOCK TABLES (ER_LOCK_OR_ACTIVE_TRANSACTION), whereas ALTER TABLE ... RENAME is allowed on a
+// table locked for WRITE
+func (this *Applier) RenameOriginalToOld(lockSession *gosql.Tx) error {
+	query := fmt.Sprintf(`alter %s table %s.%s rename %s.%s`,
+		this.migrationContext.StatementComment(),
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.OriginalTableName),
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.GetOldTableName()),
+	)
+	log.Infof("Renaming original table: %s", query)
+	this.migrationContext.RenameTablesStartTime = time.Now()
+	if _, err := lockSession.Exec(query); err != nil {
+		return err
+	}
+	log.Infof("Original table renamed")
+	return nil
+}
+
+// UnlockTables releases the locks held by given session, and releases the session itself
+func (this *Applier) UnlockTables(lockSession *gosql.Tx) error {
+	defer lockSession.Rollback()
+
+	log.Infof("Releasing lock from %s.%s",
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.OriginalTableName),
+	)
+	if _, err := lockSession.Exec(`unlock /* gh-ost */ tables`); err != nil {
+		return err
+	}
+	log.Infof("Tables unlocked")
+	return nil
+}
+
+// RenameGhostToOriginal renames the ghost table to the original table name. It is the second
+// step of the two-step cut-over. Until it completes, the original table does not exist.
+func (this *Applier) RenameGhostToOriginal() error {
+	query := fmt.Sprintf(`rename %s table %s.%s to %s.%s`,
+		this.migrationContext.StatementComment(),
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.GetGhostTableName()),
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.OriginalTableName),
+	)
+	log.Infof("Renaming ghost table: %s", query)
+	if _, err := sqlutils.ExecNoPrepare(this.db, query); err != nil {
+		return err
+	}
+	this.migrationContext.RenameTablesEndTime = time.Now()
+	log.Infof("Ghost table renamed")
+	return nil
+}
+
+// RenameOldToOriginal puts the original table back in place, after RenameOriginalToOld()
+// succeeded but RenameGhostToOriginal() failed. This rolls back the two-step cut-over.
+func (this *Applier) RenameOldToOriginal() error {
+	query := fmt.Sprintf(`rename %s table %s.%s to %s.%s`,
+		this.migrationContext.StatementComment(),
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.GetOldTableName()),
+		sql.EscapeName(this.migrationContext.DatabaseName),
+		sql.EscapeName(this.migrationContext.OriginalTableName),
+	)
+	log.Infof("Renaming back original table: %s", query)
+	if _, err := sqlutils.ExecNoPrepare(this.db, query); err != nil {
+		return err
+	}
+	log.Infof("Original table restored")
+	return nil
+}
+
 func (this *Applier) ShowStatusVariable(variableName string) (result int64, err error) {
 	query := fmt.Sprintf(`show global status like '%s'`, variableName)
 	if err := this.db.QueryRow(query).Scan(&variableName, &result); err != nil {
@@ -968,44 +1501,34 @@ func (this *Applier) updateModifiesUniqueKeyColumns(dmlEvent *binlog.BinlogDMLEv
 	return "", false
 }
 
+// matchesOriginalFilter checks whether a row (as read from a binlog event) passes --origin-filter
+func (this *Applier) matchesOriginalFilter(values *sql.ColumnValues) (bool, error) {
+	if this.migrationContext.OriginalFilterEvaluator == nil {
+		return true, nil
+	}
+	return this.migrationContext.OriginalFilterEvaluator.Matches(values)
+}
+
 // buildDMLEventQuery creates a query to operate on the ghost table, based on an intercepted binlog
 // event entry on the original table.
 func (this *Applier) buildDMLEventQuery(dmlEvent *binlog.BinlogDMLEvent) (results [](*dmlBuildResult)) {
-	switch dmlEvent.DML {
-	case binlog.DeleteDML:
-		{
-			query, uniqueKeyArgs, err := sql.BuildDMLDeleteQuery(dmlEvent.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, &this.migrationContext.UniqueKey.Columns, dmlEvent.WhereColumnValues.AbstractValues())
-			return append(results, newDmlBuildResult(query, uniqueKeyArgs, -1, err))
-		}
-	case binlog.InsertDML:
-		{
-			query, sharedArgs, err := sql.BuildDMLInsertQuery(dmlEvent.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, this.migrationContext.SharedColumns, this.migrationContext.MappedSharedColumns, dmlEvent.NewColumnValues.AbstractValues())
-			return append(results, newDmlBuildResult(query, sharedArgs, 1, err))
-		}
-	case binlog.UpdateDML:
-		{
-			// UpdateDML 如何发现UniqKey本身改变了，则需要演变成为一个Delete + Insert
-			if _, isModified := this.updateModifiesUniqueKeyColumns(dmlEvent); isModified {
-				dmlEvent.DML = binlog.DeleteDML
-				results = append(results, this.buildDMLEventQuery(dmlEvent)...)
-				dmlEvent.DML = binlog.InsertDML
-				results = append(results, this.buildDMLEventQuery(dmlEvent)...)
-				return results
-			}
-			query, sharedArgs, uniqueKeyArgs, err := sql.BuildDMLUpdateQuery(dmlEvent.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, this.migrationContext.SharedColumns, this.migrationContext.MappedSharedColumns, &this.migrationContext.UniqueKey.Columns, dmlEvent.NewColumnValues.AbstractValues(), dmlEvent.WhereColumnValues.AbstractValues())
-			args := sqlutils.Args()
-			args = append(args, sharedArgs...)
-			args = append(args, uniqueKeyArgs...)
-			return append(results, newDmlBuildResult(query, args, 0, err))
-		}
+	effects, err := this.dmlEventRowEffects(dmlEvent)
+	if err != nil {
+		return append(results, newDmlBuildResultError(err))
+	}
+	for _, effect := range effects {
+		results = append(results, this.buildRowEffectQuery(effect))
 	}
-	return append(results, newDmlBuildResultError(fmt.Errorf("Unknown dml event type: %+v", dmlEvent.DML)))
+	return results
 }
 
 // ApplyDMLEventQueries applies multiple DML queries onto the _ghost_ table
 func (this *Applier) ApplyDMLEventQueries(dmlEvents [](*binlog.BinlogDMLEvent)) error {
 
 	var totalDelta int64
+	var statements int64
+	var rowsArchived int64
+	var archivedRows [][]interface{}
 
 	err := func() error {
 		tx, err := this.db.Begin()
@@ -1029,18 +1552,33 @@ func (this *Applier) ApplyDMLEventQueries(dmlEvents [](*binlog.BinlogDMLEvent))
 		if _, err := tx.Exec(sessionQuery); err != nil {
 			return rollback(err)
 		}
+		exec := func(buildResult *dmlBuildResult) (rowsAffected int64, err error) {
+			if buildResult.err != nil {
+				return 0, buildResult.err
+			}
+			result, err := tx.Exec(buildResult.query, buildResult.args...)
+			if err != nil {
+				return 0, fmt.Errorf("%s; query=%s; args=%+v", err.Error(), buildResult.query, buildResult.args)
+			}
+			return result.RowsAffected()
+		}
 		// 如何处理dmlEvents呢?
-		for _, dmlEvent := range dmlEvents {
-			for _, buildResult := range this.buildDMLEventQuery(dmlEvent) {
-				if buildResult.err != nil {
-					return rollback(buildResult.err)
-				}
-				if _, err := tx.Exec(buildResult.query, buildResult.args...); err != nil {
-					err = fmt.Errorf("%s; query=%s; args=%+v", err.Error(), buildResult.query, buildResult.args)
-					return rollback(err)
-				}
-				totalDelta += buildResult.rowsDelta
+		var archiveResults [](*dmlBuildResult)
+		archiveResults, archivedRows = this.buildDMLEventsArchiveQueries(dmlEvents)
+		for _, buildResult := range this.buildDMLEventQueries(dmlEvents) {
+			if _, err := exec(buildResult); err != nil {
+				return rollback(err)
+			}
+			totalDelta += buildResult.rowsDelta
+			statements++
+		}
+		// archive的语句不计入 TotalDMLStatementsApplied
+		for _, buildResult := range archiveResults {
+			rowsAffected, err := exec(buildResult)
+			if err != nil {
+				return rollback(err)
 			}
+			rowsArchived += archivedRowsDelta(buildResult, rowsAffected)
 		}
 		if err := tx.Commit(); err != nil {
 			return err
@@ -1052,16 +1590,30 @@ func (this *Applier) ApplyDMLEventQueries(dmlEvents [](*binlog.BinlogDMLEvent))
 		return log.Errore(err)
 	}
 	// no error
+	if this.fileArchiver != nil && len(archivedRows) > 0 {
+		if err := this.fileArchiver.Archive(archivedRows); err != nil {
+			return log.Errore(err)
+		}
+		// archive file: 写入的行数
+		rowsArchived = int64(len(archivedRows))
+	}
+	atomic.AddInt64(&this.migrationContext.TotalRowsArchived, rowsArchived)
 	atomic.AddInt64(&this.migrationContext.TotalDMLEventsApplied, int64(len(dmlEvents)))
+	atomic.AddInt64(&this.migrationContext.TotalDMLStatementsApplied, statements)
 	if this.migrationContext.CountTableRows {
 		atomic.AddInt64(&this.migrationContext.RowsDeltaEstimate, totalDelta)
 	}
-	log.Debugf("ApplyDMLEventQueries() applied %d events in one transaction", len(dmlEvents))
+	log.Debugf("ApplyDMLEventQueries() applied %d events in one transaction, in %d statements", len(dmlEvents), statements)
 	return nil
 }
 
 func (this *Applier) Teardown() {
 	log.Debugf("Tearing down...")
 	this.db.Close()
+	if this.fileArchiver != nil {
+		if err := this.fileArchiver.Close(); err != nil {
+			log.Errore(err)
+		}
+	}
 	atomic.StoreInt64(&this.finishedMigrating, 1)
 }
@@ -0,0 +1,165 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"context"
+	gosql "database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/github/gh-ost/go/base"
+	"github.com/github/gh-ost/go/binlog"
+	"github.com/github/gh-ost/go/sql"
+	test "github.com/outbrain/golib/tests"
+)
+
+// testDB stands for the applier's database: it records the statements of each committed transaction, in commit
+// order, instead of running them
+type testDB struct {
+	mutex        sync.Mutex
+	transactions [][]string
+}
+
+func (this *testDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return &testConn{db: this}, nil
+}
+
+func (this *testDB) Driver() driver.Driver {
+	return testDriver{db: this}
+}
+
+// Transactions returns the committed transactions, each as a list of statements, e.g. `replace [1 a 1]`.
+// The session setup statement of each transaction is omitted
+func (this *testDB) Transactions() [][]string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.transactions
+}
+
+// TransactionOf returns the index of the transaction which ran given statement; -1 if none did
+func (this *testDB) TransactionOf(statement string) int {
+	for i, statements := range this.Transactions() {
+		for _, s := range statements {
+			if s == statement {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+type testDriver struct {
+	db *testDB
+}
+
+func (this testDriver) Open(name string) (driver.Conn, error) {
+	return this.db.Connect(context.Background())
+}
+
+type testConn struct {
+	db         *testDB
+	statements []string
+}
+
+func (this *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	statement := strings.Fields(query)[0]
+	if strings.EqualFold(statement, "set") {
+		return driver.RowsAffected(0), nil
+	}
+	values := []interface{}{}
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	this.statements = append(this.statements, fmt.Sprintf("%s %v", statement, values))
+	return driver.RowsAffected(1), nil
+}
+
+func (this *testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("Prepare is not supported by testConn")
+}
+
+func (this *testConn) Begin() (driver.Tx, error) {
+	this.statements = nil
+	return this, nil
+}
+
+func (this *testConn) Commit() error {
+	this.db.mutex.Lock()
+	defer this.db.mutex.Unlock()
+	this.db.transactions = append(this.db.transactions, this.statements)
+	return nil
+}
+
+func (this *testConn) Rollback() error {
+	return nil
+}
+
+func (this *testConn) Close() error {
+	return nil
+}
+
+// newTestApplier returns an applier whose transactions are recorded by the returned testDB
+func newTestApplier(migrationContext *base.MigrationContext) (*Applier, *testDB) {
+	db := &testDB{}
+	applier := NewApplier(migrationContext)
+	applier.db = gosql.OpenDB(db)
+	return applier, db
+}
+
+func TestApplyDMLEventQueries(t *testing.T) {
+	migrationContext := newTestMigrationContext("id")
+	applier, db := newTestApplier(migrationContext)
+	dmlEvents := [](*binlog.BinlogDMLEvent){
+		newTestInsert(1, "a", 1),
+		newTestUpdate(row(1, "a", 1), row(1, "a", 2)),
+		newTestUpdate(row(2, "b", 2), row(3, "b", 2)),
+	}
+	test.S(t).ExpectNil(applier.ApplyDMLEventQueries(dmlEvents))
+	test.S(t).ExpectEquals(fmt.Sprintf("%v", db.Transactions()), "[[replace [1 a 1] update [1 a 2 1] delete [2] replace [3 b 2]]]")
+	test.S(t).ExpectEquals(migrationContext.TotalDMLEventsApplied, int64(3))
+	test.S(t).ExpectEquals(migrationContext.TotalDMLStatementsApplied, int64(4))
+}
+
+func TestApplyDMLEventQueriesArchive(t *testing.T) {
+	migrationContext := newTestMigrationContext("id")
+	migrationContext.ArchiveTableName = "tbl_archive"
+	var err error
+	migrationContext.OriginalFilterEvaluator, err = sql.NewRowFilter("val < 10", migrationContext.OriginalTableColumns)
+	test.S(t).ExpectNil(err)
+	applier, db := newTestApplier(migrationContext)
+	dmlEvents := [](*binlog.BinlogDMLEvent){
+		newTestInsert(1, "a", 1),
+		newTestInsert(2, "b", 20),
+		newTestUpdate(row(3, "c", 30), row(3, "c", 40)),
+	}
+	test.S(t).ExpectNil(applier.ApplyDMLEventQueries(dmlEvents))
+	test.S(t).ExpectEquals(fmt.Sprintf("%v", db.Transactions()), "[[replace [1 a 1] delete [3] replace [2 b 20] delete [3] replace [3 c 40]]]")
+	// archive statements are not DML statements applied
+	test.S(t).ExpectEquals(migrationContext.TotalDMLStatementsApplied, int64(2))
+	test.S(t).ExpectEquals(migrationContext.TotalRowsArchived, int64(1))
+}
+
+func TestArchivedRowsDelta(t *testing.T) {
+	archive := newDmlBuildResult("replace", nil, 1, nil)
+	unarchive := newDmlBuildResult("delete", nil, -1, nil)
+	tests := []struct {
+		buildResult  *dmlBuildResult
+		rowsAffected int64
+		expected     int64
+	}{
+		{archive, 1, 1},
+		{archive, 2, 0},
+		{unarchive, 1, -1},
+		{unarchive, 0, 0},
+	}
+	for _, tt := range tests {
+		test.S(t).ExpectEquals(archivedRowsDelta(tt.buildResult, tt.rowsAffected), tt.expected)
+	}
+}
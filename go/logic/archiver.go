@@ -0,0 +1,112 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/github/gh-ost/go/sql"
+)
+
+// FileArchiver writes rows excluded by --origin-filter onto a local, gzip compressed, JSON-lines file:
+// one JSON object (column name => value) per row.
+// Values of binary columns (BINARY, VARBINARY, BLOB, BIT and spatial types) are base64 encoded, as they
+// are not necessarily valid UTF-8, whether read as []byte (row-copy) or string (binlog events); BIT values
+// decoded from binlog events as numbers are written as such. Values of other columns are written as text.
+// The file is opened for append, so that a resumed migration keeps on archiving onto the same file.
+// It is an event log: a row excluded by binlog events is written upon each update (the last line per unique key
+// wins), and never retracted, even once updated so as to pass the filter again.
+type FileArchiver struct {
+	fileName   string
+	columns    *sql.ColumnList
+	file       *os.File
+	gzipWriter *gzip.Writer
+	encoder    *json.Encoder
+	mutex      sync.Mutex
+}
+
+func NewFileArchiver(fileName string, columns *sql.ColumnList) (*FileArchiver, error) {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	gzipWriter := gzip.NewWriter(file)
+	return &FileArchiver{
+		fileName:   fileName,
+		columns:    columns,
+		file:       file,
+		gzipWriter: gzipWriter,
+		encoder:    json.NewEncoder(gzipWriter),
+	}, nil
+}
+
+// Archive writes given rows (values ordered as the archiver's columns) and flushes them onto the file
+func (this *FileArchiver) Archive(rows [][]interface{}) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	columns := this.columns.Columns()
+	for _, values := range rows {
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			row[column.Name] = archivedValue(column, values[i])
+		}
+		if err := this.encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	// 每个chunk都flush一次, 进程意外退出时已经archive的数据仍然可读
+	if err := this.gzipWriter.Flush(); err != nil {
+		return err
+	}
+	return this.file.Sync()
+}
+
+// archivedValue returns the JSON value of given column value
+func archivedValue(column sql.Column, value interface{}) interface{} {
+	if isBinaryColumn(column) {
+		// binlog events中的二进制值被解析为string, 而非[]byte
+		switch value := value.(type) {
+		case []byte:
+			return base64.StdEncoding.EncodeToString(value)
+		case string:
+			return base64.StdEncoding.EncodeToString([]byte(value))
+		}
+		return value
+	}
+	if bytes, ok := value.([]byte); ok {
+		// 文本(以及以文本形式读取的数字, 日期等)保持可读
+		return string(bytes)
+	}
+	return value
+}
+
+// isBinaryColumn tells whether the values of given column are arbitrary bytes rather than text
+func isBinaryColumn(column sql.Column) bool {
+	columnType := strings.ToLower(column.MySQLType)
+	for _, binaryType := range []string{"binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection", "geomcollection"} {
+		if columnType == binaryType || strings.HasPrefix(columnType, binaryType+"(") {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *FileArchiver) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if err := this.gzipWriter.Close(); err != nil {
+		return err
+	}
+	return this.file.Close()
+}
@@ -0,0 +1,64 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/gh-ost/go/sql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestFileArchiverArchive(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "archive.json.gz")
+	columns := sql.NewColumnListFromColumns([]sql.Column{
+		{Name: "id", MySQLType: "int(11)"},
+		{Name: "name", MySQLType: "varchar(32)"},
+		{Name: "data", MySQLType: "varbinary(16)"},
+	})
+	archiver, err := NewFileArchiver(fileName, columns)
+	test.S(t).ExpectNil(err)
+
+	invalidUTF8 := "\xff\xfe\x00a"
+	test.S(t).ExpectNil(archiver.Archive([][]interface{}{
+		// row-copy: values read as []byte
+		{[]byte("1"), []byte("row copy"), []byte(invalidUTF8)},
+		// binlog events: binary values decoded as string
+		{int32(2), "binlog event", invalidUTF8},
+		{int32(3), nil, nil},
+	}))
+	test.S(t).ExpectNil(archiver.Close())
+
+	file, err := os.Open(fileName)
+	test.S(t).ExpectNil(err)
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	test.S(t).ExpectNil(err)
+	decoder := json.NewDecoder(gzipReader)
+
+	tests := []struct {
+		id   interface{}
+		name interface{}
+		data interface{}
+	}{
+		{"1", "row copy", base64.StdEncoding.EncodeToString([]byte(invalidUTF8))},
+		{float64(2), "binlog event", base64.StdEncoding.EncodeToString([]byte(invalidUTF8))},
+		{float64(3), nil, nil},
+	}
+	for _, tt := range tests {
+		row := map[string]interface{}{}
+		test.S(t).ExpectNil(decoder.Decode(&row))
+		test.S(t).ExpectEquals(row["id"], tt.id)
+		test.S(t).ExpectEquals(row["name"], tt.name)
+		test.S(t).ExpectEquals(row["data"], tt.data)
+	}
+	test.S(t).ExpectFalse(decoder.More())
+}
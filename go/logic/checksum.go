@@ -0,0 +1,169 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/github/gh-ost/go/base"
+	"github.com/github/gh-ost/go/sql"
+	"github.com/outbrain/golib/log"
+)
+
+const checksumRecheckAttempts = 3
+
+// checksumRange is a unique key range of the original table, as checked by the Verifier
+type checksumRange struct {
+	start         *sql.ColumnValues
+	end           *sql.ColumnValues
+	includeStart  bool
+	originalCount int64
+	ghostCount    int64
+	originalCRC   int64
+	ghostCRC      int64
+}
+
+func (this *checksumRange) String() string {
+	startSign := "("
+	if this.includeStart {
+		startSign = "["
+	}
+	return fmt.Sprintf("%s%s ~ %s], rows: %d/%d, checksum: %d/%d", startSign, this.start, this.end,
+		this.originalCount, this.ghostCount, this.originalCRC, this.ghostCRC)
+}
+
+func (this *checksumRange) matches() bool {
+	return this.originalCount == this.ghostCount && this.originalCRC == this.ghostCRC
+}
+
+// Verifier compares the ghost table with the original table, chunk by chunk (along the migration unique key),
+// via row count & BIT_XOR(CRC32()) checksums over the shared columns whose type the migration does not change.
+// Chunks which do not match are re-checked after the binlog backlog is drained, as they may
+// have been modified in between reading the two tables.
+type Verifier struct {
+	migrationContext *base.MigrationContext
+	applier          *Applier
+	throttle         func()
+	drainBacklog     func() error
+
+	originalColumns []string
+	ghostColumns    []string
+}
+
+// NewVerifier creates a verifier. throttle is called before each chunk and drainBacklog before each
+// re-check of mismatching chunks; either may be nil (e.g. when replication is stopped on --test-on-replica)
+func NewVerifier(migrationContext *base.MigrationContext, applier *Applier, throttle func(), drainBacklog func() error) *Verifier {
+	return &Verifier{
+		migrationContext: migrationContext,
+		applier:          applier,
+		throttle:         throttle,
+		drainBacklog:     drainBacklog,
+	}
+}
+
+func (this *Verifier) checksum(checked *checksumRange) (err error) {
+	if checked.originalCount, checked.originalCRC, err = this.applier.ChecksumRange(false, this.originalColumns, checked.start, checked.end, checked.includeStart); err != nil {
+		return err
+	}
+	if checked.ghostCount, checked.ghostCRC, err = this.applier.ChecksumRange(true, this.ghostColumns, checked.start, checked.end, checked.includeStart); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Verify walks through the whole unique key range and returns an error when some chunks keep mismatching
+func (this *Verifier) Verify() error {
+	startTime := time.Now()
+	log.Infof(color.MagentaString("=== Verifying checksum of %s.%s vs. %s.%s ==="),
+		sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName),
+		sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.GetGhostTableName()))
+
+	var skippedColumns []string
+	this.originalColumns, this.ghostColumns, skippedColumns = this.applier.ChecksumColumns()
+	if len(skippedColumns) > 0 {
+		log.Infof("Not checksumming columns changing type: %s", strings.Join(skippedColumns, ", "))
+	}
+	if len(this.originalColumns) == 0 {
+		log.Warningf("No column keeps its type; verifying row counts only")
+	}
+
+	rangeMinValues, rangeMaxValues, err := this.applier.ReadChecksumRangeValues()
+	if err != nil {
+		return err
+	}
+	if rangeMinValues == nil || rangeMaxValues == nil {
+		log.Infof("Original table is empty; nothing to verify")
+		return nil
+	}
+
+	mismatches := []*checksumRange{}
+	var chunks int64
+	rangeStart := rangeMinValues
+	includeStart := true
+	for {
+		if this.throttle != nil {
+			this.throttle()
+		}
+		rangeEnd, err := this.applier.CalculateChecksumRangeEndValues(rangeStart, rangeMaxValues, includeStart)
+		if err != nil {
+			return err
+		}
+		if rangeEnd == nil {
+			break
+		}
+		checked := &checksumRange{start: rangeStart, end: rangeEnd, includeStart: includeStart}
+		if err := this.checksum(checked); err != nil {
+			return err
+		}
+		chunks++
+		if !checked.matches() {
+			log.Debugf("Checksum mismatch on range %s", checked)
+			mismatches = append(mismatches, checked)
+		}
+		rangeStart = rangeEnd
+		includeStart = false
+	}
+	log.Infof("Checksum verified %d chunks in %+v; %d mismatching", chunks, time.Since(startTime), len(mismatches))
+
+	// 不一致的chunk可能是因为两次读取之间有新的写入, binlog 追上之后再次比较
+	for i := 0; i < checksumRecheckAttempts && len(mismatches) > 0; i++ {
+		if this.drainBacklog != nil {
+			if err := this.drainBacklog(); err != nil {
+				return err
+			}
+		}
+		stillMismatching := []*checksumRange{}
+		for _, checked := range mismatches {
+			if this.throttle != nil {
+				this.throttle()
+			}
+			if err := this.checksum(checked); err != nil {
+				return err
+			}
+			if !checked.matches() {
+				stillMismatching = append(stillMismatching, checked)
+			}
+		}
+		mismatches = stillMismatching
+		log.Infof("Checksum re-check %d/%d: %d mismatching chunks", i+1, checksumRecheckAttempts, len(mismatches))
+	}
+
+	if len(mismatches) > 0 {
+		for _, checked := range mismatches {
+			log.Errorf("Checksum mismatch on %s range %s", this.migrationContext.UniqueKey.Name, checked)
+		}
+		return fmt.Errorf("Checksum verification failed: %d chunks of %s.%s differ from %s.%s",
+			len(mismatches),
+			sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.GetGhostTableName()),
+			sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName),
+		)
+	}
+	log.Infof(color.GreenString("Checksum verification passed: %d chunks in %+v"), chunks, time.Since(startTime))
+	return nil
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/github/gh-ost/go/base"
+	"github.com/outbrain/golib/log"
+)
+
+// copyWindowThrottleReason enforces --copy-window: it returns a throttle reason while row copy is outside the
+// windows. Entering a window applies its chunk-size/nice-ratio profile; leaving it restores the settings the
+// profile overrode, unless changed meanwhile. Windows no longer apply once row copy is complete.
+func (this *Throttler) copyWindowThrottleReason(now time.Time) string {
+	windows := this.migrationContext.GetCopyWindows()
+	if len(windows) == 0 || this.migrationContext.RowCopyComplete.Load().(bool) {
+		this.enterCopyWindow(nil)
+		return ""
+	}
+	window := windows.Find(now)
+	this.enterCopyWindow(window)
+	if window == nil {
+		return fmt.Sprintf("outside copy-window %s", windows)
+	}
+	return ""
+}
+
+// enterCopyWindow switches profiles as the window row copy is in changes. A nil window is no window at all.
+// Only the settings the window's profile sets are saved and restored; a setting changed while within the
+// window (e.g. via the `chunk-size=` or `nice-ratio=` interactive commands) is kept.
+func (this *Throttler) enterCopyWindow(window *base.TimeWindow) {
+	if window == this.copyWindow {
+		return
+	}
+	if previous := this.copyWindow; previous != nil && previous.HasProfile() {
+		log.Infof("Leaving copy-window %s", previous)
+		if previous.ChunkSize > 0 {
+			if chunkSize := atomic.LoadInt64(&this.migrationContext.ChunkSize); chunkSize == previous.ChunkSize {
+				log.Infof("Restoring chunk-size=%d", this.copyWindowSavedChunkSize)
+				this.migrationContext.SetChunkSize(this.copyWindowSavedChunkSize)
+			} else {
+				log.Infof("chunk-size changed to %d within copy-window; keeping it", chunkSize)
+			}
+		}
+		if previous.NiceRatio >= 0 {
+			if niceRatio := this.migrationContext.GetNiceRatio(); niceRatio == previous.NiceRatio {
+				log.Infof("Restoring nice-ratio=%f", this.copyWindowSavedNiceRatio)
+				this.migrationContext.SetNiceRatio(this.copyWindowSavedNiceRatio)
+			} else {
+				log.Infof("nice-ratio changed to %f within copy-window; keeping it", niceRatio)
+			}
+		}
+	}
+	this.copyWindow = window
+	if window == nil || !window.HasProfile() {
+		return
+	}
+	if window.ChunkSize > 0 {
+		this.copyWindowSavedChunkSize = atomic.LoadInt64(&this.migrationContext.ChunkSize)
+		this.migrationContext.SetChunkSize(window.ChunkSize)
+	}
+	if window.NiceRatio >= 0 {
+		this.copyWindowSavedNiceRatio = this.migrationContext.GetNiceRatio()
+		this.migrationContext.SetNiceRatio(window.NiceRatio)
+	}
+	log.Infof("Entering copy-window %s", window)
+}
+
+// cutOverWindowPostponeReason enforces --cut-over-window: it returns a reason to postpone cut-over while
+// outside the windows
+func (this *Migrator) cutOverWindowPostponeReason(now time.Time) string {
+	if windows := this.migrationContext.GetCutOverWindows(); !windows.Allows(now) {
+		return fmt.Sprintf("outside cut-over-window %s", windows)
+	}
+	return ""
+}
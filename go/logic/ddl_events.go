@@ -0,0 +1,71 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/github/gh-ost/go/binlog"
+	"github.com/outbrain/golib/log"
+)
+
+const (
+	OnDDLAbort = "abort"
+	OnDDLPause = "pause"
+)
+
+// addDDLEventsListener watches the binlog for DDL statements (ALTER, TRUNCATE, RENAME, DROP...) on the original,
+// ghost and changelog tables, issued by anyone but this migration: its own statements carry its token
+// (see MigrationContext.StatementComment()), which other migrations, even on the same tables, do not. Such statements invalidate the migration:
+// the ghost table no longer reflects the original table, and cut-over would corrupt data.
+func (this *Migrator) addDDLEventsListener() error {
+	return this.eventsStreamer.AddDDLListener(
+		this.migrationContext.DatabaseName,
+		[]string{
+			this.migrationContext.OriginalTableName,
+			this.migrationContext.GetGhostTableName(),
+			this.migrationContext.GetChangelogTableName(),
+		},
+		this.onDDLEvent,
+	)
+}
+
+// onDDLEvent aborts the migration, or, with --on-ddl=pause, blocks binlog streaming (and throttles row copy)
+// until the operator acknowledges the statement via the 'ack-ddl' interactive command, or panics.
+func (this *Migrator) onDDLEvent(ddlEvent *binlog.BinlogDDLEvent) error {
+	// gh-ost 自己执行的DDL (创建/alter ghost表, cut-over 的 rename 等) 都带有本次migration的token
+	if strings.Contains(ddlEvent.Statement, this.migrationContext.StatementComment()) {
+		return nil
+	}
+	err := fmt.Errorf("Detected %s at %+v, by a party other than this migration: %s", ddlEvent.DDL.Type, ddlEvent.Coordinates, ddlEvent.Statement)
+	if this.migrationContext.OnDDL != OnDDLPause {
+		this.migrationContext.PanicAbort <- err
+		return err
+	}
+
+	log.Errore(err)
+	log.Errorf("--on-ddl=%s: pausing binlog streaming and row copy. Make sure the migration is still valid, then issue 'ack-ddl' to continue, or 'panic' to abort", OnDDLPause)
+	atomic.StoreInt64(&this.migrationContext.UserCommandedAckDDLFlag, 0)
+	atomic.StoreInt64(&this.migrationContext.IsPausedOnDDL, 1)
+	defer atomic.StoreInt64(&this.migrationContext.IsPausedOnDDL, 0)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if atomic.LoadInt64(&this.finishedMigrating) > 0 {
+			return err
+		}
+		if atomic.LoadInt64(&this.migrationContext.UserCommandedAckDDLFlag) > 0 {
+			atomic.StoreInt64(&this.migrationContext.UserCommandedAckDDLFlag, 0)
+			break
+		}
+	}
+	log.Warningf("DDL at %+v acknowledged by user; resuming", ddlEvent.Coordinates)
+	return nil
+}
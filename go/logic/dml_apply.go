@@ -0,0 +1,122 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/github/gh-ost/go/binlog"
+	"github.com/outbrain/golib/log"
+)
+
+// initiateDMLApplyWorkers prepares --dml-apply-workers: binlog DML events are partitioned by hash of the values of
+// the migration unique key, such that all events of a given row are applied, in order, by the same worker.
+// Concurrent apply is only safe when rows are told apart by that key alone; otherwise events are applied by a
+// single worker.
+func (this *Migrator) initiateDMLApplyWorkers() {
+	if this.migrationContext.DMLApplyWorkers <= 1 {
+		return
+	}
+	fallback := func(reason string) {
+		log.Warningf("--dml-apply-workers=%d: %s. Binlog events will be applied by a single worker", this.migrationContext.DMLApplyWorkers, reason)
+		atomic.StoreInt64(&this.migrationContext.DMLApplyWorkers, 1)
+	}
+	if uniqueKey := ghostTableOtherUniqueKey(this.migrationContext); uniqueKey != nil {
+		fallback(fmt.Sprintf("ghost table has unique key %s besides the migration key %s", uniqueKey.Name, this.migrationContext.UniqueKey.Name))
+		return
+	}
+	// 字符类型的值在collation下相等(如大小写不同), 其hash却不同; 只用其他类型的列计算hash
+	this.dmlApplyHashOrdinals = []int{}
+	for _, column := range this.migrationContext.UniqueKey.Columns.Columns() {
+		if column.Charset != "" {
+			continue
+		}
+		this.dmlApplyHashOrdinals = append(this.dmlApplyHashOrdinals, this.migrationContext.OriginalTableColumns.Ordinals[column.Name])
+	}
+	if len(this.dmlApplyHashOrdinals) == 0 {
+		fallback(fmt.Sprintf("migration key %s only has character columns, whose values may be equal by collation", this.migrationContext.UniqueKey.Name))
+		return
+	}
+	log.Infof("Applying binlog events with %d workers, partitioned by %s", this.migrationContext.DMLApplyWorkers, this.migrationContext.UniqueKey.Name)
+}
+
+// dmlEventPartition returns the worker which applies given event. isBarrier is true for an UPDATE which modifies
+// the unique key: it touches two rows, possibly of different workers, and must be applied after all preceding
+// events, and before all following events.
+func (this *Migrator) dmlEventPartition(dmlEvent *binlog.BinlogDMLEvent, workers int) (partition int, isBarrier bool) {
+	values := dmlEvent.WhereColumnValues
+	switch dmlEvent.DML {
+	case binlog.InsertDML:
+		values = dmlEvent.NewColumnValues
+	case binlog.UpdateDML:
+		if _, isModified := this.applier.updateModifiesUniqueKeyColumns(dmlEvent); isModified {
+			return 0, true
+		}
+	}
+	hash := fnv.New32a()
+	abstractValues := values.AbstractValues()
+	for _, ordinal := range this.dmlApplyHashOrdinals {
+		fmt.Fprintf(hash, "%v\x00", abstractValues[ordinal])
+	}
+	return int(hash.Sum32() % uint32(workers)), false
+}
+
+// applyDMLEventsConcurrently applies given events via --dml-apply-workers connections, each applying its
+// partition of the events in a single transaction. Events of a row are applied in order, by the same worker.
+// It returns once all events are applied: callers get the same guarantees as with a single worker.
+func (this *Migrator) applyDMLEventsConcurrently(dmlEvents [](*binlog.BinlogDMLEvent)) error {
+	workers := int(this.migrationContext.DMLApplyWorkers)
+	partitions := make([][](*binlog.BinlogDMLEvent), workers)
+	flush := func() error {
+		err := this.applyDMLEventPartitions(partitions)
+		partitions = make([][](*binlog.BinlogDMLEvent), workers)
+		return err
+	}
+	for _, dmlEvent := range dmlEvents {
+		partition, isBarrier := this.dmlEventPartition(dmlEvent, workers)
+		if !isBarrier {
+			partitions[partition] = append(partitions[partition], dmlEvent)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		partitions[0] = [](*binlog.BinlogDMLEvent){dmlEvent}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// applyDMLEventPartitions applies each (non empty) partition on its own connection, concurrently,
+// and waits for all of them
+func (this *Migrator) applyDMLEventPartitions(partitions [][](*binlog.BinlogDMLEvent)) error {
+	var applyErr error
+	var applyErrOnce sync.Once
+	var wg sync.WaitGroup
+	for _, partition := range partitions {
+		if len(partition) == 0 {
+			continue
+		}
+		partition := partition
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var applyEventFunc tableWriteFunc = func() error {
+				return this.applier.ApplyDMLEventQueries(partition)
+			}
+			if err := this.retryOperation(applyEventFunc); err != nil {
+				applyErrOnce.Do(func() { applyErr = err })
+			}
+		}()
+	}
+	wg.Wait()
+	return applyErr
+}
@@ -0,0 +1,111 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"testing"
+
+	"github.com/github/gh-ost/go/base"
+	"github.com/github/gh-ost/go/binlog"
+	test "github.com/outbrain/golib/tests"
+)
+
+// newTestMigrator returns a migrator applying binlog events via an applier whose transactions are recorded
+// by the returned testDB
+func newTestMigrator(migrationContext *base.MigrationContext) (*Migrator, *testDB) {
+	migrator := NewMigrator(migrationContext)
+	var db *testDB
+	migrator.applier, db = newTestApplier(migrationContext)
+	return migrator, db
+}
+
+func TestDMLEventPartition(t *testing.T) {
+	migrationContext := newTestMigrationContext("id", "name")
+	migrationContext.UniqueKey.Columns.SetCharset("name", "utf8mb4")
+	migrationContext.DMLApplyWorkers = 4
+	migrator, _ := newTestMigrator(migrationContext)
+	migrator.initiateDMLApplyWorkers()
+	test.S(t).ExpectEquals(migrationContext.DMLApplyWorkers, int64(4))
+	test.S(t).ExpectEquals(len(migrator.dmlApplyHashOrdinals), 1)
+
+	insertPartition, isBarrier := migrator.dmlEventPartition(newTestInsert(7, "a", 1), 4)
+	test.S(t).ExpectFalse(isBarrier)
+
+	tests := []struct {
+		name      string
+		dmlEvent  *binlog.BinlogDMLEvent
+		isBarrier bool
+	}{
+		{"update", newTestUpdate(row(7, "a", 1), row(7, "a", 2)), false},
+		{"delete", newTestDelete(7, "a", 2), false},
+		{"insert of a key value equal by collation", newTestInsert(7, "A", 1), false},
+		{"update modifying the unique key", newTestUpdate(row(7, "a", 1), row(8, "a", 1)), true},
+		{"update modifying the unique key by collation", newTestUpdate(row(7, "a", 1), row(7, "A", 1)), true},
+	}
+	for _, tt := range tests {
+		partition, isBarrier := migrator.dmlEventPartition(tt.dmlEvent, 4)
+		if isBarrier != tt.isBarrier {
+			t.Errorf("%s: isBarrier=%t, expected %t", tt.name, isBarrier, tt.isBarrier)
+		}
+		if !isBarrier && partition != insertPartition {
+			t.Errorf("%s: partition %d, expected %d", tt.name, partition, insertPartition)
+		}
+	}
+}
+
+func TestInitiateDMLApplyWorkersFallback(t *testing.T) {
+	migrationContext := newTestMigrationContext("name")
+	migrationContext.UniqueKey.Columns.SetCharset("name", "utf8mb4")
+	migrationContext.DMLApplyWorkers = 4
+	migrator, _ := newTestMigrator(migrationContext)
+	migrator.initiateDMLApplyWorkers()
+	test.S(t).ExpectEquals(migrationContext.DMLApplyWorkers, int64(1))
+}
+
+func TestApplyDMLEventsConcurrently(t *testing.T) {
+	migrationContext := newTestMigrationContext("id")
+	migrationContext.DMLApplyWorkers = 4
+	migrator, db := newTestMigrator(migrationContext)
+	migrator.initiateDMLApplyWorkers()
+
+	dmlEvents := [](*binlog.BinlogDMLEvent){
+		newTestInsert(1, "a", 1),
+		newTestInsert(2, "b", 2),
+		newTestInsert(3, "c", 3),
+		newTestUpdate(row(2, "b", 2), row(20, "b", 2)),
+		newTestInsert(4, "d", 4),
+		newTestUpdate(row(4, "d", 4), row(4, "d", 5)),
+		newTestDelete(1, "a", 1),
+	}
+	test.S(t).ExpectNil(migrator.applyDMLEventsConcurrently(dmlEvents))
+
+	barrier := db.TransactionOf("delete [2]")
+	test.S(t).ExpectTrue(barrier >= 0)
+	test.S(t).ExpectEquals(len(db.Transactions()[barrier]), 2)
+	test.S(t).ExpectEquals(db.TransactionOf("replace [20 b 2]"), barrier)
+
+	// events preceding the barrier are applied before it; following events are applied after it
+	for _, statement := range []string{"replace [1 a 1]", "replace [2 b 2]", "replace [3 c 3]"} {
+		transaction := db.TransactionOf(statement)
+		if transaction < 0 || transaction >= barrier {
+			t.Errorf("%s: applied in transaction %d, expected before barrier transaction %d", statement, transaction, barrier)
+		}
+	}
+	for _, statement := range []string{"replace [4 d 4]", "update [4 d 5 4]", "delete [1]"} {
+		if transaction := db.TransactionOf(statement); transaction <= barrier {
+			t.Errorf("%s: applied in transaction %d, expected after barrier transaction %d", statement, transaction, barrier)
+		}
+	}
+	// events of a row are applied in order, by the same worker
+	insertTransaction := db.TransactionOf("replace [4 d 4]")
+	test.S(t).ExpectEquals(db.TransactionOf("update [4 d 5 4]"), insertTransaction)
+	statements := db.Transactions()[insertTransaction]
+	for i, statement := range statements {
+		if statement == "update [4 d 5 4]" {
+			test.S(t).ExpectTrue(i > 0 && statements[i-1] == "replace [4 d 4]")
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/github/gh-ost/go/base"
+	"github.com/github/gh-ost/go/binlog"
+	"github.com/github/gh-ost/go/sql"
+	"github.com/outbrain/golib/log"
+)
+
+// mysql 单个prepared statement最多 65535 个参数
+const maxPreparedStatementArgs = 65535
+
+type dmlRowEffectType int
+
+const (
+	updateRowEffect dmlRowEffectType = iota
+	replaceRowEffect
+	deleteRowEffect
+)
+
+// dmlRowEffect is the effect of binlog events on a single row of the ghost table, identified by its
+// migration unique key values
+type dmlRowEffect struct {
+	effectType  dmlRowEffectType
+	values      *sql.ColumnValues // replace/update: 新的row image; delete: 被删除的row image
+	whereValues *sql.ColumnValues // update: 被更新的row image
+	rowsDelta   int64             // 对原表行数的影响, 用于 --exact-rowcount 的估计
+}
+
+// coalesce merges the effect of a later event on the same row into this effect
+func (this *dmlRowEffect) coalesce(next *dmlRowEffect) {
+	rowsDelta := this.rowsDelta + next.rowsDelta
+	switch {
+	case next.effectType != updateRowEffect:
+		// delete/replace 覆盖之前的一切
+		*this = *next
+	case this.effectType == deleteRowEffect:
+		// 更新不存在的行: 什么也不做
+	default:
+		// replace/update 之后的 update: 取最后的row image
+		this.values = next.values
+		if this.effectType == updateRowEffect {
+			this.whereValues = next.whereValues
+		}
+	}
+	this.rowsDelta = rowsDelta
+}
+
+// ghostTableOtherUniqueKey returns a unique key of the ghost table other than the migration unique key, if any.
+// Events on different rows may then conflict on that key: REPLACE deletes rows which conflict on any unique key,
+// hence the order in which such events are applied matters.
+func ghostTableOtherUniqueKey(migrationContext *base.MigrationContext) *sql.UniqueKey {
+	for _, uniqueKey := range migrationContext.GhostTableUniqueKeys {
+		if !uniqueKey.Columns.EqualsByNames(&migrationContext.UniqueKey.Columns) {
+			return uniqueKey
+		}
+	}
+	return nil
+}
+
+// initiateDMLEventsCoalescing decides whether the events of a batch are coalesced per row before being applied.
+// Coalescing reorders events of different rows, which is only safe when rows are told apart by the migration
+// unique key alone.
+func (this *Applier) initiateDMLEventsCoalescing() {
+	if uniqueKey := ghostTableOtherUniqueKey(this.migrationContext); uniqueKey != nil {
+		log.Infof("Ghost table has unique key %s besides the migration key %s: binlog events will be applied one by one", uniqueKey.Name, this.migrationContext.UniqueKey.Name)
+		return
+	}
+	this.uniqueKeyOrdinals = []int{}
+	for _, column := range this.migrationContext.UniqueKey.Columns.Columns() {
+		this.uniqueKeyOrdinals = append(this.uniqueKeyOrdinals, this.migrationContext.OriginalTableColumns.Ordinals[column.Name])
+	}
+	this.coalesceDMLEvents = true
+	log.Infof("Binlog events will be coalesced per %s value within each batch", this.migrationContext.UniqueKey.Name)
+}
+
+// dmlEventRowEffects returns the effects of a binlog event on the rows of the ghost table, respecting --origin-filter.
+// An UPDATE which modifies the unique key deletes a row and writes another.
+func (this *Applier) dmlEventRowEffects(dmlEvent *binlog.BinlogDMLEvent) (effects [](*dmlRowEffect), err error) {
+	deleteEffect := &dmlRowEffect{effectType: deleteRowEffect, values: dmlEvent.WhereColumnValues, rowsDelta: -1}
+	replaceEffect := &dmlRowEffect{effectType: replaceRowEffect, values: dmlEvent.NewColumnValues, rowsDelta: 1}
+	switch dmlEvent.DML {
+	case binlog.DeleteDML:
+		return append(effects, deleteEffect), nil
+	case binlog.InsertDML:
+		if matches, err := this.matchesOriginalFilter(dmlEvent.NewColumnValues); err != nil || !matches {
+			// 被filter排除的数据不写入ghost table
+			return effects, err
+		}
+		return append(effects, replaceEffect), nil
+	case binlog.UpdateDML:
+		if this.migrationContext.OriginalFilterEvaluator != nil {
+			newMatches, err := this.matchesOriginalFilter(dmlEvent.NewColumnValues)
+			if err != nil {
+				return effects, err
+			}
+			whereMatches, err := this.matchesOriginalFilter(dmlEvent.WhereColumnValues)
+			if err != nil {
+				return effects, err
+			}
+			if !newMatches {
+				// 更新之后不再满足filter: 从ghost table中删除(如果存在的话)
+				return append(effects, deleteEffect), nil
+			}
+			if !whereMatches {
+				// 更新之前不满足filter, ghost table中没有这一行: 直接写入
+				return append(effects, replaceEffect), nil
+			}
+		}
+		// UpdateDML 如何发现UniqKey本身改变了，则需要演变成为一个Delete + Insert
+		if _, isModified := this.updateModifiesUniqueKeyColumns(dmlEvent); isModified {
+			return append(effects, deleteEffect, replaceEffect), nil
+		}
+		return append(effects, &dmlRowEffect{effectType: updateRowEffect, values: dmlEvent.NewColumnValues, whereValues: dmlEvent.WhereColumnValues}), nil
+	}
+	return effects, fmt.Errorf("Unknown dml event type: %+v", dmlEvent.DML)
+}
+
+// buildRowEffectQuery creates a query applying an effect on a single row of the ghost table
+func (this *Applier) buildRowEffectQuery(effect *dmlRowEffect) *dmlBuildResult {
+	switch effect.effectType {
+	case deleteRowEffect:
+		query, uniqueKeyArgs, err := sql.BuildDMLDeleteQuery(this.migrationContext.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, &this.migrationContext.UniqueKey.Columns, effect.values.AbstractValues())
+		return newDmlBuildResult(query, uniqueKeyArgs, effect.rowsDelta, err)
+	case replaceRowEffect:
+		query, sharedArgs, err := sql.BuildDMLInsertQuery(this.migrationContext.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, this.migrationContext.SharedColumns, this.migrationContext.MappedSharedColumns, effect.values.AbstractValues())
+		return newDmlBuildResult(query, sharedArgs, effect.rowsDelta, err)
+	}
+	query, sharedArgs, uniqueKeyArgs, err := sql.BuildDMLUpdateQuery(this.migrationContext.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, this.migrationContext.SharedColumns, this.migrationContext.MappedSharedColumns, &this.migrationContext.UniqueKey.Columns, effect.values.AbstractValues(), effect.whereValues.AbstractValues())
+	args := append(sharedArgs, uniqueKeyArgs...)
+	return newDmlBuildResult(query, args, effect.rowsDelta, err)
+}
+
+// uniqueKeyValuesString identifies the row of given values, by its migration unique key values
+func (this *Applier) uniqueKeyValuesString(values *sql.ColumnValues) string {
+	var buffer bytes.Buffer
+	abstractValues := values.AbstractValues()
+	for _, ordinal := range this.uniqueKeyOrdinals {
+		fmt.Fprintf(&buffer, "%v\x00", abstractValues[ordinal])
+	}
+	return buffer.String()
+}
+
+// buildCoalescedDMLEventQueries coalesces the events of a batch per row, and creates the queries applying the net
+// effect of the batch: an INSERT followed by UPDATEs writes the last row image; a chain of UPDATEs updates to the
+// last row image; anything followed by a DELETE deletes.
+// An INSERT followed by a DELETE still deletes: row-copy may have copied the row in between.
+// Deleted rows are deleted in a single statement, then rows are updated, then written in a multi row REPLACE.
+// Rows are only affected by their own events; deleting first covers a row deleted, then written again under
+// a key value equal by collation (e.g. 'a', then 'A').
+func (this *Applier) buildCoalescedDMLEventQueries(dmlEvents [](*binlog.BinlogDMLEvent)) (results [](*dmlBuildResult)) {
+	rowEffects := [](*dmlRowEffect){}
+	rowEffectsByKey := make(map[string]*dmlRowEffect)
+	for _, dmlEvent := range dmlEvents {
+		effects, err := this.dmlEventRowEffects(dmlEvent)
+		if err != nil {
+			return append(results, newDmlBuildResultError(err))
+		}
+		for _, effect := range effects {
+			key := this.uniqueKeyValuesString(effect.values)
+			if rowEffect, ok := rowEffectsByKey[key]; ok {
+				rowEffect.coalesce(effect)
+				continue
+			}
+			rowEffectsByKey[key] = effect
+			rowEffects = append(rowEffects, effect)
+		}
+	}
+
+	deleted := [](*dmlRowEffect){}
+	replaced := [](*dmlRowEffect){}
+	updates := [](*dmlBuildResult){}
+	for _, rowEffect := range rowEffects {
+		switch rowEffect.effectType {
+		case deleteRowEffect:
+			deleted = append(deleted, rowEffect)
+		case replaceRowEffect:
+			replaced = append(replaced, rowEffect)
+		default:
+			updates = append(updates, this.buildRowEffectQuery(rowEffect))
+		}
+	}
+	results = append(results, this.buildMultiRowEffectQueries(deleted, this.migrationContext.UniqueKey.Columns.Len())...)
+	results = append(results, updates...)
+	results = append(results, this.buildMultiRowEffectQueries(replaced, this.migrationContext.SharedColumns.Len())...)
+	return results
+}
+
+// buildMultiRowEffectQueries creates multi row DELETE or REPLACE queries for given effects (all of the same type),
+// each within the prepared statement arguments limit
+func (this *Applier) buildMultiRowEffectQueries(effects [](*dmlRowEffect), argsPerRow int) (results [](*dmlBuildResult)) {
+	rowsPerQuery := maxPreparedStatementArgs / argsPerRow
+	for len(effects) > 0 {
+		batch := effects
+		if len(batch) > rowsPerQuery {
+			batch = batch[:rowsPerQuery]
+		}
+		effects = effects[len(batch):]
+
+		rowsArgs := [][]interface{}{}
+		var rowsDelta int64
+		for _, effect := range batch {
+			rowsArgs = append(rowsArgs, effect.values.AbstractValues())
+			rowsDelta += effect.rowsDelta
+		}
+		if batch[0].effectType == deleteRowEffect {
+			query, uniqueKeyArgs, err := sql.BuildDMLMultiDeleteQuery(this.migrationContext.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, &this.migrationContext.UniqueKey.Columns, rowsArgs)
+			results = append(results, newDmlBuildResult(query, uniqueKeyArgs, rowsDelta, err))
+		} else {
+			query, sharedArgs, err := sql.BuildDMLMultiInsertQuery(this.migrationContext.DatabaseName, this.migrationContext.GetGhostTableName(), this.migrationContext.OriginalTableColumns, this.migrationContext.SharedColumns, this.migrationContext.MappedSharedColumns, rowsArgs)
+			results = append(results, newDmlBuildResult(query, sharedArgs, rowsDelta, err))
+		}
+	}
+	return results
+}
+
+// buildDMLEventQueries creates the queries applying given events onto the ghost table
+func (this *Applier) buildDMLEventQueries(dmlEvents [](*binlog.BinlogDMLEvent)) (results [](*dmlBuildResult)) {
+	if this.coalesceDMLEvents {
+		return this.buildCoalescedDMLEventQueries(dmlEvents)
+	}
+	for _, dmlEvent := range dmlEvents {
+		results = append(results, this.buildDMLEventQuery(dmlEvent)...)
+	}
+	return results
+}
@@ -0,0 +1,240 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/github/gh-ost/go/base"
+	"github.com/github/gh-ost/go/binlog"
+	"github.com/github/gh-ost/go/sql"
+	"github.com/outbrain/golib/log"
+	test "github.com/outbrain/golib/tests"
+)
+
+func init() {
+	log.SetLevel(log.ERROR)
+}
+
+// newTestMigrationContext returns a context migrating `test`.`tbl` (id, name, val), on given unique key columns
+func newTestMigrationContext(uniqueKeyColumns ...string) *base.MigrationContext {
+	migrationContext := base.NewMigrationContext()
+	migrationContext.DatabaseName = "test"
+	migrationContext.OriginalTableName = "tbl"
+	migrationContext.OriginalTableColumns = sql.NewColumnList([]string{"id", "name", "val"})
+	migrationContext.SharedColumns = sql.NewColumnList([]string{"id", "name", "val"})
+	migrationContext.MappedSharedColumns = sql.NewColumnList([]string{"id", "name", "val"})
+	migrationContext.UniqueKey = &sql.UniqueKey{Name: "uk", Columns: *sql.NewColumnList(uniqueKeyColumns)}
+	return migrationContext
+}
+
+func newTestDMLEvent(dml binlog.EventDML, whereValues []interface{}, newValues []interface{}) *binlog.BinlogDMLEvent {
+	dmlEvent := binlog.NewBinlogDMLEvent("test", "tbl", dml)
+	if whereValues != nil {
+		dmlEvent.WhereColumnValues = sql.ToColumnValues(whereValues)
+	}
+	if newValues != nil {
+		dmlEvent.NewColumnValues = sql.ToColumnValues(newValues)
+	}
+	return dmlEvent
+}
+
+func newTestInsert(values ...interface{}) *binlog.BinlogDMLEvent {
+	return newTestDMLEvent(binlog.InsertDML, nil, values)
+}
+
+func newTestUpdate(whereValues []interface{}, newValues []interface{}) *binlog.BinlogDMLEvent {
+	return newTestDMLEvent(binlog.UpdateDML, whereValues, newValues)
+}
+
+func newTestDelete(values ...interface{}) *binlog.BinlogDMLEvent {
+	return newTestDMLEvent(binlog.DeleteDML, values, nil)
+}
+
+func row(values ...interface{}) []interface{} {
+	return values
+}
+
+func TestDMLRowEffectCoalesce(t *testing.T) {
+	first := sql.ToColumnValues(row(1, "a", 1))
+	second := sql.ToColumnValues(row(1, "a", 2))
+	third := sql.ToColumnValues(row(1, "a", 3))
+
+	tests := []struct {
+		name     string
+		effect   dmlRowEffect
+		next     dmlRowEffect
+		expected dmlRowEffect
+	}{
+		{
+			name:     "update after replace",
+			effect:   dmlRowEffect{effectType: replaceRowEffect, values: first, rowsDelta: 1},
+			next:     dmlRowEffect{effectType: updateRowEffect, values: third, whereValues: second},
+			expected: dmlRowEffect{effectType: replaceRowEffect, values: third, rowsDelta: 1},
+		},
+		{
+			name:     "update after update",
+			effect:   dmlRowEffect{effectType: updateRowEffect, values: second, whereValues: first},
+			next:     dmlRowEffect{effectType: updateRowEffect, values: third, whereValues: second},
+			expected: dmlRowEffect{effectType: updateRowEffect, values: third, whereValues: second},
+		},
+		{
+			name:     "delete after update",
+			effect:   dmlRowEffect{effectType: updateRowEffect, values: second, whereValues: first},
+			next:     dmlRowEffect{effectType: deleteRowEffect, values: second, rowsDelta: -1},
+			expected: dmlRowEffect{effectType: deleteRowEffect, values: second, rowsDelta: -1},
+		},
+		{
+			name:     "delete after replace",
+			effect:   dmlRowEffect{effectType: replaceRowEffect, values: first, rowsDelta: 1},
+			next:     dmlRowEffect{effectType: deleteRowEffect, values: first, rowsDelta: -1},
+			expected: dmlRowEffect{effectType: deleteRowEffect, values: first, rowsDelta: 0},
+		},
+		{
+			name:     "update after delete",
+			effect:   dmlRowEffect{effectType: deleteRowEffect, values: first, rowsDelta: -1},
+			next:     dmlRowEffect{effectType: updateRowEffect, values: third, whereValues: second},
+			expected: dmlRowEffect{effectType: deleteRowEffect, values: first, rowsDelta: -1},
+		},
+		{
+			name:     "replace after delete",
+			effect:   dmlRowEffect{effectType: deleteRowEffect, values: first, rowsDelta: -1},
+			next:     dmlRowEffect{effectType: replaceRowEffect, values: second, rowsDelta: 1},
+			expected: dmlRowEffect{effectType: replaceRowEffect, values: second, rowsDelta: 0},
+		},
+	}
+	for _, tt := range tests {
+		effect := tt.effect
+		effect.coalesce(&tt.next)
+		if !reflect.DeepEqual(effect, tt.expected) {
+			t.Errorf("%s: got %+v, expected %+v", tt.name, effect, tt.expected)
+		}
+	}
+}
+
+// testQuery is the gist of a built query: its statement type and arguments
+type testQuery struct {
+	statement string
+	args      []interface{}
+	rowsDelta int64
+}
+
+func toTestQueries(t *testing.T, results [](*dmlBuildResult)) (queries []testQuery) {
+	for _, result := range results {
+		test.S(t).ExpectNil(result.err)
+		queries = append(queries, testQuery{statement: strings.Fields(result.query)[0], args: result.args, rowsDelta: result.rowsDelta})
+	}
+	return queries
+}
+
+func TestBuildCoalescedDMLEventQueries(t *testing.T) {
+	tests := []struct {
+		name      string
+		uniqueKey []string
+		dmlEvents [](*binlog.BinlogDMLEvent)
+		expected  []testQuery
+	}{
+		{
+			name:      "insert, then updates",
+			uniqueKey: []string{"id"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestInsert(1, "a", 1),
+				newTestUpdate(row(1, "a", 1), row(1, "a", 2)),
+				newTestUpdate(row(1, "a", 2), row(1, "b", 3)),
+			},
+			expected: []testQuery{
+				{"replace", row(1, "b", 3), 1},
+			},
+		},
+		{
+			name:      "insert, update, then delete",
+			uniqueKey: []string{"id"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestInsert(1, "a", 1),
+				newTestUpdate(row(1, "a", 1), row(1, "a", 2)),
+				newTestDelete(1, "a", 2),
+			},
+			expected: []testQuery{
+				{"delete", row(1), 0},
+			},
+		},
+		{
+			name:      "chain of updates",
+			uniqueKey: []string{"id"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestUpdate(row(1, "a", 1), row(1, "a", 2)),
+				newTestUpdate(row(1, "a", 2), row(1, "a", 3)),
+			},
+			expected: []testQuery{
+				{"update", row(1, "a", 3, 1), 0},
+			},
+		},
+		{
+			name:      "update modifying the unique key",
+			uniqueKey: []string{"id"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestUpdate(row(1, "a", 1), row(2, "a", 1)),
+			},
+			expected: []testQuery{
+				{"delete", row(1), -1},
+				{"replace", row(2, "a", 1), 1},
+			},
+		},
+		{
+			name:      "update modifying the unique key, then updated again",
+			uniqueKey: []string{"id"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestInsert(1, "a", 1),
+				newTestUpdate(row(1, "a", 1), row(2, "a", 1)),
+				newTestUpdate(row(2, "a", 1), row(2, "a", 2)),
+			},
+			expected: []testQuery{
+				{"delete", row(1), 0},
+				{"replace", row(2, "a", 2), 1},
+			},
+		},
+		{
+			name:      "key values equal by collation",
+			uniqueKey: []string{"name"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestInsert(1, "A", 1),
+				newTestDelete(2, "a", 1),
+			},
+			expected: []testQuery{
+				{"delete", row("a"), -1},
+				{"replace", row(1, "A", 1), 1},
+			},
+		},
+		{
+			name:      "several rows",
+			uniqueKey: []string{"id"},
+			dmlEvents: [](*binlog.BinlogDMLEvent){
+				newTestInsert(1, "a", 1),
+				newTestDelete(3, "c", 3),
+				newTestUpdate(row(4, "d", 4), row(4, "d", 5)),
+				newTestInsert(2, "b", 2),
+				newTestDelete(5, "e", 5),
+			},
+			expected: []testQuery{
+				{"delete", row(3, 5), -2},
+				{"update", row(4, "d", 5, 4), 0},
+				{"replace", row(1, "a", 1, 2, "b", 2), 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		applier := NewApplier(newTestMigrationContext(tt.uniqueKey...))
+		applier.initiateDMLEventsCoalescing()
+		test.S(t).ExpectTrue(applier.coalesceDMLEvents)
+
+		queries := toTestQueries(t, applier.buildCoalescedDMLEventQueries(tt.dmlEvents))
+		if !reflect.DeepEqual(queries, tt.expected) {
+			t.Errorf("%s: got %+v, expected %+v", tt.name, queries, tt.expected)
+		}
+	}
+}
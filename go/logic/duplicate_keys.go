@@ -0,0 +1,255 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	gosql "database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/github/gh-ost/go/sql"
+	"github.com/outbrain/golib/log"
+)
+
+const duplicateKeysSampleSize = 10
+
+// validateAddedUniqueKeys scans the original table for duplicate values of the UNIQUE/PRIMARY keys added by
+// the ALTER statement. Row copy uses INSERT IGNORE and DML apply uses REPLACE INTO: rows duplicate on a new
+// unique key would silently be lost on the ghost table. Unless --allow-lossy-unique, such duplicates fail the migration.
+func (this *Inspector) validateAddedUniqueKeys(addedUniqueKeys []*sql.AlterOperation, throttle func()) error {
+	if len(addedUniqueKeys) == 0 {
+		return nil
+	}
+	if this.migrationContext.AllowLossyUnique {
+		log.Warningf("ALTER statement adds unique key(s); --allow-lossy-unique is given, so not checking for duplicates. Rows duplicate on the new key(s) will be lost")
+		return nil
+	}
+	for _, uniqueKey := range addedUniqueKeys {
+		keyName := uniqueKey.Name
+		if keyName == "" {
+			keyName = fmt.Sprintf("(%s)", strings.Join(uniqueKey.Columns, ","))
+		}
+		keyColumns := this.addedUniqueKeyColumns(keyName, uniqueKey)
+		if keyColumns == nil {
+			continue
+		}
+		samples, err := this.scanDuplicateKeys(keyColumns, uniqueKey.PrefixLengths, throttle)
+		if err != nil {
+			return err
+		}
+		if len(samples) > 0 {
+			for _, sample := range samples {
+				log.Errorf("Duplicate on new unique key %s: %s", keyName, sample)
+			}
+			return fmt.Errorf("ALTER statement adds unique key %s, and %s.%s has duplicate values for it, e.g. %s. Such rows would be silently lost on the ghost table. Bailing out. Clean up the duplicates, or supply --allow-lossy-unique if you are fine losing them", keyName, sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName), samples[0])
+		}
+		log.Infof("No duplicates found on new unique key %s", keyName)
+	}
+	return nil
+}
+
+// addedUniqueKeyColumns maps the columns of a new unique key onto columns of the original table. It returns nil
+// when the key cannot be checked (functional key part, column added by the ALTER), or cannot have duplicates to
+// begin with (an existing unique key is a subset of its whole, non prefixed, columns)
+func (this *Inspector) addedUniqueKeyColumns(keyName string, uniqueKey *sql.AlterOperation) *sql.ColumnList {
+	names := []string{}
+	wholeNames := []string{}
+	for i, keyColumn := range uniqueKey.Columns {
+		if strings.HasPrefix(keyColumn, "(") {
+			log.Warningf("New unique key %s has functional key part %s; unable to check it for duplicates", keyName, keyColumn)
+			return nil
+		}
+		// 新的unique key使用的是rename之后的列名
+		candidate := keyColumn
+		for original, renamed := range this.migrationContext.ColumnRenameMap {
+			if strings.EqualFold(renamed, keyColumn) {
+				candidate = original
+			}
+		}
+		originalName := ""
+		for _, name := range this.migrationContext.OriginalTableColumns.Names() {
+			if strings.EqualFold(name, candidate) {
+				originalName = name
+			}
+		}
+		if originalName == "" {
+			log.Warningf("New unique key %s has column %s, which is not in the original table; unable to check it for duplicates", keyName, keyColumn)
+			return nil
+		}
+		names = append(names, originalName)
+		if i >= len(uniqueKey.PrefixLengths) || uniqueKey.PrefixLengths[i] == 0 {
+			wholeNames = append(wholeNames, originalName)
+		}
+	}
+	keyColumns := sql.NewColumnList(names)
+	// 前缀索引: 例如 unique(email) 并不意味着 unique(email(20)) 没有重复
+	wholeColumns := sql.NewColumnList(wholeNames)
+	for _, existingUniqueKey := range this.migrationContext.OriginalTableUniqueKeys {
+		if existingUniqueKey.Columns.IsSubsetOf(wholeColumns) {
+			log.Infof("New unique key %s covers existing unique key %s; no duplicates possible", keyName, existingUniqueKey.Name)
+			return nil
+		}
+	}
+	return keyColumns
+}
+
+// getIndexLeadingWith returns an index of the original table whose leading columns are the given columns (in any
+// order), along with these columns in index order. Walking such index lists duplicate values adjacently.
+// Empty indexName when there's no such index.
+func (this *Inspector) getIndexLeadingWith(keyColumns *sql.ColumnList) (indexName string, indexColumns *sql.ColumnList, err error) {
+	query := `
+		select
+			index_name, column_name, sub_part
+		from
+			information_schema.statistics
+		where
+			table_schema = ?
+			and table_name = ?
+		order by
+			index_name, seq_in_index
+	`
+	rows, err := this.db.Query(query, this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName)
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+
+	indexNames := []string{}
+	leadingColumns := make(map[string][]string)
+	usable := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var columnName, subPart gosql.NullString
+		if err := rows.Scan(&name, &columnName, &subPart); err != nil {
+			return "", nil, err
+		}
+		if _, ok := usable[name]; !ok {
+			indexNames = append(indexNames, name)
+			usable[name] = true
+		}
+		if len(leadingColumns[name]) >= keyColumns.Len() {
+			continue
+		}
+		if !columnName.Valid || subPart.Valid {
+			// 函数索引, 前缀索引
+			usable[name] = false
+			continue
+		}
+		leadingColumns[name] = append(leadingColumns[name], columnName.String)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+	for _, name := range indexNames {
+		if !usable[name] || len(leadingColumns[name]) != keyColumns.Len() {
+			continue
+		}
+		candidate := sql.NewColumnList(leadingColumns[name])
+		if candidate.IsSubsetOf(keyColumns) {
+			return name, candidate, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// scanDuplicateKeys looks for values of given columns (or of their prefix, as per prefixLengths) which appear on
+// more than one row, and returns up to duplicateKeysSampleSize of them. When an index leads with these columns, the
+// scan is chunked along said index, throttling in between chunks. Otherwise it's a single GROUP BY query.
+func (this *Inspector) scanDuplicateKeys(keyColumns *sql.ColumnList, prefixLengths []int, throttle func()) (samples []string, err error) {
+	for _, prefixLength := range prefixLengths {
+		if prefixLength > 0 {
+			// 相同前缀的行在索引中不一定位于同一个chunk内
+			log.Warningf("New unique key on %s has prefixed columns; checking for duplicates via a single, full table scan", keyColumns)
+			throttle()
+			return this.readDuplicateKeys("", keyColumns, prefixLengths, nil, nil)
+		}
+	}
+	indexName, indexColumns, err := this.getIndexLeadingWith(keyColumns)
+	if err != nil {
+		return samples, err
+	}
+	if indexName != "" {
+		this.applyColumnTypes(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, indexColumns)
+		for _, column := range indexColumns.Columns() {
+			if column.Type == sql.EnumColumnType {
+				// enum 按照序号排序, 无法用值来做分段
+				indexName = ""
+			}
+		}
+	}
+	if indexName == "" {
+		log.Warningf("No index of %s.%s leads with %s; checking for duplicates via a single, full table scan", sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName), keyColumns)
+		throttle()
+		return this.readDuplicateKeys("", keyColumns, nil, nil, nil)
+	}
+
+	log.Infof("Checking %s.%s for duplicates on %s, via index %s", sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName), keyColumns, indexName)
+	var rangeStart []interface{}
+	for chunk := 0; ; chunk++ {
+		throttle()
+		rangeEnd, err := this.readDuplicateKeysRangeEnd(indexName, indexColumns, rangeStart)
+		if err != nil {
+			return samples, err
+		}
+		if samples, err = this.readDuplicateKeys(indexName, indexColumns, nil, rangeStart, rangeEnd); err != nil || len(samples) > 0 {
+			return samples, err
+		}
+		if rangeEnd == nil {
+			log.Debugf("Checked %d chunks for duplicates", chunk+1)
+			return samples, nil
+		}
+		rangeStart = rangeEnd
+	}
+}
+
+// readDuplicateKeysRangeEnd returns the end values of the chunk following rangeStart; nil for the last chunk
+func (this *Inspector) readDuplicateKeysRangeEnd(indexName string, indexColumns *sql.ColumnList, rangeStart []interface{}) (rangeEnd []interface{}, err error) {
+	query, explodedArgs, err := sql.BuildDuplicateKeysRangeEndPreparedQuery(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, indexName, indexColumns, rangeStart, atomic.LoadInt64(&this.migrationContext.ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := this.db.Query(query, explodedArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := sql.NewColumnValues(indexColumns.Len())
+		if err := rows.Scan(values.ValuesPointers...); err != nil {
+			return nil, err
+		}
+		rangeEnd = values.AbstractValues()
+	}
+	return rangeEnd, rows.Err()
+}
+
+// readDuplicateKeys returns duplicate key values (and their row count) within range (rangeStart, rangeEnd]
+func (this *Inspector) readDuplicateKeys(indexName string, keyColumns *sql.ColumnList, prefixLengths []int, rangeStart, rangeEnd []interface{}) (samples []string, err error) {
+	query, explodedArgs, err := sql.BuildDuplicateKeysPreparedQuery(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, indexName, keyColumns, prefixLengths, rangeStart, rangeEnd, duplicateKeysSampleSize)
+	if err != nil {
+		return samples, err
+	}
+	rows, err := this.db.Query(query, explodedArgs...)
+	if err != nil {
+		return samples, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := sql.NewColumnValues(keyColumns.Len() + 1)
+		if err := rows.Scan(values.ValuesPointers...); err != nil {
+			return samples, err
+		}
+		keyValues := []string{}
+		for i := 0; i < keyColumns.Len(); i++ {
+			keyValues = append(keyValues, values.StringColumn(i))
+		}
+		samples = append(samples, fmt.Sprintf("(%s): %s rows", strings.Join(keyValues, ","), values.StringColumn(keyColumns.Len())))
+	}
+	return samples, rows.Err()
+}
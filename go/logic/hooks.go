@@ -7,11 +7,17 @@
 package logic
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/github/gh-ost/go/base"
 	"github.com/outbrain/golib/log"
@@ -33,13 +39,56 @@ const (
 	onStartReplication   = "gh-ost-on-start-replication"
 )
 
+// hookEvents names the hooks in webhook payloads
+var hookEvents = map[string]string{
+	onStartup:            "onStartup",
+	onValidated:          "onValidated",
+	onRowCountComplete:   "onRowCountComplete",
+	onBeforeRowCopy:      "onBeforeRowCopy",
+	onRowCopyComplete:    "onRowCopyComplete",
+	onBeginPostponed:     "onBeginPostponed",
+	onBeforeCutOver:      "onBeforeCutOver",
+	onInteractiveCommand: "onInteractiveCommand",
+	onSuccess:            "onSuccess",
+	onFailure:            "onFailure",
+	onStatus:             "onStatus",
+	onStopReplication:    "onStopReplication",
+	onStartReplication:   "onStartReplication",
+}
+
+// informationalHooks only notify, and do not gate the migration: their webhooks are POSTed in the background,
+// once, and a failure is logged regardless of --hooks-url-failure-policy. onStatus in particular may be invoked
+// while the original table is locked for cut-over.
+var informationalHooks = map[string]bool{
+	onSuccess: true,
+	onFailure: true,
+	onStatus:  true,
+}
+
+const (
+	HooksURLFailurePolicyAbort  = "abort"
+	HooksURLFailurePolicyIgnore = "ignore"
+)
+
+// hookVariable is context passed on to a particular hook: GH_OST_<name> environment variable for
+// executables, lower cased <name> field for webhooks
+type hookVariable struct {
+	name  string
+	value string
+}
+
 type HooksExecutor struct {
-	migrationContext *base.MigrationContext
+	migrationContext      *base.MigrationContext
+	httpClient            *http.Client
+	informationalWebhooks sync.WaitGroup
 }
 
 func NewHooksExecutor(migrationContext *base.MigrationContext) *HooksExecutor {
 	return &HooksExecutor{
 		migrationContext: migrationContext,
+		httpClient: &http.Client{
+			Timeout: time.Duration(migrationContext.HooksURLTimeoutMillis) * time.Millisecond,
+		},
 	}
 }
 
@@ -49,7 +98,7 @@ func (this *HooksExecutor) initHooks() error {
 
 // 构建新的环境变量
 // GH_OST的很多信息也放环境变量中
-func (this *HooksExecutor) applyEnvironmentVariables(extraVariables ...string) []string {
+func (this *HooksExecutor) applyEnvironmentVariables(extraVariables ...hookVariable) []string {
 	env := os.Environ()
 	env = append(env, fmt.Sprintf("GH_OST_DATABASE_NAME=%s", this.migrationContext.DatabaseName))
 	env = append(env, fmt.Sprintf("GH_OST_TABLE_NAME=%s", this.migrationContext.OriginalTableName))
@@ -69,14 +118,104 @@ func (this *HooksExecutor) applyEnvironmentVariables(extraVariables ...string) [
 	env = append(env, fmt.Sprintf("GH_OST_DRY_RUN=%t", this.migrationContext.Noop))
 
 	for _, variable := range extraVariables {
-		env = append(env, variable)
+		env = append(env, fmt.Sprintf("GH_OST_%s='%s'", variable.name, variable.value))
 	}
 	return env
 }
 
+// webhookPayload is the JSON document POSTed to webhooks; same context as the environment variables of executables
+func (this *HooksExecutor) webhookPayload(baseName string, extraVariables ...hookVariable) map[string]interface{} {
+	payload := map[string]interface{}{
+		"event":                hookEvents[baseName],
+		"hook":                 baseName,
+		"database_name":        this.migrationContext.DatabaseName,
+		"table_name":           this.migrationContext.OriginalTableName,
+		"ghost_table_name":     this.migrationContext.GetGhostTableName(),
+		"old_table_name":       this.migrationContext.GetOldTableName(),
+		"ddl":                  this.migrationContext.AlterStatement,
+		"elapsed_seconds":      this.migrationContext.ElapsedTime().Seconds(),
+		"elapsed_copy_seconds": this.migrationContext.ElapsedRowCopyTime().Seconds(),
+		"estimated_rows":       atomic.LoadInt64(&this.migrationContext.RowsEstimate) + atomic.LoadInt64(&this.migrationContext.RowsDeltaEstimate),
+		"copied_rows":          this.migrationContext.GetTotalRowsCopied(),
+		"migrated_host":        this.migrationContext.GetApplierHostname(),
+		"inspected_host":       this.migrationContext.GetInspectorHostname(),
+		"executing_host":       this.migrationContext.Hostname,
+		"hooks_hint":           this.migrationContext.HooksHintMessage,
+		"dry_run":              this.migrationContext.Noop,
+	}
+	for _, variable := range extraVariables {
+		payload[strings.ToLower(variable.name)] = variable.value
+	}
+	return payload
+}
+
+// postWebhook POSTs the payload onto given URL, retrying up to given number of times. Any non 2xx response is an error
+func (this *HooksExecutor) postWebhook(url string, payload []byte, retries int64) (err error) {
+	for i := int64(0); i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * time.Second)
+		}
+		var response *http.Response
+		response, err = this.httpClient.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			log.Warningf("webhook %s: %+v", url, err)
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode >= 200 && response.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("webhook %s responded with %s", url, response.Status)
+		log.Warningf("%s", err.Error())
+	}
+	return err
+}
+
+// executeWebhooks POSTs the hook's payload onto all --hooks-url, sequentially. A failed webhook fails the hook
+// unless --hooks-url-failure-policy=ignore. Webhooks of informational hooks are POSTed in the background instead.
+func (this *HooksExecutor) executeWebhooks(baseName string, extraVariables ...hookVariable) error {
+	if len(this.migrationContext.HooksURLs) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(this.webhookPayload(baseName, extraVariables...))
+	if err != nil {
+		return err
+	}
+	if informationalHooks[baseName] {
+		this.postInformationalWebhooks(baseName, payload)
+		return nil
+	}
+	for _, url := range this.migrationContext.HooksURLs {
+		log.Infof("posting %+v webhook: %+v", hookEvents[baseName], url)
+		if err := this.postWebhook(url, payload, this.migrationContext.HooksURLRetries); err != nil {
+			if this.migrationContext.HooksURLFailurePolicy == HooksURLFailurePolicyIgnore {
+				log.Errorf("webhook %s failed; ignoring as per --hooks-url-failure-policy: %+v", url, err)
+				continue
+			}
+			return log.Errore(err)
+		}
+	}
+	return nil
+}
+
+// postInformationalWebhooks POSTs the payload onto all --hooks-url in the background, without retries: a dead
+// endpoint must not hold the migration (nor the cut-over lock)
+func (this *HooksExecutor) postInformationalWebhooks(baseName string, payload []byte) {
+	for _, url := range this.migrationContext.HooksURLs {
+		log.Debugf("posting %+v webhook: %+v", hookEvents[baseName], url)
+		this.informationalWebhooks.Add(1)
+		go func(url string) {
+			defer this.informationalWebhooks.Done()
+			if err := this.postWebhook(url, payload, 0); err != nil {
+				log.Errorf("%+v webhook %s failed; ignoring, as the hook is informational: %+v", hookEvents[baseName], url, err)
+			}
+		}(url)
+	}
+}
+
 // executeHook executes a command, and sets relevant environment variables
 // combined output & error are printed to gh-ost's standard error.
-func (this *HooksExecutor) executeHook(hook string, extraVariables ...string) error {
+func (this *HooksExecutor) executeHook(hook string, extraVariables ...hookVariable) error {
 	// gt-ost 如何给Hook传递信息？
 	// 通过环境变量来传递
 	cmd := exec.Command(hook)
@@ -96,7 +235,7 @@ func (this *HooksExecutor) detectHooks(baseName string) (hooks []string, err err
 	return hooks, err
 }
 
-func (this *HooksExecutor) executeHooks(baseName string, extraVariables ...string) error {
+func (this *HooksExecutor) executeHooks(baseName string, extraVariables ...hookVariable) error {
 	// 检查所有的hooks
 	hooks, err := this.detectHooks(baseName)
 	if err != nil {
@@ -110,7 +249,7 @@ func (this *HooksExecutor) executeHooks(baseName string, extraVariables ...strin
 			return err
 		}
 	}
-	return nil
+	return this.executeWebhooks(baseName, extraVariables...)
 }
 
 func (this *HooksExecutor) onStartup() error {
@@ -141,21 +280,23 @@ func (this *HooksExecutor) onBeforeCutOver() error {
 }
 
 func (this *HooksExecutor) onInteractiveCommand(command string) error {
-	v := fmt.Sprintf("GH_OST_COMMAND='%s'", command)
-	return this.executeHooks(onInteractiveCommand, v)
+	return this.executeHooks(onInteractiveCommand, hookVariable{name: "COMMAND", value: command})
 }
 
+// onSuccess is a last hook: it waits for background webhooks, so that they are delivered before gh-ost exits
 func (this *HooksExecutor) onSuccess() error {
+	defer this.informationalWebhooks.Wait()
 	return this.executeHooks(onSuccess)
 }
 
+// onFailure is a last hook: it waits for background webhooks, so that they are delivered before gh-ost exits
 func (this *HooksExecutor) onFailure() error {
+	defer this.informationalWebhooks.Wait()
 	return this.executeHooks(onFailure)
 }
 
 func (this *HooksExecutor) onStatus(statusMessage string) error {
-	v := fmt.Sprintf("GH_OST_STATUS='%s'", statusMessage)
-	return this.executeHooks(onStatus, v)
+	return this.executeHooks(onStatus, hookVariable{name: "STATUS", value: statusMessage})
 }
 
 func (this *HooksExecutor) onStopReplication() error {
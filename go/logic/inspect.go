@@ -126,6 +126,14 @@ func (this *Inspector) InspectOriginalTable() (err error) {
 	if err != nil {
 		return err
 	}
+	if this.migrationContext.OriginalFilter != "" {
+		// binlog events 也需要按照filter过滤, 无法在Go中计算的filter直接拒绝
+		this.applyColumnTypes(this.migrationContext.DatabaseName, this.migrationContext.OriginalTableName, this.migrationContext.OriginalTableColumns)
+		if this.migrationContext.OriginalFilterEvaluator, err = sql.NewRowFilter(this.migrationContext.OriginalFilter, this.migrationContext.OriginalTableColumns); err != nil {
+			return fmt.Errorf("--origin-filter cannot be applied to binlog events: %s", err.Error())
+		}
+		log.Infof("Origin filter will be applied to row-copy and binlog events: %s", this.migrationContext.OriginalFilter)
+	}
 	return nil
 }
 
@@ -227,6 +235,7 @@ func (this *Inspector) validateConnection() error {
 
 	version, err := base.ValidateConnection(this.db, this.connectionConfig, this.migrationContext)
 	this.migrationContext.InspectorMySQLVersion = version
+	this.migrationContext.InspectorFlavor = mysql.FlavorFromVersion(version)
 	return err
 }
 
@@ -264,6 +273,15 @@ func (this *Inspector) validateGrants() error {
 			if strings.Contains(grant, `REPLICATION SLAVE`) && strings.Contains(grant, ` ON *.*`) {
 				foundReplicationSlave = true
 			}
+			if this.migrationContext.InspectorFlavor == mysql.MariaDBFlavor {
+				// MariaDB 10.5 起 REPLICATION CLIENT 更名为 BINLOG MONITOR, REPLICATION SLAVE 的别名为 REPLICATION REPLICA
+				if strings.Contains(grant, `BINLOG MONITOR`) && strings.Contains(grant, ` ON *.*`) {
+					foundReplicationClient = true
+				}
+				if strings.Contains(grant, `REPLICATION REPLICA`) && strings.Contains(grant, ` ON *.*`) {
+					foundReplicationSlave = true
+				}
+			}
 			if strings.Contains(grant, fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.*", this.migrationContext.DatabaseName)) {
 				foundDBAll = true
 			}
@@ -666,11 +684,35 @@ func (this *Inspector) applyColumnTypes(databaseName, tableName string, columnsL
 				columnsList.GetColumn(columnName).Type = sql.EnumColumnType
 			}
 		}
+		if strings.HasPrefix(columnType, "set(") {
+			for _, columnsList := range columnsLists {
+				if column := columnsList.GetColumn(columnName); column != nil {
+					column.Type = sql.SetColumnType
+				}
+			}
+		}
+		if columnType == "date" {
+			for _, columnsList := range columnsLists {
+				if column := columnsList.GetColumn(columnName); column != nil {
+					column.Type = sql.DateColumnType
+				}
+			}
+		}
 		if charset := m.GetString("CHARACTER_SET_NAME"); charset != "" {
 			for _, columnsList := range columnsLists {
 				columnsList.SetCharset(columnName, charset)
 			}
 		}
+		collation := m.GetString("COLLATION_NAME")
+		for _, columnsList := range columnsLists {
+			if column := columnsList.GetColumn(columnName); column != nil {
+				column.MySQLType = columnType
+				column.Collation = collation
+				if collation == "" && isBinaryColumn(*column) {
+					column.Collation = "binary"
+				}
+			}
+		}
 		return nil
 	}, databaseName, tableName)
 	return err
@@ -782,7 +824,7 @@ func (this *Inspector) getSharedColumns(originalColumns, ghostColumns *sql.Colum
 			if strings.EqualFold(originalColumn, ghostColumn) {
 				isSharedColumn = true
 			}
-			if strings.EqualFold(columnRenameMap[originalColumn], ghostColumn) {
+			if mapped, ok := mappedColumnName(columnRenameMap, originalColumn); ok && strings.EqualFold(mapped, ghostColumn) {
 				isSharedColumn = true
 			}
 		}
@@ -809,7 +851,7 @@ func (this *Inspector) getSharedColumns(originalColumns, ghostColumns *sql.Colum
 	// 2. ghost table中的对应的names
 	mappedSharedColumnNames := []string{}
 	for _, columnName := range sharedColumnNames {
-		if mapped, ok := columnRenameMap[columnName]; ok {
+		if mapped, ok := mappedColumnName(columnRenameMap, columnName); ok {
 			mappedSharedColumnNames = append(mappedSharedColumnNames, mapped)
 		} else {
 			mappedSharedColumnNames = append(mappedSharedColumnNames, columnName)
@@ -818,6 +860,17 @@ func (this *Inspector) getSharedColumns(originalColumns, ghostColumns *sql.Colum
 	return sql.NewColumnList(sharedColumnNames), sql.NewColumnList(mappedSharedColumnNames)
 }
 
+// mappedColumnName returns the name a column is renamed to by the ALTER statement. Column names are case
+// insensitive: `CHANGE ID identifier ...` renames column `id`
+func mappedColumnName(columnRenameMap map[string]string, columnName string) (string, bool) {
+	for column, renamed := range columnRenameMap {
+		if strings.EqualFold(column, columnName) {
+			return renamed, true
+		}
+	}
+	return "", false
+}
+
 // showCreateTable returns the `show create table` statement for given table
 func (this *Inspector) showCreateTable(tableName string) (createTableStatement string, err error) {
 	var dummy string
@@ -842,6 +895,18 @@ func (this *Inspector) readChangelogState(hint string) (string, error) {
 	return result, err
 }
 
+// readCheckpoint reads the migration progress as persisted by a previous, interrupted, run
+func (this *Inspector) readCheckpoint() (*base.Checkpoint, error) {
+	value, err := this.readChangelogState("checkpoint")
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, fmt.Errorf("No checkpoint found in %s.%s. Cannot resume", sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.GetChangelogTableName()))
+	}
+	return base.ParseCheckpoint(value)
+}
+
 // 如何搜索Master呢?
 func (this *Inspector) getMasterConnectionConfig() (applierConfig *mysql.ConnectionConfig, err error) {
 	log.Infof("Recursively searching for replication master")
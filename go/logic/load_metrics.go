@@ -0,0 +1,148 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/github/gh-ost/go/base"
+)
+
+// loadMetricSource reads the current value of metrics of a given kind, as referenced by --max-load and --critical-load
+type loadMetricSource interface {
+	readMetric(name string) (value float64, err error)
+}
+
+// statusLoadMetricSource reads SHOW GLOBAL STATUS variables on the applier's server
+type statusLoadMetricSource struct {
+	applier *Applier
+}
+
+func (this *statusLoadMetricSource) readMetric(name string) (value float64, err error) {
+	result, err := this.applier.ShowStatusVariable(name)
+	return float64(result), err
+}
+
+// innodbMetricsLoadMetricSource reads information_schema.INNODB_METRICS counters on the applier's server
+type innodbMetricsLoadMetricSource struct {
+	applier *Applier
+}
+
+func (this *innodbMetricsLoadMetricSource) readMetric(name string) (value float64, err error) {
+	var status string
+	query := `select count, status from information_schema.innodb_metrics where name = ?`
+	if err := this.applier.db.QueryRow(query, name).Scan(&value, &status); err != nil {
+		return 0, err
+	}
+	// 未启用的counter一直是0, 与其静默通过, 不如报错
+	if !strings.EqualFold(status, "enabled") {
+		return 0, fmt.Errorf("innodb metric %s is %s; enable it via: set global innodb_monitor_enable='%s'", name, status, name)
+	}
+	return value, nil
+}
+
+// performanceSchemaLoadMetricQueries are the queries computing the supported performance_schema metrics
+var performanceSchemaLoadMetricQueries = map[string]string{
+	// 复制延迟(秒): 各 applier worker 正在应用的事务, 距其在源头提交的时间; 空闲时为0
+	"replication_applier_lag": `
+		select
+			ifnull(max(timestampdiff(microsecond, applying_transaction_original_commit_timestamp, now(6))), 0) / 1000000
+		from
+			performance_schema.replication_applier_status_by_worker
+		where
+			applying_transaction != ''
+	`,
+}
+
+// performanceSchemaLoadMetricSource computes performance_schema metrics on the inspected server, which is
+// typically the replica gh-ost connects to
+type performanceSchemaLoadMetricSource struct {
+	inspector *Inspector
+}
+
+func (this *performanceSchemaLoadMetricSource) readMetric(name string) (value float64, err error) {
+	query, ok := performanceSchemaLoadMetricQueries[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("Unsupported performance_schema metric %s", name)
+	}
+	if err := this.inspector.db.QueryRow(query).Scan(&value); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// loadMetricSample is the value of a metric read at a given time; two samples make a rate
+type loadMetricSample struct {
+	value     float64
+	sampledAt time.Time
+}
+
+// loadMetrics reads load condition metrics via their sources, and computes rates between consecutive samples
+type loadMetrics struct {
+	sources map[string]loadMetricSource
+	samples map[string]loadMetricSample
+	mutex   sync.Mutex
+}
+
+func newLoadMetrics(applier *Applier, inspector *Inspector) *loadMetrics {
+	return &loadMetrics{
+		sources: map[string]loadMetricSource{
+			base.LoadSourceStatus:            &statusLoadMetricSource{applier: applier},
+			base.LoadSourceInnoDBMetrics:     &innodbMetricsLoadMetricSource{applier: applier},
+			base.LoadSourcePerformanceSchema: &performanceSchemaLoadMetricSource{inspector: inspector},
+		},
+		samples: make(map[string]loadMetricSample),
+	}
+}
+
+// read returns the current value of the metric of given condition. For a rate condition, it returns the per second
+// rate since the previous sample taken for the same load (identified by loadName, e.g. "max-load"), such that
+// --max-load and --critical-load, checked at different intervals, keep their own samples.
+// ok is false when there is no value yet: the first sample of a rate.
+func (this *loadMetrics) read(loadName string, condition *base.LoadCondition) (value float64, ok bool, err error) {
+	source, found := this.sources[condition.Source]
+	if !found {
+		return 0, false, fmt.Errorf("Unknown metric source %s", condition.Source)
+	}
+	value, err = source.readMetric(condition.Name)
+	if err != nil {
+		return 0, false, err
+	}
+	if !condition.Rate {
+		return value, true, nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	sample := loadMetricSample{value: value, sampledAt: time.Now()}
+	key := fmt.Sprintf("%s:%s", loadName, condition.Metric())
+	previous, found := this.samples[key]
+	this.samples[key] = sample
+	elapsed := sample.sampledAt.Sub(previous.sampledAt).Seconds()
+	if !found || elapsed <= 0 {
+		return 0, false, nil
+	}
+	return (sample.value - previous.value) / elapsed, true, nil
+}
+
+// loadIsMet checks the conditions of given load, and returns the first condition met, along with the value of its
+// metric. On error, condition is the one whose metric could not be read.
+func (this *loadMetrics) loadIsMet(loadName string, loadMap base.LoadMap) (met bool, condition *base.LoadCondition, value float64, err error) {
+	for _, condition = range loadMap {
+		value, ok, err := this.read(loadName, condition)
+		if err != nil {
+			return false, condition, value, err
+		}
+		if ok && condition.IsMet(value) {
+			return true, condition, value, nil
+		}
+	}
+	return false, nil, 0, nil
+}
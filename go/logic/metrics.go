@@ -0,0 +1,147 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/github/gh-ost/go/base"
+	"github.com/outbrain/golib/log"
+)
+
+type printMetricsFunc func(io.Writer)
+
+// 迁移所处的阶段, 通过 gh_ost_phase 暴露
+var migrationPhases = []string{"initializing", "row-copy", "postponed", "cut-over", "complete"}
+
+// MetricsServer serves Prometheus text-format metrics over HTTP, on `--serve-metrics-port`
+type MetricsServer struct {
+	migrationContext *base.MigrationContext
+	listener         net.Listener
+	printMetrics     printMetricsFunc
+}
+
+func NewMetricsServer(migrationContext *base.MigrationContext, printMetrics printMetricsFunc) *MetricsServer {
+	return &MetricsServer{
+		migrationContext: migrationContext,
+		printMetrics:     printMetrics,
+	}
+}
+
+func (this *MetricsServer) BindTCPPort() (err error) {
+	if this.migrationContext.ServeMetricsPort == 0 {
+		return nil
+	}
+	this.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", this.migrationContext.ServeMetricsPort))
+	if err != nil {
+		return err
+	}
+	log.Infof("Serving metrics on: %s", color.RedString(fmt.Sprintf("http://:%d/metrics", this.migrationContext.ServeMetricsPort)))
+	return nil
+}
+
+// Serve begins serving /metrics, if bound
+func (this *MetricsServer) Serve() {
+	if this.listener == nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		this.printMetrics(w)
+	})
+	if err := http.Serve(this.listener, mux); err != nil {
+		log.Debugf("metrics server: %+v", err)
+	}
+}
+
+func (this *MetricsServer) Close() {
+	if this.listener != nil {
+		this.listener.Close()
+	}
+}
+
+// migrationPhase tells which phase the migration is in
+func (this *Migrator) migrationPhase() string {
+	if atomic.LoadInt64(&this.migrationContext.CutOverCompleteFlag) > 0 {
+		return "complete"
+	}
+	if atomic.LoadInt64(&this.migrationContext.IsPostponingCutOver) > 0 {
+		return "postponed"
+	}
+	if this.migrationContext.RowCopyComplete.Load().(bool) {
+		return "cut-over"
+	}
+	if !this.migrationContext.RowCopyStartTime.IsZero() {
+		return "row-copy"
+	}
+	return "initializing"
+}
+
+// printMetrics writes the migration metrics in Prometheus text format. All samples are labeled by
+// database, table and db alias, so that concurrent migrations can be told apart
+func (this *Migrator) printMetrics(writer io.Writer) {
+	metricsWriter := base.NewMetricsWriter(writer,
+		"database", this.migrationContext.DatabaseName,
+		"table", this.migrationContext.OriginalTableName,
+		"alias", this.migrationContext.DatabaseAlias,
+	)
+
+	metricsWriter.Counter("gh_ost_rows_copied_total", "Rows copied from the original table onto the ghost table", float64(this.migrationContext.GetTotalRowsCopied()))
+	rowsEstimate := atomic.LoadInt64(&this.migrationContext.RowsEstimate) + atomic.LoadInt64(&this.migrationContext.RowsDeltaEstimate)
+	metricsWriter.Gauge("gh_ost_rows_estimate", "Estimated number of rows to copy", float64(rowsEstimate))
+	if this.migrationContext.ArchiveTableName != "" || this.migrationContext.ArchiveFile != "" {
+		metricsWriter.Counter("gh_ost_rows_archived_total", "Rows excluded by --origin-filter and archived", float64(this.migrationContext.GetTotalRowsArchived()))
+	}
+	dmlEventsApplied := atomic.LoadInt64(&this.migrationContext.TotalDMLEventsApplied)
+	dmlStatementsApplied := atomic.LoadInt64(&this.migrationContext.TotalDMLStatementsApplied)
+	metricsWriter.Counter("gh_ost_dml_events_applied_total", "Binlog DML events applied onto the ghost table", float64(dmlEventsApplied))
+	metricsWriter.Counter("gh_ost_dml_statements_applied_total", "Statements executed on the ghost table to apply binlog DML events", float64(dmlStatementsApplied))
+	if dmlEventsApplied > 0 {
+		metricsWriter.Gauge("gh_ost_dml_statements_per_event", "Statements executed per binlog DML event applied; below 1 as events of the same rows are coalesced", float64(dmlStatementsApplied)/float64(dmlEventsApplied))
+	}
+	metricsWriter.Counter("gh_ost_binlog_rows_events_relevant_total", "Binlog rows events on the migrated tables, handled", float64(atomic.LoadInt64(&this.migrationContext.RelevantRowsEvents)))
+	metricsWriter.Counter("gh_ost_binlog_rows_events_skipped_total", "Binlog rows events on other tables, skipped", float64(atomic.LoadInt64(&this.migrationContext.SkippedRowsEvents)))
+	metricsWriter.Gauge("gh_ost_apply_events_queue_length", "Backlog of binlog events waiting to be applied", float64(len(this.applyEventsQueue)))
+	metricsWriter.Gauge("gh_ost_apply_events_queue_capacity", "Capacity of the binlog events backlog", float64(cap(this.applyEventsQueue)))
+
+	metricsWriter.Gauge("gh_ost_lag_seconds", "Replication lag of the inspected server, as measured by the heartbeat", time.Duration(atomic.LoadInt64(&this.migrationContext.CurrentLag)).Seconds())
+	if this.migrationContext.GetThrottleControlReplicaKeys().Len() > 0 {
+		lagResult := this.migrationContext.GetControlReplicasLagResult()
+		metricsWriter.Family("gh_ost_control_replicas_lag_seconds", "Highest replication lag among --throttle-control-replicas", "gauge")
+		metricsWriter.Sample("gh_ost_control_replicas_lag_seconds", lagResult.Lag.Seconds(), "replica", lagResult.Key.DisplayString())
+	}
+
+	isThrottled, throttleReason, throttleReasonHint := this.migrationContext.IsThrottled()
+	metricsWriter.Gauge("gh_ost_throttled", "Whether the migration is throttled (1) or not (0)", boolMetricValue(isThrottled))
+	metricsWriter.Family("gh_ost_throttle_reason", "Reason of the current throttling; only present while throttled", "gauge")
+	if isThrottled {
+		metricsWriter.Sample("gh_ost_throttle_reason", 1, "reason", throttleReason, "hint", string(throttleReasonHint))
+	}
+
+	metricsWriter.Gauge("gh_ost_chunk_size", "Current chunk-size; adapted to --chunk-target-millis, when given", float64(atomic.LoadInt64(&this.migrationContext.ChunkSize)))
+	metricsWriter.Histogram("gh_ost_chunk_copy_duration_seconds", "Duration of row-copy chunks", this.chunkCopyLatency)
+	metricsWriter.Counter("gh_ost_cut_over_attempts_total", "Cut-over attempts", float64(atomic.LoadInt64(&this.migrationContext.CutOverAttempts)))
+
+	phase := this.migrationPhase()
+	metricsWriter.Family("gh_ost_phase", "Current migration phase (1), other phases (0)", "gauge")
+	for _, migrationPhase := range migrationPhases {
+		metricsWriter.Sample("gh_ost_phase", boolMetricValue(migrationPhase == phase), "phase", migrationPhase)
+	}
+}
+
+func boolMetricValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -10,11 +10,11 @@ import (
 	"io"
 	"math"
 	"os"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	"errors"
 	"github.com/fatih/color"
 	"github.com/github/gh-ost/go/base"
 	"github.com/github/gh-ost/go/binlog"
@@ -27,10 +27,14 @@ import (
 type ChangelogState string
 
 const (
-	GhostTableMigrated         ChangelogState = "GhostTableMigrated"
-	AllEventsUpToLockProcessed                = "AllEventsUpToLockProcessed"
+	GhostTableMigrated             ChangelogState = "GhostTableMigrated"
+	AllEventsUpToLockProcessed                    = "AllEventsUpToLockProcessed"
+	AllEventsUpToChecksumProcessed                = "AllEventsUpToChecksumProcessed"
 )
 
+// drainEventsBacklogTimeout bounds the wait for the binlog events backlog to be applied, see drainEventsBacklog()
+const drainEventsBacklogTimeout = 5 * time.Minute
+
 func GetRowFormat(total int64, origin bool) string {
 	if origin {
 		return "Copy: %d/%d %5.1f%%; Applied: %d; Backlog: %d/%d; Time: %+v(total), %+v(copy); streamer: %+v; State: %s; ETA: %s"
@@ -47,11 +51,25 @@ func ReadChangelogState(s string) ChangelogState {
 	return ChangelogState(strings.Split(s, ":")[0])
 }
 
+// isStaleChangelogState is true when the given "state:nanos" value was written before the given time
+func isStaleChangelogState(s string, since time.Time) bool {
+	tokens := strings.Split(s, ":")
+	if len(tokens) < 2 {
+		return false
+	}
+	nanos, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		return false
+	}
+	return nanos < since.UnixNano()
+}
+
 type tableWriteFunc func() error
 
 type applyEventStruct struct {
 	writeFunc *tableWriteFunc
 	dmlEvent  *binlog.BinlogDMLEvent
+	commit    bool // 源事务提交: 之前的 dmlEvent 构成一个完整的事务
 }
 
 func newApplyEventStructByFunc(writeFunc *tableWriteFunc) *applyEventStruct {
@@ -64,6 +82,16 @@ func newApplyEventStructByDML(dmlEvent *binlog.BinlogDMLEvent) *applyEventStruct
 	return result
 }
 
+func newApplyEventStructByCommit() *applyEventStruct {
+	result := &applyEventStruct{commit: true}
+	return result
+}
+
+const (
+	// 两步切换时, _gho --> original 的rename尝试次数; 失败则回滚
+	twoStepCutOverRenameAttempts = 5
+)
+
 type PrintStatusRule int
 
 const (
@@ -81,45 +109,76 @@ type Migrator struct {
 	applier          *Applier
 	eventsStreamer   *EventsStreamer
 	server           *Server
+	metricsServer    *MetricsServer
 	throttler        *Throttler
 	hooksExecutor    *HooksExecutor
 	migrationContext *base.MigrationContext
 
-	firstThrottlingCollected   chan bool
-	ghostTableMigrated         chan bool
-	rowCopyComplete            chan error
-	allEventsUpToLockProcessed chan string
+	firstThrottlingCollected       chan bool
+	ghostTableMigrated             chan bool
+	rowCopyComplete                chan error
+	allEventsUpToLockProcessed     chan string
+	allEventsUpToChecksumProcessed chan string
 
 	rowCopyCompleteFlag *AtomicBool // 批量数据拷贝完毕
-	binlogReceived      *AtomicBool // binlog都同步过来了，但不一定"落盘"
-	binlogApplied       *AtomicBool // 在 binlogReceived 的前提下，数据都被同步到 ghost table中
+
+	// cut-over 期间暂停 executeWriteFuncs() 的握手: waitForEventsUpToLock() 发出暂停请求, executeWriteFuncs() 在
+	// 已收到的events都apply之后确认, 并一直等待恢复; cut-over 失败时由 resumeWriteFuncs() 恢复
+	pauseWriteFuncsRequest  chan bool
+	pauseWriteFuncsAck      chan bool
+	resumeWriteFuncsRequest chan bool
+	writeFuncsPaused        bool // 只在 cut-over 的goroutine上访问
 
 	// copyRowsQueue should not be buffered; if buffered some non-damaging but
 	//  excessive work happens at the end of the iteration as new copy-jobs arrive befroe realizing the copy is complete
 	copyRowsQueue    chan tableWriteFunc
 	applyEventsQueue chan *applyEventStruct
 
+	// 由 executeWriteFuncs() 使用: 当前源事务中尚未apply的DML events, 以及等待一起apply的完整事务
+	transactionDMLEvents [](*binlog.BinlogDMLEvent)
+	batchedDMLEvents     [](*binlog.BinlogDMLEvent)
+	// --dml-apply-workers: 用于计算hash的unique key列在原表中的序号
+	dmlApplyHashOrdinals []int
+
 	handledChangelogStates map[string]bool
 
+	// checkpoint 相关: 用于 --resume
+	checkpoint               *base.Checkpoint        // 之前中断的migration写入的checkpoint
+	lastCheckpointTime       time.Time               //
+	appliedEventsCoordinates mysql.BinlogCoordinates // 最近apply的DML event的坐标
+	checkpointCoordinates    mysql.BinlogCoordinates // 该坐标(含)之前的DML events都已经apply到ghost table
+
+	rangeIterations      []*base.RangeIteration // row-copy 的状态: 整个表, 或者每个partition一个
+	rangeIterationsMutex *sync.Mutex
+	copyingConcurrently  *AtomicBool
+	chunkCopyLatency     *base.Histogram
+	chunkSizeController  *base.ChunkSizeController // --chunk-target-millis
+
 	finishedMigrating int64
 }
 
 func NewMigrator(context *base.MigrationContext) *Migrator {
 	migrator := &Migrator{
-		migrationContext:           context,
-		parser:                     sql.NewParser(),
-		ghostTableMigrated:         make(chan bool),
-		firstThrottlingCollected:   make(chan bool, 3),
-		rowCopyComplete:            make(chan error),
-		allEventsUpToLockProcessed: make(chan string),
-
-		copyRowsQueue:          make(chan tableWriteFunc),
-		applyEventsQueue:       make(chan *applyEventStruct, base.MaxEventsBatchSize),
-		handledChangelogStates: make(map[string]bool),
-		finishedMigrating:      0,
-		rowCopyCompleteFlag:    &AtomicBool{},
-		binlogReceived:         &AtomicBool{},
-		binlogApplied:          &AtomicBool{},
+		migrationContext:               context,
+		parser:                         sql.NewParser(),
+		ghostTableMigrated:             make(chan bool),
+		firstThrottlingCollected:       make(chan bool, 3),
+		rowCopyComplete:                make(chan error),
+		allEventsUpToLockProcessed:     make(chan string),
+		allEventsUpToChecksumProcessed: make(chan string),
+
+		copyRowsQueue:           make(chan tableWriteFunc),
+		applyEventsQueue:        make(chan *applyEventStruct, base.MaxEventsBatchSize),
+		handledChangelogStates:  make(map[string]bool),
+		finishedMigrating:       0,
+		rowCopyCompleteFlag:     &AtomicBool{},
+		pauseWriteFuncsRequest:  make(chan bool),
+		pauseWriteFuncsAck:      make(chan bool),
+		resumeWriteFuncsRequest: make(chan bool),
+		rangeIterationsMutex:    &sync.Mutex{},
+		copyingConcurrently:     &AtomicBool{},
+		chunkCopyLatency:        base.NewHistogram(base.DefaultLatencyBuckets),
+		chunkSizeController:     base.NewChunkSizeController(),
 	}
 	return migrator
 }
@@ -249,12 +308,27 @@ func (this *Migrator) onChangelogStateEvent(dmlEvent *binlog.BinlogDMLEvent) (er
 	switch changelogState {
 	case GhostTableMigrated:
 		{
+			// --resume 时可能会再次读到之前的run写入的state
+			if this.handledChangelogStates[string(GhostTableMigrated)] {
+				log.Infof("Skipping duplicate changelog state %s", changelogState)
+				return nil
+			}
+			this.handledChangelogStates[string(GhostTableMigrated)] = true
 			this.ghostTableMigrated <- true
 		}
-	case AllEventsUpToLockProcessed:
+	case AllEventsUpToLockProcessed, AllEventsUpToChecksumProcessed:
 		{
+			if isStaleChangelogState(changelogStateString, this.migrationContext.StartTime) {
+				// Injected by a previous (interrupted) run; nobody is waiting on it
+				log.Infof("Skipping stale changelog state %s", changelogStateString)
+				return nil
+			}
+			processed := this.allEventsUpToLockProcessed
+			if changelogState == AllEventsUpToChecksumProcessed {
+				processed = this.allEventsUpToChecksumProcessed
+			}
 			var applyEventFunc tableWriteFunc = func() error {
-				this.allEventsUpToLockProcessed <- changelogStateString
+				processed <- changelogStateString
 				return nil
 			}
 			// at this point we know all events up to lock have been read from the streamer,
@@ -287,10 +361,17 @@ func (this *Migrator) listenOnPanicAbort() {
 // At this time this means:
 // - column renames are approved
 // - no table rename allowed
+// - no operation which cannot be applied on a ghost table (tablespace, partition exchange)
 func (this *Migrator) validateStatement() (err error) {
 	if this.parser.IsRenameTable() {
 		return fmt.Errorf("ALTER statement seems to RENAME the table. This is not supported, and you should run your RENAME outside gh-ost.")
 	}
+	if unsupported := this.parser.UnsupportedOperations(); len(unsupported) > 0 {
+		return fmt.Errorf("ALTER statement has %s (%s), which cannot be applied on a ghost table. This is not supported, and you should run it outside gh-ost.", unsupported[0].Type, unsupported[0].Text)
+	}
+	for _, operation := range this.parser.UnknownOperations() {
+		log.Warningf("gh-ost does not recognize `%s` in ALTER statement; it is applied on the ghost table as is. Make sure it does not rename or drop columns", operation.Text)
+	}
 	if this.parser.HasNonTrivialRenames() && !this.migrationContext.SkipRenamedColumns {
 		this.migrationContext.ColumnRenameMap = this.parser.GetNonTrivialRenames()
 		// 一般情况下，数据库不要做rename字段；因为已有的代码可能会继续修改被rename的字段，造成大量的错误
@@ -387,6 +468,11 @@ func (this *Migrator) Migrate() (err error) {
 	if err := this.initiateInspector(); err != nil {
 		return err
 	}
+	if this.migrationContext.Resume {
+		if err := this.readCheckpoint(); err != nil {
+			return err
+		}
+	}
 
 	// TODO：最核心的逻辑（全量数据和增量的关系？）
 	//  binlog必须在数据拷贝之前接入，但是不一定需要优先于row data copy
@@ -411,6 +497,9 @@ func (this *Migrator) Migrate() (err error) {
 	if err := this.inspector.inspectOriginalAndGhostTables(); err != nil {
 		return err
 	}
+	if err := this.validateCheckpoint(); err != nil {
+		return err
+	}
 	// Validation complete! We're good to execute this migration
 	if err := this.hooksExecutor.onValidated(); err != nil {
 		return err
@@ -429,17 +518,33 @@ func (this *Migrator) Migrate() (err error) {
 		return err
 	}
 
-	if err := this.addDMLEventsListener(); err != nil {
+	if err := this.initiateThrottler(); err != nil {
 		return err
 	}
-
-	if err := this.initiateThrottler(); err != nil {
+	// 重复值检查及 --preflight-scan 可能是持续数小时的全表扫描: 此时监听原表的DML events, applyEventsQueue 写满之后
+	// 会一直阻塞streamer. row-copy 尚未开始, 之后再监听不会丢失任何数据; 但 --resume 时已经拷贝的数据需要这些events
+	if this.checkpoint != nil {
+		if err := this.addDMLEventsListener(); err != nil {
+			return err
+		}
+	}
+	if err := this.inspector.validateAddedUniqueKeys(this.parser.AddedUniqueKeys(), func() { this.throttler.throttle(nil) }); err != nil {
 		return err
 	}
+	if this.migrationContext.PreflightScan {
+		return this.preflightScan()
+	}
+	if this.checkpoint == nil {
+		if err := this.addDMLEventsListener(); err != nil {
+			return err
+		}
+	}
 	if err := this.hooksExecutor.onBeforeRowCopy(); err != nil {
 		return err
 	}
 
+	this.applier.initiateDMLEventsCoalescing()
+	this.initiateDMLApplyWorkers()
 	// 1. binlog的同步, "增量数据"
 	go this.executeWriteFuncs()
 	// 2. "批量拷贝数据"
@@ -460,6 +565,14 @@ func (this *Migrator) Migrate() (err error) {
 	}
 	this.printStatus(ForcePrintStatusRule)
 
+	// --test-on-replica 在tables swap back之后再做校验
+	if this.migrationContext.VerifyChecksum && !this.migrationContext.TestOnReplica && !this.migrationContext.Noop {
+		verifier := NewVerifier(this.migrationContext, this.applier, func() { this.throttler.throttle(nil) }, this.drainEventsBacklog)
+		if err := verifier.Verify(); err != nil {
+			return err
+		}
+	}
+
 	// 执行完毕了，然后如何cutOver呢?
 	if err := this.hooksExecutor.onBeforeCutOver(); err != nil {
 		return err
@@ -481,6 +594,14 @@ func (this *Migrator) Migrate() (err error) {
 	}
 	atomic.StoreInt64(&this.migrationContext.CutOverCompleteFlag, 1)
 
+	if this.migrationContext.TestOnReplica && !this.migrationContext.Noop {
+		// replication 已经停止, 两张表都是静止的: 不需要throttle, 也不需要等待binlog
+		verifier := NewVerifier(this.migrationContext, this.applier, nil, nil)
+		if err := verifier.Verify(); err != nil {
+			return err
+		}
+	}
+
 	// teardown和finalCleanup可能有一些不同步的问题
 	if err := this.finalCleanup(); err != nil {
 		return nil
@@ -537,6 +658,7 @@ func (this *Migrator) cutOver() (err error) {
 		log.Debugf("Noop operation; not really swapping tables")
 		return nil
 	}
+	atomic.AddInt64(&this.migrationContext.CutOverAttempts, 1)
 	this.migrationContext.MarkPointOfInterest()
 	this.throttler.throttle(func() {
 		log.Debugf("throttling before swapping tables")
@@ -547,18 +669,23 @@ func (this *Migrator) cutOver() (err error) {
 	log.Debugf("checking for cut-over postpone")
 	this.sleepWhileTrue(
 		func() (bool, error) {
-			// --postpone-cut-over-flag-file 没有指定，或指定的文件不存在
+			// --postpone-cut-over-flag-file 没有指定，或指定的文件不存在, 且在 --cut-over-window 之内
 			// 或者通过socket command来控制是否需要cutover
-			if this.migrationContext.PostponeCutOverFlagFile == "" {
+			postponeReason := this.cutOverWindowPostponeReason(time.Now())
+			if postponeReason == "" && this.migrationContext.PostponeCutOverFlagFile == "" {
 				return false, nil
 			}
 			if atomic.LoadInt64(&this.migrationContext.UserCommandedUnpostponeFlag) > 0 {
 				atomic.StoreInt64(&this.migrationContext.UserCommandedUnpostponeFlag, 0)
 				return false, nil
 			}
-			if base.FileExists(this.migrationContext.PostponeCutOverFlagFile) {
+			if postponeReason == "" && base.FileExists(this.migrationContext.PostponeCutOverFlagFile) {
 				// Postpone file defined and exists!
+				postponeReason = "postpone-cut-over-flag-file"
+			}
+			if postponeReason != "" {
 				if atomic.LoadInt64(&this.migrationContext.IsPostponingCutOver) == 0 {
+					log.Infof("Postponing cut-over: %s", postponeReason)
 					if err := this.hooksExecutor.onBeginPostponed(); err != nil {
 						return true, err
 					}
@@ -591,14 +718,127 @@ func (this *Migrator) cutOver() (err error) {
 		}
 	}
 
-	// 优先考虑：一步就CutOver
-	// Atomic solution: we use low timeout and multiple attempts. But for
-	// each failed attempt, we throttle until replication lag is back to normal
-	err = this.atomicCutOver()
+	if this.migrationContext.CutOverType == base.CutOverTwoStep {
+		// 非原子的两步切换: 中间有一小段时间original table不存在
+		err = this.cutOverTwoStep()
+	} else {
+		// 优先考虑：一步就CutOver
+		// Atomic solution: we use low timeout and multiple attempts. But for
+		// each failed attempt, we throttle until replication lag is back to normal
+		err = this.atomicCutOver()
+	}
+	if err != nil {
+		this.resumeWriteFuncs()
+	}
 	this.handleCutOverResult(err)
 	return err
 }
 
+// drainEventsBacklog waits until the binlog events written so far are applied onto the ghost table.
+// Just as waitForEventsUpToLock(), it injects a changelog state, and waits for it to be applied: events
+// are applied in binlog order, hence all events preceding it are applied by then.
+// It is used by the checksum verifier before re-checking mismatching chunks; it gives up after
+// drainEventsBacklogTimeout, e.g. when the binlog streamer is stuck.
+func (this *Migrator) drainEventsBacklog() error {
+	timeout := time.NewTimer(drainEventsBacklogTimeout)
+	defer timeout.Stop()
+
+	allEventsUpToChecksumProcessedChallenge := fmt.Sprintf("%s:%d", string(AllEventsUpToChecksumProcessed), time.Now().UnixNano())
+	log.Infof("Writing changelog state: %+v", allEventsUpToChecksumProcessedChallenge)
+	if _, err := this.applier.WriteChangelogState(allEventsUpToChecksumProcessedChallenge); err != nil {
+		return err
+	}
+	for found := false; !found; {
+		select {
+		case <-timeout.C:
+			return log.Errorf("Timeout while waiting for events backlog")
+		case state := <-this.allEventsUpToChecksumProcessed:
+			if state == allEventsUpToChecksumProcessedChallenge {
+				found = true
+			} else {
+				log.Infof("Waiting for events backlog: skipping %s", state)
+			}
+		}
+	}
+	log.Infof("Events backlog applied")
+	return nil
+}
+
+// pauseWriteFuncs asks executeWriteFuncs() to pause once the events received so far are applied, and waits
+// for it to acknowledge. It is used by cut-over, once all events up to the lock are known to be received.
+func (this *Migrator) pauseWriteFuncs(timeout <-chan time.Time) error {
+	select {
+	case this.pauseWriteFuncsRequest <- true:
+	case <-timeout:
+		return fmt.Errorf("Timeout while waiting for events apply to pause")
+	}
+	<-this.pauseWriteFuncsAck
+	this.writeFuncsPaused = true
+	return nil
+}
+
+// resumeWriteFuncs is called after a failed cut-over attempt. If waitForEventsUpToLock() paused
+// executeWriteFuncs(), we resume it so that the events backlog keeps being applied until the next attempt.
+func (this *Migrator) resumeWriteFuncs() {
+	if !this.writeFuncsPaused {
+		return
+	}
+	log.Infof("Resuming events apply after failed cut-over")
+	this.resumeWriteFuncsRequest <- true
+	this.writeFuncsPaused = false
+}
+
+// cutOverTwoStep locks the original table, waits for all events up to the lock to be applied,
+// then **non-atomically** renames original to _del, and _ghost to original.
+// In between the two renames the original table does not exist, and queries on it fail.
+// If the second rename fails, the original table is renamed back into place.
+func (this *Migrator) cutOverTwoStep() (err error) {
+	atomic.StoreInt64(&this.migrationContext.InCutOverCriticalSectionFlag, 1)
+	defer atomic.StoreInt64(&this.migrationContext.InCutOverCriticalSectionFlag, 0)
+
+	atomic.StoreInt64(&this.migrationContext.AllEventsUpToLockProcessedInjectedFlag, 0)
+
+	lockSession, err := this.applier.LockOriginalTable()
+	if err != nil {
+		return log.Errore(err)
+	}
+	// 只要没有rename成功，就需要unlock
+	if err := this.waitForEventsUpToLock(); err != nil {
+		this.applier.UnlockTables(lockSession)
+		return log.Errore(err)
+	}
+	if err := this.applier.RenameOriginalToOld(lockSession); err != nil {
+		this.applier.UnlockTables(lockSession)
+		return log.Errore(err)
+	}
+	// From this point on, the original table does not exist. We must be quick.
+	if err := this.applier.UnlockTables(lockSession); err != nil {
+		// The session is gone, and so is its lock.
+		log.Errore(err)
+	}
+
+	for i := 0; i < twoStepCutOverRenameAttempts; i++ {
+		if err = this.applier.RenameGhostToOriginal(); err == nil {
+			break
+		}
+		log.Errore(err)
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		// Roll back: original table is put back in place; we are back at pre-cut-over phase.
+		log.Errorf("Unable to rename ghost table; rolling back two-step cut-over")
+		if rollbackErr := this.retryOperation(this.applier.RenameOldToOriginal); rollbackErr != nil {
+			return log.Errore(rollbackErr)
+		}
+		return err
+	}
+
+	lockAndRenameDuration := this.migrationContext.RenameTablesEndTime.Sub(this.migrationContext.LockTablesStartTime)
+	renameDuration := this.migrationContext.RenameTablesEndTime.Sub(this.migrationContext.RenameTablesStartTime)
+	log.Infof("Lock & rename duration: %s (rename only: %s). During this time, queries on %s were blocked or failing", lockAndRenameDuration, renameDuration, sql.EscapeName(this.migrationContext.OriginalTableName))
+	return nil
+}
+
 // Inject the "AllEventsUpToLockProcessed" state hint, wait for it to appear in the binary logs,
 // make sure the queue is drained.
 func (this *Migrator) waitForEventsUpToLock() (err error) {
@@ -645,15 +885,10 @@ func (this *Migrator) waitForEventsUpToLock() (err error) {
 	log.Infof(color.GreenString("Done waiting for events up to lock; duration=%+v"), waitForEventsUpToLockDuration)
 	this.printStatus(ForcePrintStatusAndHintRule)
 
-	// 告知binlog已经接受完毕，等待这些binlog落地到ghost table中；落地完毕之后，就可以直接进行swap tables
-	this.binlogReceived.Set(true)
-	log.Infof(color.CyanString("binlog receive complete, waiting for binlog apply done..."))
-	for i := 0; i < 100; i++ {
-		if this.binlogApplied.Get() {
-			break
-		} else {
-			time.Sleep(time.Millisecond * 10) // 1s应该足够同步剩下的binlog了
-		}
+	// binlog已经接收完毕，等待这些binlog落地到ghost table中, 并暂停apply；之后就可以直接进行swap tables
+	log.Infof(color.CyanString("binlog receive complete, waiting for binlog apply to pause..."))
+	if err := this.pauseWriteFuncs(timeout.C); err != nil {
+		return log.Errore(err)
 	}
 	log.Infof(color.GreenString("binlog receive complete, binlog apply done"))
 
@@ -757,7 +992,7 @@ func (this *Migrator) initiateServer() (err error) {
 	var f printStatusFunc = func(rule PrintStatusRule, writer io.Writer) {
 		this.printStatus(rule, writer)
 	}
-	this.server = NewServer(this.migrationContext, this.hooksExecutor, f)
+	this.server = NewServer(this.migrationContext, this.hooksExecutor, f, this.getMigrationStatus)
 	// 绑定socket, socket可以用来接收外部的命令，动态设置参数
 	if err := this.server.BindSocketFile(); err != nil {
 		return err
@@ -767,6 +1002,14 @@ func (this *Migrator) initiateServer() (err error) {
 	}
 
 	go this.server.Serve()
+
+	this.metricsServer = NewMetricsServer(this.migrationContext, func(writer io.Writer) {
+		this.printMetrics(writer)
+	})
+	if err := this.metricsServer.BindTCPPort(); err != nil {
+		return err
+	}
+	go this.metricsServer.Serve()
 	return nil
 }
 
@@ -874,12 +1117,26 @@ func (this *Migrator) printMigrationStatusHint(writers ...io.Writer) {
 	fmt.Fprintln(w, fmt.Sprintf("# Migration started at %+v",
 		this.migrationContext.StartTime.Format(time.RubyDate),
 	))
+	if this.migrationContext.ArchiveTableName != "" {
+		fmt.Fprintln(w, fmt.Sprintf("# Archiving rows excluded by --origin-filter onto %s.%s",
+			sql.EscapeName(this.migrationContext.DatabaseName),
+			sql.EscapeName(this.migrationContext.ArchiveTableName),
+		))
+	} else if this.migrationContext.ArchiveFile != "" {
+		fmt.Fprintln(w, fmt.Sprintf("# Archiving rows excluded by --origin-filter onto %s",
+			this.migrationContext.ArchiveFile,
+		))
+	}
 	maxLoad := this.migrationContext.GetMaxLoad()
 	criticalLoad := this.migrationContext.GetCriticalLoad()
 
 	// 彩色打印当前的进度
-	fmt.Fprintln(w, fmt.Sprintf(color.MagentaString("# chunk-size: %+v; max-lag-millis: %+vms; dml-batch-size: %+v; max-load: %s; critical-load: %s; nice-ratio: %f"),
-		atomic.LoadInt64(&this.migrationContext.ChunkSize),
+	chunkSize := fmt.Sprintf("%d", atomic.LoadInt64(&this.migrationContext.ChunkSize))
+	if chunkTargetMillis := atomic.LoadInt64(&this.migrationContext.ChunkTargetMillis); chunkTargetMillis > 0 {
+		chunkSize = fmt.Sprintf("%s (adapting to %dms per chunk)", chunkSize, chunkTargetMillis)
+	}
+	fmt.Fprintln(w, fmt.Sprintf(color.MagentaString("# chunk-size: %s; max-lag-millis: %+vms; dml-batch-size: %+v; max-load: %s; critical-load: %s; nice-ratio: %f"),
+		chunkSize,
 		atomic.LoadInt64(&this.migrationContext.MaxLagMillisecondsThrottleThreshold),
 		atomic.LoadInt64(&this.migrationContext.DMLBatchSize),
 		maxLoad.String(),
@@ -915,6 +1172,24 @@ func (this *Migrator) printMigrationStatusHint(writers ...io.Writer) {
 		))
 	}
 
+	if copyWindows := this.migrationContext.GetCopyWindows(); len(copyWindows) > 0 {
+		setIndicator := ""
+		if !copyWindows.Allows(time.Now()) {
+			setIndicator = "[outside]"
+		}
+		fmt.Fprintln(w, fmt.Sprintf("# copy-window: %s %+v",
+			copyWindows, setIndicator,
+		))
+	}
+	if cutOverWindows := this.migrationContext.GetCutOverWindows(); len(cutOverWindows) > 0 {
+		setIndicator := ""
+		if !cutOverWindows.Allows(time.Now()) {
+			setIndicator = "[outside]"
+		}
+		fmt.Fprintln(w, fmt.Sprintf("# cut-over-window: %s %+v",
+			cutOverWindows, setIndicator,
+		))
+	}
 	if this.migrationContext.PostponeCutOverFlagFile != "" {
 		setIndicator := ""
 		if base.FileExists(this.migrationContext.PostponeCutOverFlagFile) {
@@ -942,16 +1217,10 @@ func (this *Migrator) printMigrationStatusHint(writers ...io.Writer) {
 // `rule` indicates the type of output expected.
 // By default the status is written to standard output, but other writers can
 // be used as well.
-func (this *Migrator) printStatus(rule PrintStatusRule, writers ...io.Writer) {
-	if rule == NoPrintStatusRule {
-		return
-	}
-	writers = append(writers, os.Stdout)
-
-	elapsedTime := this.migrationContext.ElapsedTime()
-	elapsedSeconds := int64(elapsedTime.Seconds())
-	totalRowsCopied := this.migrationContext.GetTotalRowsCopied()
-	rowsEstimate := atomic.LoadInt64(&this.migrationContext.RowsEstimate) + atomic.LoadInt64(&this.migrationContext.RowsDeltaEstimate)
+// progressStatus computes the row-copy progress, ETA and state of the migration
+func (this *Migrator) progressStatus() (totalRowsCopied int64, rowsEstimate int64, progressPct float64, etaSeconds float64, eta string, state string) {
+	totalRowsCopied = this.migrationContext.GetTotalRowsCopied()
+	rowsEstimate = atomic.LoadInt64(&this.migrationContext.RowsEstimate) + atomic.LoadInt64(&this.migrationContext.RowsDeltaEstimate)
 
 	if this.rowCopyCompleteFlag.Get() {
 		// Done copying rows. The totalRowsCopied value is the de-facto number of rows,
@@ -959,26 +1228,14 @@ func (this *Migrator) printStatus(rule PrintStatusRule, writers ...io.Writer) {
 		rowsEstimate = totalRowsCopied
 	}
 
-	var progressPct float64
 	if rowsEstimate == 0 {
 		progressPct = 100.0
 	} else {
 		progressPct = 100.0 * float64(totalRowsCopied) / float64(rowsEstimate)
 	}
-	// Before status, let's see if we should print a nice reminder for what exactly we're doing here.
-	shouldPrintMigrationStatusHint := (elapsedSeconds%600 == 0)
-	if rule == ForcePrintStatusAndHintRule {
-		shouldPrintMigrationStatusHint = true
-	}
-	if rule == ForcePrintStatusOnlyRule {
-		shouldPrintMigrationStatusHint = false
-	}
-	if shouldPrintMigrationStatusHint {
-		this.printMigrationStatusHint(writers...)
-	}
 
-	var etaSeconds float64 = math.MaxFloat64
-	eta := "N/A"
+	etaSeconds = math.MaxFloat64
+	eta = "N/A"
 	if progressPct >= 100.0 {
 		eta = "due"
 	} else if progressPct >= 1.0 {
@@ -993,15 +1250,41 @@ func (this *Migrator) printStatus(rule PrintStatusRule, writers ...io.Writer) {
 		}
 	}
 
-	state := "migrating"
+	state = "migrating"
 	if atomic.LoadInt64(&this.migrationContext.CountingRowsFlag) > 0 && !this.migrationContext.ConcurrentCountTableRows {
 		state = "counting rows"
+	} else if atomic.LoadInt64(&this.migrationContext.IsPausedOnDDL) > 0 {
+		state = "paused on DDL"
 	} else if atomic.LoadInt64(&this.migrationContext.IsPostponingCutOver) > 0 {
 		eta = "due"
 		state = "postponing cut-over"
 	} else if isThrottled, throttleReason, _ := this.migrationContext.IsThrottled(); isThrottled {
 		state = fmt.Sprintf("throttled, %s", throttleReason)
 	}
+	return totalRowsCopied, rowsEstimate, progressPct, etaSeconds, eta, state
+}
+
+func (this *Migrator) printStatus(rule PrintStatusRule, writers ...io.Writer) {
+	if rule == NoPrintStatusRule {
+		return
+	}
+	writers = append(writers, os.Stdout)
+
+	elapsedTime := this.migrationContext.ElapsedTime()
+	elapsedSeconds := int64(elapsedTime.Seconds())
+	totalRowsCopied, rowsEstimate, progressPct, etaSeconds, eta, state := this.progressStatus()
+
+	// Before status, let's see if we should print a nice reminder for what exactly we're doing here.
+	shouldPrintMigrationStatusHint := (elapsedSeconds%600 == 0)
+	if rule == ForcePrintStatusAndHintRule {
+		shouldPrintMigrationStatusHint = true
+	}
+	if rule == ForcePrintStatusOnlyRule {
+		shouldPrintMigrationStatusHint = false
+	}
+	if shouldPrintMigrationStatusHint {
+		this.printMigrationStatusHint(writers...)
+	}
 
 	shouldPrintStatus := false
 	if rule == HeuristicPrintStatusRule {
@@ -1056,9 +1339,15 @@ func (this *Migrator) printStatus(rule PrintStatusRule, writers ...io.Writer) {
 			state,
 			eta,
 		)
+		if this.migrationContext.ArchiveTableName != "" || this.migrationContext.ArchiveFile != "" {
+			status = fmt.Sprintf("%s; Archived: %d", status, this.migrationContext.GetTotalRowsArchived())
+		}
 		if !this.migrationContext.Noop {
 			w := io.MultiWriter(writers...)
 			fmt.Fprintln(w, status)
+			if partitionsStatus := this.partitionsStatus(); partitionsStatus != "" {
+				fmt.Fprintln(w, partitionsStatus)
+			}
 		}
 
 		if elapsedSeconds%60 == 0 {
@@ -1067,13 +1356,53 @@ func (this *Migrator) printStatus(rule PrintStatusRule, writers ...io.Writer) {
 	}
 }
 
+// partitionsStatus summarizes the row-copy progress per partition (--partition-opt), or returns empty string
+func (this *Migrator) partitionsStatus() string {
+	if len(this.migrationContext.PartitionInfos) == 0 {
+		return ""
+	}
+	rangeIterations := this.getRangeIterations()
+	completeCount := 0
+	copying := []string{}
+	for _, rangeIteration := range rangeIterations {
+		if rangeIteration.IsComplete() {
+			completeCount++
+			continue
+		}
+		if !rangeIteration.IsStarted() {
+			continue
+		}
+		rowsCopied := rangeIteration.GetRowsCopied()
+		if tableRows := rangeIteration.Partition.TableRows; tableRows > 0 {
+			copying = append(copying, fmt.Sprintf("%s: %d/%d %.1f%%", rangeIteration.Name(), rowsCopied, tableRows, 100.0*float64(rowsCopied)/float64(tableRows)))
+		} else {
+			copying = append(copying, fmt.Sprintf("%s: %d", rangeIteration.Name(), rowsCopied))
+		}
+	}
+	return fmt.Sprintf("Partitions: %d/%d copied; copying: [%s]", completeCount, len(this.migrationContext.PartitionInfos), strings.Join(copying, ", "))
+}
+
 // initiateStreaming begins streaming of binary log events and registers listeners for such events
 func (this *Migrator) initiateStreaming() error {
 	// 关注一下: NewEventsStreamer
 	this.eventsStreamer = NewEventsStreamer(this.migrationContext)
+	if this.checkpoint != nil {
+		coordinates, err := this.checkpoint.GetLastAppliedRowsEventHint()
+		if err != nil {
+			return err
+		}
+		log.Infof("Resuming binlog streaming after %+v", *coordinates)
+		this.eventsStreamer.ResumeFrom(coordinates)
+	}
 	if err := this.eventsStreamer.InitDBConnections(); err != nil {
 		return err
 	}
+	// 还没有apply任何DML event时, checkpoint 使用streamer的起始位置
+	if this.checkpoint != nil && !this.eventsStreamer.binlogReader.LastAppliedRowsEventHint.IsEmpty() {
+		this.checkpointCoordinates = this.eventsStreamer.binlogReader.LastAppliedRowsEventHint
+	} else {
+		this.checkpointCoordinates = *this.eventsStreamer.initialBinlogCoordinates
+	}
 
 	// 为binlog添加changelog的监听，用来判断是否已经同步完毕所有的binlog
 	this.eventsStreamer.AddListener(
@@ -1084,6 +1413,9 @@ func (this *Migrator) initiateStreaming() error {
 			return this.onChangelogStateEvent(dmlEvent)
 		},
 	)
+	if err := this.addDDLEventsListener(); err != nil {
+		return err
+	}
 
 	// 如何开始streaming呢?
 	go func() {
@@ -1112,14 +1444,17 @@ func (this *Migrator) initiateStreaming() error {
 // and creates & enqueues a write task per such event.
 func (this *Migrator) addDMLEventsListener() error {
 	// 用于监听origin table的binlog
-	err := this.eventsStreamer.AddListener(
-		false,
+	err := this.eventsStreamer.AddTransactionalListener(
 		this.migrationContext.DatabaseName,
 		this.migrationContext.OriginalTableName,
 		func(dmlEvent *binlog.BinlogDMLEvent) error {
 			this.applyEventsQueue <- newApplyEventStructByDML(dmlEvent)
 			return nil
 		},
+		func() error {
+			this.applyEventsQueue <- newApplyEventStructByCommit()
+			return nil
+		},
 	)
 	return err
 }
@@ -1148,19 +1483,24 @@ func (this *Migrator) initiateApplier() error {
 		return err
 	}
 	log.Infof(color.GreenString("drop old tables done"))
-	// Changelog 和 Ghost的关系?
-	if err := this.applier.CreateChangelogTable(); err != nil {
-		log.Errorf("Unable to create changelog table, see further error details. Perhaps a previous migration failed without dropping the table? OR is there a running migration? Bailing out")
-		return err
-	}
-	if err := this.applier.CreateGhostTable(); err != nil {
-		log.Errorf("Unable to create ghost table, see further error details. Perhaps a previous migration failed without dropping the table? Bailing out")
-		return err
-	}
+	if this.migrationContext.Resume {
+		// ghost & changelog tables are kept as they are: ghost table is already altered and partially populated
+		log.Infof("Resuming; reusing existing ghost and changelog tables")
+	} else {
+		// Changelog 和 Ghost的关系?
+		if err := this.applier.CreateChangelogTable(); err != nil {
+			log.Errorf("Unable to create changelog table, see further error details. Perhaps a previous migration failed without dropping the table? OR is there a running migration? Bailing out")
+			return err
+		}
+		if err := this.applier.CreateGhostTable(); err != nil {
+			log.Errorf("Unable to create ghost table, see further error details. Perhaps a previous migration failed without dropping the table? Bailing out")
+			return err
+		}
 
-	if err := this.applier.AlterGhost(); err != nil {
-		log.Errorf("Unable to ALTER ghost table, see further error details. Bailing out")
-		return err
+		if err := this.applier.AlterGhost(); err != nil {
+			log.Errorf("Unable to ALTER ghost table, see further error details. Bailing out")
+			return err
+		}
 	}
 
 	// 如果针对partition做优化
@@ -1184,6 +1524,13 @@ func (this *Migrator) initiateApplier() error {
 		}
 	}
 
+	if !this.migrationContext.Noop {
+		if err := this.applier.InitiateArchive(); err != nil {
+			log.Errorf("Unable to initiate archive of filtered out rows, see further error details. Bailing out")
+			return err
+		}
+	}
+
 	// Ghost表准备好了
 	this.applier.WriteChangelogState(string(GhostTableMigrated))
 
@@ -1194,18 +1541,12 @@ func (this *Migrator) initiateApplier() error {
 
 // iterateChunks iterates the existing table rows, and generates a copy task of
 // a chunk of rows onto the ghost table.
+// With --partition-opt each partition is a range iteration of its own; up to --partition-workers
+// partitions are iterated concurrently.
 func (this *Migrator) iterateChunks() error {
-
-	rowRangeComplete := &AtomicBool{} // 某一个指定的Partition是否遍历完毕
-	rowRangeError := &AtomicBool{}    // 遍历过程中是否出现错误
-
 	terminateRowIteration := func(err error) error {
 		// err == nil 只在成功处理完毕所有的数据之后才调用
-		rowRangeComplete.Set(true)
 		log.Infof("TerminateRowIteration err: %v", err)
-		if err != nil {
-			rowRangeError.Set(true)
-		}
 		this.rowCopyComplete <- err
 		return err
 	}
@@ -1214,198 +1555,250 @@ func (this *Migrator) iterateChunks() error {
 		return terminateRowIteration(nil)
 	}
 
-	// 处理单独的一个partition
-	partitionIter := func(partition *sql.PartitionInfo) error {
-		// 没有数据，直接返回
-		if this.migrationContext.MigrationRangeMinValues == nil {
-			return nil
+	rangeIterations := []*base.RangeIteration{}
+	if len(this.migrationContext.PartitionInfos) == 0 {
+		rangeIterations = append(rangeIterations, base.NewRangeIteration(nil))
+	} else {
+		for _, partitionInfo := range this.migrationContext.PartitionInfos {
+			rangeIterations = append(rangeIterations, base.NewRangeIteration(partitionInfo))
 		}
+	}
+	this.setRangeIterations(rangeIterations)
 
-		rowRangeComplete.Set(false)
-
-		// 每一个partition同步完毕自己的数据之后，才结束 partitionIter
-		copyRowsWg := &sync.WaitGroup{}
-		for {
-			// 退出，或者当前的partition已经处理完毕
-			if this.rowCopyCompleteFlag.Get() || rowRangeComplete.Get() {
-				// Done
-				// There's another such check down the line
-				break
+	// --resume: checkpoint之前的partition已经拷贝完毕
+	resumePartitionFound := this.checkpoint == nil || this.checkpoint.PartitionName == ""
+	pendingRangeIterations := []*base.RangeIteration{}
+	for _, rangeIteration := range rangeIterations {
+		if !resumePartitionFound {
+			if rangeIteration.Name() != this.checkpoint.PartitionName {
+				log.Infof("Skipping partition %s: copied before checkpoint", rangeIteration.Name())
+				rangeIteration.SetComplete()
+				continue
 			}
+			resumePartitionFound = true
+		}
+		pendingRangeIterations = append(pendingRangeIterations, rangeIteration)
+	}
 
-			copyRowsWg.Add(1)
-			copyRowsFunc := func() error {
-				defer copyRowsWg.Done()
+	workers := 1
+	if len(this.migrationContext.PartitionInfos) > 0 {
+		workers = int(this.migrationContext.PartitionWorkers)
+	}
+	queue := make(chan *base.RangeIteration)
+	iterationFailed := &AtomicBool{}
+	var iterationErr error
+	var iterationErrOnce sync.Once
 
-				if this.rowCopyCompleteFlag.Get() || rowRangeComplete.Get() {
-					// Done.
-					// There's another such check down the line
-					return nil
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rangeIteration := range queue {
+				if err := this.iterateRange(rangeIteration); err != nil {
+					iterationErrOnce.Do(func() { iterationErr = err })
+					iterationFailed.Set(true)
 				}
+			}
+		}()
+	}
+	// 按顺序分发: 第一个未完成的 partition 之前的 partition 都已经拷贝完毕 (checkpoint 依赖于此)
+	for _, rangeIteration := range pendingRangeIterations {
+		if iterationFailed.Get() || this.rowCopyCompleteFlag.Get() {
+			break
+		}
+		queue <- rangeIteration
+	}
+	close(queue)
+	wg.Wait()
+	this.copyingConcurrently.Set(false)
 
-				// 计算当前iteration的range
-				// 需要注意所的问题：
-				hasFurtherRange, err := this.applier.CalculateNextIterationRangeEndValues(partition)
-				if err != nil {
-					return err // wrapping call will retry
-				}
-				if !hasFurtherRange {
-					rowRangeComplete.Set(true) // 没有数据了，则本轮循环可以关闭
-					return nil
-				}
+	return terminateRowIteration(iterationErr)
+}
 
-				// 实际的数据拷贝任务
-				applyCopyRowsFunc := func() error {
-					if this.rowCopyCompleteFlag.Get() || rowRangeComplete.Get() {
-						// No need for more writes.
-						// This is the de-facto place where we avoid writing in the event of completed cut-over.
-						// There could _still_ be a race condition, but that's as close as we can get.
-						// What about the race condition? Well, there's actually no data integrity issue.
-						// when rowCopyCompleteFlag==1 that means **guaranteed** all necessary rows have been copied.
-						// But some are still then collected at the binary log, and these are the ones we're trying to
-						// not apply here. If the race condition wins over us, then we just attempt to apply onto the
-						// _ghost_ table, which no longer exists. So, bothering error messages and all, but no damage.
-						return nil
-					}
+// iterateRange copies the rows of a single range iteration: the whole table, or a single partition
+func (this *Migrator) iterateRange(rangeIteration *base.RangeIteration) error {
+	defer rangeIteration.SetComplete()
 
-					_, rowsAffected, _, err := this.applier.ApplyIterationInsertQuery(partition)
-					if err != nil {
-						// terminateRowIteration 不能轻易调用，否则就结束了
-						// return terminateRowIteration(err)
-						return err
-					}
+	if err := this.applier.ReadMigrationRangeValues(rangeIteration); err != nil {
+		return err
+	}
+	// 没有数据，直接返回
+	if !rangeIteration.HasRange() {
+		if rangeIteration.Partition == nil {
+			log.Debugf("No rows found in table. Rowcopy will be implicitly empty")
+		}
+		return nil
+	}
+	if err := this.applyCheckpointIteration(rangeIteration); err != nil {
+		return err
+	}
+	rangeIteration.SetStarted()
 
-					if this.migrationContext.OriginalFilter != "" {
-						// 如果 OriginalFilter 存在，则 rowsAffected 可能偏少，不利于进度估计，因此直接使用 chunkSize 来代替
-						chunkSize := atomic.LoadInt64(&this.migrationContext.ChunkSize)
-						atomic.AddInt64(&this.migrationContext.TotalRowsCopied, chunkSize)
-					} else {
-						atomic.AddInt64(&this.migrationContext.TotalRowsCopied, rowsAffected)
-					}
+	if this.migrationContext.CopyWorkers > 1 || this.migrationContext.PartitionWorkers > 1 {
+		return this.copyRangesConcurrently(rangeIteration)
+	}
+
+	var copyErr error
+	copyFailed := &AtomicBool{}
+	rowRangeComplete := &AtomicBool{} // 当前range是否遍历完毕
+
+	// 当前range同步完毕自己的数据之后，才结束 iterateRange
+	copyRowsWg := &sync.WaitGroup{}
+	for {
+		// 退出，或者当前的range已经处理完毕
+		if this.rowCopyCompleteFlag.Get() || rowRangeComplete.Get() || copyFailed.Get() {
+			// Done
+			// There's another such check down the line
+			break
+		}
 
-					// 更改统计数据
-					atomic.AddInt64(&this.migrationContext.Iteration, 1)
+		copyRowsWg.Add(1)
+		copyRowsFunc := func() error {
+			defer copyRowsWg.Done()
+
+			if this.rowCopyCompleteFlag.Get() || rowRangeComplete.Get() {
+				// Done.
+				// There's another such check down the line
+				return nil
+			}
+
+			// 计算当前iteration的range
+			// 需要注意所的问题：
+			hasFurtherRange, err := this.applier.CalculateNextIterationRangeEndValues(rangeIteration)
+			if err != nil {
+				return err // wrapping call will retry
+			}
+			if !hasFurtherRange {
+				rowRangeComplete.Set(true) // 没有数据了，则本轮循环可以关闭
+				return nil
+			}
+			seq := rangeIteration.DispatchChunk()
+
+			// 实际的数据拷贝任务
+			applyCopyRowsFunc := func() error {
+				if this.rowCopyCompleteFlag.Get() || rowRangeComplete.Get() {
+					// No need for more writes.
+					// This is the de-facto place where we avoid writing in the event of completed cut-over.
+					// There could _still_ be a race condition, but that's as close as we can get.
+					// What about the race condition? Well, there's actually no data integrity issue.
+					// when rowCopyCompleteFlag==1 that means **guaranteed** all necessary rows have been copied.
+					// But some are still then collected at the binary log, and these are the ones we're trying to
+					// not apply here. If the race condition wins over us, then we just attempt to apply onto the
+					// _ghost_ table, which no longer exists. So, bothering error messages and all, but no damage.
 					return nil
 				}
-				if err := this.retryOperation(applyCopyRowsFunc); err != nil {
-					return terminateRowIteration(err)
+
+				chunkSize, rowsAffected, duration, err := this.applier.ApplyIterationInsertQuery(rangeIteration)
+				if err != nil {
+					this.backOffChunkSize(chunkSize, err)
+					return err
 				}
+				this.addRowsCopied(rangeIteration, rowsAffected)
+				this.chunkCopyLatency.Observe(duration.Seconds())
+				this.adaptChunkSize(chunkSize, duration)
+
+				// 更改统计数据
+				rangeIteration.ChunkCopied(seq, rangeIteration.IterationRangeMaxValues)
+				atomic.AddInt64(&rangeIteration.Iteration, 1)
+				atomic.AddInt64(&this.migrationContext.Iteration, 1)
 				return nil
 			}
-			// Enqueue copy operation; to be executed by executeWriteFuncs()
-			this.copyRowsQueue <- copyRowsFunc
+			if err := this.retryOperation(applyCopyRowsFunc); err != nil {
+				copyErr = err
+				copyFailed.Set(true)
+				return err
+			}
+			return nil
 		}
-
-		copyRowsWg.Wait() // 等待当前的Task执行完毕
-		// log.Infof(color.GreenString("PartitionIter complete for partition: %s"), partition.PartitionName)
-
-		return nil
+		// Enqueue copy operation; to be executed by executeWriteFuncs()
+		this.copyRowsQueue <- copyRowsFunc
 	}
 
-	// 遍历处理所有的PartitionInfos
-	if len(this.migrationContext.PartitionInfos) == 0 {
-		if err := this.applier.ReadMigrationRangeValues(nil); err != nil {
-			return err
-		}
+	copyRowsWg.Wait() // 等待当前的Task执行完毕
+	return copyErr
+}
 
-		if this.migrationContext.MigrationRangeMinValues == nil {
-			log.Debugf("No rows found in table. Rowcopy will be implicitly empty")
-			return terminateRowIteration(nil)
+// onApplyEventStruct applies binlog events onto the ghost table, preserving source transactions: DML events are
+// applied once their transaction commits, several whole transactions batched together up to DMLBatchSize events.
+// Only transactions larger than DMLBatchSize are split.
+func (this *Migrator) onApplyEventStruct(eventStruct *applyEventStruct) error {
+	batchSize := int(atomic.LoadInt64(&this.migrationContext.DMLBatchSize))
+	switch {
+	case eventStruct.dmlEvent != nil:
+		this.transactionDMLEvents = append(this.transactionDMLEvents, eventStruct.dmlEvent)
+		if len(this.transactionDMLEvents) < batchSize {
+			return nil
 		}
-
-		err := partitionIter(nil)
-		if err != nil {
+		// 超过 DMLBatchSize 的源事务, 拆分成多个批次
+		if err := this.applyBatchedDMLEvents(); err != nil {
 			return err
 		}
-		return terminateRowIteration(nil)
-	}
-
-	for _, partitionInfo := range this.migrationContext.PartitionInfos {
-		if rowRangeError.Get() {
-			return terminateRowIteration(errors.New("row range error"))
+		dmlEvents := this.transactionDMLEvents
+		this.transactionDMLEvents = nil
+		return this.applyDMLEvents(dmlEvents, false)
+	case eventStruct.commit:
+		if len(this.batchedDMLEvents)+len(this.transactionDMLEvents) > batchSize {
+			if err := this.applyBatchedDMLEvents(); err != nil {
+				return err
+			}
 		}
-
-		// 重置状态
-		this.migrationContext.MigrationRangeMinValues = nil
-		this.migrationContext.MigrationRangeMaxValues = nil
-		this.migrationContext.MigrationIterationRangeMaxValues = nil
-		this.migrationContext.MigrationIterationRangeMinValues = nil
-		atomic.StoreInt64(&this.migrationContext.Iteration, 0) // 重新开始迭代
-
-		if err := this.applier.ReadMigrationRangeValues(partitionInfo); err != nil {
-			return terminateRowIteration(err)
+		this.batchedDMLEvents = append(this.batchedDMLEvents, this.transactionDMLEvents...)
+		this.transactionDMLEvents = nil
+		// 队列中还有events时, 继续积攒完整的事务
+		if len(this.batchedDMLEvents) >= batchSize || len(this.applyEventsQueue) == 0 {
+			return this.applyBatchedDMLEvents()
 		}
+		return nil
+	}
 
-		// 一次一个Partition来处理
-		err := partitionIter(partitionInfo)
-		if err != nil {
+	// Not a DML. Events received so far must be applied before it
+	if err := this.applyBatchedDMLEvents(); err != nil {
+		return err
+	}
+	if len(this.transactionDMLEvents) > 0 {
+		dmlEvents := this.transactionDMLEvents
+		this.transactionDMLEvents = nil
+		if err := this.applyDMLEvents(dmlEvents, false); err != nil {
 			return err
 		}
 	}
-	return terminateRowIteration(nil)
+	if eventStruct.writeFunc != nil {
+		if err := this.retryOperation(*eventStruct.writeFunc); err != nil {
+			return log.Errore(err)
+		}
+	}
+	return nil
 }
 
-func (this *Migrator) onApplyEventStruct(eventStruct *applyEventStruct) error {
-	handleNonDMLEventStruct := func(eventStruct *applyEventStruct) error {
-		if eventStruct.writeFunc != nil {
-			if err := this.retryOperation(*eventStruct.writeFunc); err != nil {
-				return log.Errore(err)
-			}
-		}
+// applyBatchedDMLEvents applies the (whole) transactions batched so far, in a single transaction
+func (this *Migrator) applyBatchedDMLEvents() error {
+	if len(this.batchedDMLEvents) == 0 {
 		return nil
 	}
-	if eventStruct.dmlEvent == nil {
-		return handleNonDMLEventStruct(eventStruct)
-	}
-
-	if eventStruct.dmlEvent != nil {
-		// 拷贝一份数据出来?
-		dmlEvents := [](*binlog.BinlogDMLEvent){}
-
-		// 先消费一个Event
-		dmlEvents = append(dmlEvents, eventStruct.dmlEvent)
-		var nonDmlStructToApply *applyEventStruct
-
-		availableEvents := len(this.applyEventsQueue)
-		batchSize := int(atomic.LoadInt64(&this.migrationContext.DMLBatchSize))
-		if availableEvents > batchSize-1 {
-			// The "- 1" is because we already consumed one event: the original event that led to this function getting called.
-			// So, if DMLBatchSize==1 we wish to not process any further events
-			availableEvents = batchSize - 1
-		}
-
-		// 是否考虑批量执行(把剩下可以执行的命令尽可能多地执行)
-		for i := 0; i < availableEvents; i++ {
-			//log.Infof("QueueLen: %d", len(this.applyEventsQueue))
-			additionalStruct := <-this.applyEventsQueue
-			if additionalStruct.dmlEvent == nil {
-				// Not a DML. We don't group this, and we don't batch any further
-				// 非DML, 那可能是什么情况呢?
-				nonDmlStructToApply = additionalStruct
-				break
-			}
-			dmlEvents = append(dmlEvents, additionalStruct.dmlEvent)
-		}
+	dmlEvents := this.batchedDMLEvents
+	this.batchedDMLEvents = nil
+	return this.applyDMLEvents(dmlEvents, true)
+}
 
-		// Create a task to apply the DML event; this will be execute by executeWriteFuncs()
-		// 如何实现一个RetryWrapper呢?
-		// 函数闭包，消除被Retry函数的特异性
-		//
-		var applyEventFunc tableWriteFunc = func() error {
-			return this.applier.ApplyDMLEventQueries(dmlEvents)
-		}
-		if err := this.retryOperation(applyEventFunc); err != nil {
+// applyDMLEvents applies given events in a single transaction, retrying as a whole; or, with --dml-apply-workers,
+// in a transaction per worker. transactionComplete tells whether the events end with a source transaction commit.
+func (this *Migrator) applyDMLEvents(dmlEvents [](*binlog.BinlogDMLEvent), transactionComplete bool) error {
+	if this.migrationContext.DMLApplyWorkers > 1 {
+		if err := this.applyDMLEventsConcurrently(dmlEvents); err != nil {
 			return log.Errore(err)
 		}
-
-		if nonDmlStructToApply != nil {
-			// We pulled DML events from the queue, and then we hit a non-DML event. Wait!
-			// We need to handle it!
-			if err := handleNonDMLEventStruct(nonDmlStructToApply); err != nil {
-				return log.Errore(err)
-			}
-		}
+		this.onDMLEventsApplied(dmlEvents, transactionComplete)
+		return nil
 	}
+	// 函数闭包，消除被Retry函数的特异性
+	var applyEventFunc tableWriteFunc = func() error {
+		return this.applier.ApplyDMLEventQueries(dmlEvents)
+	}
+	if err := this.retryOperation(applyEventFunc); err != nil {
+		return log.Errore(err)
+	}
+	this.onDMLEventsApplied(dmlEvents, transactionComplete)
 	return nil
 }
 
@@ -1413,19 +1806,16 @@ func (this *Migrator) onApplyEventStruct(eventStruct *applyEventStruct) error {
 // This is where the ghost table gets the data. The function fills the data single-threaded.
 // Both event backlog and rowcopy events are polled; the backlog events have precedence.
 func (this *Migrator) executeWriteFuncs() error {
-
-	defer this.binlogApplied.Set(true)
-
 	if this.migrationContext.Noop {
 		log.Debugf("Noop operation; not really executing write funcs")
 		return nil
 	}
-	binlogReceiveComplete := false
 	waitIndex := 0
 	for {
 		if atomic.LoadInt64(&this.finishedMigrating) > 0 {
 			return nil
 		}
+		this.checkpointIfDue()
 		this.throttler.throttle(nil)
 
 		// We give higher priority to event processing, then secondary priority to
@@ -1459,36 +1849,29 @@ func (this *Migrator) executeWriteFuncs() error {
 						}
 
 						//log.Infof(color.GreenString("copyRowsFunc finished"))
-						if niceRatio := this.migrationContext.GetNiceRatio(); niceRatio > 0 {
-							copyRowsDuration := time.Since(copyRowsStartTime)
-							sleepTimeNanosecondFloat64 := niceRatio * float64(copyRowsDuration.Nanoseconds())
-							sleepTime := time.Duration(time.Duration(int64(sleepTimeNanosecondFloat64)) * time.Nanosecond)
-							//log.Infof(color.RedString("sleepTime：%.3fs"), sleepTime)
-							time.Sleep(sleepTime)
-						}
+						this.niceSleep(time.Since(copyRowsStartTime))
 					}
 				default:
 					{
-
-						if binlogReceiveComplete {
-							log.Infof(color.GreenString("BinlogReceived and has appplied"))
-							return nil
-						}
-
-						// 如果binlog接收完毕，则再给上面的chan一次读取的机会(避免race condition)
-						if this.binlogReceived.Get() {
-							binlogReceiveComplete = true
-						} else {
+						select {
+						case <-this.pauseWriteFuncsRequest:
+							// cut-over: 队列已空, 已收到的events都已经apply. 暂停, 直到cut-over失败后恢复;
+							// cut-over 成功时不再恢复
+							log.Infof(color.GreenString("Events applied; pausing events apply for cut-over"))
+							this.pauseWriteFuncsAck <- true
+							<-this.resumeWriteFuncsRequest
+							log.Infof("Events apply resumed")
+						default:
 							// Hmmmmm... nothing in the queue; no events, but also no row copy.
 							// This is possible upon load. Let's just sleep it over.
 							waitIndex++
-							if waitIndex%100 == 0 {
+							// --copy-workers: row-copy 不经过 copyRowsQueue
+							if waitIndex%100 == 0 && !this.copyingConcurrently.Get() {
 								// 控制一下log的频率
 								log.Infof(color.RedString("Getting nothing in the write queue. Sleeping..."))
 							}
 							time.Sleep(time.Millisecond * 10)
 						}
-
 					}
 				}
 			}
@@ -1497,6 +1880,147 @@ func (this *Migrator) executeWriteFuncs() error {
 	return nil
 }
 
+// readCheckpoint reads the progress persisted by an interrupted migration; used by `--resume`
+func (this *Migrator) readCheckpoint() (err error) {
+	if this.checkpoint, err = this.inspector.readCheckpoint(); err != nil {
+		return err
+	}
+	log.Infof(color.GreenString("Found checkpoint: %s"), this.checkpoint)
+	// 之前的migration执行的DDL可能在checkpoint之后, 仍会被读取: 沿用其token
+	if this.checkpoint.MigrationToken != "" {
+		this.migrationContext.MigrationToken = this.checkpoint.MigrationToken
+	}
+	return nil
+}
+
+// validateCheckpoint makes sure the checkpoint applies to this migration, and restores the
+// copy progress counters. Expected to run once the migration unique key is chosen.
+func (this *Migrator) validateCheckpoint() error {
+	if this.checkpoint == nil {
+		return nil
+	}
+	if this.checkpoint.UniqueKey != this.migrationContext.UniqueKey.Name {
+		return fmt.Errorf("Checkpoint was taken on unique key %s, but this migration chose %s. Cannot resume", this.checkpoint.UniqueKey, this.migrationContext.UniqueKey.Name)
+	}
+	if this.checkpoint.PartitionName != "" {
+		found := false
+		for _, partitionInfo := range this.migrationContext.PartitionInfos {
+			if partitionInfo.PartitionName == this.checkpoint.PartitionName {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("Checkpoint was taken on partition %s, which is not being iterated (is --partition-opt given?). Cannot resume", this.checkpoint.PartitionName)
+		}
+	} else if len(this.migrationContext.PartitionInfos) > 0 && len(this.checkpoint.IterationRangeMaxValues) > 0 {
+		return fmt.Errorf("Checkpoint was taken without --partition-opt. Cannot resume with --partition-opt")
+	}
+	atomic.StoreInt64(&this.migrationContext.TotalRowsCopied, this.checkpoint.TotalRowsCopied)
+	return nil
+}
+
+// applyCheckpointIteration positions row-copy of the checkpoint's range iteration (the whole table, or a partition)
+// right after the last chunk copied by the interrupted migration. It is called once migration range values are read
+func (this *Migrator) applyCheckpointIteration(rangeIteration *base.RangeIteration) error {
+	if this.checkpoint == nil || this.checkpoint.PartitionName != rangeIteration.Name() {
+		return nil
+	}
+	iterationRangeMaxValues, err := this.checkpoint.GetIterationRangeMaxValues()
+	if err != nil {
+		return err
+	}
+	if iterationRangeMaxValues == nil {
+		return nil
+	}
+	rangeIteration.ResumeAfter(iterationRangeMaxValues, this.checkpoint.Iteration)
+	atomic.StoreInt64(&this.migrationContext.Iteration, this.checkpoint.Iteration)
+	log.Infof("Resuming row copy after %s; iteration: %d", iterationRangeMaxValues, this.checkpoint.Iteration)
+	return nil
+}
+
+func (this *Migrator) setRangeIterations(rangeIterations []*base.RangeIteration) {
+	this.rangeIterationsMutex.Lock()
+	defer this.rangeIterationsMutex.Unlock()
+	this.rangeIterations = rangeIterations
+}
+
+func (this *Migrator) getRangeIterations() []*base.RangeIteration {
+	this.rangeIterationsMutex.Lock()
+	defer this.rangeIterationsMutex.Unlock()
+	return this.rangeIterations
+}
+
+// rowCopyProgress returns the row-copy progress to be checkpointed: that of the first range iteration
+// not yet complete. Range iterations are started in order, hence all those before it are complete
+func (this *Migrator) rowCopyProgress() (partitionName string, iterationRangeMaxValues *sql.ColumnValues, iteration int64) {
+	rangeIterations := this.getRangeIterations()
+	for _, rangeIteration := range rangeIterations {
+		if !rangeIteration.IsComplete() {
+			iterationRangeMaxValues, iteration = rangeIteration.Progress()
+			return rangeIteration.Name(), iterationRangeMaxValues, iteration
+		}
+	}
+	if len(rangeIterations) > 0 {
+		lastRangeIteration := rangeIterations[len(rangeIterations)-1]
+		iterationRangeMaxValues, iteration = lastRangeIteration.Progress()
+		return lastRangeIteration.Name(), iterationRangeMaxValues, iteration
+	}
+	return "", nil, 0
+}
+
+// onDMLEventsApplied keeps track of the coordinates up to which DML events are known to be applied.
+// A single rows event may have been split between batches, hence we only trust the coordinates
+// of the event preceding the last one applied; unless the events end with a source transaction commit.
+func (this *Migrator) onDMLEventsApplied(dmlEvents [](*binlog.BinlogDMLEvent), transactionComplete bool) {
+	for _, dmlEvent := range dmlEvents {
+		if dmlEvent.Coordinates.Equals(&this.appliedEventsCoordinates) {
+			continue
+		}
+		if !this.appliedEventsCoordinates.IsEmpty() {
+			this.checkpointCoordinates = this.appliedEventsCoordinates
+		}
+		this.appliedEventsCoordinates = dmlEvent.Coordinates
+	}
+	if transactionComplete {
+		// 事务的所有rows event都已经apply
+		this.checkpointCoordinates = this.appliedEventsCoordinates
+	}
+}
+
+// checkpointIfDue persists the migration progress every `--checkpoint-interval-seconds`.
+// It must run on the executeWriteFuncs() goroutine, in between write funcs, so that row-copy
+// progress and applied binlog coordinates are consistent with the ghost table.
+func (this *Migrator) checkpointIfDue() {
+	interval := time.Duration(atomic.LoadInt64(&this.migrationContext.CheckpointIntervalSeconds)) * time.Second
+	if time.Since(this.lastCheckpointTime) < interval {
+		return
+	}
+	if atomic.LoadInt64(&this.migrationContext.CleanupImminentFlag) > 0 {
+		return
+	}
+	this.lastCheckpointTime = time.Now()
+
+	// 并发拷贝时, 只有连续拷贝完毕的chunk才能作为checkpoint
+	partitionName, iterationRangeMaxValues, iteration := this.rowCopyProgress()
+	checkpoint := &base.Checkpoint{
+		UniqueKey:                this.migrationContext.UniqueKey.Name,
+		PartitionName:            partitionName,
+		Iteration:                iteration,
+		TotalRowsCopied:          this.migrationContext.GetTotalRowsCopied(),
+		LastAppliedRowsEventHint: this.checkpointCoordinates.DisplayString(),
+		ExecutedGTIDSet:          this.checkpointCoordinates.GTIDSet,
+		MigrationToken:           this.migrationContext.MigrationToken,
+		Timestamp:                this.lastCheckpointTime.Unix(),
+	}
+	checkpoint.SetIterationRangeMaxValues(iterationRangeMaxValues)
+	if err := this.applier.WriteCheckpoint(checkpoint); err != nil {
+		// Not fatal; we will try again next interval
+		log.Errore(err)
+		return
+	}
+	log.Debugf("Checkpoint written: %s", checkpoint)
+}
+
 // finalCleanup takes actions at very end of migration, dropping tables etc.
 func (this *Migrator) finalCleanup() error {
 	atomic.StoreInt64(&this.migrationContext.CleanupImminentFlag, 1)
@@ -1558,4 +2082,8 @@ func (this *Migrator) teardown() {
 		log.Infof("Tearing down throttler")
 		this.throttler.Teardown()
 	}
+
+	if this.metricsServer != nil {
+		this.metricsServer.Close()
+	}
 }
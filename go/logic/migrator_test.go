@@ -0,0 +1,219 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/github/gh-ost/go/binlog"
+	"github.com/github/gh-ost/go/mysql"
+	test "github.com/outbrain/golib/tests"
+)
+
+// newTestApplyEventStructs reads a sequence of binlog events, as a space separated list of `<id>` (an insert of
+// row <id>), `commit` (a source transaction commit) and `write` (a non DML write func, recorded as a transaction
+// of its own)
+func newTestApplyEventStructs(events string, db *testDB) (eventStructs [](*applyEventStruct)) {
+	for _, event := range strings.Fields(events) {
+		switch event {
+		case "commit":
+			eventStructs = append(eventStructs, newApplyEventStructByCommit())
+		case "write":
+			var writeFunc tableWriteFunc = func() error {
+				db.mutex.Lock()
+				defer db.mutex.Unlock()
+				db.transactions = append(db.transactions, []string{"write"})
+				return nil
+			}
+			eventStructs = append(eventStructs, newApplyEventStructByFunc(&writeFunc))
+		default:
+			id, _ := strconv.Atoi(event)
+			eventStructs = append(eventStructs, newApplyEventStructByDML(newTestInsert(id, "a", id)))
+		}
+	}
+	return eventStructs
+}
+
+// formatTestTransactions formats the recorded transactions as the ids of their rows, e.g. `1 2 | write | 3`
+func formatTestTransactions(db *testDB) string {
+	transactions := []string{}
+	for _, statements := range db.Transactions() {
+		ids := []string{}
+		for _, statement := range statements {
+			if statement == "write" {
+				ids = append(ids, statement)
+				continue
+			}
+			ids = append(ids, strings.TrimPrefix(strings.Fields(statement)[1], "["))
+		}
+		transactions = append(transactions, strings.Join(ids, " "))
+	}
+	return strings.Join(transactions, " | ")
+}
+
+func TestOnApplyEventStruct(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   string
+		queued   bool // all events are queued at once; otherwise, each is applied as it arrives
+		expected string
+	}{
+		{
+			name:     "transactions applied as they commit",
+			events:   "1 2 commit 3 commit",
+			expected: "1 2 | 3",
+		},
+		{
+			name:     "queued transactions batched together",
+			events:   "1 2 commit 3 commit",
+			queued:   true,
+			expected: "1 2 3",
+		},
+		{
+			name:     "batch reaching DMLBatchSize",
+			events:   "1 commit 2 commit 3 commit 4 commit",
+			queued:   true,
+			expected: "1 2 3 | 4",
+		},
+		{
+			name:     "transaction not fitting in the batch",
+			events:   "1 2 commit 3 4 commit",
+			queued:   true,
+			expected: "1 2 | 3 4",
+		},
+		{
+			name:     "transaction larger than DMLBatchSize split",
+			events:   "1 2 3 4 5 6 7 commit",
+			queued:   true,
+			expected: "1 2 3 | 4 5 6 | 7",
+		},
+		{
+			name:     "batch applied before a split transaction",
+			events:   "1 commit 2 3 4 5 commit",
+			queued:   true,
+			expected: "1 | 2 3 4 | 5",
+		},
+		{
+			name:     "events applied before a write func",
+			events:   "1 commit 2 write 3 commit",
+			queued:   true,
+			expected: "1 | 2 | write | 3",
+		},
+	}
+	for _, tt := range tests {
+		migrationContext := newTestMigrationContext("id")
+		migrationContext.DMLBatchSize = 3
+		migrator, db := newTestMigrator(migrationContext)
+
+		eventStructs := newTestApplyEventStructs(tt.events, db)
+		for len(eventStructs) > 0 {
+			if tt.queued {
+				for _, eventStruct := range eventStructs {
+					migrator.applyEventsQueue <- eventStruct
+				}
+				eventStructs = nil
+			} else {
+				migrator.applyEventsQueue <- eventStructs[0]
+				eventStructs = eventStructs[1:]
+			}
+			for len(migrator.applyEventsQueue) > 0 {
+				test.S(t).ExpectNil(migrator.onApplyEventStruct(<-migrator.applyEventsQueue))
+			}
+		}
+		if transactions := formatTestTransactions(db); transactions != tt.expected {
+			t.Errorf("%s: applied %s, expected %s", tt.name, transactions, tt.expected)
+		}
+	}
+}
+
+func TestOnDMLEventsApplied(t *testing.T) {
+	// each call applies events of the given rows events positions
+	type call struct {
+		positions           []int64
+		transactionComplete bool
+	}
+	tests := []struct {
+		name                 string
+		calls                []call
+		expectedApplied      int64
+		expectedCheckpointed int64 // 0: none
+	}{
+		{
+			name:                 "transaction complete",
+			calls:                []call{{[]int64{100, 100, 200}, true}},
+			expectedApplied:      200,
+			expectedCheckpointed: 200,
+		},
+		{
+			name:                 "transaction split",
+			calls:                []call{{[]int64{100, 100, 200}, false}},
+			expectedApplied:      200,
+			expectedCheckpointed: 100,
+		},
+		{
+			name:                 "single rows event split",
+			calls:                []call{{[]int64{100, 100}, false}},
+			expectedApplied:      100,
+			expectedCheckpointed: 0,
+		},
+		{
+			name:                 "rows event split between batches",
+			calls:                []call{{[]int64{100, 200}, false}, {[]int64{200, 200}, false}},
+			expectedApplied:      200,
+			expectedCheckpointed: 100,
+		},
+		{
+			name:                 "next rows event applied",
+			calls:                []call{{[]int64{100, 200}, false}, {[]int64{200, 300}, false}},
+			expectedApplied:      300,
+			expectedCheckpointed: 200,
+		},
+		{
+			name:                 "split transaction completed",
+			calls:                []call{{[]int64{100, 200}, false}, {[]int64{200}, true}},
+			expectedApplied:      200,
+			expectedCheckpointed: 200,
+		},
+	}
+	for _, tt := range tests {
+		migrator, _ := newTestMigrator(newTestMigrationContext("id"))
+		for _, call := range tt.calls {
+			dmlEvents := [](*binlog.BinlogDMLEvent){}
+			for _, position := range call.positions {
+				dmlEvent := newTestInsert(1, "a", 1)
+				dmlEvent.Coordinates = mysql.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: position}
+				dmlEvents = append(dmlEvents, dmlEvent)
+			}
+			migrator.onDMLEventsApplied(dmlEvents, call.transactionComplete)
+		}
+		if migrator.appliedEventsCoordinates.LogPos != tt.expectedApplied {
+			t.Errorf("%s: applied up to %+v, expected %d", tt.name, migrator.appliedEventsCoordinates, tt.expectedApplied)
+		}
+		if migrator.checkpointCoordinates.LogPos != tt.expectedCheckpointed {
+			t.Errorf("%s: checkpointed up to %+v, expected %d", tt.name, migrator.checkpointCoordinates, tt.expectedCheckpointed)
+		}
+	}
+}
+
+func TestOnApplyEventStructCheckpointCoordinates(t *testing.T) {
+	migrationContext := newTestMigrationContext("id")
+	migrationContext.DMLBatchSize = 3
+	migrator, _ := newTestMigrator(migrationContext)
+
+	// a single rows event of 4 rows, split at DMLBatchSize
+	for id := 1; id <= 4; id++ {
+		dmlEvent := newTestInsert(id, "a", id)
+		dmlEvent.Coordinates = mysql.BinlogCoordinates{LogFile: "mysql-bin.000017", LogPos: 100}
+		test.S(t).ExpectNil(migrator.onApplyEventStruct(newApplyEventStructByDML(dmlEvent)))
+	}
+	test.S(t).ExpectEquals(migrator.appliedEventsCoordinates.LogPos, int64(100))
+	test.S(t).ExpectTrue(migrator.checkpointCoordinates.IsEmpty())
+
+	test.S(t).ExpectNil(migrator.onApplyEventStruct(newApplyEventStructByCommit()))
+	test.S(t).ExpectEquals(migrator.checkpointCoordinates.LogPos, int64(100))
+}
@@ -0,0 +1,280 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/github/gh-ost/go/sql"
+	"github.com/outbrain/golib/log"
+	"github.com/outbrain/golib/sqlutils"
+)
+
+const preflightScanSampleSize = 5
+
+// preflightFinding is a conversion risk, along with the rows of the original table found to be affected by it
+type preflightFinding struct {
+	risk    *sql.ConversionRisk
+	rows    int64
+	samples []string
+}
+
+func (this *preflightFinding) String() string {
+	if this.risk.Condition == "" {
+		return this.risk.String()
+	}
+	result := fmt.Sprintf("%s: %d rows", this.risk, this.rows)
+	if len(this.samples) > 0 {
+		result = fmt.Sprintf("%s, e.g. %s", result, strings.Join(this.samples, " "))
+	}
+	return result
+}
+
+// getColumnDefinitions reads the definitions of the columns of given table, mapped by lower case column name
+func (this *Inspector) getColumnDefinitions(tableName string) (map[string]*sql.ColumnDefinition, error) {
+	query := `
+		select
+				*
+			from
+				information_schema.columns
+			where
+				table_schema=?
+				and table_name=?
+		`
+	definitions := make(map[string]*sql.ColumnDefinition)
+	err := sqlutils.QueryRowsMap(this.db, query, func(m sqlutils.RowMap) error {
+		definition := &sql.ColumnDefinition{
+			Name:                   m.GetString("COLUMN_NAME"),
+			DataType:               strings.ToLower(m.GetString("DATA_TYPE")),
+			ColumnType:             strings.ToLower(m.GetString("COLUMN_TYPE")),
+			Nullable:               m.GetString("IS_NULLABLE") == "YES",
+			CharacterMaximumLength: m.GetInt64("CHARACTER_MAXIMUM_LENGTH"),
+			CharacterOctetLength:   m.GetInt64("CHARACTER_OCTET_LENGTH"),
+			NumericPrecision:       m.GetInt64("NUMERIC_PRECISION"),
+			NumericScale:           m.GetInt64("NUMERIC_SCALE"),
+			DatetimePrecision:      m.GetInt64("DATETIME_PRECISION"),
+			CharacterSet:           m.GetString("CHARACTER_SET_NAME"),
+		}
+		definitions[strings.ToLower(definition.Name)] = definition
+		return nil
+	}, this.migrationContext.DatabaseName, tableName)
+	return definitions, err
+}
+
+// getConversionRisks compares the shared columns of the original and ghost tables, and returns the ways
+// their values may fail or be truncated when copied onto the ghost table
+func (this *Inspector) getConversionRisks() (risks []*sql.ConversionRisk, err error) {
+	originalDefinitions, err := this.getColumnDefinitions(this.migrationContext.OriginalTableName)
+	if err != nil {
+		return risks, err
+	}
+	ghostDefinitions, err := this.getColumnDefinitions(this.migrationContext.GetGhostTableName())
+	if err != nil {
+		return risks, err
+	}
+	// SharedColumns 和 MappedSharedColumns 一一对应(考虑了rename)
+	originalNames := this.migrationContext.SharedColumns.Names()
+	ghostNames := this.migrationContext.MappedSharedColumns.Names()
+	for i, originalName := range originalNames {
+		original, ok := originalDefinitions[strings.ToLower(originalName)]
+		if !ok {
+			return risks, fmt.Errorf("Unable to find column %s in information_schema.columns of %s", originalName, this.migrationContext.OriginalTableName)
+		}
+		ghost, ok := ghostDefinitions[strings.ToLower(ghostNames[i])]
+		if !ok {
+			return risks, fmt.Errorf("Unable to find column %s in information_schema.columns of %s", ghostNames[i], this.migrationContext.GetGhostTableName())
+		}
+		risks = append(risks, sql.GetConversionRisks(original, ghost)...)
+	}
+	return risks, nil
+}
+
+// CountRangeConditions counts, within given unique key range of the original table (respecting OriginalFilter),
+// the rows matching each of given conditions
+func (this *Applier) CountRangeConditions(conditions []string, rangeStartValues, rangeEndValues *sql.ColumnValues, includeRangeStartValues bool) (counts []int64, err error) {
+	query, explodedArgs, err := sql.BuildRangeConditionsCountPreparedQuery(
+		this.migrationContext.DatabaseName,
+		this.migrationContext.OriginalTableName,
+		this.migrationContext.OriginalFilter,
+		conditions,
+		this.migrationContext.UniqueKey.Name,
+		&this.migrationContext.UniqueKey.Columns,
+		rangeStartValues.AbstractValues(),
+		rangeEndValues.AbstractValues(),
+		includeRangeStartValues,
+	)
+	if err != nil {
+		return counts, err
+	}
+	counts = make([]int64, len(conditions))
+	countsPointers := make([]interface{}, len(conditions))
+	for i := range counts {
+		countsPointers[i] = &counts[i]
+	}
+	err = this.db.QueryRow(query, explodedArgs...).Scan(countsPointers...)
+	return counts, err
+}
+
+// ReadRangeConditionSamples returns the unique key and column values of (up to limit) rows matching given condition,
+// within given unique key range of the original table
+func (this *Applier) ReadRangeConditionSamples(condition string, column string, rangeStartValues, rangeEndValues *sql.ColumnValues, includeRangeStartValues bool, limit int64) (samples []string, err error) {
+	uniqueKeyColumns := &this.migrationContext.UniqueKey.Columns
+	columns := append(uniqueKeyColumns.Names(), column)
+	query, explodedArgs, err := sql.BuildRangeConditionSamplePreparedQuery(
+		this.migrationContext.DatabaseName,
+		this.migrationContext.OriginalTableName,
+		this.migrationContext.OriginalFilter,
+		condition,
+		columns,
+		this.migrationContext.UniqueKey.Name,
+		uniqueKeyColumns,
+		rangeStartValues.AbstractValues(),
+		rangeEndValues.AbstractValues(),
+		includeRangeStartValues,
+		limit,
+	)
+	if err != nil {
+		return samples, err
+	}
+	rows, err := this.db.Query(query, explodedArgs...)
+	if err != nil {
+		return samples, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		values := sql.NewColumnValues(len(columns))
+		if err := rows.Scan(values.ValuesPointers...); err != nil {
+			return samples, err
+		}
+		keyValues := []string{}
+		for i := 0; i < uniqueKeyColumns.Len(); i++ {
+			keyValues = append(keyValues, values.StringColumn(i))
+		}
+		samples = append(samples, fmt.Sprintf("(%s): %s", strings.Join(keyValues, ","), values.StringColumn(uniqueKeyColumns.Len())))
+	}
+	return samples, rows.Err()
+}
+
+// preflightScan, as per --preflight-scan, compares the column types of the original and (altered) ghost tables,
+// and scans the original table, chunk by chunk along the migration unique key, for values which would fail or be
+// truncated when copied. It reports its findings, cleans up, and returns; row copy never begins.
+// It returns an error when offending rows are found.
+func (this *Migrator) preflightScan() (err error) {
+	defer func() {
+		if cleanupErr := this.preflightCleanup(); cleanupErr != nil && err == nil {
+			err = cleanupErr
+		}
+	}()
+	startTime := time.Now()
+	log.Infof(color.MagentaString("=== Preflight scan of %s.%s ==="), sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName))
+
+	risks, err := this.inspector.getConversionRisks()
+	if err != nil {
+		return err
+	}
+	findings := []*preflightFinding{}
+	scanned := []*preflightFinding{}
+	conditions := []string{}
+	for _, risk := range risks {
+		finding := &preflightFinding{risk: risk}
+		findings = append(findings, finding)
+		if risk.Condition == "" {
+			continue
+		}
+		scanned = append(scanned, finding)
+		conditions = append(conditions, risk.Condition)
+	}
+	if len(findings) == 0 {
+		log.Infof(color.GreenString("Preflight scan: no column of %s.%s is narrowed by the ALTER; nothing to scan"), sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName))
+		return nil
+	}
+	if len(conditions) > 0 {
+		if err := this.preflightScanRanges(scanned, conditions); err != nil {
+			return err
+		}
+	}
+
+	var offendingRows int64
+	for _, finding := range findings {
+		switch {
+		case finding.risk.Condition == "":
+			log.Warningf("Preflight scan: %s", finding)
+		case finding.rows > 0:
+			log.Errorf("Preflight scan: %s", finding)
+			offendingRows += finding.rows
+		default:
+			log.Infof("Preflight scan: %s: no rows", finding.risk)
+		}
+	}
+	if offendingRows > 0 {
+		return fmt.Errorf("Preflight scan found %d values of %s.%s which would fail or be truncated on the ghost table. Bailing out", offendingRows, sql.EscapeName(this.migrationContext.DatabaseName), sql.EscapeName(this.migrationContext.OriginalTableName))
+	}
+	log.Infof(color.GreenString("Preflight scan passed in %+v: no offending values found"), time.Since(startTime))
+	return nil
+}
+
+// preflightScanRanges walks through the unique key range of the original table, counting (and sampling) the rows
+// matching the conditions of given findings
+func (this *Migrator) preflightScanRanges(scanned []*preflightFinding, conditions []string) error {
+	rangeMinValues, rangeMaxValues, err := this.applier.ReadChecksumRangeValues()
+	if err != nil {
+		return err
+	}
+	if rangeMinValues == nil || rangeMaxValues == nil {
+		log.Infof("Original table is empty; nothing to scan")
+		return nil
+	}
+	var chunks int64
+	rangeStart := rangeMinValues
+	includeStart := true
+	for {
+		this.throttler.throttle(nil)
+		rangeEnd, err := this.applier.CalculateChecksumRangeEndValues(rangeStart, rangeMaxValues, includeStart)
+		if err != nil {
+			return err
+		}
+		if rangeEnd == nil {
+			break
+		}
+		counts, err := this.applier.CountRangeConditions(conditions, rangeStart, rangeEnd, includeStart)
+		if err != nil {
+			return err
+		}
+		for i, finding := range scanned {
+			finding.rows += counts[i]
+			if counts[i] == 0 || len(finding.samples) >= preflightScanSampleSize {
+				continue
+			}
+			samples, err := this.applier.ReadRangeConditionSamples(finding.risk.Condition, finding.risk.Column, rangeStart, rangeEnd, includeStart, int64(preflightScanSampleSize-len(finding.samples)))
+			if err != nil {
+				return err
+			}
+			finding.samples = append(finding.samples, samples...)
+		}
+		chunks++
+		rangeStart = rangeEnd
+		includeStart = false
+	}
+	log.Debugf("Preflight scan: scanned %d chunks", chunks)
+	return nil
+}
+
+// preflightCleanup drops the ghost & changelog tables: a preflight scan leaves nothing behind
+func (this *Migrator) preflightCleanup() error {
+	atomic.StoreInt64(&this.migrationContext.CleanupImminentFlag, 1)
+	if err := this.eventsStreamer.Close(); err != nil {
+		log.Errore(err)
+	}
+	if err := this.retryOperation(this.applier.DropChangelogTable); err != nil {
+		return err
+	}
+	return this.retryOperation(this.applier.DropGhostTable)
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/github/gh-ost/go/mysql"
+	"github.com/outbrain/golib/log"
+)
+
+// discoverThrottleControlReplicas periodically looks for replicas of the master (and, with
+// --discover-replicas-recursive, for their replicas), and adds them to the throttle control replicas.
+// Discovered replicas are never removed: a replica which goes away fails the lag check, hence throttles, until
+// the list is reset via the `throttle-control-replicas` interactive command. The next discovery adds back the
+// replicas still around.
+func (this *Throttler) discoverThrottleControlReplicas() {
+	if !this.migrationContext.DiscoverThrottleControlReplicas {
+		return
+	}
+	discover := func() {
+		visitedKeys := mysql.NewInstanceKeyMap()
+		visitedKeys.AddKey(this.migrationContext.ApplierConnectionConfig.Key)
+		throttleControlReplicaKeys := this.migrationContext.GetThrottleControlReplicaKeys()
+		for _, replicaKey := range this.discoverReplicas(this.migrationContext.ApplierConnectionConfig, visitedKeys) {
+			if throttleControlReplicaKeys.HasKey(replicaKey) || !this.migrationContext.ReplicaDiscovery.Accepts(replicaKey) {
+				continue
+			}
+			log.Infof("Discovered replica %+v; adding it to throttle-control-replicas", replicaKey)
+			this.migrationContext.AddThrottleControlReplicaKey(replicaKey)
+		}
+	}
+
+	discover()
+	ticker := time.Tick(time.Duration(this.migrationContext.ReplicaDiscovery.IntervalSeconds) * time.Second)
+	for range ticker {
+		if atomic.LoadInt64(&this.finishedMigrating) > 0 {
+			return
+		}
+		discover()
+	}
+}
+
+// discoverReplicas returns the replicas of given server, as reachable after --discover-replicas-address-mapping.
+// Servers which do not turn out to be replicas (e.g. other binlog readers) are skipped. visitedKeys guards
+// against visiting a server twice in a circular (master-master) topology.
+func (this *Throttler) discoverReplicas(connectionConfig *mysql.ConnectionConfig, visitedKeys *mysql.InstanceKeyMap) (replicaKeys []mysql.InstanceKey) {
+	db, _, err := mysql.GetDB(this.migrationContext.Uuid, connectionConfig.GetDBUri("information_schema"))
+	if err != nil {
+		log.Warningf("Cannot discover replicas of %+v: %+v", connectionConfig.Key, err)
+		return replicaKeys
+	}
+	reportedKeys, err := mysql.GetReplicaKeys(db, connectionConfig.Key.Port, this.migrationContext.ReplicaServerId)
+	if err != nil {
+		log.Warningf("Cannot discover replicas of %+v: %+v", connectionConfig.Key, err)
+		return replicaKeys
+	}
+	for _, reportedKey := range reportedKeys {
+		replicaKey, err := this.migrationContext.ReplicaDiscovery.Rewrite(reportedKey)
+		if err != nil {
+			log.Warningf("Cannot map address of replica %+v: %+v", reportedKey, err)
+			continue
+		}
+		if visitedKeys.HasKey(replicaKey) {
+			continue
+		}
+		visitedKeys.AddKey(replicaKey)
+
+		// 与 readControlReplicasLag 一样, 使用inspector的账号访问replica
+		replicaConfig := this.migrationContext.InspectorConnectionConfig.DuplicateCredentials(replicaKey)
+		// binlog dump线程不一定来自replica: gh-ost自己, 或者其他的binlog消费者
+		// 周期性探测: 使用缓存的连接池, 而不是每次都新建连接
+		replicaDB, _, err := mysql.GetDB(this.migrationContext.Uuid, replicaConfig.GetDBUri("information_schema"))
+		if err != nil {
+			log.Warningf("Cannot check replica %+v: %+v", replicaKey, err)
+			continue
+		}
+		if masterKey, err := mysql.QueryMasterKeyFromSlaveStatus(replicaDB, replicaConfig); err != nil || masterKey == nil {
+			log.Debugf("Ignoring %+v (reported as %+v) while discovering replicas: not a running replica. err=%+v", replicaKey, reportedKey, err)
+			continue
+		}
+		replicaKeys = append(replicaKeys, replicaKey)
+		if this.migrationContext.ReplicaDiscovery.Recursive {
+			replicaKeys = append(replicaKeys, this.discoverReplicas(replicaConfig, visitedKeys)...)
+		}
+	}
+	return replicaKeys
+}
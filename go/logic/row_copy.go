@@ -0,0 +1,158 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/github/gh-ost/go/base"
+	"github.com/github/gh-ost/go/mysql"
+	"github.com/github/gh-ost/go/sql"
+	"github.com/outbrain/golib/log"
+)
+
+// copyRange is a chunk of the original table, as copied by one of the --copy-workers
+type copyRange struct {
+	rangeIteration *base.RangeIteration
+	seq            int64
+	minValues      *sql.ColumnValues
+	maxValues      *sql.ColumnValues
+	includeStart   bool
+}
+
+// addRowsCopied accounts for a copied chunk
+func (this *Migrator) addRowsCopied(rangeIteration *base.RangeIteration, rowsAffected int64) {
+	if this.migrationContext.OriginalFilter != "" {
+		// 如果 OriginalFilter 存在，则 rowsAffected 可能偏少，不利于进度估计，因此直接使用 chunkSize 来代替
+		rowsAffected = atomic.LoadInt64(&this.migrationContext.ChunkSize)
+	}
+	atomic.AddInt64(&rangeIteration.RowsCopied, rowsAffected)
+	atomic.AddInt64(&this.migrationContext.TotalRowsCopied, rowsAffected)
+}
+
+// niceSleep sleeps in proportion to the time spent copying a chunk, as per --nice-ratio
+func (this *Migrator) niceSleep(copyRowsDuration time.Duration) {
+	if niceRatio := this.migrationContext.GetNiceRatio(); niceRatio > 0 {
+		sleepTimeNanosecondFloat64 := niceRatio * float64(copyRowsDuration.Nanoseconds())
+		time.Sleep(time.Duration(int64(sleepTimeNanosecondFloat64)) * time.Nanosecond)
+	}
+}
+
+// adaptChunkSize, as per --chunk-target-millis, adjusts the chunk size to the duration of a chunk just copied
+func (this *Migrator) adaptChunkSize(chunkSize int64, duration time.Duration) {
+	target := time.Duration(atomic.LoadInt64(&this.migrationContext.ChunkTargetMillis)) * time.Millisecond
+	if target <= 0 {
+		return
+	}
+	this.migrationContext.SetChunkSize(this.chunkSizeController.ChunkCopied(chunkSize, duration, target))
+}
+
+// backOffChunkSize, as per --chunk-target-millis, halves the chunk size when a chunk fails on lock wait
+// timeout or deadlock: smaller chunks hold fewer locks, for less time
+func (this *Migrator) backOffChunkSize(chunkSize int64, err error) {
+	target := time.Duration(atomic.LoadInt64(&this.migrationContext.ChunkTargetMillis)) * time.Millisecond
+	if target <= 0 || !mysql.IsLockWaitError(err) {
+		return
+	}
+	this.migrationContext.SetChunkSize(this.chunkSizeController.LockWaitFailed(chunkSize, target))
+	log.Warningf("Chunk copy failed on lock wait: %s. Backing off chunk-size to %d", err.Error(), atomic.LoadInt64(&this.migrationContext.ChunkSize))
+}
+
+// copyRangesConcurrently iterates the chunks of the given range iteration and copies them onto the ghost
+// table via --copy-workers goroutines, each on its own applier connection.
+// Chunk boundaries are calculated in order, on this goroutine; the copy itself is concurrent.
+// It returns once all chunks are copied, or on first failure.
+func (this *Migrator) copyRangesConcurrently(rangeIteration *base.RangeIteration) error {
+	this.copyingConcurrently.Set(true)
+
+	workers := int(this.migrationContext.CopyWorkers)
+	ranges := make(chan *copyRange, workers)
+
+	copyFailed := &AtomicBool{}
+	var copyErr error
+	var copyErrOnce sync.Once
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for copied := range ranges {
+				if copyFailed.Get() || this.rowCopyCompleteFlag.Get() {
+					// 消费掉剩余的range, 不再拷贝
+					continue
+				}
+				if err := this.applyCopyRange(copied); err != nil {
+					copyErrOnce.Do(func() { copyErr = err })
+					copyFailed.Set(true)
+				}
+			}
+		}()
+	}
+
+	var rangeErr error
+	for !copyFailed.Get() && !this.rowCopyCompleteFlag.Get() {
+		var hasFurtherRange bool
+		rangeErr = this.retryOperation(func() (err error) {
+			hasFurtherRange, err = this.applier.CalculateNextIterationRangeEndValues(rangeIteration)
+			return err
+		})
+		if rangeErr != nil || !hasFurtherRange {
+			break
+		}
+		ranges <- &copyRange{
+			rangeIteration: rangeIteration,
+			seq:            rangeIteration.DispatchChunk(),
+			minValues:      rangeIteration.IterationRangeMinValues,
+			maxValues:      rangeIteration.IterationRangeMaxValues,
+			includeStart:   rangeIteration.GetIteration() == 0,
+		}
+		// 并发模式下 iteration 在分发range时递增, 下一个range才能正确地计算
+		atomic.AddInt64(&rangeIteration.Iteration, 1)
+		atomic.AddInt64(&this.migrationContext.Iteration, 1)
+	}
+	close(ranges)
+	wg.Wait()
+
+	if rangeErr != nil {
+		return rangeErr
+	}
+	return copyErr
+}
+
+// applyCopyRange copies a single range onto the ghost table. Binlog events have precedence:
+// a worker waits for the events queue to drain before copying its range.
+func (this *Migrator) applyCopyRange(copied *copyRange) error {
+	for len(this.applyEventsQueue) > 0 && !this.rowCopyCompleteFlag.Get() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	this.throttler.throttle(nil)
+
+	copyRowsStartTime := time.Now()
+	applyCopyRowsFunc := func() error {
+		if this.rowCopyCompleteFlag.Get() {
+			return nil
+		}
+		chunkSize, rowsAffected, duration, err := this.applier.ApplyRangeInsertQuery(copied.rangeIteration.Partition, copied.minValues, copied.maxValues, copied.includeStart)
+		if err != nil {
+			log.Errorf("Failed copying range [%s]..[%s]: %s", copied.minValues, copied.maxValues, err.Error())
+			this.backOffChunkSize(chunkSize, err)
+			return err
+		}
+		this.addRowsCopied(copied.rangeIteration, rowsAffected)
+		this.chunkCopyLatency.Observe(duration.Seconds())
+		this.adaptChunkSize(chunkSize, duration)
+		return nil
+	}
+	if err := this.retryOperation(applyCopyRowsFunc); err != nil {
+		return err
+	}
+	copied.rangeIteration.ChunkCopied(copied.seq, copied.maxValues)
+	this.niceSleep(time.Since(copyRowsStartTime))
+	return nil
+}
@@ -7,6 +7,8 @@ package logic
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -21,6 +23,19 @@ import (
 )
 
 type printStatusFunc func(PrintStatusRule, io.Writer)
+type getStatusFunc func() *MigrationStatus
+
+// jsonCommandPrefix switches a single command onto the JSON protocol, e.g. `json chunk-size=500`
+const jsonCommandPrefix = "json "
+
+// commandAck is the JSON protocol response to a command
+type commandAck struct {
+	Command string           `json:"command"`
+	OK      bool             `json:"ok"`
+	Error   string           `json:"error,omitempty"`
+	Output  string           `json:"output,omitempty"`
+	Status  *MigrationStatus `json:"status,omitempty"`
+}
 
 // Server listens for requests on a socket file or via TCP
 type Server struct {
@@ -29,13 +44,15 @@ type Server struct {
 	tcpListener      net.Listener
 	hooksExecutor    *HooksExecutor
 	printStatus      printStatusFunc
+	getStatus        getStatusFunc
 }
 
-func NewServer(migrationContext *base.MigrationContext, hooksExecutor *HooksExecutor, printStatus printStatusFunc) *Server {
+func NewServer(migrationContext *base.MigrationContext, hooksExecutor *HooksExecutor, printStatus printStatusFunc, getStatus getStatusFunc) *Server {
 	return &Server{
 		migrationContext: migrationContext,
 		hooksExecutor:    hooksExecutor,
 		printStatus:      printStatus,
+		getStatus:        getStatus,
 	}
 }
 
@@ -124,6 +141,9 @@ func (this *Server) handleConnection(conn net.Conn) (err error) {
 
 // onServerCommand responds to a user's interactive command
 func (this *Server) onServerCommand(command string, writer *bufio.Writer) (err error) {
+	if strings.HasPrefix(command, jsonCommandPrefix) {
+		return this.onJSONServerCommand(strings.TrimPrefix(command, jsonCommandPrefix), writer)
+	}
 	defer writer.Flush()
 
 	printStatusRule, err := this.applyServerCommand(command, writer)
@@ -135,6 +155,32 @@ func (this *Server) onServerCommand(command string, writer *bufio.Writer) (err e
 	return log.Errore(err)
 }
 
+// onJSONServerCommand responds to a command on the JSON protocol: a single JSON ack, with the command's
+// output, its error if any, and the migration status where the text protocol would print status
+func (this *Server) onJSONServerCommand(command string, writer *bufio.Writer) (err error) {
+	defer writer.Flush()
+
+	var output bytes.Buffer
+	outputWriter := bufio.NewWriter(&output)
+	printStatusRule, err := this.applyServerCommand(command, outputWriter)
+	outputWriter.Flush()
+
+	ack := &commandAck{
+		Command: strings.TrimSpace(strings.SplitN(command, "=", 2)[0]),
+		OK:      err == nil,
+		Output:  strings.TrimSpace(output.String()),
+	}
+	if err != nil {
+		ack.Error = err.Error()
+	} else if printStatusRule != NoPrintStatusRule {
+		ack.Status = this.getStatus()
+	}
+	if encodeErr := json.NewEncoder(writer).Encode(ack); encodeErr != nil {
+		return log.Errore(encodeErr)
+	}
+	return log.Errore(err)
+}
+
 // applyServerCommand parses and executes commands by user
 func (this *Server) applyServerCommand(command string, writer *bufio.Writer) (printStatusRule PrintStatusRule, err error) {
 	printStatusRule = NoPrintStatusRule
@@ -161,11 +207,15 @@ func (this *Server) applyServerCommand(command string, writer *bufio.Writer) (pr
 			fmt.Fprintln(writer, `available commands:
 status                               # Print a detailed status message
 sup                                  # Print a short status message
+status-json                          # Print the status as a JSON document
 coordinates													 # Print the currently inspected coordinates
 chunk-size=<newsize>                 # Set a new chunk-size
+chunk-target-millis=<millis>         # Set a new per-chunk copy duration chunk-size adapts to; 0 disables
 dml-batch-size=<newsize>             # Set a new dml-batch-size
 nice-ratio=<ratio>                   # Set a new nice-ratio, immediate sleep after each row-copy operation, float (examples: 0 is aggressive, 0.7 adds 70% runtime, 1.0 doubles runtime, 2.0 triples runtime, ...)
 critical-load=<load>                 # Set a new set of max-load thresholds
+copy-window=<windows>                # Set new daily windows row copy runs within; empty to copy any time
+cut-over-window=<windows>            # Set new daily windows cut-over is allowed within; empty to allow any time
 max-lag-millis=<max-lag>             # Set a new replication lag threshold
 replication-lag-query=<query>        # Set a new query that determines replication lag (no quotes)
 max-load=<load>                      # Set a new set of max-load thresholds
@@ -175,15 +225,23 @@ throttle-control-replicas=<replicas> # Set a new comma delimited list of throttl
 throttle                             # Force throttling
 no-throttle                          # End forced throttling (other throttling may still apply)
 unpostpone                           # Bail out a cut-over postpone; proceed to cut-over
+ack-ddl                              # Acknowledge a DDL detected on the migrated tables (with --on-ddl=pause); resume migration
 panic                                # panic and quit without cleanup
 help                                 # This message
 - use '?' (question mark) as argument to get info rather than set. e.g. "max-load=?" will just print out current max-load.
+- prefix any command with 'json ' to get a JSON ack/error (with status), e.g. "json chunk-size=500".
 `)
 		}
 	case "sup":
 		return ForcePrintStatusOnlyRule, nil
 	case "info", "status":
 		return ForcePrintStatusAndHintRule, nil
+	case "status-json":
+		{
+			encoder := json.NewEncoder(writer)
+			encoder.SetIndent("", "  ")
+			return NoPrintStatusRule, encoder.Encode(this.getStatus())
+		}
 	case "coordinates":
 		{
 			if argIsQuestion || arg == "" {
@@ -205,6 +263,20 @@ help                                 # This message
 				return ForcePrintStatusAndHintRule, nil
 			}
 		}
+	case "chunk-target-millis":
+		{
+			if argIsQuestion {
+				fmt.Fprintf(writer, "%+v\n", atomic.LoadInt64(&this.migrationContext.ChunkTargetMillis))
+				return NoPrintStatusRule, nil
+			}
+			if chunkTargetMillis, err := strconv.Atoi(arg); err != nil {
+				return NoPrintStatusRule, err
+			} else if err := this.migrationContext.SetChunkTargetMillis(int64(chunkTargetMillis)); err != nil {
+				return NoPrintStatusRule, err
+			} else {
+				return ForcePrintStatusAndHintRule, nil
+			}
+		}
 	case "dml-batch-size":
 		{
 			if argIsQuestion {
@@ -272,6 +344,28 @@ help                                 # This message
 			}
 			return ForcePrintStatusAndHintRule, nil
 		}
+	case "copy-window":
+		{
+			if argIsQuestion {
+				fmt.Fprintf(writer, "%s\n", this.migrationContext.GetCopyWindows().String())
+				return NoPrintStatusRule, nil
+			}
+			if err := this.migrationContext.ReadCopyWindows(arg); err != nil {
+				return NoPrintStatusRule, err
+			}
+			return ForcePrintStatusAndHintRule, nil
+		}
+	case "cut-over-window":
+		{
+			if argIsQuestion {
+				fmt.Fprintf(writer, "%s\n", this.migrationContext.GetCutOverWindows().String())
+				return NoPrintStatusRule, nil
+			}
+			if err := this.migrationContext.ReadCutOverWindows(arg); err != nil {
+				return NoPrintStatusRule, err
+			}
+			return ForcePrintStatusAndHintRule, nil
+		}
 	case "throttle-query":
 		{
 			if argIsQuestion {
@@ -334,6 +428,16 @@ help                                 # This message
 			fmt.Fprintf(writer, "You may only invoke this when gh-ost is actively postponing migration. At this time it is not.\n")
 			return NoPrintStatusRule, nil
 		}
+	case "ack-ddl":
+		{
+			if atomic.LoadInt64(&this.migrationContext.IsPausedOnDDL) > 0 {
+				atomic.StoreInt64(&this.migrationContext.UserCommandedAckDDLFlag, 1)
+				fmt.Fprintf(writer, "Acknowledged\n")
+				return ForcePrintStatusAndHintRule, nil
+			}
+			fmt.Fprintf(writer, "You may only invoke this when gh-ost is paused on a DDL statement. At this time it is not.\n")
+			return NoPrintStatusRule, nil
+		}
 	case "panic":
 		{
 			err := fmt.Errorf("User commanded 'panic'. I will now panic, without cleanup. PANIC!")
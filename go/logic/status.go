@@ -0,0 +1,183 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package logic
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/github/gh-ost/go/base"
+)
+
+// MigrationStatus is the machine readable status of the migration, as returned by the `status-json`
+// interactive command and by commands on the JSON protocol
+type MigrationStatus struct {
+	Database              string                  `json:"database"`
+	Table                 string                  `json:"table"`
+	Alias                 string                  `json:"alias,omitempty"`
+	Phase                 string                  `json:"phase"`
+	State                 string                  `json:"state"`
+	RowsCopied            int64                   `json:"rows_copied"`
+	RowsEstimate          int64                   `json:"rows_estimate"`
+	ProgressPct           float64                 `json:"progress_pct"`
+	ETASeconds            *int64                  `json:"eta_seconds"` // null: 无法估计
+	ETA                   string                  `json:"eta"`
+	ElapsedSeconds        int64                   `json:"elapsed_seconds"`
+	RowCopySeconds        int64                   `json:"row_copy_seconds"`
+	DMLEventsApplied      int64                   `json:"dml_events_applied"`
+	DMLStatementsApplied  int64                   `json:"dml_statements_applied"`
+	EventsBacklog         int                     `json:"events_backlog"`
+	EventsBacklogCapacity int                     `json:"events_backlog_capacity"`
+	Throttled             bool                    `json:"throttled"`
+	ThrottleReason        string                  `json:"throttle_reason,omitempty"`
+	ThrottleReasonHint    string                  `json:"throttle_reason_hint,omitempty"`
+	BinlogCoordinates     BinlogCoordinatesStatus `json:"binlog_coordinates"`
+	BinlogRowsEvents      BinlogRowsEventsStatus  `json:"binlog_rows_events"`
+	Chunks                []ChunkStatus           `json:"chunks"`
+	Config                ConfigStatus            `json:"config"`
+	Postpone              PostponeStatus          `json:"postpone"`
+}
+
+type BinlogCoordinatesStatus struct {
+	LogFile string `json:"log_file"`
+	LogPos  int64  `json:"log_pos"`
+	GTIDSet string `json:"gtid_set,omitempty"`
+}
+
+// BinlogRowsEventsStatus counts the binlog rows events read: those on the migrated tables, which are handled,
+// and those on other tables, which are skipped
+type BinlogRowsEventsStatus struct {
+	Relevant int64 `json:"relevant"`
+	Skipped  int64 `json:"skipped"`
+}
+
+// ChunkStatus is the chunk range being copied, per range iteration (whole table, or partition) being copied
+type ChunkStatus struct {
+	Partition  string `json:"partition,omitempty"`
+	Iteration  int64  `json:"iteration"`
+	RangeStart string `json:"range_start"`
+	RangeEnd   string `json:"range_end"`
+	RowsCopied int64  `json:"rows_copied"`
+}
+
+// ConfigStatus lists the configuration values which may be changed via interactive commands
+type ConfigStatus struct {
+	ChunkSize               int64   `json:"chunk_size"`
+	ChunkTargetMillis       int64   `json:"chunk_target_millis"`
+	DMLBatchSize            int64   `json:"dml_batch_size"`
+	MaxLagMillis            int64   `json:"max_lag_millis"`
+	NiceRatio               float64 `json:"nice_ratio"`
+	MaxLoad                 string  `json:"max_load"`
+	CriticalLoad            string  `json:"critical_load"`
+	ThrottleQuery           string  `json:"throttle_query"`
+	ThrottleHTTP            string  `json:"throttle_http"`
+	ThrottleControlReplicas string  `json:"throttle_control_replicas"`
+	ThrottleCommandedByUser bool    `json:"throttle_commanded_by_user"`
+	CopyWorkers             int64   `json:"copy_workers"`
+	PartitionWorkers        int64   `json:"partition_workers"`
+	DMLApplyWorkers         int64   `json:"dml_apply_workers"`
+	CopyWindow              string  `json:"copy_window"`
+	CutOverWindow           string  `json:"cut_over_window"`
+}
+
+type PostponeStatus struct {
+	FlagFile   string `json:"flag_file,omitempty"`
+	Postponing bool   `json:"postponing"`
+}
+
+// getMigrationStatus collects the current status of the migration
+func (this *Migrator) getMigrationStatus() *MigrationStatus {
+	totalRowsCopied, rowsEstimate, progressPct, etaSeconds, eta, state := this.progressStatus()
+	isThrottled, throttleReason, throttleReasonHint := this.migrationContext.IsThrottled()
+	maxLoad := this.migrationContext.GetMaxLoad()
+	criticalLoad := this.migrationContext.GetCriticalLoad()
+
+	status := &MigrationStatus{
+		Database:              this.migrationContext.DatabaseName,
+		Table:                 this.migrationContext.OriginalTableName,
+		Alias:                 this.migrationContext.DatabaseAlias,
+		Phase:                 this.migrationPhase(),
+		State:                 state,
+		RowsCopied:            totalRowsCopied,
+		RowsEstimate:          rowsEstimate,
+		ProgressPct:           progressPct,
+		ETA:                   eta,
+		ElapsedSeconds:        int64(this.migrationContext.ElapsedTime().Seconds()),
+		RowCopySeconds:        int64(this.migrationContext.ElapsedRowCopyTime().Seconds()),
+		DMLEventsApplied:      atomic.LoadInt64(&this.migrationContext.TotalDMLEventsApplied),
+		DMLStatementsApplied:  atomic.LoadInt64(&this.migrationContext.TotalDMLStatementsApplied),
+		EventsBacklog:         len(this.applyEventsQueue),
+		EventsBacklogCapacity: cap(this.applyEventsQueue),
+		Throttled:             isThrottled,
+		BinlogRowsEvents: BinlogRowsEventsStatus{
+			Relevant: atomic.LoadInt64(&this.migrationContext.RelevantRowsEvents),
+			Skipped:  atomic.LoadInt64(&this.migrationContext.SkippedRowsEvents),
+		},
+		Chunks: []ChunkStatus{},
+		Config: ConfigStatus{
+			ChunkSize:               atomic.LoadInt64(&this.migrationContext.ChunkSize),
+			ChunkTargetMillis:       atomic.LoadInt64(&this.migrationContext.ChunkTargetMillis),
+			DMLBatchSize:            atomic.LoadInt64(&this.migrationContext.DMLBatchSize),
+			MaxLagMillis:            atomic.LoadInt64(&this.migrationContext.MaxLagMillisecondsThrottleThreshold),
+			NiceRatio:               this.migrationContext.GetNiceRatio(),
+			MaxLoad:                 maxLoad.String(),
+			CriticalLoad:            criticalLoad.String(),
+			ThrottleQuery:           this.migrationContext.GetThrottleQuery(),
+			ThrottleHTTP:            this.migrationContext.GetThrottleHTTP(),
+			ThrottleControlReplicas: this.migrationContext.GetThrottleControlReplicaKeys().ToCommaDelimitedList(),
+			ThrottleCommandedByUser: atomic.LoadInt64(&this.migrationContext.ThrottleCommandedByUser) > 0,
+			CopyWorkers:             atomic.LoadInt64(&this.migrationContext.CopyWorkers),
+			PartitionWorkers:        atomic.LoadInt64(&this.migrationContext.PartitionWorkers),
+			DMLApplyWorkers:         atomic.LoadInt64(&this.migrationContext.DMLApplyWorkers),
+			CopyWindow:              this.migrationContext.GetCopyWindows().String(),
+			CutOverWindow:           this.migrationContext.GetCutOverWindows().String(),
+		},
+		Postpone: PostponeStatus{
+			FlagFile:   this.migrationContext.PostponeCutOverFlagFile,
+			Postponing: atomic.LoadInt64(&this.migrationContext.IsPostponingCutOver) > 0,
+		},
+	}
+	if etaSeconds != math.MaxFloat64 {
+		etaSecondsValue := int64(math.Max(etaSeconds, 0))
+		status.ETASeconds = &etaSecondsValue
+	}
+	if isThrottled {
+		status.ThrottleReason = throttleReason
+		status.ThrottleReasonHint = string(throttleReasonHint)
+	}
+	if this.eventsStreamer != nil && this.eventsStreamer.binlogReader != nil {
+		coordinates := this.eventsStreamer.GetCurrentBinlogCoordinates()
+		status.BinlogCoordinates = BinlogCoordinatesStatus{
+			LogFile: coordinates.LogFile,
+			LogPos:  coordinates.LogPos,
+			GTIDSet: coordinates.GTIDSet,
+		}
+	}
+	for _, rangeIteration := range this.getRangeIterations() {
+		if !rangeIteration.IsStarted() || rangeIteration.IsComplete() {
+			continue
+		}
+		status.Chunks = append(status.Chunks, chunkStatus(rangeIteration))
+	}
+	return status
+}
+
+func chunkStatus(rangeIteration *base.RangeIteration) ChunkStatus {
+	chunk := ChunkStatus{
+		Partition:  rangeIteration.Name(),
+		Iteration:  rangeIteration.GetIteration(),
+		RowsCopied: rangeIteration.GetRowsCopied(),
+	}
+	// 由row-copy的goroutine更新, 需要加锁读取
+	iterationRangeMinValues, iterationRangeMaxValues := rangeIteration.GetIterationRange()
+	if iterationRangeMinValues != nil {
+		chunk.RangeStart = iterationRangeMinValues.String()
+	}
+	if iterationRangeMaxValues != nil {
+		chunk.RangeEnd = iterationRangeMaxValues.String()
+	}
+	return chunk
+}
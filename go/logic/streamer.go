@@ -10,11 +10,13 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/github/gh-ost/go/base"
 	"github.com/github/gh-ost/go/binlog"
 	"github.com/github/gh-ost/go/mysql"
+	"github.com/github/gh-ost/go/sql"
 
 	"github.com/outbrain/golib/log"
 	"github.com/outbrain/golib/sqlutils"
@@ -25,6 +27,16 @@ type BinlogEventListener struct {
 	databaseName string
 	tableName    string
 	onDmlEvent   func(event *binlog.BinlogDMLEvent) error
+	// onTransactionCommit (optional) is notified upon commit of each source transaction which had DML events on the table
+	onTransactionCommit func() error
+	inTransaction       bool
+}
+
+// BinlogDDLEventListener is notified of DDL statements on any of the tables it watches
+type BinlogDDLEventListener struct {
+	databaseName string
+	tableNames   []string
+	onDdlEvent   func(event *binlog.BinlogDDLEvent) error
 }
 
 const (
@@ -39,25 +51,43 @@ type EventsStreamer struct {
 	db                       *gosql.DB
 	migrationContext         *base.MigrationContext
 	initialBinlogCoordinates *mysql.BinlogCoordinates
+	resumeBinlogCoordinates  *mysql.BinlogCoordinates
 	listeners                [](*BinlogEventListener)
+	ddlListeners             [](*BinlogDDLEventListener)
 	listenersMutex           *sync.Mutex
+	listenedTables           atomic.Value // map[sql.TableName]bool, 小写; binlog reader 只解析这些表的rows event
 	eventsChannel            chan *binlog.BinlogEntry
 	binlogReader             *binlog.GoMySQLReader
+	useGTID                  bool
 }
 
 func NewEventsStreamer(migrationContext *base.MigrationContext) *EventsStreamer {
-	return &EventsStreamer{
+	streamer := &EventsStreamer{
 		connectionConfig: migrationContext.InspectorConnectionConfig,
 		migrationContext: migrationContext,
 		listeners:        [](*BinlogEventListener){},
 		listenersMutex:   &sync.Mutex{},
 		eventsChannel:    make(chan *binlog.BinlogEntry, EventsChannelBufferSize),
 	}
+	streamer.listenedTables.Store(map[sql.TableName]bool{})
+	return streamer
 }
 
 // AddListener registers a new listener for binlog events, on a per-table basis
 func (this *EventsStreamer) AddListener(
 	async bool, databaseName string, tableName string, onDmlEvent func(event *binlog.BinlogDMLEvent) error) (err error) {
+	return this.addListener(async, databaseName, tableName, onDmlEvent, nil)
+}
+
+// AddTransactionalListener registers a synchronous listener for binlog events on a table, which is also notified
+// of the commit of source transactions: all DML events of a transaction are followed by onTransactionCommit
+func (this *EventsStreamer) AddTransactionalListener(
+	databaseName string, tableName string, onDmlEvent func(event *binlog.BinlogDMLEvent) error, onTransactionCommit func() error) (err error) {
+	return this.addListener(false, databaseName, tableName, onDmlEvent, onTransactionCommit)
+}
+
+func (this *EventsStreamer) addListener(
+	async bool, databaseName string, tableName string, onDmlEvent func(event *binlog.BinlogDMLEvent) error, onTransactionCommit func() error) (err error) {
 
 	this.listenersMutex.Lock()
 	defer this.listenersMutex.Unlock()
@@ -73,8 +103,17 @@ func (this *EventsStreamer) AddListener(
 		databaseName: databaseName,
 		tableName:    tableName,
 		onDmlEvent:   onDmlEvent,
+
+		onTransactionCommit: onTransactionCommit,
 	}
 	this.listeners = append(this.listeners, listener)
+
+	// copy on write: isListenedTable() 在 binlog reader 的 goroutine 中调用
+	listenedTables := map[sql.TableName]bool{}
+	for _, listener := range this.listeners {
+		listenedTables[sql.TableName{Schema: strings.ToLower(listener.databaseName), Name: strings.ToLower(listener.tableName)}] = true
+	}
+	this.listenedTables.Store(listenedTables)
 	return nil
 }
 
@@ -85,18 +124,18 @@ func (this *EventsStreamer) notifyListeners(binlogEvent *binlog.BinlogDMLEvent)
 	defer this.listenersMutex.Unlock()
 
 	// 如何通知listeners呢? 按照加入的先后顺序来通知
-	dbNameLower := strings.ToLower(binlogEvent.DatabaseName)
-	tableNameLower := strings.ToLower(binlogEvent.TableName)
 	for _, listener := range this.listeners {
 		listener := listener
-		// DB和Table一致，可以做一个预处理, 把listener的names都统一为小写
-		if strings.ToLower(listener.databaseName) != dbNameLower {
+		if !strings.EqualFold(listener.databaseName, binlogEvent.DatabaseName) {
 			continue
 		}
-		if strings.ToLower(listener.tableName) != tableNameLower {
+		if !strings.EqualFold(listener.tableName, binlogEvent.TableName) {
 			continue
 		}
 
+		if listener.onTransactionCommit != nil {
+			listener.inTransaction = true
+		}
 		// 同步和异步的区别?
 		// Dml vs. DDL
 		if listener.async {
@@ -109,6 +148,61 @@ func (this *EventsStreamer) notifyListeners(binlogEvent *binlog.BinlogDMLEvent)
 	}
 }
 
+// isListenedTable tells whether any listener is registered for DML events on given table. Rows events of other
+// tables are skipped by the binlog reader
+func (this *EventsStreamer) isListenedTable(databaseName, tableName string) bool {
+	listenedTables := this.listenedTables.Load().(map[sql.TableName]bool)
+	return listenedTables[sql.TableName{Schema: strings.ToLower(databaseName), Name: strings.ToLower(tableName)}]
+}
+
+// notifyTransactionCommit notifies the listeners which got DML events within the transaction just committed
+func (this *EventsStreamer) notifyTransactionCommit() {
+	this.listenersMutex.Lock()
+	defer this.listenersMutex.Unlock()
+
+	for _, listener := range this.listeners {
+		if !listener.inTransaction {
+			continue
+		}
+		listener.inTransaction = false
+		listener.onTransactionCommit()
+	}
+}
+
+// AddDDLListener registers a listener for DDL statements on any of the given tables. The listener is notified
+// synchronously: binlog events following the DDL statement are only streamed on once it returns
+func (this *EventsStreamer) AddDDLListener(databaseName string, tableNames []string, onDdlEvent func(event *binlog.BinlogDDLEvent) error) (err error) {
+	this.listenersMutex.Lock()
+	defer this.listenersMutex.Unlock()
+
+	if databaseName == "" {
+		return fmt.Errorf("Empty database name in AddDDLListener")
+	}
+	if len(tableNames) == 0 {
+		return fmt.Errorf("No table names in AddDDLListener")
+	}
+	listener := &BinlogDDLEventListener{
+		databaseName: databaseName,
+		tableNames:   tableNames,
+		onDdlEvent:   onDdlEvent,
+	}
+	this.ddlListeners = append(this.ddlListeners, listener)
+	return nil
+}
+
+// notifyDDLListeners notifies the listeners watching any of the tables the DDL statement changes
+func (this *EventsStreamer) notifyDDLListeners(binlogEvent *binlog.BinlogDDLEvent) {
+	this.listenersMutex.Lock()
+	listeners := this.ddlListeners
+	this.listenersMutex.Unlock()
+
+	for _, listener := range listeners {
+		if binlogEvent.DDL.Affects(listener.databaseName, listener.tableNames...) {
+			listener.onDdlEvent(binlogEvent)
+		}
+	}
+}
+
 func (this *EventsStreamer) InitDBConnections() (err error) {
 	// 1. Connection + DB 构成完整的Uri
 	EventsStreamerUri := this.connectionConfig.GetDBUri(this.migrationContext.DatabaseName)
@@ -118,8 +212,17 @@ func (this *EventsStreamer) InitDBConnections() (err error) {
 	if _, err := base.ValidateConnection(this.db, this.connectionConfig, this.migrationContext); err != nil {
 		return err
 	}
-	// 获取当前的binlog的位置
-	if err := this.readCurrentBinlogCoordinates(); err != nil {
+	if this.migrationContext.GTIDStreaming {
+		this.useGTID = this.isGTIDModeOn()
+	}
+	if this.resumeBinlogCoordinates != nil && this.useGTID && this.resumeBinlogCoordinates.GTIDSet != "" {
+		// 从checkpoint的GTID set开始读取, 不依赖binlog文件是否还在
+		this.initialBinlogCoordinates = this.resumeBinlogCoordinates
+	} else if this.resumeBinlogCoordinates != nil {
+		// 从checkpoint所在的binlog文件开头读取, 已经apply过的events会被跳过
+		this.initialBinlogCoordinates = &mysql.BinlogCoordinates{LogFile: this.resumeBinlogCoordinates.LogFile, LogPos: 4}
+	} else if err := this.readCurrentBinlogCoordinates(); err != nil {
+		// 获取当前的binlog的位置
 		return err
 	}
 
@@ -127,16 +230,51 @@ func (this *EventsStreamer) InitDBConnections() (err error) {
 	if err := this.initBinlogReader(this.initialBinlogCoordinates); err != nil {
 		return err
 	}
+	if this.resumeBinlogCoordinates != nil && this.initialBinlogCoordinates.GTIDSet == "" {
+		this.binlogReader.LastAppliedRowsEventHint = *this.resumeBinlogCoordinates
+	}
 
 	return nil
 }
 
+// isGTIDModeOn checks whether the hooked server has gtid_mode=ON; servers not supporting GTID are treated as OFF.
+// MariaDB always has GTIDs, but only a single replication domain is supported
+func (this *EventsStreamer) isGTIDModeOn() bool {
+	if this.migrationContext.InspectorFlavor == mysql.MariaDBFlavor {
+		gtidBinlogPos, err := this.readMariaDBGTIDBinlogPos()
+		if err != nil {
+			log.Debugf("Cannot read gtid_binlog_pos, streaming by binlog file & position: %+v", err)
+			return false
+		}
+		if strings.Contains(gtidBinlogPos, ",") {
+			log.Infof("gtid_binlog_pos=%s has multiple domains, streaming by binlog file & position", gtidBinlogPos)
+			return false
+		}
+		return true
+	}
+	var gtidMode string
+	query := `select /* gh-ost */ @@global.gtid_mode`
+	if err := this.db.QueryRow(query).Scan(&gtidMode); err != nil {
+		log.Debugf("Cannot read gtid_mode, streaming by binlog file & position: %+v", err)
+		return false
+	}
+	log.Infof("gtid_mode=%s", gtidMode)
+	return strings.ToUpper(gtidMode) == "ON"
+}
+
+// ResumeFrom makes the streamer pick up where a previous migration left off: events up to
+// and including given coordinates are skipped. It must be called before InitDBConnections()
+func (this *EventsStreamer) ResumeFrom(coordinates *mysql.BinlogCoordinates) {
+	this.resumeBinlogCoordinates = coordinates
+}
+
 // initBinlogReader creates and connects the reader: we hook up to a MySQL server as a replica
 func (this *EventsStreamer) initBinlogReader(binlogCoordinates *mysql.BinlogCoordinates) error {
 	goMySQLReader, err := binlog.NewGoMySQLReader(this.migrationContext)
 	if err != nil {
 		return err
 	}
+	goMySQLReader.SetTableFilter(this.isListenedTable)
 	// 设置起始read的位置
 	if err := goMySQLReader.ConnectBinlogStreamer(*binlogCoordinates); err != nil {
 		return err
@@ -151,8 +289,21 @@ func (this *EventsStreamer) GetCurrentBinlogCoordinates() *mysql.BinlogCoordinat
 	return this.binlogReader.GetCurrentBinlogCoordinates()
 }
 
+// GetReconnectBinlogCoordinates returns the coordinates to reconnect at: the executed GTID set with GTID streaming,
+// otherwise the beginning of the current binlog file
 func (this *EventsStreamer) GetReconnectBinlogCoordinates() *mysql.BinlogCoordinates {
-	return &mysql.BinlogCoordinates{LogFile: this.GetCurrentBinlogCoordinates().LogFile, LogPos: 4}
+	currentCoordinates := this.GetCurrentBinlogCoordinates()
+	if currentCoordinates.GTIDSet != "" {
+		return currentCoordinates
+	}
+	return &mysql.BinlogCoordinates{LogFile: currentCoordinates.LogFile, LogPos: 4}
+}
+
+// readMariaDBGTIDBinlogPos reads the GTID of the last transaction in the hooked (MariaDB) server's binlog
+func (this *EventsStreamer) readMariaDBGTIDBinlogPos() (gtidBinlogPos string, err error) {
+	query := `select /* gh-ost */ @@global.gtid_binlog_pos`
+	err = this.db.QueryRow(query).Scan(&gtidBinlogPos)
+	return gtidBinlogPos, err
 }
 
 // readCurrentBinlogCoordinates reads master status from hooked server
@@ -169,6 +320,10 @@ func (this *EventsStreamer) readCurrentBinlogCoordinates() error {
 			LogFile: m.GetString("File"),
 			LogPos:  m.GetInt64("Position"),
 		}
+		if this.useGTID && this.migrationContext.InspectorFlavor != mysql.MariaDBFlavor {
+			// Executed_Gtid_Set 在多个 server uuid 时包含换行
+			this.initialBinlogCoordinates.GTIDSet = strings.Join(strings.Fields(m.GetString("Executed_Gtid_Set")), "")
+		}
 		foundMasterStatus = true
 
 		return nil
@@ -179,6 +334,12 @@ func (this *EventsStreamer) readCurrentBinlogCoordinates() error {
 	if !foundMasterStatus {
 		return fmt.Errorf("Got no results from SHOW MASTER STATUS. Bailing out")
 	}
+	if this.useGTID && this.migrationContext.InspectorFlavor == mysql.MariaDBFlavor {
+		// MariaDB 的 SHOW MASTER STATUS 不包含 GTID
+		if this.initialBinlogCoordinates.GTIDSet, err = this.readMariaDBGTIDBinlogPos(); err != nil {
+			return err
+		}
+	}
 	log.Debugf("Streamer binlog coordinates: %+v", *this.initialBinlogCoordinates)
 	return nil
 }
@@ -191,11 +352,19 @@ func (this *EventsStreamer) StreamEvents(canStopStreaming func() bool) error {
 			if binlogEntry.DmlEvent != nil {
 				this.notifyListeners(binlogEntry.DmlEvent)
 			}
+			if binlogEntry.DdlEvent != nil {
+				this.notifyDDLListeners(binlogEntry.DdlEvent)
+			}
+			// BEGIN 不需要通知: 事务中的第一个DML event即为事务的开始
+			if binlogEntry.TransactionMarker == binlog.TransactionCommitMarker {
+				this.notifyTransactionCommit()
+			}
 		}
 	}()
 	// The next should block and execute forever, unless there's a serious error
 	var successiveFailures int64
 	var lastAppliedRowsEventHint mysql.BinlogCoordinates
+	var lastReconnectGTIDSet string
 	for {
 		if canStopStreaming() {
 			return nil
@@ -213,25 +382,31 @@ func (this *EventsStreamer) StreamEvents(canStopStreaming func() bool) error {
 
 			// See if there's retry overflow
 			// 失败提示? 如果连续N次在同一个地方失败，则退出
-			if this.binlogReader.LastAppliedRowsEventHint.Equals(&lastAppliedRowsEventHint) {
+			reconnectCoordinates := this.GetReconnectBinlogCoordinates()
+			if this.binlogReader.LastAppliedRowsEventHint.Equals(&lastAppliedRowsEventHint) && reconnectCoordinates.GTIDSet == lastReconnectGTIDSet {
 				successiveFailures += 1
 			} else {
 				successiveFailures = 0
 			}
 			if successiveFailures > this.migrationContext.MaxRetries() {
-				return fmt.Errorf("%d successive failures in streamer reconnect at coordinates %+v", successiveFailures, this.GetReconnectBinlogCoordinates())
+				return fmt.Errorf("%d successive failures in streamer reconnect at coordinates %+v", successiveFailures, reconnectCoordinates)
 			}
 
-			// Reposition at same binlog file.
+			// Reposition at same binlog file, or at the executed GTID set.
 			lastAppliedRowsEventHint = this.binlogReader.LastAppliedRowsEventHint
-			log.Infof("Reconnecting... Will resume at %+v", lastAppliedRowsEventHint)
+			lastReconnectGTIDSet = reconnectCoordinates.GTIDSet
+			log.Infof("Reconnecting... Will resume at %+v", *reconnectCoordinates)
 
 			// 获取之前的binlogReader的binlog-coordinate
 			// 重新初始化binlog reader？
-			if err := this.initBinlogReader(this.GetReconnectBinlogCoordinates()); err != nil {
+			this.binlogReader.Close()
+			if err := this.initBinlogReader(reconnectCoordinates); err != nil {
 				return err
 			}
-			this.binlogReader.LastAppliedRowsEventHint = lastAppliedRowsEventHint
+			if reconnectCoordinates.GTIDSet == "" {
+				// GTID streaming 从未完整接收的事务开始, 重复的events可以安全地再次apply
+				this.binlogReader.LastAppliedRowsEventHint = lastAppliedRowsEventHint
+			}
 		}
 	}
 }
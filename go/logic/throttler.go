@@ -44,7 +44,13 @@ type Throttler struct {
 	migrationContext  *base.MigrationContext
 	applier           *Applier
 	inspector         *Inspector
+	loadMetrics       *loadMetrics
 	finishedMigrating int64
+
+	// --copy-window: 当前所在的window, 以及进入之前的设置(离开时恢复)
+	copyWindow               *base.TimeWindow
+	copyWindowSavedChunkSize int64
+	copyWindowSavedNiceRatio float64
 }
 
 func NewThrottler(migrationContext *base.MigrationContext, applier *Applier, inspector *Inspector) *Throttler {
@@ -52,6 +58,7 @@ func NewThrottler(migrationContext *base.MigrationContext, applier *Applier, ins
 		migrationContext:  migrationContext,
 		applier:           applier,
 		inspector:         inspector,
+		loadMetrics:       newLoadMetrics(applier, inspector),
 		finishedMigrating: 0,
 	}
 }
@@ -265,18 +272,8 @@ func (this *Throttler) collectControlReplicasLag() {
 	}
 }
 
-func (this *Throttler) criticalLoadIsMet() (met bool, variableName string, value int64, threshold int64, err error) {
-	criticalLoad := this.migrationContext.GetCriticalLoad()
-	for variableName, threshold = range criticalLoad {
-		value, err = this.applier.ShowStatusVariable(variableName)
-		if err != nil {
-			return false, variableName, value, threshold, err
-		}
-		if value >= threshold {
-			return true, variableName, value, threshold, nil
-		}
-	}
-	return false, variableName, value, threshold, nil
+func (this *Throttler) criticalLoadIsMet() (met bool, condition *base.LoadCondition, value float64, err error) {
+	return this.loadMetrics.loadIsMet("critical-load", this.migrationContext.GetCriticalLoad())
 }
 
 // collectReplicationLag reads the latest changelog heartbeat value
@@ -323,6 +320,9 @@ func (this *Throttler) collectGeneralThrottleMetrics() error {
 		return nil
 	}
 
+	// 无论是否throttle, 都要切换 --copy-window 的 profile
+	copyWindowThrottleReason := this.copyWindowThrottleReason(time.Now())
+
 	// Regardless of throttle, we take opportunity to check for panic-abort
 	if this.migrationContext.PanicFlagFile != "" {
 		if base.FileExists(this.migrationContext.PanicFlagFile) {
@@ -330,16 +330,16 @@ func (this *Throttler) collectGeneralThrottleMetrics() error {
 		}
 	}
 
-	criticalLoadMet, variableName, value, threshold, err := this.criticalLoadIsMet()
+	criticalLoadMet, condition, value, err := this.criticalLoadIsMet()
 	if err != nil {
-		return setThrottle(true, fmt.Sprintf("%s %s", variableName, err), base.NoThrottleReasonHint)
+		return setThrottle(true, fmt.Sprintf("%s %s", condition.Metric(), err), base.NoThrottleReasonHint)
 	}
 
 	if criticalLoadMet && this.migrationContext.CriticalLoadHibernateSeconds > 0 {
 		hibernateDuration := time.Duration(this.migrationContext.CriticalLoadHibernateSeconds) * time.Second
 		hibernateUntilTime := time.Now().Add(hibernateDuration)
 		atomic.StoreInt64(&this.migrationContext.HibernateUntil, hibernateUntilTime.UnixNano())
-		log.Errorf("critical-load met: %s=%d, >=%d. Will hibernate for the duration of %+v, until %+v", variableName, value, threshold, hibernateDuration, hibernateUntilTime)
+		log.Errorf("critical-load met: %s. Will hibernate for the duration of %+v, until %+v", condition.Explain(value), hibernateDuration, hibernateUntilTime)
 		go func() {
 			time.Sleep(hibernateDuration)
 			this.migrationContext.SetThrottleGeneralCheckResult(base.NewThrottleCheckResult(true, "leaving hibernation", base.LeavingHibernationThrottleReasonHint))
@@ -349,21 +349,24 @@ func (this *Throttler) collectGeneralThrottleMetrics() error {
 	}
 
 	if criticalLoadMet && this.migrationContext.CriticalLoadIntervalMilliseconds == 0 {
-		this.migrationContext.PanicAbort <- fmt.Errorf("critical-load met: %s=%d, >=%d", variableName, value, threshold)
+		this.migrationContext.PanicAbort <- fmt.Errorf("critical-load met: %s", condition.Explain(value))
 	}
 	if criticalLoadMet && this.migrationContext.CriticalLoadIntervalMilliseconds > 0 {
-		log.Errorf("critical-load met once: %s=%d, >=%d. Will check again in %d millis", variableName, value, threshold, this.migrationContext.CriticalLoadIntervalMilliseconds)
+		log.Errorf("critical-load met once: %s. Will check again in %d millis", condition.Explain(value), this.migrationContext.CriticalLoadIntervalMilliseconds)
 		go func() {
 			timer := time.NewTimer(time.Millisecond * time.Duration(this.migrationContext.CriticalLoadIntervalMilliseconds))
 			<-timer.C
-			if criticalLoadMetAgain, variableName, value, threshold, _ := this.criticalLoadIsMet(); criticalLoadMetAgain {
-				this.migrationContext.PanicAbort <- fmt.Errorf("critical-load met again after %d millis: %s=%d, >=%d", this.migrationContext.CriticalLoadIntervalMilliseconds, variableName, value, threshold)
+			if criticalLoadMetAgain, condition, value, _ := this.criticalLoadIsMet(); criticalLoadMetAgain {
+				this.migrationContext.PanicAbort <- fmt.Errorf("critical-load met again after %d millis: %s", this.migrationContext.CriticalLoadIntervalMilliseconds, condition.Explain(value))
 			}
 		}()
 	}
 
 	// Back to throttle considerations
 
+	if atomic.LoadInt64(&this.migrationContext.IsPausedOnDDL) > 0 {
+		return setThrottle(true, "paused on DDL, awaiting 'ack-ddl'", base.NoThrottleReasonHint)
+	}
 	// User-based throttle
 	if atomic.LoadInt64(&this.migrationContext.ThrottleCommandedByUser) > 0 {
 		return setThrottle(true, "commanded by user", base.UserCommandThrottleReasonHint)
@@ -380,16 +383,16 @@ func (this *Throttler) collectGeneralThrottleMetrics() error {
 			return setThrottle(true, "flag-file", base.NoThrottleReasonHint)
 		}
 	}
+	if copyWindowThrottleReason != "" {
+		return setThrottle(true, copyWindowThrottleReason, base.NoThrottleReasonHint)
+	}
 
-	maxLoad := this.migrationContext.GetMaxLoad()
-	for variableName, threshold := range maxLoad {
-		value, err := this.applier.ShowStatusVariable(variableName)
-		if err != nil {
-			return setThrottle(true, fmt.Sprintf("%s %s", variableName, err), base.NoThrottleReasonHint)
-		}
-		if value >= threshold {
-			return setThrottle(true, fmt.Sprintf("max-load %s=%d >= %d", variableName, value, threshold), base.NoThrottleReasonHint)
-		}
+	maxLoadMet, condition, value, err := this.loadMetrics.loadIsMet("max-load", this.migrationContext.GetMaxLoad())
+	if err != nil {
+		return setThrottle(true, fmt.Sprintf("%s %s", condition.Metric(), err), base.NoThrottleReasonHint)
+	}
+	if maxLoadMet {
+		return setThrottle(true, fmt.Sprintf("max-load %s", condition.Explain(value)), base.NoThrottleReasonHint)
 	}
 	if this.migrationContext.GetThrottleQuery() != "" {
 		// 如果返回结果 > 0, 则要执行throttle, 暂停迁移
@@ -409,6 +412,7 @@ func (this *Throttler) initiateThrottlerCollection(firstThrottlingCollected chan
 	go this.collectReplicationLag(firstThrottlingCollected)
 
 	go this.collectControlReplicasLag()
+	go this.discoverThrottleControlReplicas()
 	go this.collectThrottleHTTPStatus(firstThrottlingCollected)
 
 	go func() {
@@ -27,10 +27,13 @@ const (
 )
 
 // BinlogCoordinates described binary log coordinates in the form of log file & log position.
+// With GTID streaming, GTIDSet is the set of transactions fully streamed up to these coordinates;
+// unlike file & position it remains meaningful across servers (e.g. once the inspected replica is repointed).
 type BinlogCoordinates struct {
 	LogFile string
 	LogPos  int64
 	Type    BinlogType
+	GTIDSet string
 }
 
 // ParseInstanceKey will parse an InstanceKey from a string representation such as 127.0.0.1:3306
@@ -0,0 +1,43 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package mysql
+
+import (
+	gosql "database/sql"
+	"strings"
+)
+
+// 与 go-mysql 的 BinlogSyncerConfig.Flavor 一致
+const (
+	MySQLFlavor   = "mysql"
+	MariaDBFlavor = "mariadb"
+)
+
+// FlavorFromVersion tells the server flavor by its @@version, e.g. `10.3.27-MariaDB-log`
+func FlavorFromVersion(version string) string {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return MariaDBFlavor
+	}
+	return MySQLFlavor
+}
+
+// GetFlavor reads the flavor of the server on given DB
+func GetFlavor(db *gosql.DB) (string, error) {
+	var version string
+	if err := db.QueryRow(`select @@global.version`).Scan(&version); err != nil {
+		return "", err
+	}
+	return FlavorFromVersion(version), nil
+}
+
+// slaveStatusQuery lists the replication channels of the server. MariaDB's plain `show slave status`
+// only shows the default connection of a multi-source replica
+func slaveStatusQuery(flavor string) string {
+	if flavor == MariaDBFlavor {
+		return `show all slaves status`
+	}
+	return `show slave status`
+}
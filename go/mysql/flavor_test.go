@@ -0,0 +1,24 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestFlavorFromVersion(t *testing.T) {
+	test.S(t).ExpectEquals(FlavorFromVersion("5.7.21-log"), MySQLFlavor)
+	test.S(t).ExpectEquals(FlavorFromVersion("8.0.12"), MySQLFlavor)
+	test.S(t).ExpectEquals(FlavorFromVersion("10.3.27-MariaDB-log"), MariaDBFlavor)
+	test.S(t).ExpectEquals(FlavorFromVersion("5.5.5-10.1.44-MariaDB"), MariaDBFlavor)
+}
+
+func TestSlaveStatusQuery(t *testing.T) {
+	test.S(t).ExpectEquals(slaveStatusQuery(MySQLFlavor), "show slave status")
+	test.S(t).ExpectEquals(slaveStatusQuery(MariaDBFlavor), "show all slaves status")
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/github/gh-ost/go/sql"
 
+	driver "github.com/go-sql-driver/mysql"
 	"github.com/outbrain/golib/log"
 	"github.com/outbrain/golib/sqlutils"
 )
@@ -21,6 +22,11 @@ import (
 const MaxTableNameLength = 64
 const MaxReplicationPasswordLength = 32
 
+const (
+	ErrLockWaitTimeout = 1205 // ER_LOCK_WAIT_TIMEOUT
+	ErrLockDeadlock    = 1213 // ER_LOCK_DEADLOCK
+)
+
 type ReplicationLagResult struct {
 	Key InstanceKey
 	Lag time.Duration
@@ -79,18 +85,26 @@ func GetReplicationLag(informationSchemaDb *gosql.DB, connectionConfig *Connecti
 func GetMasterKeyFromSlaveStatus(connectionConfig *ConnectionConfig) (masterKey *InstanceKey, err error) {
 	// 这个DB有什么特别的意义?
 	currentUri := connectionConfig.GetDBUri("information_schema")
-	// This function is only called once, okay to not have a cached connection pool
+	// This function is only called upon inspection, okay to not have a cached connection pool.
+	// Replica discovery, which probes replicas periodically, uses QueryMasterKeyFromSlaveStatus() on a cached pool
 	db, err := gosql.Open("mysql", currentUri)
 	if err != nil {
 		return nil, err
 	}
 	defer db.Close()
 
+	return QueryMasterKeyFromSlaveStatus(db, connectionConfig)
+}
+
+// QueryMasterKeyFromSlaveStatus returns the master key of the server behind given (information_schema) db,
+// or nil when it does not replicate
+func QueryMasterKeyFromSlaveStatus(db *gosql.DB, connectionConfig *ConnectionConfig) (masterKey *InstanceKey, err error) {
+	flavor, err := GetFlavor(db)
 	if err != nil {
 		return nil, err
 	}
 	// 执行show slave status?
-	err = sqlutils.QueryRowsMap(db, `show slave status`, func(rowMap sqlutils.RowMap) error {
+	err = sqlutils.QueryRowsMap(db, slaveStatusQuery(flavor), func(rowMap sqlutils.RowMap) error {
 		// We wish to recognize the case where the topology's master actually has replication configuration.
 		// This can happen when a DBA issues a `RESET SLAVE` instead of `RESET SLAVE ALL`.
 
@@ -112,6 +126,14 @@ func GetMasterKeyFromSlaveStatus(connectionConfig *ConnectionConfig) (masterKey
 			)
 		}
 
+		// MariaDB multi-source: 多个master无法确定migration所在的master
+		if masterKey != nil {
+			return fmt.Errorf("%+v replicates from multiple masters (connection %s). Multi-source replication is unsupported",
+				connectionConfig.Key,
+				rowMap.GetString("Connection_name"),
+			)
+		}
+
 		// 得到master key
 		masterKey = &InstanceKey{
 			Hostname: rowMap.GetString("Master_Host"),
@@ -163,6 +185,54 @@ func GetMasterConnectionConfigSafe(connectionConfig *ConnectionConfig, visitedKe
 	return GetMasterConnectionConfigSafe(masterConfig, visitedKeys, allowMasterMaster)
 }
 
+// GetReplicaKeys returns the replicas of the server on given DB: those listed by SHOW SLAVE HOSTS (replicas which
+// set report_host), and the hosts of binlog dump threads in its processlist. The latter do not tell the replica's
+// port, which is assumed to be defaultPort. The replica of ignoreServerId (gh-ost's own binlog reader) is skipped.
+// Binlog dump threads are not necessarily replicas (e.g. gh-ost itself, or other binlog readers): callers should
+// verify the keys.
+func GetReplicaKeys(db *gosql.DB, defaultPort int, ignoreServerId uint) (replicaKeys []InstanceKey, err error) {
+	replicaHostnames := make(map[string]bool)
+	err = sqlutils.QueryRowsMap(db, `show slave hosts`, func(m sqlutils.RowMap) error {
+		if m.GetUint("Server_id") == ignoreServerId || m.GetString("Host") == "" {
+			return nil
+		}
+		replicaKey := InstanceKey{Hostname: m.GetString("Host"), Port: m.GetIntD("Port", defaultPort)}
+		replicaKeys = append(replicaKeys, replicaKey)
+		replicaHostnames[replicaKey.Hostname] = true
+		return nil
+	})
+	if err != nil {
+		return replicaKeys, err
+	}
+
+	query := `
+		select
+			host
+		from
+			information_schema.processlist
+		where
+			command in ('Binlog Dump', 'Binlog Dump GTID')
+	`
+	err = sqlutils.QueryRowsMap(db, query, func(m sqlutils.RowMap) error {
+		hostname := processlistHostname(m.GetString("host"))
+		if hostname == "" || replicaHostnames[hostname] {
+			return nil
+		}
+		replicaKeys = append(replicaKeys, InstanceKey{Hostname: hostname, Port: defaultPort})
+		replicaHostnames[hostname] = true
+		return nil
+	})
+	return replicaKeys, err
+}
+
+// processlistHostname strips the client port off a processlist host, e.g. `10.0.0.5:51234`
+func processlistHostname(host string) string {
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
+
 // 获取当前的slave的复制情况?
 func GetReplicationBinlogCoordinates(db *gosql.DB) (readBinlogCoordinates *BinlogCoordinates, executeBinlogCoordinates *BinlogCoordinates, err error) {
 	err = sqlutils.QueryRowsMap(db, `show slave status`, func(m sqlutils.RowMap) error {
@@ -228,3 +298,12 @@ func GetTableColumns(db *gosql.DB, databaseName, tableName string) (*sql.ColumnL
 	}
 	return sql.NewColumnList(columnNames), sql.NewColumnList(virtualColumnNames), nil
 }
+
+// IsLockWaitError tells whether given error is a lock wait timeout or a deadlock, as reported by the server
+func IsLockWaitError(err error) bool {
+	mysqlErr, ok := err.(*driver.MySQLError)
+	if !ok {
+		return false
+	}
+	return mysqlErr.Number == ErrLockWaitTimeout || mysqlErr.Number == ErrLockDeadlock
+}
@@ -0,0 +1,30 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package mysql
+
+import (
+	"fmt"
+	"testing"
+
+	driver "github.com/go-sql-driver/mysql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestIsLockWaitError(t *testing.T) {
+	test.S(t).ExpectTrue(IsLockWaitError(&driver.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded; try restarting transaction"}))
+	test.S(t).ExpectTrue(IsLockWaitError(&driver.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}))
+	test.S(t).ExpectFalse(IsLockWaitError(&driver.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}))
+	test.S(t).ExpectFalse(IsLockWaitError(fmt.Errorf("Error 1205: Lock wait timeout exceeded")))
+	test.S(t).ExpectFalse(IsLockWaitError(nil))
+}
+
+func TestProcesslistHostname(t *testing.T) {
+	test.S(t).ExpectEquals(processlistHostname("10.0.0.5:51234"), "10.0.0.5")
+	test.S(t).ExpectEquals(processlistHostname("shard00-r3.db.test.com:40112"), "shard00-r3.db.test.com")
+	test.S(t).ExpectEquals(processlistHostname("[fe80::1]:40112"), "fe80::1")
+	test.S(t).ExpectEquals(processlistHostname("localhost"), "localhost")
+	test.S(t).ExpectEquals(processlistHostname(""), "")
+}
@@ -260,7 +260,7 @@ func BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName strin
 	result = fmt.Sprintf(`
       insert /* gh-ost %s.%s */ ignore into %s.%s %s (%s)
       (select %s from %s.%s %s force index (%s)
-        where (%s and %s %s) %s
+        where (%s and %s%s) %s
       )
     `, databaseName, originalTableName, databaseName, ghostTableName, partitionInfo, mappedSharedColumnsListing,
 		sharedColumnsListing, databaseName, originalTableName, partitionInfo, uniqueKey,
@@ -284,6 +284,318 @@ func BuildRangeInsertPreparedQuery(databaseName, originalTableName, ghostTableNa
 		includeRangeStartValues, transactionalTable)
 }
 
+// BuildComplementFilterCondition returns a condition matching the rows which given filter excludes,
+// i.e. for which the filter is either false or NULL
+func BuildComplementFilterCondition(filterCondition string) string {
+	return fmt.Sprintf("(%s) is not true", filterCondition)
+}
+
+// BuildRangeSelectPreparedQuery builds a query selecting given columns of the rows within the given unique key range
+func BuildRangeSelectPreparedQuery(databaseName, tableName string, partition *PartitionInfo, filterCondition string, columns []string, uniqueKey string, uniqueKeyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}, includeRangeStartValues bool, transactionalTable bool) (result string, explodedArgs []interface{}, err error) {
+	if len(columns) == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 columns in BuildRangeSelectPreparedQuery")
+	}
+	databaseName = EscapeName(databaseName)
+	tableName = EscapeName(tableName)
+
+	var startRangeComparisonSign ValueComparisonSign = GreaterThanComparisonSign
+	if includeRangeStartValues {
+		startRangeComparisonSign = GreaterThanOrEqualsComparisonSign
+	}
+	rangeStartComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(uniqueKeyColumns, rangeStartArgs, startRangeComparisonSign)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+	rangeEndComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(uniqueKeyColumns, rangeEndArgs, LessThanOrEqualsComparisonSign)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+
+	columns = duplicateNames(columns)
+	for i := range columns {
+		columns[i] = EscapeName(columns[i])
+	}
+	if len(filterCondition) > 0 {
+		filterCondition = " and (" + filterCondition + ")"
+	}
+	partitionInfo := ""
+	if partition != nil {
+		partitionInfo = fmt.Sprintf("partition(%s)", partition.PartitionName)
+	}
+	transactionalClause := ""
+	if transactionalTable {
+		transactionalClause = "lock in share mode"
+	}
+	result = fmt.Sprintf(`
+      select /* gh-ost %s.%s */ %s
+        from %s.%s %s force index (%s)
+        where (%s and %s%s) %s
+    `, databaseName, tableName, strings.Join(columns, ", "),
+		databaseName, tableName, partitionInfo, EscapeName(uniqueKey),
+		rangeStartComparison, rangeEndComparison, filterCondition, transactionalClause,
+	)
+	return result, explodedArgs, nil
+}
+
+// BuildRangeChecksumPreparedQuery builds a query returning the number of rows and a BIT_XOR(CRC32(...))
+// checksum over given columns, for rows within the given unique key range. Running it on both the original
+// and the ghost table (with respective column and unique key column names) tells whether the chunk was migrated intact.
+// With no columns, the checksum is 0 and only row counts are compared.
+func BuildRangeChecksumPreparedQuery(databaseName, tableName string, filterCondition string, columns []string, uniqueKey string, uniqueKeyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}, includeRangeStartValues bool) (result string, explodedArgs []interface{}, err error) {
+	if uniqueKeyColumns.Len() == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildRangeChecksumPreparedQuery")
+	}
+	databaseName = EscapeName(databaseName)
+	tableName = EscapeName(tableName)
+
+	var startRangeComparisonSign ValueComparisonSign = GreaterThanComparisonSign
+	if includeRangeStartValues {
+		startRangeComparisonSign = GreaterThanOrEqualsComparisonSign
+	}
+	rangeStartComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(uniqueKeyColumns, rangeStartArgs, startRangeComparisonSign)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+	rangeEndComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(uniqueKeyColumns, rangeEndArgs, LessThanOrEqualsComparisonSign)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+
+	// concat_ws 会忽略NULL, 因此额外加上isnull(), 以区分 (NULL, 'a') 和 ('a', NULL)
+	columns = duplicateNames(columns)
+	nullFlags := make([]string, len(columns), len(columns))
+	for i := range columns {
+		columns[i] = EscapeName(columns[i])
+		nullFlags[i] = fmt.Sprintf("isnull(%s)", columns[i])
+	}
+	checksum := "0"
+	if len(columns) > 0 {
+		checksum = fmt.Sprintf("coalesce(bit_xor(crc32(concat_ws('#', %s))), 0)", strings.Join(append(columns, nullFlags...), ", "))
+	}
+
+	forceIndex := ""
+	if uniqueKey != "" {
+		forceIndex = fmt.Sprintf("force index (%s)", EscapeName(uniqueKey))
+	}
+	if len(filterCondition) > 0 {
+		filterCondition = " and (" + filterCondition + ")"
+	}
+	result = fmt.Sprintf(`
+      select /* gh-ost %s.%s checksum */
+          count(*), %s
+        from %s.%s %s
+        where (%s and %s%s)
+    `, databaseName, tableName,
+		checksum,
+		databaseName, tableName, forceIndex,
+		rangeStartComparison, rangeEndComparison, filterCondition,
+	)
+	return result, explodedArgs, nil
+}
+
+// buildRangePreparedCondition builds the condition of a unique key range [start, end], or (start, end]
+func buildRangePreparedCondition(uniqueKeyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}, includeRangeStartValues bool) (result string, explodedArgs []interface{}, err error) {
+	var startRangeComparisonSign ValueComparisonSign = GreaterThanComparisonSign
+	if includeRangeStartValues {
+		startRangeComparisonSign = GreaterThanOrEqualsComparisonSign
+	}
+	rangeStartComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(uniqueKeyColumns, rangeStartArgs, startRangeComparisonSign)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+	rangeEndComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(uniqueKeyColumns, rangeEndArgs, LessThanOrEqualsComparisonSign)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+	return fmt.Sprintf("%s and %s", rangeStartComparison, rangeEndComparison), explodedArgs, nil
+}
+
+// BuildRangeConditionsCountPreparedQuery returns the query counting, within a unique key range, the rows
+// matching each of given conditions (one result column per condition)
+func BuildRangeConditionsCountPreparedQuery(databaseName, tableName string, filterCondition string, conditions []string, uniqueKey string, uniqueKeyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}, includeRangeStartValues bool) (result string, explodedArgs []interface{}, err error) {
+	if len(conditions) == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 conditions in BuildRangeConditionsCountPreparedQuery")
+	}
+	if uniqueKeyColumns.Len() == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildRangeConditionsCountPreparedQuery")
+	}
+	rangeCondition, explodedArgs, err := buildRangePreparedCondition(uniqueKeyColumns, rangeStartArgs, rangeEndArgs, includeRangeStartValues)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	counts := make([]string, len(conditions))
+	for i, condition := range conditions {
+		counts[i] = fmt.Sprintf("coalesce(sum(%s), 0)", condition)
+	}
+	forceIndex := ""
+	if uniqueKey != "" {
+		forceIndex = fmt.Sprintf("force index (%s)", EscapeName(uniqueKey))
+	}
+	if len(filterCondition) > 0 {
+		filterCondition = " and (" + filterCondition + ")"
+	}
+	result = fmt.Sprintf(`
+      select /* gh-ost %s.%s conditions count */
+          %s
+        from %s.%s %s
+        where (%s%s)
+    `, EscapeName(databaseName), EscapeName(tableName),
+		strings.Join(counts, ", "),
+		EscapeName(databaseName), EscapeName(tableName), forceIndex,
+		rangeCondition, filterCondition,
+	)
+	return result, explodedArgs, nil
+}
+
+// BuildRangeConditionSamplePreparedQuery returns the query reading given columns of (up to limit) rows which
+// match a condition, within a unique key range
+func BuildRangeConditionSamplePreparedQuery(databaseName, tableName string, filterCondition string, condition string, columns []string, uniqueKey string, uniqueKeyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}, includeRangeStartValues bool, limit int64) (result string, explodedArgs []interface{}, err error) {
+	if len(columns) == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 columns in BuildRangeConditionSamplePreparedQuery")
+	}
+	if uniqueKeyColumns.Len() == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildRangeConditionSamplePreparedQuery")
+	}
+	rangeCondition, explodedArgs, err := buildRangePreparedCondition(uniqueKeyColumns, rangeStartArgs, rangeEndArgs, includeRangeStartValues)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	columns = duplicateNames(columns)
+	for i := range columns {
+		columns[i] = EscapeName(columns[i])
+	}
+	forceIndex := ""
+	if uniqueKey != "" {
+		forceIndex = fmt.Sprintf("force index (%s)", EscapeName(uniqueKey))
+	}
+	if len(filterCondition) > 0 {
+		filterCondition = " and (" + filterCondition + ")"
+	}
+	result = fmt.Sprintf(`
+      select /* gh-ost %s.%s condition sample */
+          %s
+        from %s.%s %s
+        where (%s%s) and (%s)
+        limit %d
+    `, EscapeName(databaseName), EscapeName(tableName),
+		strings.Join(columns, ", "),
+		EscapeName(databaseName), EscapeName(tableName), forceIndex,
+		rangeCondition, filterCondition, condition,
+		limit,
+	)
+	return result, explodedArgs, nil
+}
+
+// buildDuplicateKeysConditions builds the conditions of a duplicate keys scan over given range (start, end]:
+// rows with NULL in any of the key columns are excluded, as NULLs never violate a unique key
+func buildDuplicateKeysConditions(keyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}) (conditions []string, explodedArgs []interface{}, err error) {
+	for _, name := range keyColumns.Names() {
+		conditions = append(conditions, fmt.Sprintf("%s is not null", EscapeName(name)))
+	}
+	if rangeStartArgs != nil {
+		rangeStartComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(keyColumns, rangeStartArgs, GreaterThanComparisonSign)
+		if err != nil {
+			return conditions, explodedArgs, err
+		}
+		conditions = append(conditions, rangeStartComparison)
+		explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+	}
+	if rangeEndArgs != nil {
+		rangeEndComparison, rangeExplodedArgs, err := BuildRangePreparedComparison(keyColumns, rangeEndArgs, LessThanOrEqualsComparisonSign)
+		if err != nil {
+			return conditions, explodedArgs, err
+		}
+		conditions = append(conditions, rangeEndComparison)
+		explodedArgs = append(explodedArgs, rangeExplodedArgs...)
+	}
+	return conditions, explodedArgs, nil
+}
+
+// BuildDuplicateKeysRangeEndPreparedQuery returns the query reading the end values of the next chunk of a
+// duplicate keys scan, which walks through an index whose leading columns are the key columns
+func BuildDuplicateKeysRangeEndPreparedQuery(databaseName, tableName, indexName string, keyColumns *ColumnList, rangeStartArgs []interface{}, chunkSize int64) (result string, explodedArgs []interface{}, err error) {
+	if keyColumns.Len() == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 columns in BuildDuplicateKeysRangeEndPreparedQuery")
+	}
+	conditions, explodedArgs, err := buildDuplicateKeysConditions(keyColumns, rangeStartArgs, nil)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	keyColumnNames := duplicateNames(keyColumns.Names())
+	for i := range keyColumnNames {
+		keyColumnNames[i] = EscapeName(keyColumnNames[i])
+	}
+	result = fmt.Sprintf(`
+				select  /* gh-ost %s.%s duplicate keys range end */
+						%s
+					from
+						%s.%s force index (%s)
+					where %s
+					order by
+						%s
+					limit 1
+					offset %d
+    `, EscapeName(databaseName), EscapeName(tableName),
+		strings.Join(keyColumnNames, ", "),
+		EscapeName(databaseName), EscapeName(tableName), EscapeName(indexName),
+		strings.Join(conditions, " and "),
+		strings.Join(keyColumnNames, ", "),
+		(chunkSize - 1),
+	)
+	return result, explodedArgs, nil
+}
+
+// BuildDuplicateKeysPreparedQuery returns the query listing key values which appear on more than one row
+// (along with their row count), within range (rangeStartArgs, rangeEndArgs].
+// Either range boundary may be nil, for an open range. With an empty indexName, the query scans the whole table.
+// prefixLengths, if given, are the key prefix lengths of the columns (0: the whole column), as in `email(20)`:
+// such columns are grouped by their prefix.
+func BuildDuplicateKeysPreparedQuery(databaseName, tableName, indexName string, keyColumns *ColumnList, prefixLengths []int, rangeStartArgs, rangeEndArgs []interface{}, limit int64) (result string, explodedArgs []interface{}, err error) {
+	if keyColumns.Len() == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 columns in BuildDuplicateKeysPreparedQuery")
+	}
+	conditions, explodedArgs, err := buildDuplicateKeysConditions(keyColumns, rangeStartArgs, rangeEndArgs)
+	if err != nil {
+		return "", explodedArgs, err
+	}
+	keyColumnNames := duplicateNames(keyColumns.Names())
+	for i := range keyColumnNames {
+		keyColumnNames[i] = EscapeName(keyColumnNames[i])
+		if i < len(prefixLengths) && prefixLengths[i] > 0 {
+			// 与前缀索引一致: 字符串按字符, 二进制串按字节
+			keyColumnNames[i] = fmt.Sprintf("left(%s, %d)", keyColumnNames[i], prefixLengths[i])
+		}
+	}
+	indexHint := ""
+	if indexName != "" {
+		indexHint = fmt.Sprintf("force index (%s)", EscapeName(indexName))
+	}
+	result = fmt.Sprintf(`
+				select  /* gh-ost %s.%s duplicate keys */
+						%s, count(*) as duplicates
+					from
+						%s.%s %s
+					where %s
+					group by
+						%s
+					having count(*) > 1
+					limit %d
+    `, EscapeName(databaseName), EscapeName(tableName),
+		strings.Join(keyColumnNames, ", "),
+		EscapeName(databaseName), EscapeName(tableName), indexHint,
+		strings.Join(conditions, " and "),
+		strings.Join(keyColumnNames, ", "),
+		limit,
+	)
+	return result, explodedArgs, nil
+}
+
 func BuildUniqueKeyRangeEndPreparedQueryViaOffset(databaseName, tableName string, partition *PartitionInfo, uniqueKeyColumns *ColumnList, rangeStartArgs, rangeEndArgs []interface{}, chunkSize int64, includeRangeStartValues bool, hint string) (result string, explodedArgs []interface{}, err error) {
 	if uniqueKeyColumns.Len() == 0 {
 		return "", explodedArgs, fmt.Errorf("Got 0 columns in BuildUniqueKeyRangeEndPreparedQuery")
@@ -604,3 +916,103 @@ func BuildDMLUpdateQuery(databaseName, tableName string,
 	)
 	return result, sharedArgs, uniqueKeyArgs, nil
 }
+
+// BuildDMLMultiDeleteQuery deletes multiple rows, given by their full row images, in a single statement.
+// Rows are matched by unique key: `key in (...)`, or, with a multi column key, an `or` of equality comparisons;
+// the row constructor `(a, b) in (...)` is not optimized as range access prior to MySQL 5.7
+func BuildDMLMultiDeleteQuery(databaseName, tableName string,
+	tableColumns, uniqueKeyColumns *ColumnList,
+	rowsArgs [][]interface{}) (result string, uniqueKeyArgs []interface{}, err error) {
+
+	if len(rowsArgs) == 0 {
+		return result, uniqueKeyArgs, fmt.Errorf("No rows given in BuildDMLMultiDeleteQuery")
+	}
+	if uniqueKeyColumns.Len() == 0 {
+		return result, uniqueKeyArgs, fmt.Errorf("No unique key columns found in BuildDMLMultiDeleteQuery")
+	}
+	for _, args := range rowsArgs {
+		if len(args) != tableColumns.Len() {
+			return result, uniqueKeyArgs, fmt.Errorf("args count differs from table column count in BuildDMLMultiDeleteQuery")
+		}
+		for _, column := range uniqueKeyColumns.Columns() {
+			tableOrdinal := tableColumns.Ordinals[column.Name]
+			uniqueKeyArgs = append(uniqueKeyArgs, column.convertArg(args[tableOrdinal]))
+		}
+	}
+
+	databaseName = EscapeName(databaseName)
+	tableName = EscapeName(tableName)
+	var condition string
+	if uniqueKeyColumns.Len() == 1 {
+		condition = fmt.Sprintf("(%s in (%s))", EscapeName(uniqueKeyColumns.Names()[0]), strings.Join(buildPreparedValues(len(rowsArgs)), ", "))
+	} else {
+		equalsComparison, err := BuildEqualsPreparedComparison(uniqueKeyColumns.Names())
+		if err != nil {
+			return result, uniqueKeyArgs, err
+		}
+		comparisons := make([]string, len(rowsArgs))
+		for i := range comparisons {
+			comparisons[i] = equalsComparison
+		}
+		condition = fmt.Sprintf("(%s)", strings.Join(comparisons, " or "))
+	}
+
+	result = fmt.Sprintf(`
+			delete /* gh-ost %s.%s */
+				from
+					%s.%s
+				where
+					%s
+		`, databaseName, tableName,
+		databaseName, tableName,
+		condition,
+	)
+	return result, uniqueKeyArgs, nil
+}
+
+// BuildDMLMultiInsertQuery writes multiple rows, given by their full row images, in a single `replace` statement
+func BuildDMLMultiInsertQuery(databaseName, tableName string,
+	tableColumns, sharedColumns, mappedSharedColumns *ColumnList, rowsArgs [][]interface{}) (result string, sharedArgs []interface{}, err error) {
+
+	if len(rowsArgs) == 0 {
+		return result, sharedArgs, fmt.Errorf("No rows given in BuildDMLMultiInsertQuery")
+	}
+	if !sharedColumns.IsSubsetOf(tableColumns) {
+		return result, sharedArgs, fmt.Errorf("shared columns is not a subset of table columns in BuildDMLMultiInsertQuery")
+	}
+	if sharedColumns.Len() == 0 {
+		return result, sharedArgs, fmt.Errorf("No shared columns found in BuildDMLMultiInsertQuery")
+	}
+	preparedValues := fmt.Sprintf("(%s)", strings.Join(buildColumnsPreparedValues(mappedSharedColumns), ", "))
+	rowsValues := []string{}
+	for _, args := range rowsArgs {
+		if len(args) != tableColumns.Len() {
+			return result, sharedArgs, fmt.Errorf("args count differs from table column count in BuildDMLMultiInsertQuery")
+		}
+		for _, column := range sharedColumns.Columns() {
+			tableOrdinal := tableColumns.Ordinals[column.Name]
+			sharedArgs = append(sharedArgs, column.convertArg(args[tableOrdinal]))
+		}
+		rowsValues = append(rowsValues, preparedValues)
+	}
+
+	databaseName = EscapeName(databaseName)
+	tableName = EscapeName(tableName)
+	mappedSharedColumnNames := duplicateNames(mappedSharedColumns.Names())
+	for i := range mappedSharedColumnNames {
+		mappedSharedColumnNames[i] = EscapeName(mappedSharedColumnNames[i])
+	}
+
+	result = fmt.Sprintf(`
+			replace /* gh-ost %s.%s */ into
+				%s.%s
+					(%s)
+				values
+					%s
+		`, databaseName, tableName,
+		databaseName, tableName,
+		strings.Join(mappedSharedColumnNames, ", "),
+		strings.Join(rowsValues, ", "),
+	)
+	return result, sharedArgs, nil
+}
@@ -172,7 +172,7 @@ func TestBuildRangeInsertQuery(t *testing.T) {
 		rangeStartArgs := []interface{}{3}
 		rangeEndArgs := []interface{}{103}
 
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, "", sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
+		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, nil, "", sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
 		test.S(t).ExpectNil(err)
 		expected := `
 				insert /* gh-ost mydb.tbl */ ignore into mydb.ghost (id, name, position)
@@ -191,7 +191,7 @@ func TestBuildRangeInsertQuery(t *testing.T) {
 		rangeStartArgs := []interface{}{3, 17}
 		rangeEndArgs := []interface{}{103, 117}
 
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, "", sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
+		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, nil, "", sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
 		test.S(t).ExpectNil(err)
 		expected := `
 				insert /* gh-ost mydb.tbl */ ignore into mydb.ghost (id, name, position)
@@ -218,7 +218,7 @@ func TestBuildRangeInsertQueryRenameMap(t *testing.T) {
 		rangeStartArgs := []interface{}{3}
 		rangeEndArgs := []interface{}{103}
 
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, "", sharedColumns, mappedSharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
+		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, nil, "", sharedColumns, mappedSharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
 		test.S(t).ExpectNil(err)
 		expected := `
 				insert /* gh-ost mydb.tbl */ ignore into mydb.ghost (id, name, location)
@@ -237,7 +237,7 @@ func TestBuildRangeInsertQueryRenameMap(t *testing.T) {
 		rangeStartArgs := []interface{}{3, 17}
 		rangeEndArgs := []interface{}{103, 117}
 
-		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, "", sharedColumns, mappedSharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
+		query, explodedArgs, err := BuildRangeInsertQuery(databaseName, originalTableName, ghostTableName, nil, "", sharedColumns, mappedSharedColumns, uniqueKey, uniqueKeyColumns, rangeStartValues, rangeEndValues, rangeStartArgs, rangeEndArgs, true, false)
 		test.S(t).ExpectNil(err)
 		expected := `
 				insert /* gh-ost mydb.tbl */ ignore into mydb.ghost (id, name, location)
@@ -261,7 +261,7 @@ func TestBuildRangeInsertPreparedQuery(t *testing.T) {
 		rangeStartArgs := []interface{}{3, 17}
 		rangeEndArgs := []interface{}{103, 117}
 
-		query, explodedArgs, err := BuildRangeInsertPreparedQuery(databaseName, originalTableName, ghostTableName, "", sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartArgs, rangeEndArgs, true, true)
+		query, explodedArgs, err := BuildRangeInsertPreparedQuery(databaseName, originalTableName, ghostTableName, nil, "", sharedColumns, sharedColumns, uniqueKey, uniqueKeyColumns, rangeStartArgs, rangeEndArgs, true, true)
 		test.S(t).ExpectNil(err)
 		expected := `
 				insert /* gh-ost mydb.tbl */ ignore into mydb.ghost (id, name, position)
@@ -274,6 +274,69 @@ func TestBuildRangeInsertPreparedQuery(t *testing.T) {
 	}
 }
 
+func TestBuildRangeChecksumPreparedQuery(t *testing.T) {
+	databaseName := "mydb"
+	columns := []string{"id", "name"}
+	uniqueKeyColumns := NewColumnList([]string{"id"})
+	rangeStartArgs := []interface{}{3}
+	rangeEndArgs := []interface{}{103}
+	{
+		query, explodedArgs, err := BuildRangeChecksumPreparedQuery(databaseName, "tbl", "", columns, "PRIMARY", uniqueKeyColumns, rangeStartArgs, rangeEndArgs, true)
+		test.S(t).ExpectNil(err)
+		expected := `
+				select /* gh-ost mydb.tbl checksum */
+				  count(*), coalesce(bit_xor(crc32(concat_ws('#', id, name, isnull(id), isnull(name)))), 0)
+				from mydb.tbl force index (PRIMARY)
+				where (((id > ?) or ((id = ?))) and ((id < ?) or ((id = ?))))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 103, 103}))
+	}
+	{
+		query, explodedArgs, err := BuildRangeChecksumPreparedQuery(databaseName, "_tbl_gho", "name is not null", columns, "", uniqueKeyColumns, rangeStartArgs, rangeEndArgs, false)
+		test.S(t).ExpectNil(err)
+		expected := `
+				select /* gh-ost mydb._tbl_gho checksum */
+				  count(*), coalesce(bit_xor(crc32(concat_ws('#', id, name, isnull(id), isnull(name)))), 0)
+				from mydb._tbl_gho
+				where (((id > ?)) and ((id < ?) or ((id = ?))) and (name is not null))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 103, 103}))
+	}
+	{
+		query, _, err := BuildRangeChecksumPreparedQuery(databaseName, "tbl", "", []string{}, "PRIMARY", uniqueKeyColumns, rangeStartArgs, rangeEndArgs, true)
+		test.S(t).ExpectNil(err)
+		expected := `
+				select /* gh-ost mydb.tbl checksum */
+				  count(*), 0
+				from mydb.tbl force index (PRIMARY)
+				where (((id > ?) or ((id = ?))) and ((id < ?) or ((id = ?))))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	}
+	{
+		_, _, err := BuildRangeChecksumPreparedQuery(databaseName, "tbl", "", columns, "PRIMARY", NewColumnList([]string{}), rangeStartArgs, rangeEndArgs, true)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildRangeSelectPreparedQuery(t *testing.T) {
+	uniqueKeyColumns := NewColumnList([]string{"id"})
+	filterCondition := BuildComplementFilterCondition("created_at > '2017-01-01'")
+	test.S(t).ExpectEquals(filterCondition, "(created_at > '2017-01-01') is not true")
+
+	query, explodedArgs, err := BuildRangeSelectPreparedQuery("mydb", "tbl", &PartitionInfo{PartitionName: "p1"}, filterCondition, []string{"id", "created_at"}, "PRIMARY", uniqueKeyColumns, []interface{}{3}, []interface{}{103}, false, true)
+	test.S(t).ExpectNil(err)
+	expected := `
+		select /* gh-ost mydb.tbl */ id, created_at
+		  from mydb.tbl partition(p1) force index (PRIMARY)
+		  where (((id > ?)) and ((id < ?) or ((id = ?))) and ((created_at > '2017-01-01') is not true)) lock in share mode
+	`
+	test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 103, 103}))
+}
+
 func TestBuildUniqueKeyRangeEndPreparedQuery(t *testing.T) {
 	databaseName := "mydb"
 	originalTableName := "tbl"
@@ -283,7 +346,7 @@ func TestBuildUniqueKeyRangeEndPreparedQuery(t *testing.T) {
 		rangeStartArgs := []interface{}{3, 17}
 		rangeEndArgs := []interface{}{103, 117}
 
-		query, explodedArgs, err := BuildUniqueKeyRangeEndPreparedQueryViaTemptable(databaseName, originalTableName, uniqueKeyColumns, rangeStartArgs, rangeEndArgs, chunkSize, false, "test")
+		query, explodedArgs, err := BuildUniqueKeyRangeEndPreparedQueryViaTemptable(databaseName, originalTableName, nil, uniqueKeyColumns, rangeStartArgs, rangeEndArgs, chunkSize, false, "test")
 		test.S(t).ExpectNil(err)
 		expected := `
 				select /* gh-ost mydb.tbl test */ name, position
@@ -306,12 +369,137 @@ func TestBuildUniqueKeyRangeEndPreparedQuery(t *testing.T) {
 	}
 }
 
+func TestBuildRangeConditionsCountPreparedQuery(t *testing.T) {
+	uniqueKeyColumns := NewColumnList([]string{"id"})
+	{
+		query, explodedArgs, err := BuildRangeConditionsCountPreparedQuery("mydb", "tbl", "", []string{"i is null", "char_length(s) > 32"}, "PRIMARY", uniqueKeyColumns, []interface{}{3}, []interface{}{103}, true)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl conditions count */
+					coalesce(sum(i is null), 0), coalesce(sum(char_length(s) > 32), 0)
+				from mydb.tbl force index (PRIMARY)
+				where (((id > ?) or ((id = ?))) and ((id < ?) or ((id = ?))))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 3, 103, 103}))
+	}
+	{
+		query, _, err := BuildRangeConditionsCountPreparedQuery("mydb", "tbl", "ts > '2020-01-01'", []string{"i is null"}, "PRIMARY", uniqueKeyColumns, []interface{}{3}, []interface{}{103}, false)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl conditions count */
+					coalesce(sum(i is null), 0)
+				from mydb.tbl force index (PRIMARY)
+				where (((id > ?)) and ((id < ?) or ((id = ?))) and (ts > '2020-01-01'))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	}
+	{
+		_, _, err := BuildRangeConditionsCountPreparedQuery("mydb", "tbl", "", []string{}, "PRIMARY", uniqueKeyColumns, []interface{}{3}, []interface{}{103}, false)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildRangeConditionSamplePreparedQuery(t *testing.T) {
+	uniqueKeyColumns := NewColumnList([]string{"id"})
+	query, explodedArgs, err := BuildRangeConditionSamplePreparedQuery("mydb", "tbl", "", "i is null", []string{"id", "i"}, "PRIMARY", uniqueKeyColumns, []interface{}{3}, []interface{}{103}, false, 5)
+	test.S(t).ExpectNil(err)
+	expected := `
+		select /* gh-ost mydb.tbl condition sample */
+				id, i
+			from mydb.tbl force index (PRIMARY)
+			where (((id > ?)) and ((id < ?) or ((id = ?)))) and (i is null)
+			limit 5
+	`
+	test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 103, 103}))
+}
+
+func TestBuildDuplicateKeysRangeEndPreparedQuery(t *testing.T) {
+	keyColumns := NewColumnList([]string{"email", "site"})
+	{
+		query, explodedArgs, err := BuildDuplicateKeysRangeEndPreparedQuery("mydb", "tbl", "idx_email", keyColumns, nil, 1000)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl duplicate keys range end */ email, site
+				from mydb.tbl force index (idx_email)
+				where email is not null and site is not null
+				order by email, site
+				limit 1
+				offset 999
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectEquals(len(explodedArgs), 0)
+	}
+	{
+		query, explodedArgs, err := BuildDuplicateKeysRangeEndPreparedQuery("mydb", "tbl", "idx_email", keyColumns, []interface{}{"a@b", 3}, 1000)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl duplicate keys range end */ email, site
+				from mydb.tbl force index (idx_email)
+				where email is not null and site is not null and ((email > ?) or (((email = ?)) AND (site > ?)))
+				order by email, site
+				limit 1
+				offset 999
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{"a@b", "a@b", 3}))
+	}
+}
+
+func TestBuildDuplicateKeysPreparedQuery(t *testing.T) {
+	keyColumns := NewColumnList([]string{"email"})
+	{
+		query, explodedArgs, err := BuildDuplicateKeysPreparedQuery("mydb", "tbl", "", keyColumns, nil, nil, nil, 10)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl duplicate keys */ email, count(*) as duplicates
+				from mydb.tbl
+				where email is not null
+				group by email
+				having count(*) > 1
+				limit 10
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectEquals(len(explodedArgs), 0)
+	}
+	{
+		query, explodedArgs, err := BuildDuplicateKeysPreparedQuery("mydb", "tbl", "idx_email", keyColumns, nil, []interface{}{"a"}, []interface{}{"m"}, 10)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl duplicate keys */ email, count(*) as duplicates
+				from mydb.tbl force index (idx_email)
+				where email is not null and ((email > ?)) and ((email < ?) or ((email = ?)))
+				group by email
+				having count(*) > 1
+				limit 10
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{"a", "m", "m"}))
+	}
+	{
+		keyColumns := NewColumnList([]string{"email", "site_id"})
+		query, explodedArgs, err := BuildDuplicateKeysPreparedQuery("mydb", "tbl", "", keyColumns, []int{20, 0}, nil, nil, 10)
+		test.S(t).ExpectNil(err)
+		expected := `
+			select /* gh-ost mydb.tbl duplicate keys */ left(email, 20), site_id, count(*) as duplicates
+				from mydb.tbl
+				where email is not null and site_id is not null
+				group by left(email, 20), site_id
+				having count(*) > 1
+				limit 10
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectEquals(len(explodedArgs), 0)
+	}
+}
+
 func TestBuildUniqueKeyMinValuesPreparedQuery(t *testing.T) {
 	databaseName := "mydb"
 	originalTableName := "tbl"
 	uniqueKeyColumns := NewColumnList([]string{"name", "position"})
 	{
-		query, err := BuildUniqueKeyMinValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
+		query, err := BuildUniqueKeyMinValuesPreparedQuery(databaseName, originalTableName, nil, uniqueKeyColumns)
 		test.S(t).ExpectNil(err)
 		expected := `
 			select /* gh-ost mydb.tbl */ name, position
@@ -324,7 +512,7 @@ func TestBuildUniqueKeyMinValuesPreparedQuery(t *testing.T) {
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
 	}
 	{
-		query, err := BuildUniqueKeyMaxValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
+		query, err := BuildUniqueKeyMaxValuesPreparedQuery(databaseName, originalTableName, nil, uniqueKeyColumns)
 		test.S(t).ExpectNil(err)
 		expected := `
 			select /* gh-ost mydb.tbl */ name, position
@@ -677,3 +865,87 @@ func TestBuildDMLUpdateQuerySignedUnsigned(t *testing.T) {
 		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{uint8(253)}))
 	}
 }
+
+func TestBuildDMLMultiDeleteQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
+	rowsArgs := [][]interface{}{
+		{3, "testname", "first", 17, 23},
+		{4, "othername", "second", 18, 24},
+	}
+	{
+		uniqueKeyColumns := NewColumnList([]string{"position"})
+
+		query, uniqueKeyArgs, err := BuildDMLMultiDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, rowsArgs)
+		test.S(t).ExpectNil(err)
+		expected := `
+			delete /* gh-ost mydb.tbl */
+				from
+					mydb.tbl
+				where
+					(position in (?, ?))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{17, 18}))
+	}
+	{
+		uniqueKeyColumns := NewColumnList([]string{"name", "position"})
+
+		query, uniqueKeyArgs, err := BuildDMLMultiDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, rowsArgs)
+		test.S(t).ExpectNil(err)
+		expected := `
+			delete /* gh-ost mydb.tbl */
+				from
+					mydb.tbl
+				where
+					(((name = ?) and (position = ?)) or ((name = ?) and (position = ?)))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{"testname", 17, "othername", 18}))
+	}
+	{
+		uniqueKeyColumns := NewColumnList([]string{"position"})
+		_, _, err := BuildDMLMultiDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, [][]interface{}{})
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		uniqueKeyColumns := NewColumnList([]string{"position"})
+		_, _, err := BuildDMLMultiDeleteQuery(databaseName, tableName, tableColumns, uniqueKeyColumns, [][]interface{}{{3, "testname", "first", 17}})
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLMultiInsertQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := NewColumnList([]string{"id", "name", "rank", "position", "age"})
+	rowsArgs := [][]interface{}{
+		{3, "testname", "first", 17, 23},
+		{4, "othername", "second", 18, 24},
+	}
+	{
+		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
+		query, sharedArgs, err := BuildDMLMultiInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, rowsArgs)
+		test.S(t).ExpectNil(err)
+		expected := `
+			replace /* gh-ost mydb.tbl */
+				into mydb.tbl
+					(id, name, position, age)
+				values
+					(?, ?, ?, ?), (?, ?, ?, ?)
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname", 17, 23, 4, "othername", 18, 24}))
+	}
+	{
+		sharedColumns := NewColumnList([]string{"position", "name", "surprise", "id"})
+		_, _, err := BuildDMLMultiInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, rowsArgs)
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		sharedColumns := NewColumnList([]string{"id", "name", "position", "age"})
+		_, _, err := BuildDMLMultiInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, [][]interface{}{})
+		test.S(t).ExpectNotNil(err)
+	}
+}
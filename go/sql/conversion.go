@@ -0,0 +1,263 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package sql
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ColumnDefinition is the definition of a column, as found in INFORMATION_SCHEMA.COLUMNS
+type ColumnDefinition struct {
+	Name                   string
+	DataType               string // e.g. varchar
+	ColumnType             string // e.g. varchar(32)
+	Nullable               bool
+	CharacterMaximumLength int64
+	CharacterOctetLength   int64
+	NumericPrecision       int64
+	NumericScale           int64
+	DatetimePrecision      int64
+	CharacterSet           string
+}
+
+func (this *ColumnDefinition) String() string {
+	result := this.ColumnType
+	if this.CharacterSet != "" {
+		result = fmt.Sprintf("%s character set %s", result, this.CharacterSet)
+	}
+	if !this.Nullable {
+		result = fmt.Sprintf("%s not null", result)
+	}
+	return result
+}
+
+func (this *ColumnDefinition) isUnsigned() bool {
+	return strings.Contains(strings.ToLower(this.ColumnType), "unsigned")
+}
+
+// ConversionRisk is a way values of a column may fail, or be truncated, when copied onto the ghost table
+type ConversionRisk struct {
+	Column      string // name of the column in the original table
+	Description string
+	// Condition matches the rows of the original table which are affected. Empty when gh-ost is unable to tell.
+	Condition string
+}
+
+func (this *ConversionRisk) String() string {
+	return fmt.Sprintf("%s: %s", this.Column, this.Description)
+}
+
+var integerColumnBits = map[string]uint{
+	"tinyint":   8,
+	"smallint":  16,
+	"mediumint": 24,
+	"int":       32,
+	"integer":   32,
+	"bigint":    64,
+}
+
+// integerColumnRange returns the range of values of an integer column
+func integerColumnRange(column *ColumnDefinition) (min, max *big.Int) {
+	bits := integerColumnBits[column.DataType]
+	if column.isUnsigned() {
+		max = new(big.Int).Lsh(big.NewInt(1), bits)
+		return big.NewInt(0), max.Sub(max, big.NewInt(1))
+	}
+	max = new(big.Int).Lsh(big.NewInt(1), bits-1)
+	min = new(big.Int).Neg(max)
+	return min, max.Sub(max, big.NewInt(1))
+}
+
+func isStringDataType(dataType string) bool {
+	switch dataType {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext",
+		"binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return true
+	}
+	return false
+}
+
+// isFixedLengthStringDataType tells whether the maximum length of the type is given in its definition, e.g. varchar(32)
+func isFixedLengthStringDataType(dataType string) bool {
+	switch dataType {
+	case "char", "varchar", "binary", "varbinary":
+		return true
+	}
+	return false
+}
+
+// maxStringCharacters is the maximum number of characters a string column holds. text/blob columns are limited
+// in bytes, and may hold as many single byte characters.
+func maxStringCharacters(column *ColumnDefinition) int64 {
+	if isFixedLengthStringDataType(column.DataType) {
+		return column.CharacterMaximumLength
+	}
+	return column.CharacterOctetLength
+}
+
+func isNumericDataType(dataType string) bool {
+	_, isInteger := integerColumnBits[dataType]
+	return isInteger || dataType == "decimal" || dataType == "float" || dataType == "double"
+}
+
+func isTemporalDataType(dataType string) bool {
+	switch dataType {
+	case "datetime", "timestamp", "time", "date", "year":
+		return true
+	}
+	return false
+}
+
+// parseEnumValues parses the values of an enum('a','b') or set('a','b') column type
+func parseEnumValues(columnType string) (values []string) {
+	start := strings.Index(columnType, "(")
+	end := strings.LastIndex(columnType, ")")
+	if start < 0 || end < start {
+		return values
+	}
+	list := columnType[start+1 : end]
+	for i := 0; i < len(list); i++ {
+		if list[i] != '\'' {
+			continue
+		}
+		value := []byte{}
+		for i++; i < len(list); i++ {
+			if list[i] == '\'' {
+				if i+1 < len(list) && list[i+1] == '\'' {
+					i++
+				} else {
+					break
+				}
+			}
+			value = append(value, list[i])
+		}
+		values = append(values, string(value))
+	}
+	return values
+}
+
+func quoteStringLiteral(value string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(strings.Replace(value, `\`, `\\`, -1), "'", "''", -1))
+}
+
+// GetConversionRisks compares the definitions of a column in the original and in the ghost table, and returns
+// the ways values may fail or be truncated when copied: narrowing integer/decimal/string types, charset
+// conversion, dropped enum values, reduced fractional seconds, and NOT NULL on a nullable column.
+func GetConversionRisks(original, ghost *ColumnDefinition) (risks []*ConversionRisk) {
+	column := EscapeName(original.Name)
+	addRisk := func(description string, condition string) {
+		risks = append(risks, &ConversionRisk{Column: original.Name, Description: description, Condition: condition})
+	}
+	change := fmt.Sprintf("%s -> %s", original, ghost)
+
+	if original.Nullable && !ghost.Nullable {
+		addRisk(fmt.Sprintf("NULL values in %s", change), fmt.Sprintf("%s is null", column))
+	}
+
+	switch {
+	case original.DataType == ghost.DataType && original.ColumnType == ghost.ColumnType && original.CharacterSet == ghost.CharacterSet:
+		// same type
+	case integerColumnBits[ghost.DataType] > 0 && isNumericDataType(original.DataType):
+		ghostMin, ghostMax := integerColumnRange(ghost)
+		conditions := []string{}
+		if integerColumnBits[original.DataType] > 0 {
+			originalMin, originalMax := integerColumnRange(original)
+			if originalMin.Cmp(ghostMin) < 0 {
+				conditions = append(conditions, fmt.Sprintf("%s < %s", column, ghostMin))
+			}
+			if originalMax.Cmp(ghostMax) > 0 {
+				conditions = append(conditions, fmt.Sprintf("%s > %s", column, ghostMax))
+			}
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s < %s", column, ghostMin), fmt.Sprintf("%s > %s", column, ghostMax), fmt.Sprintf("%s <> round(%s)", column, column))
+		}
+		if len(conditions) > 0 {
+			addRisk(fmt.Sprintf("out of range values in %s", change), strings.Join(conditions, " or "))
+		}
+	case ghost.DataType == "decimal" && isNumericDataType(original.DataType):
+		conditions := []string{}
+		ghostIntegerDigits := ghost.NumericPrecision - ghost.NumericScale
+		originalIntegerDigits := original.NumericPrecision - original.NumericScale
+		if original.DataType == "float" || original.DataType == "double" || originalIntegerDigits > ghostIntegerDigits {
+			conditions = append(conditions, fmt.Sprintf("abs(%s) >= 1%s", column, strings.Repeat("0", int(ghostIntegerDigits))))
+		}
+		if original.DataType == "float" || original.DataType == "double" || original.NumericScale > ghost.NumericScale {
+			conditions = append(conditions, fmt.Sprintf("%s <> round(%s, %d)", column, column, ghost.NumericScale))
+		}
+		if len(conditions) > 0 {
+			addRisk(fmt.Sprintf("out of range or truncated values in %s", change), strings.Join(conditions, " or "))
+		}
+	case isStringDataType(ghost.DataType):
+		value := column
+		if original.CharacterSet != "" && ghost.CharacterSet != "" && !strings.EqualFold(original.CharacterSet, ghost.CharacterSet) {
+			value = fmt.Sprintf("convert(%s using %s)", column, ghost.CharacterSet)
+			if !strings.EqualFold(ghost.CharacterSet, "utf8mb4") {
+				// 转换到 utf8mb4 不会丢失字符
+				addRisk(fmt.Sprintf("characters not representable in %s", change),
+					fmt.Sprintf("cast(convert(%s using %s) as binary) <> cast(%s as binary)", value, original.CharacterSet, column))
+			}
+		}
+		var narrowing bool
+		switch {
+		case !isStringDataType(original.DataType):
+			narrowing = true
+		case isFixedLengthStringDataType(ghost.DataType):
+			narrowing = ghost.CharacterMaximumLength < maxStringCharacters(original)
+		default:
+			// text/blob 的长度限制是字节数, 字符集转换可能改变字节数
+			narrowing = value != column || ghost.CharacterOctetLength < original.CharacterOctetLength
+		}
+		if narrowing {
+			switch ghost.DataType {
+			case "char", "varchar":
+				addRisk(fmt.Sprintf("values too long for %s", change), fmt.Sprintf("char_length(%s) > %d", value, ghost.CharacterMaximumLength))
+			case "binary", "varbinary":
+				addRisk(fmt.Sprintf("values too long for %s", change), fmt.Sprintf("length(%s) > %d", value, ghost.CharacterMaximumLength))
+			default:
+				addRisk(fmt.Sprintf("values too long for %s", change), fmt.Sprintf("length(%s) > %d", value, ghost.CharacterOctetLength))
+			}
+		}
+	case (ghost.DataType == "enum" || ghost.DataType == "set") && original.DataType == ghost.DataType:
+		ghostValues := map[string]bool{}
+		for _, value := range parseEnumValues(ghost.ColumnType) {
+			ghostValues[value] = true
+		}
+		conditions := []string{}
+		for _, value := range parseEnumValues(original.ColumnType) {
+			if ghostValues[value] {
+				continue
+			}
+			if ghost.DataType == "enum" {
+				conditions = append(conditions, fmt.Sprintf("%s = %s", column, quoteStringLiteral(value)))
+			} else {
+				conditions = append(conditions, fmt.Sprintf("find_in_set(%s, %s) > 0", quoteStringLiteral(value), column))
+			}
+		}
+		if len(conditions) > 0 {
+			addRisk(fmt.Sprintf("values removed in %s", change), strings.Join(conditions, " or "))
+		}
+	case isTemporalDataType(ghost.DataType) && isTemporalDataType(original.DataType):
+		conditions := []string{}
+		if ghost.DataType == "date" && original.DataType != "date" {
+			conditions = append(conditions, fmt.Sprintf("time(%s) <> '00:00:00'", column))
+		} else if ghost.DatetimePrecision < original.DatetimePrecision {
+			conditions = append(conditions, fmt.Sprintf("microsecond(%s) %% 1%s <> 0", column, strings.Repeat("0", int(6-ghost.DatetimePrecision))))
+		}
+		if ghost.DataType == "timestamp" && original.DataType == "datetime" {
+			conditions = append(conditions, fmt.Sprintf("%s < '1970-01-01 00:00:01' or %s > '2038-01-19 03:14:07'", column, column))
+		}
+		if original.DataType != ghost.DataType && (ghost.DataType == "time" || ghost.DataType == "year" || original.DataType == "time" || original.DataType == "year") {
+			addRisk(fmt.Sprintf("unable to verify conversion %s", change), "")
+		} else if len(conditions) > 0 {
+			addRisk(fmt.Sprintf("out of range or truncated values in %s", change), strings.Join(conditions, " or "))
+		}
+	case original.DataType != ghost.DataType:
+		addRisk(fmt.Sprintf("unable to verify conversion %s", change), "")
+	}
+	return risks
+}
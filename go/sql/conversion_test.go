@@ -0,0 +1,154 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package sql
+
+import (
+	"reflect"
+	"testing"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestParseEnumValues(t *testing.T) {
+	test.S(t).ExpectTrue(reflect.DeepEqual(parseEnumValues("enum('a','b','c')"), []string{"a", "b", "c"}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(parseEnumValues("set('x,y','it''s')"), []string{"x,y", "it's"}))
+	test.S(t).ExpectEquals(len(parseEnumValues("int(11)")), 0)
+}
+
+func TestGetConversionRisks(t *testing.T) {
+	tests := []struct {
+		original   ColumnDefinition
+		ghost      ColumnDefinition
+		conditions []string
+	}{
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)", Nullable: true},
+			ghost:      ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)", Nullable: true},
+			conditions: nil,
+		},
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)", Nullable: true},
+			ghost:      ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)", Nullable: false},
+			conditions: []string{"i is null"},
+		},
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)"},
+			ghost:      ColumnDefinition{Name: "i", DataType: "smallint", ColumnType: "smallint(6)"},
+			conditions: []string{"i < -32768 or i > 32767"},
+		},
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)"},
+			ghost:      ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(10) unsigned"},
+			conditions: []string{"i < 0"},
+		},
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "bigint", ColumnType: "bigint(20) unsigned"},
+			ghost:      ColumnDefinition{Name: "i", DataType: "bigint", ColumnType: "bigint(20)"},
+			conditions: []string{"i > 9223372036854775807"},
+		},
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "smallint", ColumnType: "smallint(6)"},
+			ghost:      ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)"},
+			conditions: nil,
+		},
+		{
+			original:   ColumnDefinition{Name: "d", DataType: "decimal", ColumnType: "decimal(10,4)", NumericPrecision: 10, NumericScale: 4},
+			ghost:      ColumnDefinition{Name: "d", DataType: "decimal", ColumnType: "decimal(6,2)", NumericPrecision: 6, NumericScale: 2},
+			conditions: []string{"abs(d) >= 10000 or d <> round(d, 2)"},
+		},
+		{
+			original:   ColumnDefinition{Name: "d", DataType: "decimal", ColumnType: "decimal(10,2)", NumericPrecision: 10, NumericScale: 2},
+			ghost:      ColumnDefinition{Name: "d", DataType: "int", ColumnType: "int(11)"},
+			conditions: []string{"d < -2147483648 or d > 2147483647 or d <> round(d)"},
+		},
+		{
+			original:   ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(64)", CharacterMaximumLength: 64, CharacterOctetLength: 256, CharacterSet: "utf8mb4"},
+			ghost:      ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 128, CharacterSet: "utf8mb4"},
+			conditions: []string{"char_length(s) > 32"},
+		},
+		{
+			original:   ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 128, CharacterSet: "utf8mb4"},
+			ghost:      ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(64)", CharacterMaximumLength: 64, CharacterOctetLength: 256, CharacterSet: "utf8mb4"},
+			conditions: nil,
+		},
+		{
+			original:   ColumnDefinition{Name: "s", DataType: "text", ColumnType: "text", CharacterMaximumLength: 16383, CharacterOctetLength: 65535, CharacterSet: "utf8mb4"},
+			ghost:      ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(20000)", CharacterMaximumLength: 20000, CharacterOctetLength: 80000, CharacterSet: "utf8mb4"},
+			conditions: []string{"char_length(s) > 20000"},
+		},
+		{
+			original:   ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 32, CharacterSet: "latin1"},
+			ghost:      ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 128, CharacterSet: "utf8mb4"},
+			conditions: nil,
+		},
+		{
+			original: ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 128, CharacterSet: "utf8mb4"},
+			ghost:    ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 32, CharacterSet: "latin1"},
+			conditions: []string{
+				"cast(convert(convert(s using latin1) using utf8mb4) as binary) <> cast(s as binary)",
+			},
+		},
+		{
+			original:   ColumnDefinition{Name: "s", DataType: "text", ColumnType: "text", CharacterMaximumLength: 65535, CharacterOctetLength: 65535, CharacterSet: "latin1"},
+			ghost:      ColumnDefinition{Name: "s", DataType: "text", ColumnType: "text", CharacterMaximumLength: 16383, CharacterOctetLength: 65535, CharacterSet: "utf8mb4"},
+			conditions: []string{"length(convert(s using utf8mb4)) > 65535"},
+		},
+		{
+			original:   ColumnDefinition{Name: "b", DataType: "varbinary", ColumnType: "varbinary(16)", CharacterMaximumLength: 16, CharacterOctetLength: 16},
+			ghost:      ColumnDefinition{Name: "b", DataType: "binary", ColumnType: "binary(8)", CharacterMaximumLength: 8, CharacterOctetLength: 8},
+			conditions: []string{"length(b) > 8"},
+		},
+		{
+			original:   ColumnDefinition{Name: "i", DataType: "int", ColumnType: "int(11)"},
+			ghost:      ColumnDefinition{Name: "i", DataType: "varchar", ColumnType: "varchar(4)", CharacterMaximumLength: 4, CharacterOctetLength: 16, CharacterSet: "utf8mb4"},
+			conditions: []string{"char_length(i) > 4"},
+		},
+		{
+			original:   ColumnDefinition{Name: "e", DataType: "enum", ColumnType: "enum('red','green','blue')"},
+			ghost:      ColumnDefinition{Name: "e", DataType: "enum", ColumnType: "enum('red','blue')"},
+			conditions: []string{"e = 'green'"},
+		},
+		{
+			original:   ColumnDefinition{Name: "e", DataType: "set", ColumnType: "set('a','b','c')"},
+			ghost:      ColumnDefinition{Name: "e", DataType: "set", ColumnType: "set('a','b','c','d')"},
+			conditions: nil,
+		},
+		{
+			original:   ColumnDefinition{Name: "ts", DataType: "datetime", ColumnType: "datetime(6)", DatetimePrecision: 6},
+			ghost:      ColumnDefinition{Name: "ts", DataType: "datetime", ColumnType: "datetime(3)", DatetimePrecision: 3},
+			conditions: []string{"microsecond(ts) % 1000 <> 0"},
+		},
+		{
+			original:   ColumnDefinition{Name: "ts", DataType: "datetime", ColumnType: "datetime"},
+			ghost:      ColumnDefinition{Name: "ts", DataType: "date", ColumnType: "date"},
+			conditions: []string{"time(ts) <> '00:00:00'"},
+		},
+		{
+			original:   ColumnDefinition{Name: "ts", DataType: "datetime", ColumnType: "datetime"},
+			ghost:      ColumnDefinition{Name: "ts", DataType: "timestamp", ColumnType: "timestamp"},
+			conditions: []string{"ts < '1970-01-01 00:00:01' or ts > '2038-01-19 03:14:07'"},
+		},
+		{
+			original:   ColumnDefinition{Name: "s", DataType: "varchar", ColumnType: "varchar(32)", CharacterMaximumLength: 32, CharacterOctetLength: 128, CharacterSet: "utf8mb4"},
+			ghost:      ColumnDefinition{Name: "s", DataType: "int", ColumnType: "int(11)"},
+			conditions: []string{""},
+		},
+	}
+	for _, tt := range tests {
+		original, ghost := tt.original, tt.ghost
+		risks := GetConversionRisks(&original, &ghost)
+		conditions := []string{}
+		for _, risk := range risks {
+			test.S(t).ExpectEquals(risk.Column, original.Name)
+			conditions = append(conditions, normalizeQuery(risk.Condition))
+		}
+		if len(tt.conditions) == 0 {
+			test.S(t).ExpectEquals(len(risks), 0)
+			continue
+		}
+		test.S(t).ExpectTrue(reflect.DeepEqual(conditions, tt.conditions))
+	}
+}
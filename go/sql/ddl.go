@@ -0,0 +1,220 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TableName is a table referenced by a statement. An empty Name stands for all tables of the schema (DROP DATABASE)
+type TableName struct {
+	Schema string
+	Name   string
+}
+
+func (this TableName) String() string {
+	if this.Name == "" {
+		return EscapeName(this.Schema)
+	}
+	return fmt.Sprintf("%s.%s", EscapeName(this.Schema), EscapeName(this.Name))
+}
+
+// DDLStatement is a statement (as found in a binlog query event) which changes the structure, or the content
+// as a whole, of tables: ALTER, TRUNCATE, RENAME, DROP, CREATE TABLE and CREATE/DROP INDEX
+type DDLStatement struct {
+	Type   string // e.g. "ALTER TABLE"
+	Tables []TableName
+}
+
+func (this *DDLStatement) String() string {
+	tables := []string{}
+	for _, table := range this.Tables {
+		tables = append(tables, table.String())
+	}
+	return fmt.Sprintf("%s %s", this.Type, strings.Join(tables, ", "))
+}
+
+// Affects tells whether the statement references any of given tables of given schema
+func (this *DDLStatement) Affects(schema string, tableNames ...string) bool {
+	for _, table := range this.Tables {
+		if !strings.EqualFold(table.Schema, schema) {
+			continue
+		}
+		if table.Name == "" {
+			return true
+		}
+		for _, tableName := range tableNames {
+			// 表名大小写是否敏感取决于 lower_case_table_names, 这里宁可误报
+			if strings.EqualFold(table.Name, tableName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseDDLStatement parses a statement executed on defaultSchema, and returns the tables it changes. It returns
+// nil for statements which are not DDL on tables (BEGIN, COMMIT, DML in statement based replication, GRANT...),
+// or which cannot be parsed.
+func ParseDDLStatement(statement string, defaultSchema string) *DDLStatement {
+	tokens, err := tokenizeAlter(statement)
+	if err != nil {
+		return nil
+	}
+	parser := &ddlParser{alterParser: alterParser{statement: statement, tokens: tokens}, defaultSchema: defaultSchema}
+	ddlStatement, err := parser.parse()
+	if err != nil {
+		return nil
+	}
+	return ddlStatement
+}
+
+type ddlParser struct {
+	alterParser
+	defaultSchema string
+}
+
+// parseTableName parses `name` or `schema`.`name`
+func (this *ddlParser) parseTableName() (table TableName, err error) {
+	name, err := this.parseIdentifier()
+	if err != nil {
+		return table, err
+	}
+	if this.acceptPunctuation(".") {
+		table.Schema = name
+		table.Name, err = this.parseIdentifier()
+		return table, err
+	}
+	return TableName{Schema: this.defaultSchema, Name: name}, nil
+}
+
+// parseTableNames parses a comma separated list of table names
+func (this *ddlParser) parseTableNames() (tables []TableName, err error) {
+	for {
+		table, err := this.parseTableName()
+		if err != nil {
+			return tables, err
+		}
+		tables = append(tables, table)
+		if !this.acceptPunctuation(",") {
+			return tables, nil
+		}
+	}
+}
+
+func (this *ddlParser) parse() (ddlStatement *DDLStatement, err error) {
+	switch {
+	case this.acceptKeyword("alter"):
+		this.acceptKeyword("online")
+		this.acceptKeyword("ignore")
+		if !this.acceptKeyword("table") {
+			return nil, fmt.Errorf("Not an ALTER TABLE statement")
+		}
+		ddlStatement = &DDLStatement{Type: "ALTER TABLE"}
+		table, err := this.parseTableName()
+		if err != nil {
+			return nil, err
+		}
+		ddlStatement.Tables = append(ddlStatement.Tables, table)
+		// ALTER TABLE ... RENAME [TO|AS] new_name
+		for !this.done() {
+			if this.acceptKeyword("rename") && !this.peek().isKeyword("column", "index", "key") {
+				if !this.acceptKeyword("to") {
+					this.acceptKeyword("as")
+				}
+				if newTable, err := this.parseTableName(); err == nil {
+					ddlStatement.Tables = append(ddlStatement.Tables, newTable)
+				}
+				continue
+			}
+			this.next()
+		}
+		return ddlStatement, nil
+	case this.acceptKeyword("truncate"):
+		this.acceptKeyword("table")
+		table, err := this.parseTableName()
+		if err != nil {
+			return nil, err
+		}
+		return &DDLStatement{Type: "TRUNCATE TABLE", Tables: []TableName{table}}, nil
+	case this.acceptKeyword("rename", "table"):
+		ddlStatement = &DDLStatement{Type: "RENAME TABLE"}
+		for {
+			from, err := this.parseTableName()
+			if err != nil {
+				return nil, err
+			}
+			if err := this.expectKeyword("to"); err != nil {
+				return nil, err
+			}
+			to, err := this.parseTableName()
+			if err != nil {
+				return nil, err
+			}
+			ddlStatement.Tables = append(ddlStatement.Tables, from, to)
+			if !this.acceptPunctuation(",") {
+				return ddlStatement, nil
+			}
+		}
+	case this.acceptKeyword("drop"):
+		this.acceptKeyword("temporary")
+		switch {
+		case this.acceptKeyword("table"):
+			this.acceptKeyword("if", "exists")
+			tables, err := this.parseTableNames()
+			if err != nil {
+				return nil, err
+			}
+			return &DDLStatement{Type: "DROP TABLE", Tables: tables}, nil
+		case this.acceptKeyword("database"), this.acceptKeyword("schema"):
+			this.acceptKeyword("if", "exists")
+			schema, err := this.parseIdentifier()
+			if err != nil {
+				return nil, err
+			}
+			return &DDLStatement{Type: "DROP DATABASE", Tables: []TableName{{Schema: schema}}}, nil
+		case this.acceptKeyword("index"):
+			return this.parseIndexOnTable("DROP INDEX")
+		}
+	case this.acceptKeyword("create"):
+		this.acceptKeyword("temporary")
+		if this.acceptKeyword("table") {
+			this.acceptKeyword("if", "not", "exists")
+			table, err := this.parseTableName()
+			if err != nil {
+				return nil, err
+			}
+			return &DDLStatement{Type: "CREATE TABLE", Tables: []TableName{table}}, nil
+		}
+		this.acceptKeyword("online")
+		this.acceptKeyword("unique")
+		this.acceptKeyword("fulltext")
+		this.acceptKeyword("spatial")
+		if this.acceptKeyword("index") {
+			return this.parseIndexOnTable("CREATE INDEX")
+		}
+	}
+	return nil, fmt.Errorf("Not a DDL statement on tables")
+}
+
+// parseIndexOnTable parses the `index_name ... ON table` part of CREATE/DROP INDEX
+func (this *ddlParser) parseIndexOnTable(statementType string) (ddlStatement *DDLStatement, err error) {
+	if _, err := this.parseIdentifier(); err != nil {
+		return nil, err
+	}
+	for !this.done() && !this.peek().isKeyword("on") {
+		this.next()
+	}
+	if err := this.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	table, err := this.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	return &DDLStatement{Type: statementType, Tables: []TableName{table}}, nil
+}
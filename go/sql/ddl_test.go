@@ -0,0 +1,61 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package sql
+
+import (
+	"testing"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestParseDDLStatement(t *testing.T) {
+	tests := []struct {
+		statement string
+		expected  string // empty when not a DDL statement on tables
+	}{
+		{"BEGIN", ""},
+		{"COMMIT", ""},
+		{"insert into tbl values (1)", ""},
+		{"GRANT select on mydb.* to 'u'@'%'", ""},
+		{"alter database mydb character set utf8mb4", ""},
+		{"alter table tbl add column i int", "ALTER TABLE `mydb`.`tbl`"},
+		{"ALTER ONLINE IGNORE TABLE `other`.`tbl` ADD KEY (i)", "ALTER TABLE `other`.`tbl`"},
+		{"alter table tbl rename to tbl_new", "ALTER TABLE `mydb`.`tbl`, `mydb`.`tbl_new`"},
+		{"alter table tbl rename column a to b, rename index i to j", "ALTER TABLE `mydb`.`tbl`"},
+		{"alter /* comment */ table tbl comment 'rename to x'", "ALTER TABLE `mydb`.`tbl`"},
+		{"truncate tbl", "TRUNCATE TABLE `mydb`.`tbl`"},
+		{"TRUNCATE TABLE mydb.tbl", "TRUNCATE TABLE `mydb`.`tbl`"},
+		{"rename table tbl to _tbl_old, _tbl_new to tbl", "RENAME TABLE `mydb`.`tbl`, `mydb`.`_tbl_old`, `mydb`.`_tbl_new`, `mydb`.`tbl`"},
+		{"DROP TABLE IF EXISTS `tbl`,`other`.`t2` /* generated by server */", "DROP TABLE `mydb`.`tbl`, `other`.`t2`"},
+		{"DROP /*!40005 TEMPORARY */ TABLE IF EXISTS `tbl`", "DROP TABLE `mydb`.`tbl`"},
+		{"drop database if exists mydb", "DROP DATABASE `mydb`"},
+		{"drop index i_idx on tbl", "DROP INDEX `mydb`.`tbl`"},
+		{"create table if not exists tbl (id int)", "CREATE TABLE `mydb`.`tbl`"},
+		{"create unique index i_idx using btree on other.tbl (i)", "CREATE INDEX `other`.`tbl`"},
+		{"create view v as select 1", ""},
+		{"alter table tbl add column s varchar(10) default 'unterminated", ""},
+	}
+	for _, tt := range tests {
+		ddlStatement := ParseDDLStatement(tt.statement, "mydb")
+		if tt.expected == "" {
+			test.S(t).ExpectTrue(ddlStatement == nil)
+			continue
+		}
+		test.S(t).ExpectTrue(ddlStatement != nil)
+		test.S(t).ExpectEquals(ddlStatement.String(), tt.expected)
+	}
+}
+
+func TestDDLStatementAffects(t *testing.T) {
+	ddlStatement := ParseDDLStatement("rename table tbl to _tbl_del", "mydb")
+	test.S(t).ExpectTrue(ddlStatement.Affects("mydb", "TBL"))
+	test.S(t).ExpectTrue(ddlStatement.Affects("mydb", "other", "_tbl_del"))
+	test.S(t).ExpectFalse(ddlStatement.Affects("mydb", "_tbl_gho", "_tbl_ghc"))
+	test.S(t).ExpectFalse(ddlStatement.Affects("otherdb", "tbl"))
+
+	ddlStatement = ParseDDLStatement("drop database mydb", "")
+	test.S(t).ExpectTrue(ddlStatement.Affects("mydb", "tbl"))
+}
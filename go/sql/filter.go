@@ -0,0 +1,965 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package sql
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// RowFilter evaluates a --origin-filter condition over a row of the original table, as read
+// from the binary log. It supports the subset of MySQL expressions typically used for data cleanup:
+//
+//   - column references, number/string literals, NULL, TRUE, FALSE
+//   - comparison: =, !=, <>, <, <=, >, >=, <=>
+//   - IS [NOT] NULL, [NOT] IN (...), [NOT] BETWEEN ... AND ..., [NOT] LIKE
+//   - AND, OR, NOT (and &&, ||, !), parentheses
+//
+// Functions, arithmetic and sub-queries are not supported; NewRowFilter() rejects them.
+// Evaluation follows MySQL's three valued logic: a row matches only when the condition is TRUE (not NULL).
+type RowFilter struct {
+	condition string
+	columns   *ColumnList
+	root      filterNode
+	location  *time.Location
+}
+
+// NewRowFilter parses given condition and validates it against the table columns
+func NewRowFilter(condition string, columns *ColumnList) (*RowFilter, error) {
+	filter := &RowFilter{
+		condition: condition,
+		columns:   columns,
+		location:  time.UTC,
+	}
+	tokens, err := tokenizeFilter(condition)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens, filter: filter}
+	if filter.root, err = parser.parseExpression(); err != nil {
+		return nil, err
+	}
+	if !parser.done() {
+		return nil, fmt.Errorf("Unexpected %s in filter: %s", parser.peek(), condition)
+	}
+	return filter, nil
+}
+
+// SetLocation sets the time zone in which TIMESTAMP values are compared with literals; this
+// should be the time zone of the session copying rows, so that binlog events and row-copy agree
+func (this *RowFilter) SetLocation(location *time.Location) {
+	this.location = location
+}
+
+// Matches evaluates the filter over given row values (ordered as the table columns)
+func (this *RowFilter) Matches(values *ColumnValues) (bool, error) {
+	abstractValues := values.AbstractValues()
+	if len(abstractValues) != this.columns.Len() {
+		return false, fmt.Errorf("Filter expects %d values, got %d", this.columns.Len(), len(abstractValues))
+	}
+	truth, err := this.root.evaluate(abstractValues)
+	if err != nil {
+		return false, err
+	}
+	return truth == filterTrue, nil
+}
+
+func (this *RowFilter) String() string {
+	return this.condition
+}
+
+// filter tokens
+
+type filterTokenType int
+
+const (
+	identifierFilterToken filterTokenType = iota
+	numberFilterToken
+	stringFilterToken
+	operatorFilterToken
+)
+
+type filterToken struct {
+	tokenType filterTokenType
+	text      string
+	quoted    bool // `quoted` identifier 不会被当作关键字
+}
+
+func (this filterToken) String() string {
+	return fmt.Sprintf("'%s'", this.text)
+}
+
+func (this filterToken) isKeyword(keyword string) bool {
+	return this.tokenType == identifierFilterToken && !this.quoted && strings.EqualFold(this.text, keyword)
+}
+
+func (this filterToken) isOperator(operators ...string) bool {
+	if this.tokenType != operatorFilterToken {
+		return false
+	}
+	for _, operator := range operators {
+		if this.text == operator {
+			return true
+		}
+	}
+	return false
+}
+
+var filterOperators = []string{"<=>", "<>", "!=", "<=", ">=", "&&", "||", "=", "<", ">", "!", "(", ")", ",", ".", "-"}
+
+func tokenizeFilter(condition string) (tokens []filterToken, err error) {
+	runes := []rune(condition)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			// 引号内: 支持 '' 以及 \' 转义
+			var text []rune
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == '\\' && c != '`' && j+1 < len(runes) {
+					j++
+					if runes[j] == '%' || runes[j] == '_' {
+						// 与MySQL一致: \% 和 \_ 保留反斜杠, 留给LIKE处理
+						text = append(text, '\\')
+					}
+					text = append(text, unescapeFilterRune(runes[j]))
+					continue
+				}
+				if runes[j] == c {
+					if j+1 < len(runes) && runes[j+1] == c {
+						j++
+						text = append(text, c)
+						continue
+					}
+					break
+				}
+				text = append(text, runes[j])
+			}
+			if j >= len(runes) {
+				return tokens, fmt.Errorf("Unterminated quote in filter: %s", condition)
+			}
+			tokenType := stringFilterToken
+			if c == '`' {
+				tokenType = identifierFilterToken
+			}
+			tokens = append(tokens, filterToken{tokenType: tokenType, text: string(text), quoted: true})
+			i = j + 1
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' ||
+				runes[j] == 'e' || runes[j] == 'E' ||
+				((runes[j] == '-' || runes[j] == '+') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenType: numberFilterToken, text: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(c) || c == '_' || c == '$':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '$') {
+				j++
+			}
+			tokens = append(tokens, filterToken{tokenType: identifierFilterToken, text: string(runes[i:j])})
+			i = j
+		default:
+			found := false
+			for _, operator := range filterOperators {
+				if strings.HasPrefix(string(runes[i:]), operator) {
+					tokens = append(tokens, filterToken{tokenType: operatorFilterToken, text: operator})
+					i += len([]rune(operator))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return tokens, fmt.Errorf("Unsupported character '%c' in filter: %s", c, condition)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func unescapeFilterRune(c rune) rune {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	}
+	return c
+}
+
+// filter parser
+
+var filterReservedWords = map[string]bool{
+	"and": true, "or": true, "not": true, "is": true, "null": true, "in": true, "between": true,
+	"like": true, "true": true, "false": true, "escape": true, "xor": true, "select": true,
+}
+
+type filterParser struct {
+	tokens   []filterToken
+	position int
+	filter   *RowFilter
+}
+
+func (this *filterParser) done() bool {
+	return this.position >= len(this.tokens)
+}
+
+func (this *filterParser) peek() filterToken {
+	if this.done() {
+		return filterToken{tokenType: operatorFilterToken, text: "<end>"}
+	}
+	return this.tokens[this.position]
+}
+
+func (this *filterParser) next() filterToken {
+	token := this.peek()
+	this.position++
+	return token
+}
+
+func (this *filterParser) expectOperator(operator string) error {
+	if token := this.next(); !token.isOperator(operator) {
+		return fmt.Errorf("Expected '%s', got %s in filter: %s", operator, token, this.filter.condition)
+	}
+	return nil
+}
+
+func (this *filterParser) parseExpression() (filterNode, error) {
+	left, err := this.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for this.peek().isKeyword("or") || this.peek().isOperator("||") {
+		this.next()
+		right, err := this.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orFilterNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (this *filterParser) parseAnd() (filterNode, error) {
+	left, err := this.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for this.peek().isKeyword("and") || this.peek().isOperator("&&") {
+		this.next()
+		right, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andFilterNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (this *filterParser) parseNot() (filterNode, error) {
+	if this.peek().isKeyword("not") || this.peek().isOperator("!") {
+		this.next()
+		node, err := this.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notFilterNode{node: node}, nil
+	}
+	return this.parsePredicate()
+}
+
+func (this *filterParser) parsePredicate() (filterNode, error) {
+	if this.peek().isOperator("(") {
+		this.next()
+		node, err := this.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := this.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	left, err := this.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	token := this.peek()
+	switch {
+	case token.isOperator("=", "!=", "<>", "<", "<=", ">", ">=", "<=>"):
+		this.next()
+		right, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &comparisonFilterNode{left: left, right: right, operator: token.text}, nil
+	case token.isKeyword("is"):
+		this.next()
+		negate := false
+		if this.peek().isKeyword("not") {
+			this.next()
+			negate = true
+		}
+		if !this.next().isKeyword("null") {
+			return nil, fmt.Errorf("Only IS [NOT] NULL is supported in filter: %s", this.filter.condition)
+		}
+		return &isNullFilterNode{operand: left, negate: negate}, nil
+	}
+
+	negate := false
+	if token.isKeyword("not") {
+		this.next()
+		negate = true
+		token = this.peek()
+	}
+	var node filterNode
+	switch {
+	case token.isKeyword("in"):
+		this.next()
+		if err := this.expectOperator("("); err != nil {
+			return nil, err
+		}
+		inNode := &inFilterNode{operand: left}
+		for {
+			value, err := this.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			inNode.values = append(inNode.values, value)
+			if !this.peek().isOperator(",") {
+				break
+			}
+			this.next()
+		}
+		if err := this.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		node = inNode
+	case token.isKeyword("between"):
+		this.next()
+		low, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !this.next().isKeyword("and") {
+			return nil, fmt.Errorf("Expected AND in BETWEEN in filter: %s", this.filter.condition)
+		}
+		high, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node = &andFilterNode{
+			left:  &comparisonFilterNode{left: left, right: low, operator: ">="},
+			right: &comparisonFilterNode{left: left, right: high, operator: "<="},
+		}
+	case token.isKeyword("like"):
+		this.next()
+		pattern, err := this.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node = &likeFilterNode{operand: left, pattern: pattern}
+	default:
+		if negate {
+			return nil, fmt.Errorf("Unexpected NOT in filter: %s", this.filter.condition)
+		}
+		// 单独的operand, 例如: `where is_valid`
+		return &truthFilterNode{operand: left}, nil
+	}
+	if negate {
+		return &notFilterNode{node: node}, nil
+	}
+	return node, nil
+}
+
+func (this *filterParser) parseOperand() (filterOperand, error) {
+	token := this.next()
+	switch token.tokenType {
+	case numberFilterToken:
+		return newNumberFilterOperand(token.text, this.filter.condition)
+	case stringFilterToken:
+		return &literalFilterOperand{literal: textFilterValue(token.text)}, nil
+	case operatorFilterToken:
+		if token.isOperator("-") && this.peek().tokenType == numberFilterToken {
+			return newNumberFilterOperand("-"+this.next().text, this.filter.condition)
+		}
+		return nil, fmt.Errorf("Unexpected %s in filter: %s", token, this.filter.condition)
+	}
+
+	// identifier
+	switch {
+	case token.isKeyword("null"):
+		return &literalFilterOperand{literal: nullFilterValue}, nil
+	case token.isKeyword("true"):
+		return newNumberFilterOperand("1", this.filter.condition)
+	case token.isKeyword("false"):
+		return newNumberFilterOperand("0", this.filter.condition)
+	}
+	if this.peek().isOperator("(") {
+		return nil, fmt.Errorf("Function %s() is not supported in filter: %s", token.text, this.filter.condition)
+	}
+	columnName := token.text
+	if this.peek().isOperator(".") {
+		// table.column
+		this.next()
+		if qualified := this.next(); qualified.tokenType == identifierFilterToken {
+			columnName = qualified.text
+		} else {
+			return nil, fmt.Errorf("Unexpected %s in filter: %s", qualified, this.filter.condition)
+		}
+	} else if filterReservedWords[strings.ToLower(columnName)] && !token.quoted {
+		return nil, fmt.Errorf("Unexpected %s in filter: %s", token, this.filter.condition)
+	}
+	return newColumnFilterOperand(this.filter, columnName)
+}
+
+// filter values
+
+type filterValueKind int
+
+const (
+	nullFilterValueKind filterValueKind = iota
+	numberFilterValueKind
+	textFilterValueKind
+	timeFilterValueKind
+)
+
+type filterValue struct {
+	kind      filterValueKind
+	number    *big.Rat
+	text      string
+	time      time.Time
+	collation *filterCollation // text of a column; nil for literals
+}
+
+var nullFilterValue = filterValue{kind: nullFilterValueKind}
+
+func textFilterValue(text string) filterValue {
+	return filterValue{kind: textFilterValueKind, text: text}
+}
+
+func numberFilterValue(text string) (filterValue, bool) {
+	number, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nullFilterValue, false
+	}
+	return filterValue{kind: numberFilterValueKind, number: number, text: text}, true
+}
+
+var filterNumberPrefixRegexp = regexp.MustCompile(`^\s*[-+]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][-+]?[0-9]+)?`)
+
+// toNumber converts the value as MySQL does when comparing a string with a number:
+// the leading numeric part is used, or 0 when there is none
+func (this filterValue) toNumber() *big.Rat {
+	switch this.kind {
+	case numberFilterValueKind:
+		return this.number
+	case timeFilterValueKind:
+		number, _ := new(big.Rat).SetString(this.time.Format("20060102150405"))
+		return number
+	}
+	if prefix := filterNumberPrefixRegexp.FindString(this.text); prefix != "" {
+		if number, ok := new(big.Rat).SetString(strings.TrimSpace(prefix)); ok {
+			return number
+		}
+	}
+	return new(big.Rat)
+}
+
+var filterTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"20060102150405",
+	"20060102",
+}
+
+// parseFilterTime parses a date/datetime literal or value, as a "wall clock" UTC time
+func parseFilterTime(text string) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "0000-00-00") {
+		return time.Time{}, true
+	}
+	for _, layout := range filterTimeLayouts {
+		if t, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (this filterValue) formatText() string {
+	if this.kind == timeFilterValueKind {
+		return this.time.Format("2006-01-02 15:04:05.999999")
+	}
+	return this.text
+}
+
+// compareFilterValues compares two non-NULL values the way MySQL would
+func compareFilterValues(left, right filterValue) (int, error) {
+	switch {
+	case left.kind == timeFilterValueKind || right.kind == timeFilterValueKind:
+		leftTime, leftOk := left.time, left.kind == timeFilterValueKind
+		rightTime, rightOk := right.time, right.kind == timeFilterValueKind
+		if !leftOk {
+			leftTime, leftOk = parseFilterTime(left.text)
+		}
+		if !rightOk {
+			rightTime, rightOk = parseFilterTime(right.text)
+		}
+		if leftOk && rightOk {
+			switch {
+			case leftTime.Before(rightTime):
+				return -1, nil
+			case leftTime.After(rightTime):
+				return 1, nil
+			}
+			return 0, nil
+		}
+		if left.kind == numberFilterValueKind || right.kind == numberFilterValueKind {
+			return left.toNumber().Cmp(right.toNumber()), nil
+		}
+		return comparisonCollation(left, right).compare(left.formatText(), right.formatText())
+	case left.kind == numberFilterValueKind || right.kind == numberFilterValueKind:
+		return left.toNumber().Cmp(right.toNumber()), nil
+	}
+	return comparisonCollation(left, right).compare(left.text, right.text)
+}
+
+// filter collations
+
+// filterCollation compares strings as a MySQL collation does. Only collations whose rules are reproduced
+// exactly are supported: binary, the `_bin` collations of charsets ordered by code point, and the simple
+// case insensitive collations (e.g. utf8mb4_general_ci), the latter over ASCII text only.
+type filterCollation struct {
+	name            string
+	caseInsensitive bool // 只支持ASCII字母: 非ASCII字符(例如 é = e)的规则无法完全复现
+	padSpace        bool // 比较时忽略末尾的空格
+}
+
+// defaultFilterCollation applies to literals and non-text columns, as the connection collation would
+var defaultFilterCollation = &filterCollation{name: "utf8mb4_general_ci", caseInsensitive: true, padSpace: true}
+
+// filterCollationCharsets are the charsets whose `_bin` collation orders strings by code point
+// (MySQL's latin1 is cp1252, whose bytes 0x80-0x9f are not in code point order)
+var filterCollationCharsets = map[string]bool{"ascii": true, "utf8": true, "utf8mb3": true, "utf8mb4": true}
+
+// newFilterCollation returns the collation of given name, or an error when it is not supported.
+// An empty name (e.g. a column of non-text type) stands for the default collation.
+func newFilterCollation(name string) (*filterCollation, error) {
+	switch name {
+	case "":
+		return defaultFilterCollation, nil
+	case "binary":
+		return &filterCollation{name: name}, nil
+	case "latin1_swedish_ci":
+		return &filterCollation{name: name, caseInsensitive: true, padSpace: true}, nil
+	}
+	tokens := strings.SplitN(name, "_", 2)
+	if len(tokens) == 2 && filterCollationCharsets[tokens[0]] {
+		switch {
+		case tokens[1] == "bin":
+			return &filterCollation{name: name, padSpace: true}, nil
+		case tokens[1] == "0900_bin" && tokens[0] == "utf8mb4":
+			return &filterCollation{name: name}, nil
+		case tokens[1] == "general_ci":
+			return &filterCollation{name: name, caseInsensitive: true, padSpace: true}, nil
+		}
+	}
+	return nil, fmt.Errorf("collation %s is not supported", name)
+}
+
+// comparisonCollation returns the collation two values are compared by: a column's collation
+// takes precedence over the default collation of literals
+func comparisonCollation(left, right filterValue) *filterCollation {
+	if left.collation != nil {
+		return left.collation
+	}
+	if right.collation != nil {
+		return right.collation
+	}
+	return defaultFilterCollation
+}
+
+// fold returns the text as this collation weighs it; case insensitive collations cannot evaluate non-ASCII text
+func (this *filterCollation) fold(text string) (string, error) {
+	if !this.caseInsensitive {
+		return text, nil
+	}
+	for _, c := range text {
+		if c > unicode.MaxASCII {
+			return text, fmt.Errorf("Cannot compare non-ASCII text %q by collation %s", text, this.name)
+		}
+	}
+	// 与 general_ci 一致: 转换为大写, 因此 'a' < '_'
+	return strings.ToUpper(text), nil
+}
+
+func (this *filterCollation) compare(left, right string) (int, error) {
+	if this.padSpace {
+		left = strings.TrimRight(left, " ")
+		right = strings.TrimRight(right, " ")
+	}
+	left, err := this.fold(left)
+	if err != nil {
+		return 0, err
+	}
+	right, err = this.fold(right)
+	if err != nil {
+		return 0, err
+	}
+	return strings.Compare(left, right), nil
+}
+
+// filter operands: columns & literals
+
+type filterOperand interface {
+	value(values []interface{}) (filterValue, error)
+}
+
+type literalFilterOperand struct {
+	literal filterValue
+}
+
+func (this *literalFilterOperand) value(values []interface{}) (filterValue, error) {
+	return this.literal, nil
+}
+
+type columnFilterOperand struct {
+	filter    *RowFilter
+	column    *Column
+	ordinal   int
+	collation *filterCollation
+}
+
+func newNumberFilterOperand(text string, condition string) (filterOperand, error) {
+	value, ok := numberFilterValue(text)
+	if !ok {
+		return nil, fmt.Errorf("Invalid number %s in filter: %s", text, condition)
+	}
+	return &literalFilterOperand{literal: value}, nil
+}
+
+func newColumnFilterOperand(filter *RowFilter, columnName string) (filterOperand, error) {
+	var column *Column
+	for i := range filter.columns.columns {
+		if strings.EqualFold(filter.columns.columns[i].Name, columnName) {
+			column = &filter.columns.columns[i]
+		}
+	}
+	if column == nil {
+		return nil, fmt.Errorf("Unknown column %s in filter: %s", columnName, filter.condition)
+	}
+	switch column.Type {
+	case EnumColumnType, SetColumnType, JSONColumnType:
+		// binlog 中 enum/set 是序号/bitmask, 与字面值无法直接比较
+		return nil, fmt.Errorf("Column %s in filter is of ENUM, SET or JSON type, which cannot be evaluated on binlog events: %s", column.Name, filter.condition)
+	}
+	collation, err := newFilterCollation(column.Collation)
+	if err != nil {
+		return nil, fmt.Errorf("Column %s in filter has %s, which cannot be evaluated on binlog events: %s", column.Name, err.Error(), filter.condition)
+	}
+	return &columnFilterOperand{filter: filter, column: column, ordinal: filter.columns.Ordinals[column.Name], collation: collation}, nil
+}
+
+func (this *columnFilterOperand) value(values []interface{}) (filterValue, error) {
+	arg := values[this.ordinal]
+	if arg == nil {
+		return nullFilterValue, nil
+	}
+	arg = this.column.convertArg(arg)
+
+	switch this.column.Type {
+	case TimestampColumnType, DateTimeColumnType, DateColumnType:
+		switch v := arg.(type) {
+		case time.Time:
+			// TIMESTAMP 是绝对时间, 转换成copy rows的session所在时区的"墙上时间"
+			wall := v.In(this.filter.location)
+			return filterValue{kind: timeFilterValueKind, time: time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)}, nil
+		case string:
+			if t, ok := parseFilterTime(v); ok {
+				return filterValue{kind: timeFilterValueKind, time: t}, nil
+			}
+			return this.textValue(v), nil
+		case []byte:
+			if t, ok := parseFilterTime(string(v)); ok {
+				return filterValue{kind: timeFilterValueKind, time: t}, nil
+			}
+			return this.textValue(string(v)), nil
+		}
+	}
+
+	switch v := arg.(type) {
+	case string:
+		if this.column.IsUnsigned {
+			// convertArg() 对 unsigned bigint 返回的是字符串
+			if value, ok := numberFilterValue(v); ok {
+				return value, nil
+			}
+		}
+		return this.textValue(v), nil
+	case []byte:
+		return this.textValue(string(v)), nil
+	case float32:
+		return filterValue{kind: numberFilterValueKind, number: new(big.Rat).SetFloat64(float64(v)), text: strconv.FormatFloat(float64(v), 'g', -1, 32)}, nil
+	case float64:
+		return filterValue{kind: numberFilterValueKind, number: new(big.Rat).SetFloat64(v), text: strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case time.Time:
+		return filterValue{kind: timeFilterValueKind, time: v}, nil
+	}
+	if value, ok := numberFilterValue(fmt.Sprintf("%d", arg)); ok {
+		return value, nil
+	}
+	return nullFilterValue, fmt.Errorf("Cannot evaluate value %+v of column %s in filter: %s", arg, this.column.Name, this.filter.condition)
+}
+
+// textValue returns given text, compared by the column's collation
+func (this *columnFilterOperand) textValue(text string) filterValue {
+	return filterValue{kind: textFilterValueKind, text: text, collation: this.collation}
+}
+
+// filter nodes: three valued logic
+
+type filterTruth int
+
+const (
+	filterFalse filterTruth = iota
+	filterTrue
+	filterUnknown
+)
+
+func toFilterTruth(value bool) filterTruth {
+	if value {
+		return filterTrue
+	}
+	return filterFalse
+}
+
+type filterNode interface {
+	evaluate(values []interface{}) (filterTruth, error)
+}
+
+type andFilterNode struct {
+	left, right filterNode
+}
+
+func (this *andFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	left, err := this.left.evaluate(values)
+	if err != nil || left == filterFalse {
+		return left, err
+	}
+	right, err := this.right.evaluate(values)
+	if err != nil || right == filterFalse {
+		return right, err
+	}
+	if left == filterUnknown || right == filterUnknown {
+		return filterUnknown, nil
+	}
+	return filterTrue, nil
+}
+
+type orFilterNode struct {
+	left, right filterNode
+}
+
+func (this *orFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	left, err := this.left.evaluate(values)
+	if err != nil || left == filterTrue {
+		return left, err
+	}
+	right, err := this.right.evaluate(values)
+	if err != nil || right == filterTrue {
+		return right, err
+	}
+	if left == filterUnknown || right == filterUnknown {
+		return filterUnknown, nil
+	}
+	return filterFalse, nil
+}
+
+type notFilterNode struct {
+	node filterNode
+}
+
+func (this *notFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	truth, err := this.node.evaluate(values)
+	switch truth {
+	case filterTrue:
+		return filterFalse, err
+	case filterFalse:
+		return filterTrue, err
+	}
+	return truth, err
+}
+
+type comparisonFilterNode struct {
+	left, right filterOperand
+	operator    string
+}
+
+func (this *comparisonFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	left, err := this.left.value(values)
+	if err != nil {
+		return filterUnknown, err
+	}
+	right, err := this.right.value(values)
+	if err != nil {
+		return filterUnknown, err
+	}
+	if left.kind == nullFilterValueKind || right.kind == nullFilterValueKind {
+		if this.operator == "<=>" {
+			return toFilterTruth(left.kind == right.kind), nil
+		}
+		return filterUnknown, nil
+	}
+	comparison, err := compareFilterValues(left, right)
+	if err != nil {
+		return filterUnknown, err
+	}
+	switch this.operator {
+	case "=", "<=>":
+		return toFilterTruth(comparison == 0), nil
+	case "!=", "<>":
+		return toFilterTruth(comparison != 0), nil
+	case "<":
+		return toFilterTruth(comparison < 0), nil
+	case "<=":
+		return toFilterTruth(comparison <= 0), nil
+	case ">":
+		return toFilterTruth(comparison > 0), nil
+	case ">=":
+		return toFilterTruth(comparison >= 0), nil
+	}
+	return filterUnknown, fmt.Errorf("Unknown operator %s", this.operator)
+}
+
+type isNullFilterNode struct {
+	operand filterOperand
+	negate  bool
+}
+
+func (this *isNullFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	value, err := this.operand.value(values)
+	if err != nil {
+		return filterUnknown, err
+	}
+	return toFilterTruth((value.kind == nullFilterValueKind) != this.negate), nil
+}
+
+type inFilterNode struct {
+	operand filterOperand
+	values  []filterOperand
+}
+
+func (this *inFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	value, err := this.operand.value(values)
+	if err != nil || value.kind == nullFilterValueKind {
+		return filterUnknown, err
+	}
+	result := filterFalse
+	for _, operand := range this.values {
+		candidate, err := operand.value(values)
+		if err != nil {
+			return filterUnknown, err
+		}
+		if candidate.kind == nullFilterValueKind {
+			result = filterUnknown
+			continue
+		}
+		comparison, err := compareFilterValues(value, candidate)
+		if err != nil {
+			return filterUnknown, err
+		}
+		if comparison == 0 {
+			return filterTrue, nil
+		}
+	}
+	return result, nil
+}
+
+type likeFilterNode struct {
+	operand filterOperand
+	pattern filterOperand
+}
+
+// likeToRegexp translates a LIKE pattern (% and _ wildcards, \ escape) into a regular expression
+func likeToRegexp(pattern string, collation *filterCollation) (*regexp.Regexp, error) {
+	var expression []string
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '%':
+			expression = append(expression, ".*")
+		case '_':
+			expression = append(expression, ".")
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			expression = append(expression, regexp.QuoteMeta(string(runes[i])))
+		default:
+			expression = append(expression, regexp.QuoteMeta(string(c)))
+		}
+	}
+	flags := `(?s)`
+	if collation.caseInsensitive {
+		flags = `(?is)`
+	}
+	return regexp.Compile(flags + `^` + strings.Join(expression, "") + `$`)
+}
+
+func (this *likeFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	value, err := this.operand.value(values)
+	if err != nil || value.kind == nullFilterValueKind {
+		return filterUnknown, err
+	}
+	pattern, err := this.pattern.value(values)
+	if err != nil || pattern.kind == nullFilterValueKind {
+		return filterUnknown, err
+	}
+	// LIKE 不忽略末尾空格, 但大小写规则与collation一致
+	collation := comparisonCollation(value, pattern)
+	for _, text := range []string{value.formatText(), pattern.formatText()} {
+		if _, err := collation.fold(text); err != nil {
+			return filterUnknown, err
+		}
+	}
+	likeRegexp, err := likeToRegexp(pattern.formatText(), collation)
+	if err != nil {
+		return filterUnknown, err
+	}
+	return toFilterTruth(likeRegexp.MatchString(value.formatText())), nil
+}
+
+type truthFilterNode struct {
+	operand filterOperand
+}
+
+func (this *truthFilterNode) evaluate(values []interface{}) (filterTruth, error) {
+	value, err := this.operand.value(values)
+	if err != nil || value.kind == nullFilterValueKind {
+		return filterUnknown, err
+	}
+	if value.kind == timeFilterValueKind {
+		return filterTrue, nil
+	}
+	return toFilterTruth(value.toNumber().Sign() != 0), nil
+}
@@ -0,0 +1,187 @@
+/*
+   Copyright 2016 GitHub Inc.
+	 See https://github.com/github/gh-ost/blob/master/LICENSE
+*/
+
+package sql
+
+import (
+	"testing"
+	"time"
+
+	test "github.com/outbrain/golib/tests"
+)
+
+func newFilterTestColumns() *ColumnList {
+	columns := NewColumnList([]string{"id", "name", "created_at", "updated_at", "birthday", "status", "score", "flags"})
+	columns.SetUnsigned("id")
+	columns.SetColumnType("created_at", DateTimeColumnType)
+	columns.SetColumnType("updated_at", TimestampColumnType)
+	columns.SetColumnType("birthday", DateColumnType)
+	columns.SetColumnType("status", EnumColumnType)
+	columns.SetColumnType("score", FloatColumnType)
+	return columns
+}
+
+func TestRowFilterMatches(t *testing.T) {
+	columns := newFilterTestColumns()
+	row := ToColumnValues([]interface{}{
+		int64(-1), // unsigned: 18446744073709551615
+		"Alice ",
+		"2017-06-01 12:30:00",
+		time.Date(2017, 6, 1, 4, 0, 0, 0, time.UTC),
+		"1990-02-03",
+		int64(2),
+		float64(3.5),
+		nil,
+	})
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{"id > 100", true},
+		{"id = 18446744073709551615", true},
+		{"`id` >= -5 and id <= 18446744073709551615", true},
+		{"name = 'alice'", true},
+		{"name = \"ALICE\"", true},
+		{"name <> 'bob' AND NOT name = 'carol'", true},
+		{"name like 'al%'", true},
+		{"name not like '_lice%'", false},
+		{"name like 'a\\_%'", false},
+		{"tbl.name in ('bob', 'alice')", true},
+		{"name not in ('bob', 'alice')", false},
+		{"name in ('bob', null)", false},
+		{"created_at > '2017-06-01'", true},
+		{"created_at >= '2017-06-01 12:30:00' and created_at < '2017-06-01 12:30:01'", true},
+		{"created_at between '2017-01-01' and '2017-12-31'", true},
+		{"created_at not between '2017-01-01' and '2017-12-31'", false},
+		{"updated_at = '2017-06-01 04:00:00'", true},
+		{"birthday = '1990-02-03 00:00:00'", true},
+		{"birthday < 19900204", true},
+		{"score > 3 && score < 4", true},
+		{"score = 3.5", true},
+		{"flags is null", true},
+		{"flags is not null", false},
+		{"flags = 1", false},
+		{"not flags = 1", false},
+		{"flags <=> null", true},
+		{"flags = 1 or id > 0", true},
+		{"(flags = 1 or id < 0) and name = 'alice'", false},
+		{"!(id < 0)", true},
+		{"score", true},
+		{"flags", false},
+		{"true", true},
+		{"1 = 0 || false", false},
+	}
+	for _, tt := range tests {
+		filter, err := NewRowFilter(tt.condition, columns)
+		test.S(t).ExpectNil(err)
+		if err != nil {
+			continue
+		}
+		matches, err := filter.Matches(row)
+		test.S(t).ExpectNil(err)
+		if matches != tt.expected {
+			t.Errorf("%s: expected %t, got %t", tt.condition, tt.expected, matches)
+		}
+	}
+}
+
+func TestRowFilterTimestampLocation(t *testing.T) {
+	columns := newFilterTestColumns()
+	row := ToColumnValues([]interface{}{int64(1), "a", nil, time.Date(2017, 6, 1, 4, 0, 0, 0, time.UTC), nil, nil, nil, nil})
+
+	filter, err := NewRowFilter("updated_at >= '2017-06-01 12:00:00'", columns)
+	test.S(t).ExpectNil(err)
+	matches, err := filter.Matches(row)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(matches, false)
+
+	filter.SetLocation(time.FixedZone("", 8*3600))
+	matches, err = filter.Matches(row)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(matches, true)
+}
+
+func TestRowFilterCollation(t *testing.T) {
+	columns := NewColumnList([]string{"ci", "bin", "nopad", "raw"})
+	columns.GetColumn("ci").Collation = "utf8mb4_general_ci"
+	columns.GetColumn("bin").Collation = "utf8mb4_bin"
+	columns.GetColumn("nopad").Collation = "utf8mb4_0900_bin"
+	columns.GetColumn("raw").Collation = "binary"
+	row := ToColumnValues([]interface{}{"Alice ", "Alice ", "Alice ", []byte("Alice ")})
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{"ci = 'alice'", true},
+		{"ci < 'alice_'", true},
+		{"ci like 'al%'", true},
+		{"bin = 'Alice'", true},
+		{"bin = 'alice'", false},
+		{"bin > 'Z'", false},
+		{"bin like 'al%'", false},
+		{"bin like 'Al%'", true},
+		{"nopad = 'Alice'", false},
+		{"nopad = 'Alice '", true},
+		{"raw = 'Alice'", false},
+		{"raw = 'Alice '", true},
+		{"raw in ('alice ', 'Alice ')", true},
+		{"bin = ci", true},
+	}
+	for _, tt := range tests {
+		filter, err := NewRowFilter(tt.condition, columns)
+		test.S(t).ExpectNil(err)
+		if err != nil {
+			continue
+		}
+		matches, err := filter.Matches(row)
+		test.S(t).ExpectNil(err)
+		if matches != tt.expected {
+			t.Errorf("%s: expected %t, got %t", tt.condition, tt.expected, matches)
+		}
+	}
+
+	// 非ASCII字符无法按照 _ci collation 比较
+	filter, err := NewRowFilter("ci = 'alicé'", columns)
+	test.S(t).ExpectNil(err)
+	_, err = filter.Matches(row)
+	test.S(t).ExpectNotNil(err)
+	filter, err = NewRowFilter("bin = 'alicé'", columns)
+	test.S(t).ExpectNil(err)
+	matches, err := filter.Matches(row)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectFalse(matches)
+
+	for _, collation := range []string{"utf8mb4_0900_ai_ci", "utf8mb4_unicode_ci", "latin1_german1_ci", "latin1_bin", "gbk_bin", "gbk_chinese_ci"} {
+		columns.GetColumn("ci").Collation = collation
+		if _, err := NewRowFilter("ci = 'alice'", columns); err == nil {
+			t.Errorf("%s: expected error", collation)
+		}
+	}
+}
+
+func TestRowFilterRejected(t *testing.T) {
+	columns := newFilterTestColumns()
+	conditions := []string{
+		"",
+		"no_such_column = 1",
+		"created_at > now() - interval 7 day",
+		"id + 1 > 3",
+		"status = 'active'",
+		"id in (select id from t)",
+		"name = 'unterminated",
+		"id > 3 and",
+		"(id > 3",
+		"id between 1",
+		"name is 'alice'",
+		"id > 3; drop table t",
+	}
+	for _, condition := range conditions {
+		_, err := NewRowFilter(condition, columns)
+		if err == nil {
+			t.Errorf("%s: expected error", condition)
+		}
+	}
+}
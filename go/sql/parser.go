@@ -6,19 +6,122 @@
 package sql
 
 import (
-	"regexp"
+	"fmt"
 	"strconv"
 	"strings"
 )
 
-var (
-	sanitizeQuotesRegexp = regexp.MustCompile("('[^']*')")
-	renameColumnRegexp   = regexp.MustCompile(`(?i)\bchange\s+(column\s+|)([\S]+)\s+([\S]+)\s+`)
-	dropColumnRegexp     = regexp.MustCompile(`(?i)\bdrop\s+(column\s+|)([\S]+)$`)
-	renameTableRegexp    = regexp.MustCompile(`(?i)\brename\s+(to|as)\s+`)
+type AlterOperationType int
+
+const (
+	UnknownAlterOperation AlterOperationType = iota
+	AddColumnAlterOperation
+	ChangeColumnAlterOperation
+	ModifyColumnAlterOperation
+	DropColumnAlterOperation
+	RenameColumnAlterOperation
+	AlterColumnAlterOperation
+	AddIndexAlterOperation
+	AddUniqueKeyAlterOperation
+	AddPrimaryKeyAlterOperation
+	AddForeignKeyAlterOperation
+	AddCheckAlterOperation
+	DropIndexAlterOperation
+	DropPrimaryKeyAlterOperation
+	DropForeignKeyAlterOperation
+	DropCheckAlterOperation
+	RenameIndexAlterOperation
+	AlterIndexAlterOperation
+	AlterCheckAlterOperation
+	RenameTableAlterOperation
+	TableOptionAlterOperation
+	ConvertCharsetAlterOperation
+	AlgorithmAlterOperation
+	LockAlterOperation
+	ForceAlterOperation
+	OrderByAlterOperation
+	KeysAlterOperation
+	PartitionAlterOperation
+	ExchangePartitionAlterOperation
+	TablespaceAlterOperation
 )
 
+var alterOperationTypeNames = []string{
+	"unknown operation",
+	"ADD COLUMN",
+	"CHANGE COLUMN",
+	"MODIFY COLUMN",
+	"DROP COLUMN",
+	"RENAME COLUMN",
+	"ALTER COLUMN",
+	"ADD INDEX",
+	"ADD UNIQUE KEY",
+	"ADD PRIMARY KEY",
+	"ADD FOREIGN KEY",
+	"ADD CHECK",
+	"DROP INDEX",
+	"DROP PRIMARY KEY",
+	"DROP FOREIGN KEY",
+	"DROP CHECK",
+	"RENAME INDEX",
+	"ALTER INDEX",
+	"ALTER CHECK",
+	"RENAME TABLE",
+	"table option",
+	"CONVERT TO CHARACTER SET",
+	"ALGORITHM",
+	"LOCK",
+	"FORCE",
+	"ORDER BY",
+	"ENABLE/DISABLE KEYS",
+	"partitioning",
+	"EXCHANGE PARTITION",
+	"DISCARD/IMPORT TABLESPACE",
+}
+
+func (this AlterOperationType) String() string {
+	return alterOperationTypeNames[this]
+}
+
+// IsSupported tells whether gh-ost is able to apply this operation on the ghost table. Unsupported operations
+// either rename the table, or move data in or out of it (tablespace, partition exchange), which cannot be
+// reproduced on a ghost table.
+func (this AlterOperationType) IsSupported() bool {
+	switch this {
+	case RenameTableAlterOperation, ExchangePartitionAlterOperation, TablespaceAlterOperation:
+		return false
+	}
+	return true
+}
+
+// AlterOperation is a single operation of an ALTER TABLE statement, e.g. `DROP COLUMN c` or `ENGINE=InnoDB`
+type AlterOperation struct {
+	Type AlterOperationType
+	// Name is the column, index, constraint or table option the operation applies to
+	Name string
+	// NewName is the new name on CHANGE COLUMN, RENAME COLUMN, RENAME INDEX and RENAME TABLE
+	NewName string
+	// Columns are the key columns on ADD INDEX, ADD UNIQUE KEY, ADD PRIMARY KEY and ADD FOREIGN KEY.
+	// Functional key parts are given as their (parenthesized) expression
+	Columns []string
+	// PrefixLengths are the prefix lengths of the key columns, e.g. 20 for `email(20)`; 0 when the whole column is indexed
+	PrefixLengths []int
+	// Definition is the column definition on ADD/CHANGE/MODIFY COLUMN, or the value of a table option
+	Definition string
+	// Text is the operation as it appears in the statement
+	Text string
+}
+
+func (this *AlterOperation) String() string {
+	return this.Text
+}
+
+// Parser parses the ALTER TABLE specification given via --alter, e.g. "add column i int, drop key idx_t",
+// into a list of AlterOperation.
+// Parts of the statement it does not recognize are kept as UnknownAlterOperation: MySQL has the last
+// word on syntax, when the statement is applied on the ghost table.
 type Parser struct {
+	operations      []*AlterOperation
 	columnRenameMap map[string]string
 	droppedColumns  map[string]bool
 	isRenameTable   bool
@@ -31,85 +134,86 @@ func NewParser() *Parser {
 	}
 }
 
+// tokenizeAlterStatement splits the statement into its comma separated specifications
 func (this *Parser) tokenizeAlterStatement(alterStatement string) (tokens []string, err error) {
-	terminatingQuote := rune(0)
-	f := func(c rune) bool {
-		switch {
-		case c == terminatingQuote:
-			terminatingQuote = rune(0)
-			return false
-		case terminatingQuote != rune(0):
-			return false
-		case c == '\'':
-			terminatingQuote = c
-			return false
-		case c == '(':
-			terminatingQuote = ')'
-			return false
-		default:
-			return c == ','
-		}
+	alterTokens, err := tokenizeAlter(alterStatement)
+	if err != nil {
+		return tokens, err
 	}
-
-	tokens = strings.FieldsFunc(alterStatement, f)
-	for i := range tokens {
-		tokens[i] = strings.TrimSpace(tokens[i])
+	parser := &alterParser{statement: alterStatement, tokens: alterTokens}
+	for !parser.done() {
+		start := parser.position
+		parser.skipToComma()
+		tokens = append(tokens, parser.textOf(start, parser.position))
+		parser.acceptPunctuation(",")
 	}
 	return tokens, nil
 }
 
-func (this *Parser) sanitizeQuotesFromAlterStatement(alterStatement string) (strippedStatement string) {
-	strippedStatement = alterStatement
-	strippedStatement = sanitizeQuotesRegexp.ReplaceAllString(strippedStatement, "''")
-	return strippedStatement
+func (this *Parser) ParseAlterStatement(alterStatement string) (err error) {
+	tokens, err := tokenizeAlter(alterStatement)
+	if err != nil {
+		return err
+	}
+	parser := &alterParser{statement: alterStatement, tokens: tokens}
+	for _, operation := range parser.parse() {
+		this.addOperation(operation)
+	}
+	return nil
 }
 
-func (this *Parser) parseAlterToken(alterToken string) (err error) {
-	{
-		// rename
-		allStringSubmatch := renameColumnRegexp.FindAllStringSubmatch(alterToken, -1)
-		for _, submatch := range allStringSubmatch {
-			if unquoted, err := strconv.Unquote(submatch[2]); err == nil {
-				submatch[2] = unquoted
-			}
-			if unquoted, err := strconv.Unquote(submatch[3]); err == nil {
-				submatch[3] = unquoted
-			}
-			this.columnRenameMap[submatch[2]] = submatch[3]
-		}
+func (this *Parser) addOperation(operation *AlterOperation) {
+	this.operations = append(this.operations, operation)
+	switch operation.Type {
+	case ChangeColumnAlterOperation, RenameColumnAlterOperation:
+		this.columnRenameMap[operation.Name] = operation.NewName
+	case DropColumnAlterOperation:
+		this.droppedColumns[operation.Name] = true
+	case RenameTableAlterOperation:
+		this.isRenameTable = true
 	}
-	{
-		// drop
-		allStringSubmatch := dropColumnRegexp.FindAllStringSubmatch(alterToken, -1)
-		for _, submatch := range allStringSubmatch {
-			if unquoted, err := strconv.Unquote(submatch[2]); err == nil {
-				submatch[2] = unquoted
-			}
-			this.droppedColumns[submatch[2]] = true
+}
+
+// Operations returns all operations of the statement, in order
+func (this *Parser) Operations() []*AlterOperation {
+	return this.operations
+}
+
+// UnsupportedOperations returns the operations gh-ost cannot apply on a ghost table
+func (this *Parser) UnsupportedOperations() (operations []*AlterOperation) {
+	for _, operation := range this.operations {
+		if !operation.Type.IsSupported() {
+			operations = append(operations, operation)
 		}
 	}
-	{
-		// rename table
-		if renameTableRegexp.MatchString(alterToken) {
-			this.isRenameTable = true
+	return operations
+}
+
+// UnknownOperations returns the parts of the statement the parser could not make sense of
+func (this *Parser) UnknownOperations() (operations []*AlterOperation) {
+	for _, operation := range this.operations {
+		if operation.Type == UnknownAlterOperation {
+			operations = append(operations, operation)
 		}
 	}
-	return nil
+	return operations
 }
 
-func (this *Parser) ParseAlterStatement(alterStatement string) (err error) {
-	alterTokens, _ := this.tokenizeAlterStatement(alterStatement)
-	for _, alterToken := range alterTokens {
-		alterToken = this.sanitizeQuotesFromAlterStatement(alterToken)
-		this.parseAlterToken(alterToken)
+// AddedUniqueKeys returns the UNIQUE and PRIMARY keys added by the statement
+func (this *Parser) AddedUniqueKeys() (operations []*AlterOperation) {
+	for _, operation := range this.operations {
+		if operation.Type == AddUniqueKeyAlterOperation || operation.Type == AddPrimaryKeyAlterOperation {
+			operations = append(operations, operation)
+		}
 	}
-	return nil
+	return operations
 }
 
 func (this *Parser) GetNonTrivialRenames() map[string]string {
 	result := make(map[string]string)
 	for column, renamed := range this.columnRenameMap {
-		if column != renamed {
+		// column names are case insensitive
+		if !strings.EqualFold(column, renamed) {
 			result[column] = renamed
 		}
 	}
@@ -127,3 +231,659 @@ func (this *Parser) DroppedColumnsMap() map[string]bool {
 func (this *Parser) IsRenameTable() bool {
 	return this.isRenameTable
 }
+
+// alter tokens
+
+type alterTokenType int
+
+const (
+	wordAlterToken alterTokenType = iota // keyword, unquoted identifier or number
+	quotedAlterToken
+	punctuationAlterToken
+)
+
+type alterToken struct {
+	tokenType alterTokenType
+	text      string // unquoted & unescaped
+	quote     byte   // ` " or '
+	start     int    // offsets of the token within the statement
+	end       int
+}
+
+func (this alterToken) String() string {
+	return fmt.Sprintf("'%s'", this.text)
+}
+
+func (this alterToken) isKeyword(keywords ...string) bool {
+	if this.tokenType != wordAlterToken {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(this.text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this alterToken) isPunctuation(punctuation string) bool {
+	return this.tokenType == punctuationAlterToken && this.text == punctuation
+}
+
+// isIdentifier: unquoted words, `quoted` identifiers, and "quoted" ones (as with ANSI_QUOTES)
+func (this alterToken) isIdentifier() bool {
+	return this.tokenType == wordAlterToken || (this.tokenType == quotedAlterToken && this.quote != '\'')
+}
+
+func isAlterWordByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '$' || c >= 0x80
+}
+
+func isAlterSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// tokenizeAlter tokenizes the statement, skipping comments. The content of executable comments (/*!50100 ... */)
+// is tokenized as part of the statement.
+func tokenizeAlter(statement string) (tokens []alterToken, err error) {
+	inExecutableComment := false
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		rest := statement[i:]
+		switch {
+		case isAlterSpaceByte(c):
+			i++
+		case c == '#' || (strings.HasPrefix(rest, "--") && (len(rest) == 2 || isAlterSpaceByte(rest[2]))):
+			for i < len(statement) && statement[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(rest, "/*!"):
+			i += 3
+			for i < len(statement) && statement[i] >= '0' && statement[i] <= '9' {
+				i++
+			}
+			inExecutableComment = true
+		case inExecutableComment && strings.HasPrefix(rest, "*/"):
+			i += 2
+			inExecutableComment = false
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				return tokens, fmt.Errorf("Unterminated comment in alter statement: %s", statement)
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			// 引号内: 支持双写引号, 以及字符串中的 \ 转义
+			var text []byte
+			j := i + 1
+			for ; j < len(statement); j++ {
+				if statement[j] == '\\' && c != '`' && j+1 < len(statement) {
+					j++
+					text = append(text, statement[j])
+					continue
+				}
+				if statement[j] == c {
+					if j+1 < len(statement) && statement[j+1] == c {
+						j++
+						text = append(text, c)
+						continue
+					}
+					break
+				}
+				text = append(text, statement[j])
+			}
+			if j >= len(statement) {
+				return tokens, fmt.Errorf("Unterminated quote in alter statement: %s", statement)
+			}
+			tokens = append(tokens, alterToken{tokenType: quotedAlterToken, text: string(text), quote: c, start: i, end: j + 1})
+			i = j + 1
+		case isAlterWordByte(c):
+			j := i
+			for j < len(statement) && isAlterWordByte(statement[j]) {
+				j++
+			}
+			tokens = append(tokens, alterToken{tokenType: wordAlterToken, text: statement[i:j], start: i, end: j})
+			i = j
+		default:
+			tokens = append(tokens, alterToken{tokenType: punctuationAlterToken, text: string(c), start: i, end: i + 1})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// alter parser
+
+// alterTableOptions are the table options which may appear in an ALTER TABLE statement
+var alterTableOptions = map[string]bool{
+	"AUTOEXTEND_SIZE": true, "AUTO_INCREMENT": true, "AVG_ROW_LENGTH": true, "CHARACTER SET": true, "CHARSET": true,
+	"CHECKSUM": true, "COLLATE": true, "COMMENT": true, "COMPRESSION": true, "CONNECTION": true, "DATA DIRECTORY": true,
+	"INDEX DIRECTORY": true, "DELAY_KEY_WRITE": true, "ENCRYPTION": true, "ENGINE": true, "ENGINE_ATTRIBUTE": true,
+	"INSERT_METHOD": true, "KEY_BLOCK_SIZE": true, "MAX_ROWS": true, "MIN_ROWS": true, "PACK_KEYS": true,
+	"PASSWORD": true, "ROW_FORMAT": true, "SECONDARY_ENGINE_ATTRIBUTE": true, "STATS_AUTO_RECALC": true,
+	"STATS_PERSISTENT": true, "STATS_SAMPLE_PAGES": true, "TABLESPACE": true, "UNION": true,
+	"PAGE_CHECKSUM": true, "PAGE_COMPRESSED": true, "PAGE_COMPRESSION_LEVEL": true, "TRANSACTIONAL": true,
+}
+
+// alterPartitionKeywords start partition maintenance operations, e.g. `TRUNCATE PARTITION p0, p1`
+var alterPartitionKeywords = []string{"add", "drop", "truncate", "coalesce", "reorganize", "analyze", "check", "optimize", "rebuild", "repair"}
+
+type alterParser struct {
+	statement string
+	tokens    []alterToken
+	position  int
+}
+
+func (this *alterParser) done() bool {
+	return this.position >= len(this.tokens)
+}
+
+func (this *alterParser) peek() alterToken {
+	return this.peekAt(0)
+}
+
+func (this *alterParser) peekAt(offset int) alterToken {
+	if this.position+offset >= len(this.tokens) {
+		return alterToken{tokenType: punctuationAlterToken, text: "<end>"}
+	}
+	return this.tokens[this.position+offset]
+}
+
+func (this *alterParser) next() alterToken {
+	token := this.peek()
+	this.position++
+	return token
+}
+
+// acceptKeyword consumes the given sequence of keywords, if that's what comes next
+func (this *alterParser) acceptKeyword(keywords ...string) bool {
+	for i, keyword := range keywords {
+		if !this.peekAt(i).isKeyword(keyword) {
+			return false
+		}
+	}
+	this.position += len(keywords)
+	return true
+}
+
+func (this *alterParser) acceptPunctuation(punctuation string) bool {
+	if this.peek().isPunctuation(punctuation) {
+		this.position++
+		return true
+	}
+	return false
+}
+
+func (this *alterParser) expectKeyword(keywords ...string) error {
+	if !this.acceptKeyword(keywords...) {
+		return fmt.Errorf("Expected %s, got %s", strings.ToUpper(strings.Join(keywords, " ")), this.peek())
+	}
+	return nil
+}
+
+func (this *alterParser) parseIdentifier() (string, error) {
+	token := this.peek()
+	if !token.isIdentifier() {
+		return "", fmt.Errorf("Expected identifier, got %s", token)
+	}
+	this.position++
+	return token.text, nil
+}
+
+// parseQualifiedName parses `name` or `schema`.`name`, returning the name
+func (this *alterParser) parseQualifiedName() (name string, err error) {
+	if name, err = this.parseIdentifier(); err != nil {
+		return name, err
+	}
+	if this.acceptPunctuation(".") {
+		return this.parseIdentifier()
+	}
+	return name, nil
+}
+
+// skipParenthesized consumes a balanced (...) group, returning the tokens within
+func (this *alterParser) skipParenthesized() (inner []alterToken, err error) {
+	if !this.acceptPunctuation("(") {
+		return inner, fmt.Errorf("Expected '(', got %s", this.peek())
+	}
+	start := this.position
+	for depth := 1; depth > 0; {
+		if this.done() {
+			return inner, fmt.Errorf("Unbalanced parentheses")
+		}
+		token := this.next()
+		if token.isPunctuation("(") {
+			depth++
+		} else if token.isPunctuation(")") {
+			depth--
+		}
+	}
+	return this.tokens[start : this.position-1], nil
+}
+
+// skipToComma consumes tokens up to (excluding) the next comma that is not within parentheses
+func (this *alterParser) skipToComma() {
+	for depth := 0; !this.done(); this.position++ {
+		token := this.peek()
+		if token.isPunctuation(",") && depth == 0 {
+			return
+		}
+		if token.isPunctuation("(") {
+			depth++
+		} else if token.isPunctuation(")") && depth > 0 {
+			depth--
+		}
+	}
+}
+
+// skipToEnd consumes all remaining tokens
+func (this *alterParser) skipToEnd() {
+	this.position = len(this.tokens)
+}
+
+// textOf returns the statement text of tokens [start, end)
+func (this *alterParser) textOf(start, end int) string {
+	if start >= end {
+		return ""
+	}
+	return this.statement[this.tokens[start].start:this.tokens[end-1].end]
+}
+
+// subParser parses a (parenthesized) list of tokens of the same statement
+func (this *alterParser) subParser(tokens []alterToken) *alterParser {
+	return &alterParser{statement: this.statement, tokens: tokens}
+}
+
+func (this *alterParser) parse() (operations []*AlterOperation) {
+	for !this.done() {
+		start := this.position
+		specificationOperations, err := this.parseSpecification()
+		if err == nil && !this.done() && !this.peek().isPunctuation(",") {
+			err = fmt.Errorf("Unexpected %s", this.peek())
+		}
+		if err != nil {
+			// 无法识别的部分原样保留, 由MySQL在ghost表上执行时校验
+			this.position = start
+			this.skipToComma()
+			specificationOperations = []*AlterOperation{{Type: UnknownAlterOperation, Text: this.textOf(start, this.position)}}
+		}
+		operations = append(operations, specificationOperations...)
+		this.acceptPunctuation(",")
+	}
+	return operations
+}
+
+// parseSpecification parses a single, comma delimited, alter specification. Table options (and a table rename)
+// may follow each other without commas, e.g. `engine=innodb row_format=compressed`.
+func (this *alterParser) parseSpecification() (operations []*AlterOperation, err error) {
+	for {
+		start := this.position
+		var parsed []*AlterOperation
+		if parsed, err = this.parseOperation(); err != nil {
+			return operations, err
+		}
+		if len(parsed) == 0 {
+			return operations, fmt.Errorf("Empty specification")
+		}
+		for _, operation := range parsed {
+			if operation.Text == "" {
+				operation.Text = this.textOf(start, this.position)
+			}
+		}
+		operations = append(operations, parsed...)
+
+		if this.done() || this.peek().isPunctuation(",") {
+			return operations, nil
+		}
+		switch parsed[len(parsed)-1].Type {
+		case TableOptionAlterOperation, RenameTableAlterOperation, AlgorithmAlterOperation, LockAlterOperation:
+			continue
+		}
+		return operations, nil
+	}
+}
+
+func singleAlterOperation(operation *AlterOperation, err error) ([]*AlterOperation, error) {
+	if err != nil {
+		return nil, err
+	}
+	return []*AlterOperation{operation}, nil
+}
+
+func (this *alterParser) parseOperation() (operations []*AlterOperation, err error) {
+	for _, keyword := range alterPartitionKeywords {
+		if this.acceptKeyword(keyword, "partition") {
+			this.skipToEnd()
+			return singleAlterOperation(&AlterOperation{Type: PartitionAlterOperation, Name: strings.ToUpper(keyword) + " PARTITION"}, nil)
+		}
+	}
+	switch {
+	case this.acceptKeyword("add"):
+		return this.parseAdd()
+	case this.acceptKeyword("drop"):
+		return singleAlterOperation(this.parseDrop())
+	case this.acceptKeyword("change"):
+		return singleAlterOperation(this.parseChange())
+	case this.acceptKeyword("modify"):
+		this.acceptKeyword("column")
+		this.acceptKeyword("if", "exists")
+		return singleAlterOperation(this.parseColumnDefinition(ModifyColumnAlterOperation))
+	case this.acceptKeyword("rename"):
+		return singleAlterOperation(this.parseRename())
+	case this.acceptKeyword("alter"):
+		return singleAlterOperation(this.parseAlter())
+	case this.acceptKeyword("algorithm"):
+		return singleAlterOperation(this.parseOptionValue(AlgorithmAlterOperation, "ALGORITHM"))
+	case this.acceptKeyword("lock"):
+		return singleAlterOperation(this.parseOptionValue(LockAlterOperation, "LOCK"))
+	case this.acceptKeyword("force"):
+		return singleAlterOperation(&AlterOperation{Type: ForceAlterOperation}, nil)
+	case this.acceptKeyword("order", "by"):
+		this.skipToComma()
+		return singleAlterOperation(&AlterOperation{Type: OrderByAlterOperation}, nil)
+	case this.acceptKeyword("enable", "keys"), this.acceptKeyword("disable", "keys"):
+		return singleAlterOperation(&AlterOperation{Type: KeysAlterOperation}, nil)
+	case this.acceptKeyword("convert", "to"):
+		if !this.acceptKeyword("character", "set") && !this.acceptKeyword("charset") {
+			return nil, fmt.Errorf("Expected CHARACTER SET, got %s", this.peek())
+		}
+		start := this.position
+		this.skipToComma()
+		return singleAlterOperation(&AlterOperation{Type: ConvertCharsetAlterOperation, Definition: this.textOf(start, this.position)}, nil)
+	case this.acceptKeyword("discard", "tablespace"), this.acceptKeyword("import", "tablespace"):
+		return singleAlterOperation(&AlterOperation{Type: TablespaceAlterOperation}, nil)
+	case this.acceptKeyword("discard", "partition"), this.acceptKeyword("import", "partition"):
+		this.skipToEnd()
+		return singleAlterOperation(&AlterOperation{Type: TablespaceAlterOperation}, nil)
+	case this.acceptKeyword("exchange", "partition"):
+		this.skipToEnd()
+		return singleAlterOperation(&AlterOperation{Type: ExchangePartitionAlterOperation}, nil)
+	case this.acceptKeyword("remove", "partitioning"):
+		return singleAlterOperation(&AlterOperation{Type: PartitionAlterOperation, Name: "REMOVE PARTITIONING"}, nil)
+	case this.acceptKeyword("partition", "by"):
+		this.skipToEnd()
+		return singleAlterOperation(&AlterOperation{Type: PartitionAlterOperation, Name: "PARTITION BY"}, nil)
+	}
+	return singleAlterOperation(this.parseTableOption())
+}
+
+// parseAdd parses what follows ADD
+func (this *alterParser) parseAdd() (operations []*AlterOperation, err error) {
+	switch {
+	case this.acceptKeyword("index"), this.acceptKeyword("key"):
+		return singleAlterOperation(this.parseIndexDefinition(AddIndexAlterOperation))
+	case this.acceptKeyword("fulltext"), this.acceptKeyword("spatial"):
+		if !this.acceptKeyword("index") {
+			this.acceptKeyword("key")
+		}
+		return singleAlterOperation(this.parseIndexDefinition(AddIndexAlterOperation))
+	}
+
+	constraintName := ""
+	if this.acceptKeyword("constraint") {
+		if !this.peek().isKeyword("primary", "unique", "foreign", "check") {
+			if constraintName, err = this.parseIdentifier(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch {
+	case this.acceptKeyword("primary", "key"):
+		return singleAlterOperation(this.parseIndexDefinition(AddPrimaryKeyAlterOperation))
+	case this.acceptKeyword("unique"):
+		if !this.acceptKeyword("index") {
+			this.acceptKeyword("key")
+		}
+		operation, err := this.parseIndexDefinition(AddUniqueKeyAlterOperation)
+		if err == nil && operation.Name == "" {
+			operation.Name = constraintName
+		}
+		return singleAlterOperation(operation, err)
+	case this.acceptKeyword("foreign", "key"):
+		operation, err := this.parseIndexDefinition(AddForeignKeyAlterOperation)
+		if err == nil && constraintName != "" {
+			operation.Name = constraintName
+		}
+		return singleAlterOperation(operation, err)
+	case this.acceptKeyword("check"):
+		if _, err := this.skipParenthesized(); err != nil {
+			return nil, err
+		}
+		this.skipToComma()
+		return singleAlterOperation(&AlterOperation{Type: AddCheckAlterOperation, Name: constraintName}, nil)
+	case constraintName != "":
+		return nil, fmt.Errorf("Expected PRIMARY KEY, UNIQUE, FOREIGN KEY or CHECK, got %s", this.peek())
+	}
+
+	this.acceptKeyword("column")
+	this.acceptKeyword("if", "not", "exists")
+	if this.peek().isPunctuation("(") {
+		// ADD COLUMN (c1 int, c2 int)
+		inner, err := this.skipParenthesized()
+		if err != nil {
+			return nil, err
+		}
+		columnsParser := this.subParser(inner)
+		for !columnsParser.done() {
+			start := columnsParser.position
+			operation, err := columnsParser.parseColumnDefinition(AddColumnAlterOperation)
+			if err != nil {
+				return nil, err
+			}
+			operation.Text = columnsParser.textOf(start, columnsParser.position)
+			operations = append(operations, operation)
+			columnsParser.acceptPunctuation(",")
+		}
+		return operations, nil
+	}
+	return singleAlterOperation(this.parseColumnDefinition(AddColumnAlterOperation))
+}
+
+// parseColumnDefinition parses `name definition`, as in ADD and MODIFY
+func (this *alterParser) parseColumnDefinition(operationType AlterOperationType) (operation *AlterOperation, err error) {
+	operation = &AlterOperation{Type: operationType}
+	if operation.Name, err = this.parseIdentifier(); err != nil {
+		return nil, err
+	}
+	start := this.position
+	this.skipToComma()
+	if start == this.position {
+		return nil, fmt.Errorf("Missing definition of column %s", operation.Name)
+	}
+	operation.Definition = this.textOf(start, this.position)
+	return operation, nil
+}
+
+// parseIndexDefinition parses `[name] [USING type] (key_part, ...) [options]`
+func (this *alterParser) parseIndexDefinition(operationType AlterOperationType) (operation *AlterOperation, err error) {
+	operation = &AlterOperation{Type: operationType}
+	if operationType != AddPrimaryKeyAlterOperation {
+		if this.peek().isIdentifier() && !this.peek().isKeyword("using") {
+			operation.Name, _ = this.parseIdentifier()
+		}
+	}
+	if this.acceptKeyword("using") {
+		this.next()
+	}
+	inner, err := this.skipParenthesized()
+	if err != nil {
+		return nil, err
+	}
+	keyPartsParser := this.subParser(inner)
+	for !keyPartsParser.done() {
+		start := keyPartsParser.position
+		if keyPartsParser.peek().isPunctuation("(") {
+			// functional key part
+			keyPartsParser.skipParenthesized()
+			operation.Columns = append(operation.Columns, keyPartsParser.textOf(start, keyPartsParser.position))
+			operation.PrefixLengths = append(operation.PrefixLengths, 0)
+		} else {
+			column, err := keyPartsParser.parseIdentifier()
+			if err != nil {
+				return nil, err
+			}
+			prefixLength := 0
+			if keyPartsParser.peek().isPunctuation("(") {
+				lengthTokens, err := keyPartsParser.skipParenthesized()
+				if err != nil {
+					return nil, err
+				}
+				if len(lengthTokens) == 1 {
+					prefixLength, _ = strconv.Atoi(lengthTokens[0].text)
+				}
+				if prefixLength <= 0 {
+					return nil, fmt.Errorf("Invalid prefix length of key column %s", column)
+				}
+			}
+			operation.Columns = append(operation.Columns, column)
+			operation.PrefixLengths = append(operation.PrefixLengths, prefixLength)
+		}
+		// ASC/DESC
+		keyPartsParser.skipToComma()
+		keyPartsParser.acceptPunctuation(",")
+	}
+	if len(operation.Columns) == 0 {
+		return nil, fmt.Errorf("Missing key columns")
+	}
+	// index options, REFERENCES clause
+	this.skipToComma()
+	return operation, nil
+}
+
+// parseDrop parses what follows DROP
+func (this *alterParser) parseDrop() (operation *AlterOperation, err error) {
+	dropNamed := func(operationType AlterOperationType) (*AlterOperation, error) {
+		this.acceptKeyword("if", "exists")
+		name, err := this.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		return &AlterOperation{Type: operationType, Name: name}, nil
+	}
+	switch {
+	case this.acceptKeyword("primary", "key"):
+		return &AlterOperation{Type: DropPrimaryKeyAlterOperation}, nil
+	case this.acceptKeyword("index"), this.acceptKeyword("key"):
+		return dropNamed(DropIndexAlterOperation)
+	case this.acceptKeyword("foreign", "key"):
+		return dropNamed(DropForeignKeyAlterOperation)
+	case this.acceptKeyword("check"), this.acceptKeyword("constraint"):
+		return dropNamed(DropCheckAlterOperation)
+	}
+	this.acceptKeyword("column")
+	return dropNamed(DropColumnAlterOperation)
+}
+
+// parseChange parses `[COLUMN] old_name new_name definition`
+func (this *alterParser) parseChange() (operation *AlterOperation, err error) {
+	this.acceptKeyword("column")
+	this.acceptKeyword("if", "exists")
+	name, err := this.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	if operation, err = this.parseColumnDefinition(ChangeColumnAlterOperation); err != nil {
+		return nil, err
+	}
+	operation.Name, operation.NewName = name, operation.Name
+	return operation, nil
+}
+
+// parseRename parses what follows RENAME
+func (this *alterParser) parseRename() (operation *AlterOperation, err error) {
+	renameNamed := func(operationType AlterOperationType) (*AlterOperation, error) {
+		operation := &AlterOperation{Type: operationType}
+		if operation.Name, err = this.parseIdentifier(); err != nil {
+			return nil, err
+		}
+		if err := this.expectKeyword("to"); err != nil {
+			return nil, err
+		}
+		if operation.NewName, err = this.parseIdentifier(); err != nil {
+			return nil, err
+		}
+		return operation, nil
+	}
+	switch {
+	case this.acceptKeyword("column"):
+		return renameNamed(RenameColumnAlterOperation)
+	case this.acceptKeyword("index"), this.acceptKeyword("key"):
+		return renameNamed(RenameIndexAlterOperation)
+	}
+	if !this.acceptKeyword("to") {
+		this.acceptKeyword("as")
+	}
+	operation = &AlterOperation{Type: RenameTableAlterOperation}
+	if operation.NewName, err = this.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+// parseAlter parses what follows ALTER
+func (this *alterParser) parseAlter() (operation *AlterOperation, err error) {
+	operationType := AlterColumnAlterOperation
+	switch {
+	case this.acceptKeyword("index"):
+		operationType = AlterIndexAlterOperation
+	case this.acceptKeyword("check"), this.acceptKeyword("constraint"):
+		operationType = AlterCheckAlterOperation
+	default:
+		this.acceptKeyword("column")
+	}
+	operation = &AlterOperation{Type: operationType}
+	if operation.Name, err = this.parseIdentifier(); err != nil {
+		return nil, err
+	}
+	start := this.position
+	this.skipToComma()
+	if start == this.position {
+		return nil, fmt.Errorf("Missing action on %s", operation.Name)
+	}
+	operation.Definition = this.textOf(start, this.position)
+	return operation, nil
+}
+
+// parseOptionValue parses `[=] value`
+func (this *alterParser) parseOptionValue(operationType AlterOperationType, name string) (operation *AlterOperation, err error) {
+	this.acceptPunctuation("=")
+	operation = &AlterOperation{Type: operationType, Name: name}
+	start := this.position
+	if this.peek().isPunctuation("(") {
+		if _, err := this.skipParenthesized(); err != nil {
+			return nil, err
+		}
+	} else if this.done() || this.peek().tokenType == punctuationAlterToken {
+		return nil, fmt.Errorf("Missing value of %s", name)
+	} else {
+		this.next()
+	}
+	operation.Definition = this.textOf(start, this.position)
+	return operation, nil
+}
+
+// parseTableOption parses `[DEFAULT] name [=] value`, e.g. `ENGINE=InnoDB`, `DEFAULT CHARACTER SET utf8mb4`
+func (this *alterParser) parseTableOption() (operation *AlterOperation, err error) {
+	this.acceptKeyword("default")
+	token := this.next()
+	if token.tokenType != wordAlterToken {
+		return nil, fmt.Errorf("Unexpected %s", token)
+	}
+	name := strings.ToUpper(token.text)
+	if name == "CHARACTER" || name == "DATA" || name == "INDEX" {
+		name = name + " " + strings.ToUpper(this.next().text)
+	}
+	if !alterTableOptions[name] {
+		return nil, fmt.Errorf("Unknown table option %s", name)
+	}
+	if operation, err = this.parseOptionValue(TableOptionAlterOperation, name); err != nil {
+		return nil, err
+	}
+	if name == "TABLESPACE" {
+		this.acceptKeyword("storage")
+		if this.peek().isKeyword("disk", "memory") {
+			this.next()
+		}
+	}
+	return operation, nil
+}
@@ -105,19 +105,15 @@ func TestTokenizeAlterStatement(t *testing.T) {
 		tokens, _ := parser.tokenizeAlterStatement(alterStatement)
 		test.S(t).ExpectTrue(reflect.DeepEqual(tokens, []string{"add column t int(11)", "add column e enum('a','b','c')"}))
 	}
-}
-
-func TestSanitizeQuotesFromAlterStatement(t *testing.T) {
-	parser := NewParser()
 	{
-		alterStatement := "add column e enum('a','b','c')"
-		strippedStatement := parser.sanitizeQuotesFromAlterStatement(alterStatement)
-		test.S(t).ExpectEquals(strippedStatement, "add column e enum('','','')")
+		alterStatement := "add column `c,d` int, add column t int comment \"x, y\""
+		tokens, _ := parser.tokenizeAlterStatement(alterStatement)
+		test.S(t).ExpectTrue(reflect.DeepEqual(tokens, []string{"add column `c,d` int", "add column t int comment \"x, y\""}))
 	}
 	{
-		alterStatement := "change column i int 'some comment, with comma'"
-		strippedStatement := parser.sanitizeQuotesFromAlterStatement(alterStatement)
-		test.S(t).ExpectEquals(strippedStatement, "change column i int ''")
+		alterStatement := "add column t int /* a, b */, drop column d -- trailing, comment"
+		tokens, _ := parser.tokenizeAlterStatement(alterStatement)
+		test.S(t).ExpectTrue(reflect.DeepEqual(tokens, []string{"add column t int", "drop column d"}))
 	}
 }
 
@@ -198,3 +194,262 @@ func TestParseAlterStatementRenameTable(t *testing.T) {
 		test.S(t).ExpectTrue(parser.isRenameTable)
 	}
 }
+
+func TestParseAlterStatementOperations(t *testing.T) {
+	type expectedOperation struct {
+		operationType AlterOperationType
+		name          string
+		newName       string
+		columns       []string
+		prefixLengths []int
+		definition    string
+	}
+	tests := []struct {
+		statement string
+		expected  []expectedOperation
+	}{
+		{
+			statement: "add column i int",
+			expected:  []expectedOperation{{operationType: AddColumnAlterOperation, name: "i", definition: "int"}},
+		},
+		{
+			statement: "add i int not null default 0 after id",
+			expected:  []expectedOperation{{operationType: AddColumnAlterOperation, name: "i", definition: "int not null default 0 after id"}},
+		},
+		{
+			statement: "add column if not exists `i,j` decimal(10,2) comment 'x, y'",
+			expected:  []expectedOperation{{operationType: AddColumnAlterOperation, name: "i,j", definition: "decimal(10,2) comment 'x, y'"}},
+		},
+		{
+			statement: "add column (a int, b varchar(16))",
+			expected: []expectedOperation{
+				{operationType: AddColumnAlterOperation, name: "a", definition: "int"},
+				{operationType: AddColumnAlterOperation, name: "b", definition: "varchar(16)"},
+			},
+		},
+		{
+			statement: "change column `i` `count` bigint unsigned first",
+			expected:  []expectedOperation{{operationType: ChangeColumnAlterOperation, name: "i", newName: "count", definition: "bigint unsigned first"}},
+		},
+		{
+			statement: "modify column e enum('a','b','c') not null",
+			expected:  []expectedOperation{{operationType: ModifyColumnAlterOperation, name: "e", definition: "enum('a','b','c') not null"}},
+		},
+		{
+			statement: "modify t varchar(32)",
+			expected:  []expectedOperation{{operationType: ModifyColumnAlterOperation, name: "t", definition: "varchar(32)"}},
+		},
+		{
+			statement: "rename column a to b",
+			expected:  []expectedOperation{{operationType: RenameColumnAlterOperation, name: "a", newName: "b"}},
+		},
+		{
+			statement: "alter column c set default 7, alter index idx_c invisible",
+			expected: []expectedOperation{
+				{operationType: AlterColumnAlterOperation, name: "c", definition: "set default 7"},
+				{operationType: AlterIndexAlterOperation, name: "idx_c", definition: "invisible"},
+			},
+		},
+		{
+			statement: "drop column b, drop `c`, drop column if exists d",
+			expected: []expectedOperation{
+				{operationType: DropColumnAlterOperation, name: "b"},
+				{operationType: DropColumnAlterOperation, name: "c"},
+				{operationType: DropColumnAlterOperation, name: "d"},
+			},
+		},
+		{
+			statement: "add index idx_ab (a, b(10) desc), add key (c), add fulltext index ft_t (t), add index using btree (d)",
+			expected: []expectedOperation{
+				{operationType: AddIndexAlterOperation, name: "idx_ab", columns: []string{"a", "b"}, prefixLengths: []int{0, 10}},
+				{operationType: AddIndexAlterOperation, columns: []string{"c"}, prefixLengths: []int{0}},
+				{operationType: AddIndexAlterOperation, name: "ft_t", columns: []string{"t"}, prefixLengths: []int{0}},
+				{operationType: AddIndexAlterOperation, columns: []string{"d"}, prefixLengths: []int{0}},
+			},
+		},
+		{
+			statement: "add unique key uk_email (email) comment 'unique, really', add unique (`a`, `b`)",
+			expected: []expectedOperation{
+				{operationType: AddUniqueKeyAlterOperation, name: "uk_email", columns: []string{"email"}, prefixLengths: []int{0}},
+				{operationType: AddUniqueKeyAlterOperation, columns: []string{"a", "b"}, prefixLengths: []int{0, 0}},
+			},
+		},
+		{
+			statement: "add unique key uk_email (email(20)), add unique (`a` (3) asc, b)",
+			expected: []expectedOperation{
+				{operationType: AddUniqueKeyAlterOperation, name: "uk_email", columns: []string{"email"}, prefixLengths: []int{20}},
+				{operationType: AddUniqueKeyAlterOperation, columns: []string{"a", "b"}, prefixLengths: []int{3, 0}},
+			},
+		},
+		{
+			statement: "add constraint uk_c unique (c), add unique index ((lower(email)))",
+			expected: []expectedOperation{
+				{operationType: AddUniqueKeyAlterOperation, name: "uk_c", columns: []string{"c"}, prefixLengths: []int{0}},
+				{operationType: AddUniqueKeyAlterOperation, columns: []string{"(lower(email))"}, prefixLengths: []int{0}},
+			},
+		},
+		{
+			statement: "drop primary key, add primary key (id, ts)",
+			expected: []expectedOperation{
+				{operationType: DropPrimaryKeyAlterOperation},
+				{operationType: AddPrimaryKeyAlterOperation, columns: []string{"id", "ts"}, prefixLengths: []int{0, 0}},
+			},
+		},
+		{
+			statement: "add constraint fk_parent foreign key (parent_id) references parent (id) on delete cascade, drop foreign key fk_old",
+			expected: []expectedOperation{
+				{operationType: AddForeignKeyAlterOperation, name: "fk_parent", columns: []string{"parent_id"}, prefixLengths: []int{0}},
+				{operationType: DropForeignKeyAlterOperation, name: "fk_old"},
+			},
+		},
+		{
+			statement: "add constraint chk_positive check (i > 0), drop check chk_old",
+			expected: []expectedOperation{
+				{operationType: AddCheckAlterOperation, name: "chk_positive"},
+				{operationType: DropCheckAlterOperation, name: "chk_old"},
+			},
+		},
+		{
+			statement: "drop index idx_a, drop key `idx_b`, rename index idx_c to idx_d",
+			expected: []expectedOperation{
+				{operationType: DropIndexAlterOperation, name: "idx_a"},
+				{operationType: DropIndexAlterOperation, name: "idx_b"},
+				{operationType: RenameIndexAlterOperation, name: "idx_c", newName: "idx_d"},
+			},
+		},
+		{
+			statement: "engine=innodb row_format = compressed, default charset=utf8mb4 collate utf8mb4_bin, comment 'a, b'",
+			expected: []expectedOperation{
+				{operationType: TableOptionAlterOperation, name: "ENGINE", definition: "innodb"},
+				{operationType: TableOptionAlterOperation, name: "ROW_FORMAT", definition: "compressed"},
+				{operationType: TableOptionAlterOperation, name: "CHARSET", definition: "utf8mb4"},
+				{operationType: TableOptionAlterOperation, name: "COLLATE", definition: "utf8mb4_bin"},
+				{operationType: TableOptionAlterOperation, name: "COMMENT", definition: "'a, b'"},
+			},
+		},
+		{
+			statement: "convert to character set utf8mb4 collate utf8mb4_unicode_ci",
+			expected:  []expectedOperation{{operationType: ConvertCharsetAlterOperation, definition: "utf8mb4 collate utf8mb4_unicode_ci"}},
+		},
+		{
+			statement: "add column i int, algorithm=inplace, lock=none",
+			expected: []expectedOperation{
+				{operationType: AddColumnAlterOperation, name: "i", definition: "int"},
+				{operationType: AlgorithmAlterOperation, name: "ALGORITHM", definition: "inplace"},
+				{operationType: LockAlterOperation, name: "LOCK", definition: "none"},
+			},
+		},
+		{
+			statement: "force",
+			expected:  []expectedOperation{{operationType: ForceAlterOperation}},
+		},
+		{
+			statement: "engine=innodb partition by range (id) (partition p0 values less than (10), partition p1 values less than maxvalue)",
+			expected: []expectedOperation{
+				{operationType: TableOptionAlterOperation, name: "ENGINE", definition: "innodb"},
+				{operationType: PartitionAlterOperation, name: "PARTITION BY"},
+			},
+		},
+		{
+			statement: "drop partition p0, p1",
+			expected:  []expectedOperation{{operationType: PartitionAlterOperation, name: "DROP PARTITION"}},
+		},
+		{
+			statement: "remove partitioning",
+			expected:  []expectedOperation{{operationType: PartitionAlterOperation, name: "REMOVE PARTITIONING"}},
+		},
+		{
+			statement: "exchange partition p0 with table t2",
+			expected:  []expectedOperation{{operationType: ExchangePartitionAlterOperation}},
+		},
+		{
+			statement: "discard tablespace",
+			expected:  []expectedOperation{{operationType: TablespaceAlterOperation}},
+		},
+		{
+			statement: "rename to db.other_table",
+			expected:  []expectedOperation{{operationType: RenameTableAlterOperation, newName: "other_table"}},
+		},
+		{
+			statement: "add column i int /*!50100 , add column j int */",
+			expected: []expectedOperation{
+				{operationType: AddColumnAlterOperation, name: "i", definition: "int"},
+				{operationType: AddColumnAlterOperation, name: "j", definition: "int"},
+			},
+		},
+		{
+			statement: "drop bad statement, add column i int",
+			expected: []expectedOperation{
+				{operationType: UnknownAlterOperation},
+				{operationType: AddColumnAlterOperation, name: "i", definition: "int"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		parser := NewParser()
+		err := parser.ParseAlterStatement(tt.statement)
+		test.S(t).ExpectNil(err)
+		operations := parser.Operations()
+		if len(operations) != len(tt.expected) {
+			t.Fatalf("%s: expected %d operations, got %d: %+v", tt.statement, len(tt.expected), len(operations), operations)
+		}
+		for i, expected := range tt.expected {
+			operation := operations[i]
+			if operation.Type != expected.operationType {
+				t.Fatalf("%s: operation %d: expected %s, got %s", tt.statement, i, expected.operationType, operation.Type)
+			}
+			test.S(t).ExpectEquals(operation.Name, expected.name)
+			test.S(t).ExpectEquals(operation.NewName, expected.newName)
+			test.S(t).ExpectEquals(operation.Definition, expected.definition)
+			test.S(t).ExpectTrue(reflect.DeepEqual(operation.Columns, expected.columns))
+			test.S(t).ExpectTrue(reflect.DeepEqual(operation.PrefixLengths, expected.prefixLengths))
+		}
+	}
+}
+
+func TestParseAlterStatementOperationText(t *testing.T) {
+	parser := NewParser()
+	err := parser.ParseAlterStatement("add column i int,  DROP   KEY idx_t , engine=innodb rename to t2")
+	test.S(t).ExpectNil(err)
+	operations := parser.Operations()
+	test.S(t).ExpectEquals(len(operations), 4)
+	test.S(t).ExpectEquals(operations[0].Text, "add column i int")
+	test.S(t).ExpectEquals(operations[1].Text, "DROP   KEY idx_t")
+	test.S(t).ExpectEquals(operations[2].Text, "engine=innodb")
+	test.S(t).ExpectEquals(operations[3].Text, "rename to t2")
+}
+
+func TestParseAlterStatementRenameColumn(t *testing.T) {
+	parser := NewParser()
+	err := parser.ParseAlterStatement("rename column a to b, change c d int, change e E int")
+	test.S(t).ExpectNil(err)
+	renames := parser.GetNonTrivialRenames()
+	test.S(t).ExpectEquals(len(renames), 2)
+	test.S(t).ExpectEquals(renames["a"], "b")
+	test.S(t).ExpectEquals(renames["c"], "d")
+}
+
+func TestParseAlterStatementUnsupported(t *testing.T) {
+	{
+		parser := NewParser()
+		err := parser.ParseAlterStatement("add column i int, add unique key uk (i), engine=innodb")
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(len(parser.UnsupportedOperations()), 0)
+		test.S(t).ExpectEquals(len(parser.UnknownOperations()), 0)
+		test.S(t).ExpectEquals(len(parser.AddedUniqueKeys()), 1)
+	}
+	{
+		parser := NewParser()
+		err := parser.ParseAlterStatement("add column i int, rename as t2, discard tablespace")
+		test.S(t).ExpectNil(err)
+		unsupported := parser.UnsupportedOperations()
+		test.S(t).ExpectEquals(len(unsupported), 2)
+		test.S(t).ExpectEquals(unsupported[0].Type, RenameTableAlterOperation)
+		test.S(t).ExpectEquals(unsupported[1].Type, TablespaceAlterOperation)
+	}
+	{
+		parser := NewParser()
+		err := parser.ParseAlterStatement("add column i int comment 'unterminated")
+		test.S(t).ExpectNotNil(err)
+	}
+}
@@ -23,6 +23,8 @@ const (
 	MediumIntColumnType
 	JSONColumnType
 	FloatColumnType
+	DateColumnType
+	SetColumnType
 )
 
 const maxMediumintUnsigned int32 = 16777215
@@ -36,6 +38,8 @@ type Column struct {
 	IsUnsigned         bool
 	Charset            string
 	Type               ColumnType
+	MySQLType          string // COLUMN_TYPE, e.g. `varchar(64)`, `int(10) unsigned`
+	Collation          string // COLLATION_NAME of text columns, `binary` for binary strings
 	timezoneConversion *TimezoneConversion
 }
 
@@ -148,6 +152,15 @@ func NewColumnList(names []string) *ColumnList {
 	return result
 }
 
+// NewColumnListFromColumns creates an object given ordered list of columns, keeping their types
+func NewColumnListFromColumns(columns []Column) *ColumnList {
+	result := &ColumnList{
+		columns: columns,
+	}
+	result.Ordinals = NewColumnsMap(result.columns)
+	return result
+}
+
 // ParseColumnList parses a comma delimited list of column names
 func ParseColumnList(names string) *ColumnList {
 	result := &ColumnList{
@@ -0,0 +1,25 @@
+drop table if exists gh_ost_test;
+create table gh_ost_test (
+  id int auto_increment,
+  i int not null,
+  s varchar(32) null,
+  ts timestamp,
+  primary key(id)
+) auto_increment=1;
+
+insert into gh_ost_test values (null, 11, 'short', now());
+insert into gh_ost_test values (null, 70000, 'somewhat longer value', now());
+insert into gh_ost_test values (null, 13, null, now());
+
+drop event if exists gh_ost_test;
+delimiter ;;
+create event gh_ost_test
+  on schedule every 1 second
+  starts current_timestamp
+  ends current_timestamp + interval 60 second
+  on completion not preserve
+  enable
+  do
+begin
+  insert into gh_ost_test values (null, 17, 'short', now());
+end ;;
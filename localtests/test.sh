@@ -21,6 +21,7 @@ master_port=
 replica_host=
 replica_port=
 original_sql_mode=
+mysql_flavor=
 
 OPTIND=1
 while getopts "b:" OPTION
@@ -49,6 +50,11 @@ verify_master_and_replica() {
   fi
   original_sql_mode="$(gh-ost-test-mysql-master -e "select @@global.sql_mode" -s -s)"
   echo "sql_mode on master is ${original_sql_mode}"
+  mysql_flavor="mysql"
+  if gh-ost-test-mysql-master -s -s -e "select @@version" | grep -qi "mariadb" ; then
+    mysql_flavor="mariadb"
+  fi
+  echo "# flavor is ${mysql_flavor}"
 
   if [ "$(gh-ost-test-mysql-replica -e "select 1" -ss)" != "1" ] ; then
     echo "Cannot verify gh-ost-test-mysql-replica"
@@ -101,6 +107,14 @@ test_single() {
     fi
   fi
 
+  if [ -f $tests_path/$test_name/ignore_flavors ] ; then
+    ignore_flavors=$(cat $tests_path/$test_name/ignore_flavors)
+    if echo "$mysql_flavor" | egrep -q "^(${ignore_flavors})$" ; then
+      echo -n "Skipping: $test_name"
+      return 0
+    fi
+  fi
+
   echo -n "Testing: $test_name"
 
   echo_dot